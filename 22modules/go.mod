@@ -3,3 +3,8 @@ module github.com/debarshee2004/mymodule
 go 1.23.6
 
 require github.com/gorilla/mux v1.8.1
+
+// The TLS setup is shared with the course API
+require github.com/debarshee2004/apimux v0.0.0
+
+replace github.com/debarshee2004/apimux => ../23apimux
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/debarshee2004/apimux/tlsserver"
 	"github.com/gorilla/mux"
 )
 
@@ -14,6 +16,22 @@ func main() {
 	r := mux.NewRouter()
 	r.HandleFunc("/", serveHome).Methods("GET")
 
+	// Serve HTTPS and HTTP/2 when TLS=on (see tlsserver)
+	settings, err := tlsserver.LoadSettings("mymodule")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if settings.Enabled {
+		config, reloader, err := tlsserver.ServerConfig(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go reloader.Watch(context.Background())
+
+		server := &http.Server{Addr: ":4000", Handler: r, TLSConfig: config}
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+
 	log.Fatal(http.ListenAndServe(":4000", r))
 }
 
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin UI - admin.go
+// Server-rendered pages for managing courses and authors, built from
+// html/template files embedded in the binary, so there is no JS build step
+// and nothing to deploy next to the server. Forms post back to the same
+// store as the API and show the API's validation messages next to each field.
+//
+// Every form carries a CSRF token: an HMAC of a random per-browser cookie.
+// Cross-site pages can't read the cookie, so they can't forge the token.
+
+//go:embed templates static
+var adminFiles embed.FS
+
+// adminPages holds each page parsed together with the shared layout
+var adminPages = parseAdminPages(
+	"home.html",
+	"courses.html", "course_form.html",
+	"authors.html", "author_form.html",
+	"error.html",
+)
+
+// adminNotices are the messages shown after a redirect, keyed by ?done=
+var adminNotices = map[string]string{
+	"course-created": "Course created.",
+	"course-updated": "Course saved.",
+	"course-deleted": "Course deleted.",
+	"author-created": "Author created.",
+	"author-updated": "Author saved.",
+	"author-deleted": "Author deleted.",
+}
+
+// parseAdminPages parses each named template with layout.html
+func parseAdminPages(names ...string) map[string]*template.Template {
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		pages[name] = template.Must(template.ParseFS(adminFiles, "templates/layout.html", "templates/"+name))
+	}
+	return pages
+}
+
+// adminPage is the data every page's layout uses
+type adminPage struct {
+	CSRF   string // Token for the page's forms
+	Notice string // Message about the previous action, if any
+}
+
+// newAdminPage sets up the layout data for a request
+func newAdminPage(w http.ResponseWriter, r *http.Request) adminPage {
+	return adminPage{
+		CSRF:   csrfToken(w, r),
+		Notice: adminNotices[r.URL.Query().Get("done")],
+	}
+}
+
+// renderPage executes a page into a buffer first, so a template error gives
+// a clean 500 instead of half a page
+func renderPage(w http.ResponseWriter, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := adminPages[name].ExecuteTemplate(&buf, "layout", data); err != nil {
+		http.Error(w, "Failed to render page: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// renderAdminError shows an error page
+func renderAdminError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	renderPage(w, status, "error.html", struct {
+		adminPage
+		Status string
+		Error  string
+	}{newAdminPage(w, r), http.StatusText(status), message})
+}
+
+// redirectDone sends the browser back to a list page after a successful post
+func redirectDone(w http.ResponseWriter, r *http.Request, path, done string) {
+	http.Redirect(w, r, path+"?done="+done, http.StatusSeeOther)
+}
+
+// CSRF protection
+
+const (
+	csrfCookie    = "admin_csrf"
+	csrfField     = "csrf_token"
+	maxAdminForm  = 1 << 20 // Largest form body accepted, in bytes
+	csrfSecretLen = 32
+)
+
+// csrfKey signs CSRF tokens; it only has to live as long as the process
+var csrfKey = randomBytes(32)
+
+// randomBytes returns n bytes from crypto/rand
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// csrfToken returns the form token for the browser's CSRF cookie, setting
+// a new cookie if it doesn't have one yet
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return signCSRF(c.Value)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(randomBytes(csrfSecretLen))
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    secret,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// Make the cookie visible to the rest of this request too
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: secret})
+	return signCSRF(secret)
+}
+
+// signCSRF derives the form token from a cookie value
+func signCSRF(secret string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkOrigin rejects requests that a page on another site sent
+func checkOrigin(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return errors.New("Cross-origin form posts are not allowed")
+		}
+	}
+	return nil
+}
+
+// checkCSRF verifies a form post came from one of our own pages
+func checkCSRF(r *http.Request) error {
+	if err := checkOrigin(r); err != nil {
+		return err
+	}
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return errors.New("Missing CSRF cookie; reload the page and try again")
+	}
+	token := r.PostFormValue(csrfField)
+	if !hmac.Equal([]byte(token), []byte(signCSRF(c.Value))) {
+		return errors.New("Invalid CSRF token; reload the page and try again")
+	}
+	return nil
+}
+
+// protectAdmin checks CSRF tokens on every non-GET request and stops the
+// pages from being framed or loading scripts from elsewhere
+func protectAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; form-action 'self'")
+		h.Set("Referrer-Policy", "same-origin")
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			r.Body = http.MaxBytesReader(w, r.Body, maxAdminForm)
+			if err := checkCSRF(r); err != nil {
+				renderAdminError(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Home page
+
+// serveHome shows a landing page with links to the admin UI and the APIs
+// GET /
+func serveHome(w http.ResponseWriter, r *http.Request) {
+	store := storeFrom(r)
+	renderPage(w, http.StatusOK, "home.html", struct {
+		adminPage
+		Courses int
+		Authors int
+	}{newAdminPage(w, r), len(store.List()), len(store.Authors())})
+}
+
+// Courses
+
+// courseForm is the course form's raw input, kept as typed so that a form
+// with errors is shown again unchanged
+type courseForm struct {
+	Name      string
+	Duration  string // Go or ISO 8601 duration
+	Price     string // Decimal in major units, e.g. "29.99"
+	Currency  string
+	AuthorID  string
+	Status    string
+	PublishAt string // UTC, in the "2006-01-02T15:04" form of datetime-local inputs
+}
+
+// publishAtLayout is the format of datetime-local inputs
+const publishAtLayout = "2006-01-02T15:04"
+
+// courseFormPage is the data for course_form.html
+type courseFormPage struct {
+	adminPage
+	ID         string // Empty when creating
+	Form       courseForm
+	Errors     validationErrors
+	Error      string // Problem that isn't about one field
+	HasLessons bool
+	Authors    []Author
+	Currencies []string
+	Statuses   []CourseStatus
+}
+
+// newCourseForm fills the form from a stored course
+func newCourseForm(c Course) courseForm {
+	f := courseForm{
+		Name:     c.Name,
+		Duration: c.Duration.Short(),
+		Price:    c.Price.Decimal(),
+		Currency: c.Price.Currency,
+		Status:   string(c.Status),
+	}
+	if c.Author != nil {
+		f.AuthorID = c.Author.ID
+	}
+	if c.PublishAt != nil {
+		f.PublishAt = c.PublishAt.UTC().Format(publishAtLayout)
+	}
+	return f
+}
+
+// readCourseForm reads the posted course form
+func readCourseForm(r *http.Request) courseForm {
+	return courseForm{
+		Name:      r.PostFormValue("name"),
+		Duration:  r.PostFormValue("duration"),
+		Price:     r.PostFormValue("price"),
+		Currency:  r.PostFormValue("currency"),
+		AuthorID:  r.PostFormValue("author_id"),
+		Status:    r.PostFormValue("status"),
+		PublishAt: r.PostFormValue("publish_at"),
+	}
+}
+
+// course converts the form to a course of store s and validates it; errors parsing the
+// text fields are reported the same way as the store's validation errors
+func (f courseForm) course(s *courseStore) (Course, validationErrors) {
+	var errs validationErrors
+	c := Course{Name: f.Name}
+
+	d, err := ParseDuration(f.Duration)
+	if err != nil {
+		errs.add("duration", err.Error())
+	}
+	c.Duration = d
+
+	price := f.Price
+	if strings.TrimSpace(price) == "" {
+		price = "0"
+	}
+	if c.Price, err = ParseMoney(price, f.Currency); err != nil {
+		errs.add("price", err.Error())
+	}
+
+	if f.AuthorID != "" {
+		a, ok := s.Author(f.AuthorID)
+		if !ok {
+			errs.add("author_id", errAuthorNotFound.Error())
+		}
+		c.Author = &a
+	}
+
+	c.Status = CourseStatus(f.Status)
+	if c.Status == StatusScheduled && f.PublishAt != "" {
+		if at, err := time.Parse(publishAtLayout, f.PublishAt); err != nil {
+			errs.add("publish_at", "Enter a date and time")
+		} else {
+			c.PublishAt = &at
+		}
+	}
+
+	// The store validates again on save, but checking here too shows every
+	// message at once instead of only the parse errors
+	if err := validateCourse(&c); err != nil {
+		for _, e := range err.(validationErrors) {
+			if errs.For(e.Field) == "" {
+				errs = append(errs, e)
+			}
+		}
+	}
+	return c, errs
+}
+
+// showCourseForm renders the course form
+func showCourseForm(w http.ResponseWriter, r *http.Request, status int, page courseFormPage) {
+	page.adminPage = newAdminPage(w, r)
+	page.Authors = storeFrom(r).Authors()
+	page.Currencies = slices.Sorted(maps.Keys(currencyExponents))
+	page.Statuses = courseStatuses
+	if page.Form.Currency == "" {
+		page.Form.Currency = defaultCurrency
+	}
+	if page.Form.Status == "" {
+		page.Form.Status = string(StatusDraft) // New courses start as drafts here
+	}
+	renderPage(w, status, "course_form.html", page)
+}
+
+// saveCourseForm validates a posted form and stores it with save; on failure
+// the form is shown again with the messages
+func saveCourseForm(w http.ResponseWriter, r *http.Request, id string, save func(Course) (Course, error)) bool {
+	page := courseFormPage{ID: id, Form: readCourseForm(r)}
+	c, errs := page.Form.course(storeFrom(r))
+	if len(errs) == 0 {
+		_, err := save(c)
+		switch e := err.(type) {
+		case nil:
+			return true
+		case validationErrors:
+			errs = e
+		default:
+			if errors.Is(err, errCourseNotFound) {
+				renderAdminError(w, r, http.StatusNotFound, err.Error())
+				return false
+			}
+			page.Error = err.Error()
+		}
+	}
+	page.Errors = errs
+	if stored, ok := storeFrom(r).Get(id); ok {
+		page.HasLessons = len(courseLessonIDs(stored)) > 0
+	}
+	showCourseForm(w, r, http.StatusUnprocessableEntity, page)
+	return false
+}
+
+// adminCourses lists courses, optionally filtered by name or author
+// GET /admin/courses?q=go
+func adminCourses(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	courses := slices.DeleteFunc(storeFrom(r).List(), func(c Course) bool {
+		if q == "" || containsFold(c.Name, q) {
+			return false
+		}
+		return c.Author == nil || !containsFold(c.Author.Fullname, q)
+	})
+	renderPage(w, http.StatusOK, "courses.html", struct {
+		adminPage
+		Query   string
+		Courses []Course
+	}{newAdminPage(w, r), q, courses})
+}
+
+// adminNewCourse shows an empty course form
+// GET /admin/courses/new
+func adminNewCourse(w http.ResponseWriter, r *http.Request) {
+	showCourseForm(w, r, http.StatusOK, courseFormPage{})
+}
+
+// adminCreateCourse stores a new course from the form
+// POST /admin/courses
+func adminCreateCourse(w http.ResponseWriter, r *http.Request) {
+	if saveCourseForm(w, r, "", storeFrom(r).Create) {
+		redirectDone(w, r, "/admin/courses", "course-created")
+	}
+}
+
+// adminEditCourse shows the form for a stored course
+// GET /admin/courses/{id}/edit
+func adminEditCourse(w http.ResponseWriter, r *http.Request) {
+	c, ok := storeFrom(r).Get(mux.Vars(r)["id"])
+	if !ok {
+		renderAdminError(w, r, http.StatusNotFound, errCourseNotFound.Error())
+		return
+	}
+	showCourseForm(w, r, http.StatusOK, courseFormPage{
+		ID:         c.ID,
+		Form:       newCourseForm(c),
+		HasLessons: len(courseLessonIDs(c)) > 0,
+	})
+}
+
+// adminUpdateCourse saves the form over a stored course
+// POST /admin/courses/{id}
+func adminUpdateCourse(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	save := func(c Course) (Course, error) { return storeFrom(r).Update(id, c) }
+	if saveCourseForm(w, r, id, save) {
+		redirectDone(w, r, "/admin/courses", "course-updated")
+	}
+}
+
+// adminDeleteCourse deletes a course
+// POST /admin/courses/{id}/delete
+func adminDeleteCourse(w http.ResponseWriter, r *http.Request) {
+	if !storeFrom(r).Delete(mux.Vars(r)["id"]) {
+		renderAdminError(w, r, http.StatusNotFound, errCourseNotFound.Error())
+		return
+	}
+	redirectDone(w, r, "/admin/courses", "course-deleted")
+}
+
+// Authors
+
+// authorFormPage is the data for author_form.html
+type authorFormPage struct {
+	adminPage
+	ID     string // Empty when creating
+	Form   Author
+	Errors validationErrors
+	Error  string // Problem that isn't about one field
+}
+
+// saveAuthorForm stores a posted author form with save; on failure the form
+// is shown again with the messages
+func saveAuthorForm(w http.ResponseWriter, r *http.Request, id string, save func(Author) (Author, error)) bool {
+	page := authorFormPage{ID: id, Form: Author{
+		Fullname: r.PostFormValue("fullname"),
+		Email:    r.PostFormValue("email"),
+	}}
+	_, err := save(page.Form)
+	switch e := err.(type) {
+	case nil:
+		return true
+	case validationErrors:
+		page.Errors = e
+	default:
+		switch {
+		case errors.Is(err, errAuthorNotFound):
+			renderAdminError(w, r, http.StatusNotFound, err.Error())
+			return false
+		case errors.Is(err, errAuthorExists):
+			page.Errors.add("email", err.Error())
+		default:
+			page.Error = err.Error()
+		}
+	}
+	page.adminPage = newAdminPage(w, r)
+	renderPage(w, http.StatusUnprocessableEntity, "author_form.html", page)
+	return false
+}
+
+// adminAuthors lists authors, optionally filtered by name or email
+// GET /admin/authors?q=jane
+func adminAuthors(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	authors := slices.DeleteFunc(storeFrom(r).Authors(), func(a Author) bool {
+		return q != "" && !containsFold(a.Fullname, q) && !containsFold(a.Email, q)
+	})
+	counts := map[string]int{}
+	for _, c := range storeFrom(r).List() {
+		if c.Author != nil {
+			counts[c.Author.ID]++
+		}
+	}
+	renderPage(w, http.StatusOK, "authors.html", struct {
+		adminPage
+		Query        string
+		Authors      []Author
+		CourseCounts map[string]int
+	}{newAdminPage(w, r), q, authors, counts})
+}
+
+// adminNewAuthor shows an empty author form
+// GET /admin/authors/new
+func adminNewAuthor(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, http.StatusOK, "author_form.html", authorFormPage{adminPage: newAdminPage(w, r)})
+}
+
+// adminCreateAuthor stores a new author from the form
+// POST /admin/authors
+func adminCreateAuthor(w http.ResponseWriter, r *http.Request) {
+	if saveAuthorForm(w, r, "", storeFrom(r).CreateAuthor) {
+		redirectDone(w, r, "/admin/authors", "author-created")
+	}
+}
+
+// adminEditAuthor shows the form for a stored author
+// GET /admin/authors/{id}/edit
+func adminEditAuthor(w http.ResponseWriter, r *http.Request) {
+	a, ok := storeFrom(r).Author(mux.Vars(r)["id"])
+	if !ok {
+		renderAdminError(w, r, http.StatusNotFound, errAuthorNotFound.Error())
+		return
+	}
+	renderPage(w, http.StatusOK, "author_form.html", authorFormPage{
+		adminPage: newAdminPage(w, r),
+		ID:        a.ID,
+		Form:      a,
+	})
+}
+
+// adminUpdateAuthor saves the form over a stored author
+// POST /admin/authors/{id}
+func adminUpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	save := func(a Author) (Author, error) { return storeFrom(r).UpdateAuthor(id, a) }
+	if saveAuthorForm(w, r, id, save) {
+		redirectDone(w, r, "/admin/authors", "author-updated")
+	}
+}
+
+// adminDeleteAuthor deletes an author who has no courses
+// POST /admin/authors/{id}/delete
+func adminDeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	switch err := storeFrom(r).DeleteAuthor(mux.Vars(r)["id"]); {
+	case errors.Is(err, errAuthorNotFound):
+		renderAdminError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, errAuthorInUse):
+		renderAdminError(w, r, http.StatusConflict, err.Error()+"; reassign or delete them first")
+	case err != nil:
+		renderAdminError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		redirectDone(w, r, "/admin/authors", "author-deleted")
+	}
+}
+
+// registerAdminRoutes mounts the admin UI under /admin
+func registerAdminRoutes(r *mux.Router) {
+	r.Handle("/admin", http.RedirectHandler("/admin/courses", http.StatusFound)).Methods("GET")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(protectAdmin)
+
+	static, _ := fs.Sub(adminFiles, "static")
+	admin.PathPrefix("/static/").Handler(http.StripPrefix("/admin/static/", http.FileServer(http.FS(static))))
+
+	admin.HandleFunc("/courses", adminCourses).Methods("GET")
+	admin.HandleFunc("/courses", adminCreateCourse).Methods("POST")
+	admin.HandleFunc("/courses/new", adminNewCourse).Methods("GET")
+	admin.HandleFunc("/courses/{id}/edit", adminEditCourse).Methods("GET")
+	admin.HandleFunc("/courses/{id}", adminUpdateCourse).Methods("POST")
+	admin.HandleFunc("/courses/{id}/delete", adminDeleteCourse).Methods("POST")
+
+	admin.HandleFunc("/authors", adminAuthors).Methods("GET")
+	admin.HandleFunc("/authors", adminCreateAuthor).Methods("POST")
+	admin.HandleFunc("/authors/new", adminNewAuthor).Methods("GET")
+	admin.HandleFunc("/authors/{id}/edit", adminEditAuthor).Methods("GET")
+	admin.HandleFunc("/authors/{id}", adminUpdateAuthor).Methods("POST")
+	admin.HandleFunc("/authors/{id}/delete", adminDeleteAuthor).Methods("POST")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Controller for Author - author.go
+// Authors are managed in the admin UI (see admin.go) and through the courses
+// that reference them; the API exposes the registry read-only so that the
+// "author" links of course responses lead somewhere.
+
+// getAuthors lists every author sorted by name
+// GET /authors?page=1&per_page=20
+func getAuthors(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r)
+	if err != nil {
+		render(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	renderAuthors(w, r, http.StatusOK, paginate(w, r, p, "authors", storeFrom(r).Authors()))
+}
+
+// getAuthor returns one author
+// GET /authors/{author}
+func getAuthor(w http.ResponseWriter, r *http.Request) {
+	a, ok := storeFrom(r).Author(mux.Vars(r)["author"])
+	if !ok {
+		renderStoreError(w, r, errAuthorNotFound)
+		return
+	}
+	renderAuthor(w, r, http.StatusOK, a)
+}
+
+// getAuthorCourses lists the courses an author wrote
+// GET /authors/{author}/courses
+func getAuthorCourses(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["author"]
+	if _, ok := storeFrom(r).Author(id); !ok {
+		renderStoreError(w, r, errAuthorNotFound)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		render(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	renderCourses(w, r, http.StatusOK, paginate(w, r, p, "authorCourses", publishedOnly(storeFrom(r).CoursesByAuthor(id)), "author", id))
+}
+
+// registerAuthorRoutes adds the author routes, named through l for links
+func registerAuthorRoutes(r *mux.Router, l *linker) {
+	r.HandleFunc("/authors", getAuthors).Methods("GET").Name(l.name("authors"))
+	r.HandleFunc("/authors/{author}", getAuthor).Methods("GET").Name(l.name("author"))
+	r.HandleFunc("/authors/{author}/courses", getAuthorCourses).Methods("GET").Name(l.name("authorCourses"))
+}
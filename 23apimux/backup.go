@@ -0,0 +1,745 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Backups - backup.go
+// Online backups of a tenant's store for disaster recovery. A backup is
+// written from a snapshot (see snapshot.go), so writes carry on while it is
+// taken, and is kept as a gzip-compressed tar archive next to its checksum:
+//
+//	$BACKUP_DIR/<tenant>/20261019T164502.123Z.tar.gz
+//	$BACKUP_DIR/<tenant>/20261019T164502.123Z.tar.gz.sha256   (sha256sum format)
+//
+// The archive holds manifest.json, store.json with the snapshot, and the
+// material files under blobs/. The manifest lists the SHA-256 and size of
+// every other entry. A restore checks the archive's checksum and every entry
+// before it changes anything, then replaces the store's data while the
+// tenant's other requests wait, as in a transactional batch.
+//
+// The routes live under /admin but are API routes: they take an admin key of
+// the tenant (see tenant.go) and turn away cross-origin posts, rather than
+// using the admin UI's CSRF tokens. cmd/backup is a command-line client for
+// them.
+
+const (
+	backupFormat     = 1                      // Version of the archive layout
+	backupExt        = ".tar.gz"              // Archive file extension
+	backupNameLayout = "20060102T150405.000Z" // Names of new backups, in UTC
+	maxBackupUpload  = 1 << 30                // Largest archive that can be uploaded, in bytes
+)
+
+var (
+	errBackupNotFound = errors.New("No backup found with the given name")
+	errBackupExists   = errors.New("A backup with this name already exists")
+	errBackupCorrupt  = errors.New("Backup failed its integrity check")
+	errBackupTenant   = errors.New("Backup belongs to another tenant")
+	errBackupName     = errors.New("Backup names may only have letters, digits, dots, dashes and underscores")
+)
+
+// backupNamePattern is what backup names may look like; they are file names
+var backupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// backupDir is where backups are kept, one directory per tenant
+var backupDir = loadBackupDir()
+
+// loadBackupDir reads BACKUP_DIR, defaulting to a temporary directory
+func loadBackupDir() string {
+	if dir := os.Getenv("BACKUP_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "apimux-backups")
+}
+
+// backupManifest is the first entry of an archive
+type backupManifest struct {
+	Format    int            `json:"format"`
+	Tenant    string         `json:"tenant"`     // Tenant the data was taken from
+	CreatedAt time.Time      `json:"created_at"` // When the snapshot was taken
+	Counts    map[string]int `json:"counts"`     // Records of each kind, for listings
+	Store     backupEntry    `json:"store"`      // store.json
+	Blobs     []backupEntry  `json:"blobs"`      // Material files, stored as blobs/<sha256>
+}
+
+// backupEntry is the checksum of an archive entry
+type backupEntry struct {
+	SHA256 string `json:"sha256"` // Hex digest
+	Size   int64  `json:"size"`   // In bytes
+}
+
+// backupInfo describes a stored backup in listings
+type backupInfo struct {
+	Name      string         `json:"name"`
+	Tenant    string         `json:"tenant"`
+	CreatedAt time.Time      `json:"created_at"`
+	Size      int64          `json:"size"`   // Of the archive, in bytes
+	SHA256    string         `json:"sha256"` // Of the archive; empty if its checksum file is missing
+	Counts    map[string]int `json:"counts"`
+}
+
+// stateCounts counts the records of a snapshot
+func stateCounts(state storeState) map[string]int {
+	return map[string]int{
+		"courses":     len(state.Courses),
+		"authors":     len(state.Authors),
+		"learners":    len(state.Learners),
+		"enrollments": len(state.Enrollments),
+		"reviews":     len(state.Reviews),
+		"materials":   len(state.Materials),
+		"coupons":     len(state.Coupons),
+		"carts":       len(state.Carts),
+		"orders":      len(state.Orders),
+	}
+}
+
+// Archives
+
+// writeBackup writes an archive of a snapshot. The material files are read
+// from blobs and checked against their digests on the way.
+func writeBackup(w io.Writer, m backupManifest, data []byte, blobs blobStore) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	header := func(name string, size int64) *tar.Header {
+		return &tar.Header{Name: name, Size: size, Mode: 0o644, ModTime: m.CreatedAt, Typeflag: tar.TypeReg}
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{"manifest.json", manifest}, {"store.json", data}} {
+		if err := tw.WriteHeader(header(entry.name, int64(len(entry.data)))); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range m.Blobs {
+		if err := tw.WriteHeader(header("blobs/"+e.SHA256, e.Size)); err != nil {
+			return err
+		}
+		f, err := blobs.open(e.SHA256)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		_, err = io.CopyN(tw, io.TeeReader(f, hash), e.Size)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("material file %s: %w", e.SHA256, err)
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != e.SHA256 {
+			return fmt.Errorf("material file %s is damaged", e.SHA256)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// readBackup reads and checks an archive. Each material file is passed to
+// blob, which must read it to the end; it is checked against its digest
+// afterwards. Any damage is reported as errBackupCorrupt.
+func readBackup(r io.Reader, blob func(e backupEntry, r io.Reader) error) (backupManifest, storeState, error) {
+	var m backupManifest
+	var state storeState
+	corrupt := func(format string, args ...any) (backupManifest, storeState, error) {
+		return backupManifest{}, storeState{}, fmt.Errorf("%w: %s", errBackupCorrupt, fmt.Sprintf(format, args...))
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return corrupt("not a gzip file")
+	}
+	tr := tar.NewReader(zr)
+
+	// The manifest comes first, then the store
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "manifest.json" {
+		return corrupt("manifest.json is missing")
+	}
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return corrupt("manifest.json: %v", err)
+	}
+	if m.Format != backupFormat {
+		return corrupt("format %d isn't supported", m.Format)
+	}
+	hdr, err = tr.Next()
+	if err != nil || hdr.Name != "store.json" {
+		return corrupt("store.json is missing")
+	}
+	data, err := readEntry(tr, hdr, m.Store)
+	if err != nil {
+		return corrupt("store.json: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return corrupt("store.json: %v", err)
+	}
+	if state.Coupons == nil {
+		state.Coupons = map[string]Coupon{} // The store writes to it in place
+	}
+
+	// Then every material file, once
+	pending := map[string]backupEntry{}
+	for _, e := range m.Blobs {
+		pending[e.SHA256] = e
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return corrupt("%v", err)
+		}
+		sum, ok := strings.CutPrefix(hdr.Name, "blobs/")
+		e, listed := pending[sum]
+		if !ok || !listed || hdr.Size != e.Size {
+			return corrupt("unexpected entry %s", hdr.Name)
+		}
+		delete(pending, sum)
+		hash := sha256.New()
+		if err := blob(e, io.TeeReader(tr, hash)); err != nil {
+			return backupManifest{}, storeState{}, err
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != sum {
+			return corrupt("material file %s doesn't match its checksum", sum)
+		}
+	}
+	if len(pending) > 0 {
+		return corrupt("%d material files are missing", len(pending))
+	}
+
+	// Read to the end of the gzip stream, which checks its CRC
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return corrupt("%v", err)
+	}
+	return m, state, nil
+}
+
+// readEntry reads a small archive entry and checks it against its manifest entry
+func readEntry(tr *tar.Reader, hdr *tar.Header, e backupEntry) ([]byte, error) {
+	if hdr.Size != e.Size {
+		return nil, fmt.Errorf("size is %d bytes, not %d", hdr.Size, e.Size)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != e.SHA256 {
+		return nil, errors.New("doesn't match its checksum")
+	}
+	return data, nil
+}
+
+// readManifest reads just the manifest of an archive, for listings
+func readManifest(r io.Reader) (backupManifest, error) {
+	var m backupManifest
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return m, fmt.Errorf("%w: not a gzip file", errBackupCorrupt)
+	}
+	tr := tar.NewReader(zr)
+	if hdr, err := tr.Next(); err != nil || hdr.Name != "manifest.json" {
+		return m, fmt.Errorf("%w: manifest.json is missing", errBackupCorrupt)
+	}
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return m, fmt.Errorf("%w: manifest.json: %v", errBackupCorrupt, err)
+	}
+	return m, nil
+}
+
+// Backup storage
+
+// backupStore keeps a tenant's archives on disk
+type backupStore struct {
+	dir string
+}
+
+// backups returns the backup storage of the store's tenant
+func (s *courseStore) backups() backupStore {
+	return backupStore{dir: filepath.Join(backupDir, s.tenant)}
+}
+
+// path is where the archive with the given name is stored
+func (b backupStore) path(name string) string {
+	return filepath.Join(b.dir, name+backupExt)
+}
+
+// checkName makes sure a name from a request is a plain file name
+func checkBackupName(name string) error {
+	if !backupNamePattern.MatchString(name) {
+		return errBackupName
+	}
+	return nil
+}
+
+// save writes an archive to a temporary file, then links it into place with
+// its checksum file. A taken name fails with errBackupExists, unless unique is
+// set, in which case name-2, name-3... are tried.
+func (b backupStore) save(name string, unique bool, write func(io.Writer) error) (string, error) {
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(b.dir, "backup-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if err := write(io.MultiWriter(tmp, hash)); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	// Link rather than rename, so an existing backup is never replaced
+	final := name
+	for i := 2; ; i++ {
+		err = os.Link(tmp.Name(), b.path(final))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		if !unique {
+			return "", errBackupExists
+		}
+		final = fmt.Sprintf("%s-%d", name, i)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	return final, writeFileAtomic(b.path(final)+".sha256", []byte(sum+"  "+final+backupExt+"\n"))
+}
+
+// writeFileAtomic writes a small file through a temporary one, so readers
+// never see it half written
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// info describes the backup with the given name
+func (b backupStore) info(name string) (backupInfo, error) {
+	if err := checkBackupName(name); err != nil {
+		return backupInfo{}, err
+	}
+	f, err := os.Open(b.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return backupInfo{}, errBackupNotFound
+	}
+	if err != nil {
+		return backupInfo{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return backupInfo{}, err
+	}
+	m, err := readManifest(f)
+	if err != nil {
+		return backupInfo{}, err
+	}
+	sum, _ := b.checksum(name) // Listed even without one; restoring it fails
+	return backupInfo{
+		Name:      name,
+		Tenant:    m.Tenant,
+		CreatedAt: m.CreatedAt,
+		Size:      st.Size(),
+		SHA256:    sum,
+		Counts:    m.Counts,
+	}, nil
+}
+
+// checksum reads the archive's digest from its checksum file
+func (b backupStore) checksum(name string) (string, error) {
+	data, err := os.ReadFile(b.path(name) + ".sha256")
+	if err != nil {
+		return "", fmt.Errorf("%w: the checksum file is missing", errBackupCorrupt)
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	return sum, nil
+}
+
+// list describes every backup, newest first. Archives that can't be read are
+// left out and logged.
+func (b backupStore) list() ([]backupInfo, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	list := []backupInfo{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), backupExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := b.info(name)
+		if err != nil {
+			fmt.Println("Listing backup", e.Name()+":", err)
+			continue
+		}
+		list = append(list, info)
+	}
+	slices.SortFunc(list, func(a, b backupInfo) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return list, nil
+}
+
+// verify checks a backup against its checksum file, then every entry of the
+// archive against the manifest, and returns the archive's data. blob is
+// called for each material file, as by readBackup.
+func (b backupStore) verify(name string, blob func(e backupEntry, r io.Reader) error) (backupManifest, storeState, error) {
+	var m backupManifest
+	var state storeState
+	if _, err := b.info(name); err != nil {
+		return m, state, err
+	}
+	want, err := b.checksum(name)
+	if err != nil {
+		return m, state, err
+	}
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		return m, state, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return m, state, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != want {
+		return m, state, fmt.Errorf("%w: the archive doesn't match its checksum", errBackupCorrupt)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return m, state, err
+	}
+	return readBackup(f, blob)
+}
+
+// discardBlob reads a material file without keeping it
+func discardBlob(_ backupEntry, r io.Reader) error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// remove deletes a backup and its checksum file
+func (b backupStore) remove(name string) error {
+	if err := checkBackupName(name); err != nil {
+		return err
+	}
+	err := os.Remove(b.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errBackupNotFound
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(b.path(name) + ".sha256")
+	return nil
+}
+
+// Store methods
+
+// Backup writes a backup of the store's data and material files
+func (s *courseStore) Backup() (backupInfo, error) {
+	// Keep the files of materials deleted meanwhile until they are copied
+	defer s.holdSweeps()()
+	state := s.snapshot()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return backupInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+	m := backupManifest{
+		Format:    backupFormat,
+		Tenant:    s.tenant,
+		CreatedAt: time.Now().UTC(),
+		Counts:    stateCounts(state),
+		Store:     backupEntry{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))},
+		Blobs:     []backupEntry{},
+	}
+	for _, mat := range state.Materials {
+		e := backupEntry{SHA256: mat.SHA256, Size: mat.Size}
+		if !slices.Contains(m.Blobs, e) {
+			m.Blobs = append(m.Blobs, e)
+		}
+	}
+
+	b := s.backups()
+	name, err := b.save(m.CreatedAt.Format(backupNameLayout), true, func(w io.Writer) error {
+		return writeBackup(w, m, data, s.blobs())
+	})
+	if err != nil {
+		return backupInfo{}, err
+	}
+	return b.info(name)
+}
+
+// ImportBackup keeps an archive sent by a client as the backup with the given
+// name, once it is verified; wantSum is the archive's expected SHA-256, if known
+func (s *courseStore) ImportBackup(name string, r io.Reader, wantSum string) (backupInfo, error) {
+	if err := checkBackupName(name); err != nil {
+		return backupInfo{}, err
+	}
+	b := s.backups()
+	if _, err := os.Stat(b.path(name)); err == nil {
+		return backupInfo{}, errBackupExists
+	}
+
+	_, err := b.save(name, false, func(w io.Writer) error {
+		hash := sha256.New()
+		m, _, err := readBackup(io.TeeReader(r, io.MultiWriter(w, hash)), discardBlob)
+		switch {
+		case err != nil:
+			return err
+		case m.Tenant != s.tenant:
+			return errBackupTenant
+		case wantSum != "" && !strings.EqualFold(wantSum, hex.EncodeToString(hash.Sum(nil))):
+			return fmt.Errorf("%w: the upload doesn't match its checksum", errBackupCorrupt)
+		}
+		return nil
+	})
+	if err != nil {
+		return backupInfo{}, err
+	}
+	return b.info(name)
+}
+
+// RestoreBackup replaces the store's data with a verified backup, bringing
+// back its material files first. Callers hold the tenant's gate, so no other
+// request sees the store half restored.
+func (s *courseStore) RestoreBackup(name string) (backupInfo, error) {
+	b := s.backups()
+	info, err := b.info(name)
+	if err != nil {
+		return backupInfo{}, err
+	}
+	if info.Tenant != s.tenant {
+		return backupInfo{}, errBackupTenant
+	}
+
+	// Files put back must survive until the restored materials refer to them
+	defer s.holdSweeps()()
+	blobs := s.blobs()
+	_, state, err := b.verify(name, func(e backupEntry, r io.Reader) error {
+		_, err := blobs.put(r, e.Size)
+		return err
+	})
+	if err != nil {
+		return backupInfo{}, err
+	}
+	s.restore(state)
+	return info, nil
+}
+
+// Handlers
+
+// backupErrors are the errors a client can act on; others are disk problems
+var backupErrors = []error{errBackupNotFound, errBackupExists, errBackupCorrupt, errBackupTenant, errBackupName}
+
+// renderBackupError reports a failed backup operation
+func renderBackupError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		render(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Backups may be at most %d MB", maxBackupUpload>>20))
+	case slices.ContainsFunc(backupErrors, func(target error) bool { return errors.Is(err, target) }):
+		renderStoreError(w, r, err)
+	default:
+		fmt.Println("Could not "+action+":", err)
+		render(w, r, http.StatusInternalServerError, "Could not "+action)
+	}
+}
+
+// sameOriginOnly turns away posts from other sites' pages, which browsers
+// mark with an Origin header
+func sameOriginOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if err := checkOrigin(r); err != nil {
+				render(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listBackups lists the tenant's backups, newest first
+// GET /admin/backups
+func listBackups(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a list backups route")
+
+	list, err := storeFrom(r).backups().list()
+	if err != nil {
+		renderBackupError(w, r, "list backups", err)
+		return
+	}
+	render(w, r, http.StatusOK, list)
+}
+
+// createBackup takes a backup of the tenant's store
+// POST /admin/backups
+func createBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a create backup route")
+
+	info, err := storeFrom(r).Backup()
+	if err != nil {
+		renderBackupError(w, r, "take a backup", err)
+		return
+	}
+	w.Header().Set("Location", "/admin/backups/"+info.Name)
+	render(w, r, http.StatusCreated, info)
+}
+
+// downloadBackup sends a backup's archive, with its digest in X-Backup-SHA256
+// GET /admin/backups/{name}
+func downloadBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a download backup route")
+
+	b := storeFrom(r).backups()
+	name := mux.Vars(r)["name"]
+	info, err := b.info(name)
+	if err != nil {
+		renderBackupError(w, r, "read the backup", err)
+		return
+	}
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		renderBackupError(w, r, "read the backup", err)
+		return
+	}
+	defer f.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "application/gzip")
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + backupExt}))
+	if info.SHA256 != "" {
+		h.Set("ETag", `"`+info.SHA256+`"`)
+		h.Set("X-Backup-SHA256", info.SHA256)
+	}
+	http.ServeContent(w, r, name+backupExt, info.CreatedAt, f)
+}
+
+// uploadBackup stores an archive downloaded earlier, e.g. from another
+// machine, so it can be restored. The body is the archive; X-Backup-SHA256,
+// if sent, is checked against it.
+// PUT /admin/backups/{name}
+func uploadBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is an upload backup route")
+
+	body := http.MaxBytesReader(w, r.Body, maxBackupUpload)
+	info, err := storeFrom(r).ImportBackup(mux.Vars(r)["name"], body, r.Header.Get("X-Backup-SHA256"))
+	if err != nil {
+		renderBackupError(w, r, "store the backup", err)
+		return
+	}
+	render(w, r, http.StatusCreated, info)
+}
+
+// verifyBackup checks a backup's integrity without restoring it
+// GET /admin/backups/{name}/verify
+func verifyBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a verify backup route")
+
+	b := storeFrom(r).backups()
+	name := mux.Vars(r)["name"]
+	if _, _, err := b.verify(name, discardBlob); err != nil {
+		renderBackupError(w, r, "verify the backup", err)
+		return
+	}
+	info, err := b.info(name)
+	if err != nil {
+		renderBackupError(w, r, "verify the backup", err)
+		return
+	}
+	render(w, r, http.StatusOK, map[string]any{"verified": true, "backup": info})
+}
+
+// restoreBackup replaces the tenant's data with a backup. Other requests of
+// the tenant wait until it is done.
+// POST /admin/backups/{name}/restore
+func restoreBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a restore backup route")
+
+	t := tenantFrom(r.Context())
+	t.gate.Lock()
+	defer t.gate.Unlock()
+
+	info, err := t.store.RestoreBackup(mux.Vars(r)["name"])
+	if err != nil {
+		renderBackupError(w, r, "restore the backup", err)
+		return
+	}
+	render(w, r, http.StatusOK, map[string]any{"restored": true, "backup": info})
+}
+
+// deleteBackup removes a backup
+// DELETE /admin/backups/{name}
+func deleteBackup(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a delete backup route")
+
+	if err := storeFrom(r).backups().remove(mux.Vars(r)["name"]); err != nil {
+		renderBackupError(w, r, "delete the backup", err)
+		return
+	}
+	render(w, r, http.StatusOK, "Backup deleted successfully")
+}
+
+// registerBackupRoutes adds the backup routes. They must come before the
+// admin UI, whose /admin subrouter would otherwise take them.
+func registerBackupRoutes(r *mux.Router) {
+	backups := r.PathPrefix("/admin/backups").Subrouter()
+	backups.Use(sameOriginOnly)
+	backups.HandleFunc("", requireAdmin(listBackups)).Methods("GET")
+	backups.HandleFunc("", requireAdmin(createBackup)).Methods("POST")
+	backups.HandleFunc("/{name}", requireAdmin(downloadBackup)).Methods("GET")
+	backups.HandleFunc("/{name}", requireAdmin(uploadBackup)).Methods("PUT")
+	backups.HandleFunc("/{name}", requireAdmin(deleteBackup)).Methods("DELETE")
+	backups.HandleFunc("/{name}/verify", requireAdmin(verifyBackup)).Methods("GET")
+	backups.HandleFunc("/{name}/restore", requireAdmin(restoreBackup)).Methods("POST").Name("restoreBackup")
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Batch operations - batch.go
+// POST /batch runs many API calls in one round trip. Each operation is turned
+// into an ordinary request and dispatched through the same router, so it gets
+// the same routing, versioning, validation and responses as a direct call.
+//
+//	{"transactional": true, "operations": [
+//	  {"id": "new", "method": "POST", "path": "/v2/courses", "body": {...}},
+//	  {"method": "DELETE", "path": "/v2/courses/7"}
+//	]}
+//
+// A bare array of operations is accepted too. Operations run in order. In
+// transactional mode the batch stops at the first operation that fails (any
+// status of 400 or above) and the store is restored to how it was before the
+// batch; otherwise every operation runs and reports its own result.
+
+const (
+	maxBatchOperations = 100     // Most operations in one batch
+	maxBatchBody       = 8 << 20 // Largest batch request body, in bytes
+)
+
+// batchOperation is one sub-request of a batch
+type batchOperation struct {
+	ID      string            `json:"id,omitempty"`      // Client's reference, echoed in the result
+	Method  string            `json:"method"`            // HTTP method
+	Path    string            `json:"path"`              // Path and query, e.g. "/v2/courses?sort=name"
+	Headers map[string]string `json:"headers,omitempty"` // Extra request headers (e.g. Accept for a version)
+	Body    json.RawMessage   `json:"body,omitempty"`    // JSON request body
+}
+
+// batchRequest is the body of POST /batch
+type batchRequest struct {
+	Transactional bool             `json:"transactional"` // All or nothing
+	Operations    []batchOperation `json:"operations"`
+}
+
+// batchResult is the response to one operation
+type batchResult struct {
+	ID       string          `json:"id,omitempty"`
+	Status   int             `json:"status"`
+	Location string          `json:"location,omitempty"` // Location header, if any
+	Body     json.RawMessage `json:"body,omitempty"`     // JSON body; other content is sent as a JSON string
+}
+
+// batchResponse is the response to POST /batch
+type batchResponse struct {
+	Transactional bool          `json:"transactional"`
+	Committed     bool          `json:"committed"` // False when a transactional batch was rolled back
+	Results       []batchResult `json:"results"`
+}
+
+// batchMethods are the methods an operation may use
+var batchMethods = map[string]bool{
+	http.MethodGet: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true,
+}
+
+// forwardedHeaders are copied from the batch request to every operation, so
+// operations run as the same client. Operations always run as the batch's
+// tenant, which is passed on in their context.
+var forwardedHeaders = []string{"Authorization", "X-Client-ID"}
+
+// Transactional batches are isolated by their tenant's gate: ordinary requests
+// hold it for reading and a transactional batch holds it for writing, so
+// nothing else sees or changes the tenant's store while the batch may still
+// be rolled back. Other tenants aren't held up.
+
+// inBatchKey marks requests dispatched by a batch, which run under the
+// batch's hold on the gate
+type inBatchKey struct{}
+
+// selfGatedRoutes take the gate themselves or not at all: batches, which hold
+// it for writing when transactional, backup restores (see backup.go), and
+// replication streams, which would hold it for as long as a follower is
+// connected (see replication.go)
+var selfGatedRoutes = map[string]bool{"batch": true, "restoreBackup": true, "replicationStream": true}
+
+// gateRequests makes every request except batches and their operations wait
+// for a running transactional batch or restore of the same tenant
+func gateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(inBatchKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && selfGatedRoutes[route.GetName()] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t := tenantFrom(r.Context())
+		t.gate.RLock()
+		defer t.gate.RUnlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// batchHandler dispatches operations through the router it is mounted on
+type batchHandler struct {
+	router *mux.Router
+}
+
+// ServeHTTP handles POST /batch
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(inBatchKey{}) != nil {
+		render(w, r, http.StatusBadRequest, "Batches can't be nested")
+		return
+	}
+
+	batch, err := readBatch(w, r)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		render(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Batch body is larger than %d bytes", maxBatchBody))
+		return
+	case err != nil:
+		render(w, r, http.StatusBadRequest, err.Error())
+		return
+	case len(batch.Operations) == 0:
+		render(w, r, http.StatusBadRequest, "Batch has no operations")
+		return
+	case len(batch.Operations) > maxBatchOperations:
+		render(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Batch has %d operations; the limit is %d", len(batch.Operations), maxBatchOperations))
+		return
+	}
+
+	// The batch request itself was charged one request; charge the rest
+	t := tenantFrom(r.Context())
+	if ok, wait := t.limiter.allow(len(batch.Operations) - 1); !ok {
+		tooManyRequests(w, r, wait)
+		return
+	}
+
+	resp := batchResponse{Transactional: batch.Transactional, Committed: true}
+	if batch.Transactional {
+		t.gate.Lock()
+		defer t.gate.Unlock()
+	} else {
+		t.gate.RLock()
+		defer t.gate.RUnlock()
+	}
+
+	var before storeState
+	if batch.Transactional {
+		// Keep the files of deleted materials, and the batch's changes to the
+		// catalog from followers, until the outcome is known
+		defer t.store.holdSweeps()()
+		releaseWAL := t.store.holdWAL()
+		defer func() { releaseWAL(resp.Committed) }()
+		before = t.store.snapshot()
+	}
+
+	ctx := context.WithValue(r.Context(), inBatchKey{}, true)
+	for i, op := range batch.Operations {
+		result := h.run(ctx, r, op, batch.Transactional)
+		resp.Results = append(resp.Results, result)
+
+		if batch.Transactional && result.Status >= http.StatusBadRequest {
+			// Roll back, and report the rest as not attempted
+			t.store.restore(before)
+			resp.Committed = false
+			for _, skipped := range batch.Operations[i+1:] {
+				resp.Results = append(resp.Results, batchResult{
+					ID:     skipped.ID,
+					Status: http.StatusFailedDependency,
+					Body:   jsonString("Not run because an earlier operation failed"),
+				})
+			}
+			break
+		}
+	}
+
+	status := http.StatusOK
+	if !resp.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	render(w, r, status, resp)
+}
+
+// readBatch decodes a batch request body or a bare array of operations
+func readBatch(w http.ResponseWriter, r *http.Request) (batchRequest, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		return batchRequest{}, err
+	}
+	data = bytes.TrimSpace(data)
+
+	var batch batchRequest
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &batch.Operations)
+	} else {
+		err = json.Unmarshal(data, &batch)
+	}
+	if err != nil {
+		return batchRequest{}, fmt.Errorf("invalid batch: %v", err)
+	}
+	return batch, nil
+}
+
+// run dispatches one operation through the router and records its response
+func (h *batchHandler) run(ctx context.Context, parent *http.Request, op batchOperation, transactional bool) batchResult {
+	result := batchResult{ID: op.ID}
+	fail := func(status int, message string) batchResult {
+		result.Status = status
+		result.Body = jsonString(message)
+		return result
+	}
+
+	method := strings.ToUpper(op.Method)
+	path, _, _ := strings.Cut(op.Path, "?")
+	switch {
+	case !batchMethods[method]:
+		return fail(http.StatusBadRequest, fmt.Sprintf("Unsupported method %q", op.Method))
+	case !strings.HasPrefix(op.Path, "/"):
+		return fail(http.StatusBadRequest, "Path must start with /")
+	case path == "/batch":
+		return fail(http.StatusBadRequest, "Batches can't be nested")
+	}
+
+	var body io.Reader = http.NoBody
+	if len(op.Body) > 0 {
+		body = bytes.NewReader(op.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, op.Path, body)
+	if err != nil {
+		return fail(http.StatusBadRequest, fmt.Sprintf("Invalid path %q", op.Path))
+	}
+	req.Host = parent.Host
+	req.RemoteAddr = parent.RemoteAddr
+	req.TLS = parent.TLS
+	req.RequestURI = op.Path
+	for _, name := range forwardedHeaders {
+		if v := parent.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(op.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range op.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// Operations are told apart by the route they match rather than by their
+	// path, which may be escaped ("/carts/1/%63heckout")
+	var match mux.RouteMatch
+	if h.router.Match(req, &match) && match.Route != nil {
+		switch name := match.Route.GetName(); {
+		case transactional && name == "checkout":
+			// A payment can't be taken back by restoring the store
+			return fail(http.StatusBadRequest, "Checkout can't be part of a transactional batch")
+		case name == "restoreBackup":
+			// A restore takes the tenant's gate for writing, and the batch holds it
+			return fail(http.StatusBadRequest, "A backup restore can't be part of a batch")
+		}
+	}
+
+	rec := &batchRecorder{header: http.Header{}}
+	h.router.ServeHTTP(rec, req)
+
+	result.Status = rec.statusCode()
+	result.Location = rec.header.Get("Location")
+	if out := bytes.TrimSpace(rec.body.Bytes()); json.Valid(out) && len(out) > 0 {
+		result.Body = out
+	} else if len(out) > 0 {
+		result.Body = jsonString(string(out))
+	}
+	return result
+}
+
+// jsonString encodes s as a JSON string
+func jsonString(s string) json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// batchRecorder captures an operation's response in memory
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *batchRecorder) Header() http.Header { return rec.header }
+
+func (rec *batchRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *batchRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// statusCode is the response status, 200 if the handler never set one
+func (rec *batchRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// registerBatchRoutes mounts POST /batch, which dispatches through r
+func registerBatchRoutes(r *mux.Router) {
+	r.Handle("/batch", &batchHandler{router: r}).Methods("POST").Name("batch")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Response caching - cache.go
+// GET responses of the public catalog routes are kept in memory and replayed
+// until a course is created, updated or deleted. Every cached response carries
+// the catalog's Last-Modified time so clients can revalidate cheaply with
+// If-Modified-Since and get a 304 instead of the full payload.
+//
+// Only routes that answer every caller of a tenant alike are cached (see
+// cachedRoutes): learners, enrollments, carts, orders and the like are
+// per-learner data and always reach their handlers.
+
+const (
+	maxCachedResponses = 256     // Bounds the cache; the oldest entry is evicted first
+	maxCachedBody      = 1 << 20 // Larger responses (e.g. material downloads) aren't kept
+)
+
+// cachedResponse is a stored copy of a successful GET response
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+// responseCache holds cached responses and the time the catalog last changed
+type responseCache struct {
+	mu           sync.RWMutex
+	entries      map[string]*cachedResponse
+	order        []string  // Insertion order, used for eviction
+	lastModified time.Time // Last catalog mutation (second precision, as in HTTP dates)
+	maxAge       time.Duration
+}
+
+// newResponseCache creates an empty cache; maxAge is sent in Cache-Control
+func newResponseCache(maxAge time.Duration) *responseCache {
+	return &responseCache{
+		entries:      map[string]*cachedResponse{},
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		maxAge:       maxAge,
+	}
+}
+
+// catalogChanged must be called after every mutation of the store. It drops
+// all of its tenant's cached responses and moves Last-Modified forward.
+// Changes of courses and authors are also logged for followers where they
+// are made (see logWAL).
+func (s *courseStore) catalogChanged() {
+	s.cache.invalidate()
+}
+
+// cachedRoutes are the path templates of the public catalog, without the
+// version prefix
+var cachedRoutes = map[string]bool{
+	"/courses":                                   true,
+	"/courses/{id}":                              true,
+	"/courses/{id}/status":                       true,
+	"/courses/{id}/prerequisites":                true,
+	"/courses/{id}/modules":                      true,
+	"/courses/{id}/modules/{mid}":                true,
+	"/courses/{id}/modules/{mid}/lessons":        true,
+	"/courses/{id}/modules/{mid}/lessons/{lid}":  true,
+	"/courses/{id}/materials":                    true,
+	"/courses/{id}/materials/{material}":         true,
+	"/courses/{id}/materials/{material}/content": true,
+	"/courses/{id}/reviews":                      true,
+	"/courses/{id}/reviews/{rid}":                true,
+	"/authors":                                   true,
+	"/authors/{author}":                          true,
+	"/authors/{author}/courses":                  true,
+}
+
+// cacheable reports whether a request is for a public catalog route. Hidden
+// reviews are only listed for moderators, so those listings aren't shared.
+func cacheable(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil || r.URL.Query().Has("include") {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	return cachedRoutes[strings.TrimPrefix(template, "/"+versionFrom(r).Name)]
+}
+
+// cacheCatalog caches the public catalog routes of each tenant separately.
+// max-age is 0 so clients always revalidate, which is cheap because
+// revalidation is answered from memory.
+func cacheCatalog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		storeFrom(r).cache.middleware(next).ServeHTTP(w, r)
+	})
+}
+
+// invalidate empties the cache and records the modification time
+func (c *responseCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*cachedResponse{}
+	c.order = nil
+	now := time.Now().UTC().Truncate(time.Second)
+	// Keep Last-Modified strictly increasing so If-Modified-Since never
+	// matches a response from before the change
+	if !now.After(c.lastModified) {
+		now = c.lastModified.Add(time.Second)
+	}
+	c.lastModified = now
+}
+
+// cacheKey identifies a response variant: URL plus every header it varies on
+func cacheKey(r *http.Request) string {
+	return r.URL.RequestURI() + "\n" + r.Header.Get("Accept")
+}
+
+// middleware serves GET requests from the cache, filling it on a miss
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Partial responses are left to the handler
+		if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		c.mu.RLock()
+		entry, hit := c.entries[key]
+		lastModified := c.lastModified
+		c.mu.RUnlock()
+
+		c.setCacheHeaders(w.Header(), lastModified)
+
+		// Conditional request that is still fresh - no body needed
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+			writeCached(w, entry)
+			return
+		}
+
+		// Miss: record the handler's response while sending it to the client
+		w.Header().Set("X-Cache", "MISS")
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK || rec.tooLarge {
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// Don't store a response computed before a concurrent mutation
+		if !c.lastModified.Equal(lastModified) {
+			return
+		}
+		if _, exists := c.entries[key]; !exists {
+			c.order = append(c.order, key)
+		}
+		c.entries[key] = &cachedResponse{header: rec.header, body: rec.body.Bytes()}
+		for len(c.order) > maxCachedResponses {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	})
+}
+
+// setCacheHeaders adds Cache-Control and Last-Modified
+func (c *responseCache) setCacheHeaders(h http.Header, lastModified time.Time) {
+	if c.maxAge > 0 {
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(c.maxAge.Seconds())))
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	addVary(h, "Accept")
+}
+
+// writeCached replays a stored response
+func writeCached(w http.ResponseWriter, entry *cachedResponse) {
+	for name, values := range entry.header {
+		switch name {
+		case "Vary":
+			for _, v := range values {
+				addVary(w.Header(), v)
+			}
+		case "Cache-Control", "Last-Modified", "X-Cache", "Content-Encoding", "Content-Length":
+			// Set fresh by the middleware or by compression
+		default:
+			if strings.HasPrefix(name, "Access-Control-") {
+				continue // Depends on the request's Origin (see cors.go)
+			}
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+}
+
+// recordingWriter passes a response through while keeping a copy of it. The
+// headers are copied when the response starts, before outer middleware (such
+// as compression) adds anything of its own.
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool // Body passed maxCachedBody and wasn't kept
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.header == nil {
+		rw.status = status
+		rw.header = rw.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.header == nil {
+		rw.header = rw.Header().Clone()
+	}
+	if !rw.tooLarge && rw.body.Len()+len(p) > maxCachedBody {
+		rw.tooLarge = true
+		rw.body = bytes.Buffer{}
+	}
+	if !rw.tooLarge {
+		rw.body.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Completion certificates - certificate.go
+// A learner who completed a course can fetch a certificate, either as a JSON
+// document or as a printable HTML page. The JSON form carries an HMAC-SHA256
+// signature over its other fields, so anyone holding the document can have it
+// checked with POST /certificates/verify.
+//
+// The signing key comes from CERTIFICATE_KEY. Without it a random key is
+// generated at startup, and certificates stop verifying after a restart.
+// Tenants other than the default one sign with a key derived from it, so a
+// certificate only verifies with the tenant that issued it.
+
+// Certificate states that a learner completed a course
+type Certificate struct {
+	ID             string    `json:"id"`                  // Same as the enrollment ID
+	LearnerID      string    `json:"learner_id"`          // Who completed the course
+	LearnerName    string    `json:"learner_name"`        // Learner's name when issued
+	CourseID       string    `json:"course_id"`           // Which course was completed
+	CourseName     string    `json:"course_name"`         // Course name when issued
+	CourseDuration Duration  `json:"course_duration"`     // Course length at completion time
+	IssuedAt       time.Time `json:"issued_at"`           // When the course was completed
+	Algorithm      string    `json:"alg"`                 // Always "HS256"
+	Signature      string    `json:"signature,omitempty"` // HMAC-SHA256 of the other fields, base64url
+}
+
+// errNotCompleted is returned when asking for the certificate of a course
+// the learner hasn't finished
+var errNotCompleted = errors.New("The course is not completed yet")
+
+// certificateKey signs and verifies certificates
+var certificateKey = loadCertificateKey()
+
+// loadCertificateKey reads CERTIFICATE_KEY or makes up a random key
+func loadCertificateKey() []byte {
+	if key := os.Getenv("CERTIFICATE_KEY"); key != "" {
+		return []byte(key)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// certificateKey returns the key the store's tenant signs certificates with
+func (s *courseStore) certificateKey() []byte {
+	if s.tenant == defaultTenantID {
+		return certificateKey
+	}
+	mac := hmac.New(sha256.New, certificateKey)
+	mac.Write([]byte("tenant:" + s.tenant))
+	return mac.Sum(nil)
+}
+
+// signature computes the signature of a certificate's other fields
+func (c Certificate) signature(key []byte) string {
+	c.Signature = ""
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Valid reports whether the signature matches the certificate's contents
+func (c Certificate) Valid(key []byte) bool {
+	return c.Algorithm == "HS256" && hmac.Equal([]byte(c.Signature), []byte(c.signature(key)))
+}
+
+// IssueCertificate builds a signed certificate for a completed enrollment
+func (s *courseStore) IssueCertificate(courseID, id string) (Certificate, error) {
+	e, err := s.Enrollment(courseID, id)
+	if err != nil {
+		return Certificate{}, err
+	}
+	if e.CompletedAt == nil {
+		return Certificate{}, fmt.Errorf("%w (%.1f%% done)", errNotCompleted, e.Progress)
+	}
+	course, ok := s.Get(courseID)
+	if !ok {
+		return Certificate{}, errCourseNotFound
+	}
+	learner, err := s.Learner(e.LearnerID)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	c := Certificate{
+		ID:             e.ID,
+		LearnerID:      learner.ID,
+		LearnerName:    learner.Name,
+		CourseID:       course.ID,
+		CourseName:     course.Name,
+		CourseDuration: course.Duration,
+		IssuedAt:       *e.CompletedAt,
+		Algorithm:      "HS256",
+	}
+	c.Signature = c.signature(s.certificateKey())
+	return c, nil
+}
+
+// getCertificate returns the certificate of a completed enrollment, as HTML
+// when the client prefers text/html and in any API format otherwise
+// GET /courses/{id}/enrollments/{eid}/certificate
+func getCertificate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c, err := storeFrom(r).IssueCertificate(vars["id"], vars["eid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+
+	addVary(w.Header(), "Accept")
+	if ranges := parseAccept(r.Header.Get("Accept")); len(ranges) > 0 && ranges[0].mediaType == "text/html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		certificatePage.Execute(w, c)
+		return
+	}
+	render(w, r, http.StatusOK, c)
+}
+
+// verifyCertificate checks a certificate document's signature
+// POST /certificates/verify
+func verifyCertificate(w http.ResponseWriter, r *http.Request) {
+	var c Certificate
+	if renderDecodeError(w, r, decodeBody(r, &c)) {
+		return
+	}
+	render(w, r, http.StatusOK, map[string]bool{"valid": c.Signature != "" && c.Valid(storeFrom(r).certificateKey())})
+}
+
+var certificatePage = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Certificate of Completion - {{.CourseName}}</title>
+<style>
+  body { font-family: Georgia, serif; text-align: center; padding: 4rem; }
+  .frame { border: .5rem double #345; padding: 3rem; max-width: 40rem; margin: auto; }
+  h1 { font-size: 2rem; letter-spacing: .1em; text-transform: uppercase; }
+  .name { font-size: 1.8rem; font-style: italic; margin: 1.5rem 0; }
+  footer { margin-top: 3rem; font: 11px monospace; color: #666; word-break: break-all; }
+</style>
+</head>
+<body>
+<div class="frame">
+  <h1>Certificate of Completion</h1>
+  <p>This certifies that</p>
+  <p class="name">{{.LearnerName}}</p>
+  <p>has completed the course</p>
+  <h2>{{.CourseName}}</h2>
+  <p>{{.CourseDuration.Short}} of instruction, completed on {{.IssuedAt.Format "January 2, 2006"}}</p>
+  <footer>Certificate {{.ID}} &middot; {{.Algorithm}} {{.Signature}}</footer>
+</div>
+</body>
+</html>
+`))
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Checkout - checkout.go
+// A learner collects courses in a cart, may apply one coupon and picks a tax
+// region. Checkout charges the total through the payment provider (see
+// payment.go), records an order and enrolls the learner in every course.
+//
+// Carts and orders are only open to their learner, who sends the
+// X-Learner-Token they got when signing up (see enrollment.go), and to
+// admins. Managing coupons needs an admin key.
+//
+// All totals are Money values in minor units: percentages are applied with
+// Money.Share, which rounds once per step, so totals never drift the way
+// float arithmetic does.
+
+// taxRates are the sales tax / VAT rates per region in basis points (1/100 of
+// a percent). Regions are ISO 3166 country codes, optionally with a
+// subdivision ("US-CA").
+var taxRates = map[string]int64{
+	"AU":    1000,
+	"CA":    500,
+	"DE":    1900,
+	"FR":    2000,
+	"GB":    2000,
+	"IN":    1800,
+	"JP":    1000,
+	"NL":    2100,
+	"US":    0,
+	"US-CA": 725,
+	"US-NY": 400,
+	"US-TX": 625,
+}
+
+// Coupon is a discount code, either a percentage or a fixed amount off
+type Coupon struct {
+	Code      string     `json:"code"`       // Case-insensitive, stored upper-case
+	Percent   int64      `json:"percent"`    // Percentage off (1-100), or 0 for a fixed discount
+	Amount    *Money     `json:"amount"`     // Fixed amount off, or nil for a percentage
+	ExpiresAt *time.Time `json:"expires_at"` // Last moment the coupon can be used (nil: never)
+	MaxUses   int        `json:"max_uses"`   // How many orders may use it (0: unlimited)
+	Uses      int        `json:"uses"`       // Orders that used it so far
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Cart is a learner's pending purchase, priced with the current course prices
+type Cart struct {
+	ID        string     `json:"id"`         // Unique identifier for the cart
+	LearnerID string     `json:"learner_id"` // Buyer
+	Region    string     `json:"region"`     // Tax region (see taxRates)
+	Coupon    string     `json:"coupon"`     // Applied coupon code, if any
+	Items     []CartItem `json:"items"`      // Courses in the cart
+	Quote
+}
+
+// CartItem is one course in a cart or order
+type CartItem struct {
+	CourseID string `json:"course_id"`
+	Name     string `json:"name"`
+	Price    Money  `json:"price"`
+}
+
+// Quote holds the computed totals of a cart or order
+type Quote struct {
+	Subtotal    Money  `json:"subtotal"`               // Sum of the item prices
+	Discount    Money  `json:"discount"`               // Coupon discount
+	Tax         Money  `json:"tax"`                    // Tax on the discounted subtotal
+	TaxRate     int64  `json:"tax_rate"`               // Tax rate in basis points
+	Total       Money  `json:"total"`                  // Subtotal - discount + tax
+	CouponError string `json:"coupon_error,omitempty"` // Why the coupon doesn't apply
+}
+
+// Order is a completed checkout
+type Order struct {
+	ID        string     `json:"id"`         // Unique identifier for the order
+	LearnerID string     `json:"learner_id"` // Buyer
+	Region    string     `json:"region"`     // Tax region
+	Coupon    string     `json:"coupon"`     // Coupon used, if any
+	Items     []CartItem `json:"items"`      // Purchased courses at their paid prices
+	Quote
+	Payment   *Payment  `json:"payment"` // Nil for free orders
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	errCartNotFound   = errors.New("No cart found with the given ID")
+	errOrderNotFound  = errors.New("No order found with the given ID")
+	errCouponNotFound = errors.New("No coupon found with the given code")
+	errCouponExists   = errors.New("A coupon with this code already exists")
+	errCouponExpired  = errors.New("The coupon has expired")
+	errCouponUsedUp   = errors.New("The coupon has reached its usage limit")
+	errEmptyCart      = errors.New("The cart is empty")
+	errPaymentFailed  = errors.New("The payment could not be processed")
+)
+
+// couponCode is the format of coupon codes
+var couponCode = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// Coupons
+
+// CreateCoupon stores a new coupon
+func (s *courseStore) CreateCoupon(c Coupon) (Coupon, error) {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	switch {
+	case !couponCode.MatchString(c.Code):
+		return Coupon{}, errors.New("coupon code must be 3 to 32 letters, digits, '-' or '_'")
+	case (c.Percent == 0) == (c.Amount == nil):
+		return Coupon{}, errors.New("a coupon needs either percent or amount")
+	case c.Percent < 0 || c.Percent > 100:
+		return Coupon{}, errors.New("percent must be between 1 and 100")
+	case c.Amount != nil && c.Amount.Amount <= 0:
+		return Coupon{}, errors.New("amount must be positive")
+	case c.MaxUses < 0:
+		return Coupon{}, errors.New("max_uses must not be negative")
+	}
+	c.Uses = 0
+	c.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	if _, ok := s.coupons[c.Code]; ok {
+		s.mu.Unlock()
+		return Coupon{}, errCouponExists
+	}
+	s.coupons[c.Code] = c
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return c, nil
+}
+
+// Coupons returns every coupon sorted by code
+func (s *courseStore) Coupons() []Coupon {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Coupon, 0, len(s.coupons))
+	for _, c := range s.coupons {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// Coupon returns the coupon with the given code
+func (s *courseStore) Coupon(code string) (Coupon, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if c, ok := s.coupons[strings.ToUpper(code)]; ok {
+		return c, nil
+	}
+	return Coupon{}, errCouponNotFound
+}
+
+// DeleteCoupon removes a coupon; carts that applied it stop getting a discount
+func (s *courseStore) DeleteCoupon(code string) error {
+	s.mu.Lock()
+	code = strings.ToUpper(code)
+	_, ok := s.coupons[code]
+	delete(s.coupons, code)
+	s.mu.Unlock()
+
+	if !ok {
+		return errCouponNotFound
+	}
+	s.catalogChanged()
+	return nil
+}
+
+// usable reports why a coupon can't be used at the given time, if it can't
+func (c Coupon) usable(now time.Time) error {
+	switch {
+	case c.ExpiresAt != nil && now.After(*c.ExpiresAt):
+		return errCouponExpired
+	case c.MaxUses > 0 && c.Uses >= c.MaxUses:
+		return errCouponUsedUp
+	}
+	return nil
+}
+
+// discount returns what a coupon takes off a subtotal at the given time
+func (c Coupon) discount(subtotal Money, now time.Time) (Money, error) {
+	if err := c.usable(now); err != nil {
+		return Money{}, err
+	}
+	switch {
+	case c.Amount == nil:
+		return subtotal.Share(c.Percent * 100), nil
+	case c.Amount.Currency != subtotal.Currency:
+		return Money{}, fmt.Errorf("The coupon is for %s prices", c.Amount.Currency)
+	case c.Amount.Amount > subtotal.Amount:
+		// A fixed discount never makes the total negative
+		return subtotal, nil
+	}
+	return *c.Amount, nil
+}
+
+// Carts
+
+// CreateCart opens an empty cart for a learner
+func (s *courseStore) CreateCart(learnerID, region string) (Cart, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if _, ok := taxRates[region]; region != "" && !ok {
+		return Cart{}, fmt.Errorf("unsupported tax region %q", region)
+	}
+
+	s.mu.Lock()
+	if s.learnerIndex(learnerID) < 0 {
+		s.mu.Unlock()
+		return Cart{}, errLearnerNotFound
+	}
+	cart := Cart{ID: strconv.Itoa(s.nextCartID), LearnerID: learnerID, Region: region, Items: []CartItem{}}
+	s.nextCartID++
+	s.carts[cart.ID] = cart
+	cart, _ = s.quoteCart(cart)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return cart, nil
+}
+
+// Cart returns a cart priced with the current course prices
+func (s *courseStore) Cart(id string) (Cart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cart, ok := s.carts[id]
+	if !ok {
+		return Cart{}, errCartNotFound
+	}
+	cart, _ = s.quoteCart(cart)
+	return cart, nil
+}
+
+// updateCart applies fn to a copy of a cart and stores it if fn succeeds
+func (s *courseStore) updateCart(id string, fn func(cart *Cart) error) (Cart, error) {
+	s.mu.Lock()
+	cart, ok := s.carts[id]
+	if !ok {
+		s.mu.Unlock()
+		return Cart{}, errCartNotFound
+	}
+	cart.Items = slices.Clone(cart.Items)
+	if err := fn(&cart); err != nil {
+		s.mu.Unlock()
+		return Cart{}, err
+	}
+	s.carts[id] = cart
+	cart, _ = s.quoteCart(cart)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return cart, nil
+}
+
+// AddToCart adds a course the learner isn't enrolled in yet
+func (s *courseStore) AddToCart(id, courseID string) (Cart, error) {
+	return s.updateCart(id, func(cart *Cart) error {
+		i := s.index(courseID)
+		switch {
+		case i < 0:
+			return errCourseNotFound
+		case !s.courses[i].Live():
+			return errCourseNotPublished
+		case slices.ContainsFunc(cart.Items, func(item CartItem) bool { return item.CourseID == courseID }):
+			return errors.New("The course is already in the cart")
+		case slices.ContainsFunc(s.enrollments, func(e Enrollment) bool {
+			return e.CourseID == courseID && e.LearnerID == cart.LearnerID
+		}):
+			return errAlreadyEnrolled
+		case len(cart.Items) > 0:
+			// Totals are only meaningful in a single currency
+			if first := s.index(cart.Items[0].CourseID); first >= 0 &&
+				s.courses[first].Price.Currency != s.courses[i].Price.Currency {
+				return errors.New("A cart can only hold courses priced in one currency")
+			}
+		}
+		cart.Items = append(cart.Items, CartItem{CourseID: courseID})
+		return nil
+	})
+}
+
+// RemoveFromCart removes a course from a cart
+func (s *courseStore) RemoveFromCart(id, courseID string) (Cart, error) {
+	return s.updateCart(id, func(cart *Cart) error {
+		i := slices.IndexFunc(cart.Items, func(item CartItem) bool { return item.CourseID == courseID })
+		if i < 0 {
+			return errCourseNotFound
+		}
+		cart.Items = slices.Delete(cart.Items, i, i+1)
+		return nil
+	})
+}
+
+// SetCartOptions sets the tax region and coupon of a cart. An empty coupon
+// removes the current one.
+func (s *courseStore) SetCartOptions(id, region, coupon string) (Cart, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	coupon = strings.ToUpper(strings.TrimSpace(coupon))
+	if _, ok := taxRates[region]; region != "" && !ok {
+		return Cart{}, fmt.Errorf("unsupported tax region %q", region)
+	}
+
+	return s.updateCart(id, func(cart *Cart) error {
+		if coupon != "" {
+			c, ok := s.coupons[coupon]
+			if !ok {
+				return errCouponNotFound
+			}
+			// Reject coupons that can't be used now, rather than at checkout
+			if err := c.usable(time.Now()); err != nil {
+				return err
+			}
+		}
+		cart.Region = region
+		cart.Coupon = coupon
+		return nil
+	})
+}
+
+// DeleteCart discards a cart
+func (s *courseStore) DeleteCart(id string) error {
+	s.mu.Lock()
+	_, ok := s.carts[id]
+	delete(s.carts, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return errCartNotFound
+	}
+	s.catalogChanged()
+	return nil
+}
+
+// quoteCart prices a cart: it fills in the item names and prices from the
+// store (dropping deleted courses) and computes the totals. The error reports
+// why the cart can't be checked out. Called with s.mu held.
+func (s *courseStore) quoteCart(cart Cart) (Cart, error) {
+	items := []CartItem{}
+	for _, item := range cart.Items {
+		if i := s.index(item.CourseID); i >= 0 {
+			c := s.courses[i]
+			items = append(items, CartItem{CourseID: c.ID, Name: c.Name, Price: c.Price})
+		}
+	}
+	cart.Items = items
+	cart.Quote = Quote{}
+
+	currency := defaultCurrency
+	if len(items) > 0 {
+		currency = items[0].Price.Currency
+	}
+	subtotal := Money{Currency: currency}
+	for _, item := range items {
+		var err error
+		if subtotal, err = subtotal.Add(item.Price); err != nil {
+			return cart, err
+		}
+	}
+
+	discount := Money{Currency: currency}
+	var couponErr error
+	if cart.Coupon != "" {
+		if c, ok := s.coupons[cart.Coupon]; !ok {
+			couponErr = errCouponNotFound
+		} else if discount, couponErr = c.discount(subtotal, time.Now()); couponErr != nil {
+			discount = Money{Currency: currency}
+		}
+	}
+	if couponErr != nil {
+		cart.CouponError = couponErr.Error()
+	}
+
+	discounted, err := subtotal.Sub(discount)
+	if err != nil {
+		return cart, err
+	}
+	cart.TaxRate = taxRates[cart.Region]
+	tax := discounted.Share(cart.TaxRate)
+	total, err := discounted.Add(tax)
+	if err != nil {
+		return cart, err
+	}
+
+	cart.Subtotal, cart.Discount, cart.Tax, cart.Total = subtotal, discount, tax, total
+	switch {
+	case len(items) == 0:
+		return cart, errEmptyCart
+	case cart.Region == "":
+		return cart, errors.New("Choose a tax region before checking out")
+	}
+	return cart, couponErr
+}
+
+// Orders
+
+// Checkout charges a cart and turns it into an order. Checkouts run one at a
+// time, so a coupon's usage limit can't be exceeded by concurrent orders.
+func (s *courseStore) Checkout(ctx context.Context, cartID, paymentToken string) (Order, error) {
+	s.checkoutMu.Lock()
+	defer s.checkoutMu.Unlock()
+
+	s.mu.Lock()
+	cart, ok := s.carts[cartID]
+	if !ok {
+		s.mu.Unlock()
+		return Order{}, errCartNotFound
+	}
+	cart, err := s.quoteCart(cart)
+	if err != nil {
+		s.mu.Unlock()
+		return Order{}, err
+	}
+	order := Order{
+		LearnerID: cart.LearnerID,
+		Region:    cart.Region,
+		Coupon:    cart.Coupon,
+		Items:     cart.Items,
+		Quote:     cart.Quote,
+	}
+	s.mu.Unlock()
+
+	// Charge without holding the store lock; free orders skip the provider
+	if order.Total.Amount > 0 {
+		// A cart is checked out at most once, so it identifies the charge
+		req := PaymentRequest{Reference: s.tenant + "/cart-" + cartID, Amount: order.Total, Token: paymentToken}
+		payment, err := payments.Charge(ctx, req)
+		switch {
+		case errors.Is(err, errPaymentDeclined):
+			return Order{}, err
+		case err != nil:
+			return Order{}, fmt.Errorf("%w: %v", errPaymentFailed, err)
+		}
+		order.Payment = &payment
+	}
+	order.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	order.ID = strconv.Itoa(s.nextOrderID)
+	s.nextOrderID++
+	s.orders = append(s.orders, order)
+	if c, ok := s.coupons[order.Coupon]; ok {
+		c.Uses++
+		s.coupons[order.Coupon] = c
+	}
+	for _, item := range order.Items {
+		// Courses can't be added to a cart once enrolled, but the learner may
+		// have enrolled some other way since
+		if !slices.ContainsFunc(s.enrollments, func(e Enrollment) bool {
+			return e.CourseID == item.CourseID && e.LearnerID == order.LearnerID
+		}) {
+			s.addEnrollment(item.CourseID, order.LearnerID)
+		}
+	}
+	delete(s.carts, cartID)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return order, nil
+}
+
+// Order returns the order with the given ID
+func (s *courseStore) Order(id string) (Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, o := range s.orders {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return Order{}, errOrderNotFound
+}
+
+// LearnerOrders returns the orders of a learner, oldest first
+func (s *courseStore) LearnerOrders(learnerID string) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.learnerIndex(learnerID) < 0 {
+		return nil, errLearnerNotFound
+	}
+	list := []Order{}
+	for _, o := range s.orders {
+		if o.LearnerID == learnerID {
+			list = append(list, o)
+		}
+	}
+	return list, nil
+}
+
+// Controller for coupons, carts and orders
+
+// authorizeCart checks that the caller may use the cart a request names. It
+// reports false after responding.
+func authorizeCart(w http.ResponseWriter, r *http.Request) bool {
+	cart, err := storeFrom(r).Cart(mux.Vars(r)["cart"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return false
+	}
+	return authorizeLearner(w, r, cart.LearnerID)
+}
+
+// createCoupon creates a discount code
+// POST /coupons  {"code": "LAUNCH20", "percent": 20, "max_uses": 100}
+func createCoupon(w http.ResponseWriter, r *http.Request) {
+	var c Coupon
+	if renderDecodeError(w, r, decodeBody(r, &c)) {
+		return
+	}
+	c, err := storeFrom(r).CreateCoupon(c)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusCreated, c)
+}
+
+// getCoupons lists every coupon
+// GET /coupons
+func getCoupons(w http.ResponseWriter, r *http.Request) {
+	render(w, r, http.StatusOK, storeFrom(r).Coupons())
+}
+
+// getCoupon returns one coupon
+// GET /coupons/{code}
+func getCoupon(w http.ResponseWriter, r *http.Request) {
+	c, err := storeFrom(r).Coupon(mux.Vars(r)["code"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, c)
+}
+
+// deleteCoupon removes a coupon
+// DELETE /coupons/{code}
+func deleteCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := storeFrom(r).DeleteCoupon(mux.Vars(r)["code"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Coupon deleted successfully")
+}
+
+// createCart opens a cart for a learner
+// POST /carts  {"learner_id": "1", "region": "DE"}
+func createCart(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LearnerID string `json:"learner_id"`
+		Region    string `json:"region"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) {
+		return
+	}
+	if !authorizeLearner(w, r, body.LearnerID) {
+		return
+	}
+	cart, err := storeFrom(r).CreateCart(body.LearnerID, body.Region)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusCreated, cart)
+}
+
+// getCart returns a cart with its current totals
+// GET /carts/{cart}
+func getCart(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCart(w, r) {
+		return
+	}
+	cart, err := storeFrom(r).Cart(mux.Vars(r)["cart"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, cart)
+}
+
+// updateCart sets the tax region and coupon of a cart
+// PUT /carts/{cart}  {"region": "US-CA", "coupon": "LAUNCH20"}
+func updateCart(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Region string `json:"region"`
+		Coupon string `json:"coupon"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) || !authorizeCart(w, r) {
+		return
+	}
+	cart, err := storeFrom(r).SetCartOptions(mux.Vars(r)["cart"], body.Region, body.Coupon)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, cart)
+}
+
+// deleteCart discards a cart
+// DELETE /carts/{cart}
+func deleteCart(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCart(w, r) {
+		return
+	}
+	if err := storeFrom(r).DeleteCart(mux.Vars(r)["cart"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Cart deleted successfully")
+}
+
+// addCartItem adds a course to a cart
+// POST /carts/{cart}/items  {"course_id": "2"}
+func addCartItem(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CourseID string `json:"course_id"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) || !authorizeCart(w, r) {
+		return
+	}
+	cart, err := storeFrom(r).AddToCart(mux.Vars(r)["cart"], body.CourseID)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, cart)
+}
+
+// removeCartItem removes a course from a cart
+// DELETE /carts/{cart}/items/{course}
+func removeCartItem(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCart(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	cart, err := storeFrom(r).RemoveFromCart(vars["cart"], vars["course"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, cart)
+}
+
+// checkoutCart pays for a cart and returns the order
+// POST /carts/{cart}/checkout  {"payment_token": "tok_ok"}
+func checkoutCart(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PaymentToken string `json:"payment_token"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) || !authorizeCart(w, r) {
+		return
+	}
+
+	order, err := storeFrom(r).Checkout(r.Context(), mux.Vars(r)["cart"], body.PaymentToken)
+	switch {
+	case errors.Is(err, errPaymentDeclined):
+		render(w, r, http.StatusPaymentRequired, err.Error())
+	case errors.Is(err, errPaymentFailed):
+		// Nothing was charged or recorded, so the client can simply retry
+		render(w, r, http.StatusBadGateway, err.Error())
+	case err != nil:
+		renderStoreError(w, r, err)
+	default:
+		render(w, r, http.StatusCreated, order)
+	}
+}
+
+// getOrder returns one order
+// GET /orders/{order}
+func getOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := storeFrom(r).Order(mux.Vars(r)["order"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	if !authorizeLearner(w, r, order.LearnerID) {
+		return
+	}
+	render(w, r, http.StatusOK, order)
+}
+
+// getLearnerOrders lists a learner's orders
+// GET /learners/{learner}/orders
+func getLearnerOrders(w http.ResponseWriter, r *http.Request) {
+	learnerID := mux.Vars(r)["learner"]
+	if !authorizeLearner(w, r, learnerID) {
+		return
+	}
+	list, err := storeFrom(r).LearnerOrders(learnerID)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, list)
+}
+
+// registerCheckoutRoutes adds the coupon, cart and order routes
+func registerCheckoutRoutes(r *mux.Router) {
+	r.HandleFunc("/coupons", requireAdmin(getCoupons)).Methods("GET")
+	r.HandleFunc("/coupons", requireAdmin(createCoupon)).Methods("POST")
+	r.HandleFunc("/coupons/{code}", getCoupon).Methods("GET")
+	r.HandleFunc("/coupons/{code}", requireAdmin(deleteCoupon)).Methods("DELETE")
+
+	r.HandleFunc("/carts", createCart).Methods("POST")
+	r.HandleFunc("/carts/{cart}", getCart).Methods("GET")
+	r.HandleFunc("/carts/{cart}", updateCart).Methods("PUT")
+	r.HandleFunc("/carts/{cart}", deleteCart).Methods("DELETE")
+	r.HandleFunc("/carts/{cart}/items", addCartItem).Methods("POST")
+	r.HandleFunc("/carts/{cart}/items/{course}", removeCartItem).Methods("DELETE")
+	r.HandleFunc("/carts/{cart}/checkout", checkoutCart).Methods("POST").Name("checkout")
+
+	r.HandleFunc("/orders/{order}", getOrder).Methods("GET")
+	r.HandleFunc("/learners/{learner}/orders", getLearnerOrders).Methods("GET")
+}
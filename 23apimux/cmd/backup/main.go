@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Backup client - main.go
+// backup takes, lists, moves and restores backups of a tenant's catalog
+// through the server's /admin/backups routes (see backup.go):
+//
+//	go run ./cmd/backup create
+//	go run ./cmd/backup list
+//	go run ./cmd/backup download 20261019T164502.123Z ./offsite/
+//	go run ./cmd/backup upload ./offsite/20261019T164502.123Z.tar.gz
+//	go run ./cmd/backup verify 20261019T164502.123Z
+//	go run ./cmd/backup -tenant physics -api-key $ADMIN_KEY restore 20261019T164502.123Z
+//
+// Downloads and uploads are checked end to end against the archive's
+// SHA-256, so a copy kept elsewhere is known to be intact.
+
+const usage = `usage: backup [flags] <command> [arguments]
+
+commands:
+  create                 take a backup now
+  list                   list the backups, newest first
+  download NAME [PATH]   save a backup's archive (PATH may be a directory)
+  upload FILE [NAME]     send an archive to the server, named after FILE by default
+  verify NAME            check a backup's integrity on the server
+  restore NAME           replace the tenant's data with a backup
+  delete NAME            remove a backup
+
+flags:
+`
+
+const backupExt = ".tar.gz"
+
+// client talks to the server's backup routes
+type client struct {
+	baseURL string // e.g. http://localhost:4000
+	tenant  string // Sent as X-Tenant when set
+	apiKey  string // Sent as X-API-Key when set
+	http    *http.Client
+}
+
+// backupInfo is a backup as the server describes it
+type backupInfo struct {
+	Name      string         `json:"name"`
+	Tenant    string         `json:"tenant"`
+	CreatedAt time.Time      `json:"created_at"`
+	Size      int64          `json:"size"`
+	SHA256    string         `json:"sha256"`
+	Counts    map[string]int `json:"counts"`
+}
+
+func main() {
+	c, args, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := c.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		os.Exit(1)
+	}
+}
+
+// parseFlags reads the flags and returns the command with its arguments
+func parseFlags(args []string) (*client, []string, error) {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	baseURL := fs.String("url", "http://localhost:4000", "server to talk to")
+	tenant := fs.String("tenant", "", "tenant to send as X-Tenant")
+	apiKey := fs.String("api-key", os.Getenv("API_KEY"), "admin key of the tenant to send as X-API-Key (default $API_KEY)")
+	timeout := fs.Duration("timeout", 10*time.Minute, "per-request timeout")
+	caFile := fs.String("cacert", "", "PEM CA certificates to trust for https:// (e.g. the server's development ca.pem)")
+	certFile := fs.String("cert", "", "PEM client certificate for mutual TLS")
+	keyFile := fs.String("key", "", "PEM key of the -cert client certificate")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, nil, errors.New("no command given")
+	}
+
+	tlsConfig, err := clientTLSConfig(*caFile, *certFile, *keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &client{
+		baseURL: strings.TrimSuffix(*baseURL, "/"),
+		tenant:  *tenant,
+		apiKey:  *apiKey,
+		http: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+		},
+	}, fs.Args(), nil
+}
+
+// clientTLSConfig trusts the CAs in caFile besides the system ones and
+// presents the client certificate, when given
+func clientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("-cert and -key go together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// run runs a command
+func (c *client) run(args []string) error {
+	cmd, args := args[0], args[1:]
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	need := func(n int) error {
+		if len(args) < n {
+			return fmt.Errorf("%s needs %d argument(s); see -h", cmd, n)
+		}
+		return nil
+	}
+
+	switch cmd {
+	case "create":
+		var info backupInfo
+		if err := c.call("POST", "/admin/backups", nil, http.StatusCreated, &info); err != nil {
+			return err
+		}
+		printBackups(os.Stdout, []backupInfo{info})
+	case "list":
+		var list []backupInfo
+		if err := c.call("GET", "/admin/backups", nil, http.StatusOK, &list); err != nil {
+			return err
+		}
+		printBackups(os.Stdout, list)
+	case "download":
+		if err := need(1); err != nil {
+			return err
+		}
+		return c.download(arg(0), arg(1))
+	case "upload":
+		if err := need(1); err != nil {
+			return err
+		}
+		return c.upload(arg(0), arg(1))
+	case "verify":
+		if err := need(1); err != nil {
+			return err
+		}
+		if err := c.call("GET", "/admin/backups/"+arg(0)+"/verify", nil, http.StatusOK, nil); err != nil {
+			return err
+		}
+		fmt.Println("Backup", arg(0), "is intact")
+	case "restore":
+		if err := need(1); err != nil {
+			return err
+		}
+		if err := c.call("POST", "/admin/backups/"+arg(0)+"/restore", nil, http.StatusOK, nil); err != nil {
+			return err
+		}
+		fmt.Println("Restored backup", arg(0))
+	case "delete":
+		if err := need(1); err != nil {
+			return err
+		}
+		if err := c.call("DELETE", "/admin/backups/"+arg(0), nil, http.StatusOK, nil); err != nil {
+			return err
+		}
+		fmt.Println("Deleted backup", arg(0))
+	default:
+		return fmt.Errorf("unknown command %q; see -h", cmd)
+	}
+	return nil
+}
+
+// request builds a request with the client's headers
+func (c *client) request(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant", c.tenant)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	return req, nil
+}
+
+// call sends a request and decodes the JSON response into v, if given
+func (c *client) call(method, path string, body io.Reader, want int, v any) error {
+	req, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// responseError turns an error response into an error with the server's message
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var message string
+	if json.Unmarshal(data, &message) != nil {
+		message = strings.TrimSpace(string(data))
+	}
+	return fmt.Errorf("%s: %s", resp.Status, message)
+}
+
+// download saves a backup's archive to path, through a temporary file that
+// is only kept if it matches the server's checksum
+func (c *client) download(name, path string) error {
+	switch info, err := os.Stat(path); {
+	case path == "":
+		path = name + backupExt
+	case err == nil && info.IsDir():
+		path = filepath.Join(path, name+backupExt)
+	}
+
+	req, err := c.request("GET", "/admin/backups/"+name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	want := resp.Header.Get("X-Backup-SHA256")
+	if want == "" {
+		return errors.New("the server sent no checksum; verify the backup on the server first")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != want {
+		return fmt.Errorf("download is damaged: SHA-256 %s, expected %s", sum, want)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".sha256", []byte(want+"  "+filepath.Base(path)+"\n"), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s (%d bytes, SHA-256 %s)\n", path, n, want)
+	return nil
+}
+
+// upload sends an archive to the server under name, which defaults to the
+// file name without its extension
+func (c *client) upload(path, name string) error {
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), backupExt)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := c.request("PUT", "/admin/backups/"+name, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Backup-SHA256", hex.EncodeToString(hash.Sum(nil)))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	var info backupInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	printBackups(os.Stdout, []backupInfo{info})
+	return nil
+}
+
+// printBackups prints backups as a table
+func printBackups(w io.Writer, list []backupInfo) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTENANT\tCREATED\tSIZE\tCOURSES\tAUTHORS\tMATERIALS\tSHA-256")
+	for _, b := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.12s\n",
+			b.Name, b.Tenant, b.CreatedAt.Local().Format(time.DateTime), b.Size,
+			b.Counts["courses"], b.Counts["authors"], b.Counts["materials"], b.SHA256)
+	}
+	tw.Flush()
+}
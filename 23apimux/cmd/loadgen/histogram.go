@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Latency histogram - histogram.go
+// An HDR-style histogram: values are recorded in microseconds into buckets
+// whose width grows with the value, so every recorded value is kept to three
+// significant digits (within 0.1%) from 1µs up to an hour, in a fixed ~24k
+// counters. Each worker records into its own histogram and they are merged
+// once the run is over, so recording never takes a lock.
+
+const (
+	subBucketBits  = 11                                  // 2048 sub-buckets: 1/1024 precision
+	subBucketCount = 1 << subBucketBits                  // Values below this have a counter each
+	subBucketHalf  = subBucketCount / 2                  // Counters per doubling above that
+	maxTrackable   = int64(time.Hour / time.Microsecond) // Longer latencies are recorded as an hour
+)
+
+// histogram counts latencies
+type histogram struct {
+	counts []int64
+	total  int64
+	min    int64   // Smallest value recorded, in µs
+	max    int64   // Largest value recorded, in µs
+	sum    float64 // For the mean
+	sumSq  float64 // For the standard deviation
+}
+
+// newHistogram creates an empty histogram
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, bucketIndex(maxTrackable)+1), min: math.MaxInt64}
+}
+
+// bucketIndex returns the counter of a value. Values below subBucketCount
+// have a counter each; above that, each doubling of the value shares
+// subBucketHalf counters.
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	top := v >> shift // In [subBucketHalf, subBucketCount)
+	return subBucketCount + (shift-1)*subBucketHalf + int(top-subBucketHalf)
+}
+
+// highestEquivalent returns the largest value that lands in counter i
+func highestEquivalent(i int) int64 {
+	if i < subBucketCount {
+		return int64(i)
+	}
+	shift := (i-subBucketCount)/subBucketHalf + 1
+	top := int64((i-subBucketCount)%subBucketHalf + subBucketHalf)
+	return (top+1)<<shift - 1
+}
+
+// Record adds one latency; values past an hour are counted as an hour
+func (h *histogram) Record(d time.Duration) {
+	v := min(max(d.Microseconds(), 0), maxTrackable)
+	h.counts[bucketIndex(v)]++
+	h.total++
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+	h.sum += float64(v)
+	h.sumSq += float64(v) * float64(v)
+}
+
+// Merge adds the counts of other
+func (h *histogram) Merge(other *histogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.min = min(h.min, other.min)
+	h.max = max(h.max, other.max)
+	h.sum += other.sum
+	h.sumSq += other.sumSq
+}
+
+// Percentile returns the latency that p percent of the recorded values are at
+// or below
+func (h *histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := max(int64(math.Ceil(p/100*float64(h.total))), 1)
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return micros(min(highestEquivalent(i), h.max))
+		}
+	}
+	return micros(h.max)
+}
+
+// Mean returns the average latency
+func (h *histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return micros(int64(h.sum / float64(h.total)))
+}
+
+// StdDev returns the standard deviation of the latencies
+func (h *histogram) StdDev() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	mean := h.sum / float64(h.total)
+	variance := max(h.sumSq/float64(h.total)-mean*mean, 0)
+	return micros(int64(math.Sqrt(variance)))
+}
+
+// Min returns the smallest latency recorded
+func (h *histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return micros(h.min)
+}
+
+// Max returns the largest latency recorded
+func (h *histogram) Max() time.Duration {
+	return micros(h.max)
+}
+
+func micros(v int64) time.Duration {
+	return time.Duration(v) * time.Microsecond
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Histogram tests - histogram_test.go
+// Bucket boundaries are checked where the bucket width doubles, and
+// percentiles against values worked out by hand: exact below 2048µs, the top
+// of the value's bucket (within 0.1%) above that.
+
+func TestBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       int64 // µs
+		wantIndex   int
+		wantHighest int64 // Largest value sharing the bucket
+	}{
+		{"zero", 0, 0, 0},
+		{"one", 1, 1, 1},
+		{"last exact counter", 2047, 2047, 2047},
+		{"first shared counter", 2048, 2048, 2049},
+		{"shares the first shared counter", 2049, 2048, 2049},
+		{"second shared counter", 2050, 2049, 2051},
+		{"end of the first doubling", 4095, 3071, 4095},
+		{"start of the second doubling", 4096, 3072, 4099},
+		{"just past a bucket", 4100, 3073, 4103},
+		{"an hour", maxTrackable, 23220, 3600809983},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := bucketIndex(tt.value)
+			if i != tt.wantIndex {
+				t.Errorf("bucketIndex(%d) = %d, want %d", tt.value, i, tt.wantIndex)
+			}
+			if got := highestEquivalent(i); got != tt.wantHighest {
+				t.Errorf("highestEquivalent(%d) = %d, want %d", i, got, tt.wantHighest)
+			}
+		})
+	}
+
+	if n := len(newHistogram().counts); n != bucketIndex(maxTrackable)+1 {
+		t.Errorf("histogram has %d counters, want %d", n, bucketIndex(maxTrackable)+1)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	// series records from..to in steps of unit
+	series := func(from, to int, unit time.Duration) []time.Duration {
+		var ds []time.Duration
+		for v := from; v <= to; v++ {
+			ds = append(ds, time.Duration(v)*unit)
+		}
+		return ds
+	}
+
+	tests := []struct {
+		name          string
+		record        []time.Duration
+		p50, p95, p99 time.Duration
+	}{
+		{"empty", nil, 0, 0, 0},
+		{"one value", []time.Duration{5 * time.Millisecond}, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond},
+		{"exact range", series(1, 100, time.Microsecond), 50 * time.Microsecond, 95 * time.Microsecond, 99 * time.Microsecond},
+		{"shared buckets", series(1, 100, time.Millisecond), 50015 * time.Microsecond, 95039 * time.Microsecond, 99007 * time.Microsecond},
+		{"slow tail", append(series(1, 98, time.Microsecond), time.Second, time.Second), 50 * time.Microsecond, 95 * time.Microsecond, time.Second},
+		{"capped at an hour", []time.Duration{2 * time.Hour}, time.Hour, time.Hour, time.Hour},
+		{"negative as zero", []time.Duration{-time.Millisecond}, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Record into two histograms and merge them, as the workers do
+			h, other := newHistogram(), newHistogram()
+			for i, d := range tt.record {
+				if i%2 == 0 {
+					h.Record(d)
+				} else {
+					other.Record(d)
+				}
+			}
+			h.Merge(other)
+
+			for _, p := range []struct {
+				percentile float64
+				want       time.Duration
+			}{{50, tt.p50}, {95, tt.p95}, {99, tt.p99}} {
+				if got := h.Percentile(p.percentile); got != p.want {
+					t.Errorf("p%g = %v, want %v", p.percentile, got, p.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load generator - main.go
+// loadgen drives a mix of course CRUD traffic at an API server and reports
+// throughput, error rate and latency percentiles:
+//
+//	go run ./cmd/loadgen -rate 500 -workers 16 -duration 30s -json run.json
+//	go run ./cmd/loadgen -rate 500 -workers 16 -duration 30s -baseline run.json
+//	go run ./cmd/loadgen -mix home=1 -duration 10s   # 22modules only serves /
+//	go run ./cmd/loadgen -url https://localhost:4000 -cacert $TLS_DIR/ca.pem
+//
+// With -rate, requests are started on a fixed schedule whether or not earlier
+// ones have finished, and latency is measured from when a request was due
+// rather than when a free worker sent it. A server that stalls is therefore
+// charged for the requests that queued up behind the stall, instead of the
+// stall hiding them (coordinated omission). Without -rate each worker sends
+// its next request as soon as the previous one is done.
+
+// config is what a run does, from the flags
+type config struct {
+	target   target
+	mix      mix
+	rate     float64       // Requests per second; 0 is as fast as the workers go
+	workers  int           // Concurrent requests
+	duration time.Duration // Run length; 0 runs until -requests are sent
+	requests int64         // Stops after this many requests; 0 is no limit
+	timeout  time.Duration // Per request
+	tls      *tls.Config   // For https:// URLs
+	jsonPath string        // Where to save the report; "-" is stdout
+	baseline string        // Report of an earlier run to compare with
+	quiet    bool          // No progress lines
+}
+
+func main() {
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var base *report
+	if cfg.baseline != "" {
+		if base, err = loadReport(cfg.baseline); err != nil {
+			log.Fatalf("Loading the baseline: %v", err)
+		}
+	}
+
+	// Ctrl-C ends the run early but still reports
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rep, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Keep stdout for the JSON when it is written there
+	out := io.Writer(os.Stdout)
+	if cfg.jsonPath == "-" {
+		out = os.Stderr
+	}
+	rep.print(out)
+	if base != nil {
+		fmt.Fprintln(out)
+		rep.compare(out, base)
+	}
+	if cfg.jsonPath != "" {
+		if err := rep.save(cfg.jsonPath); err != nil {
+			log.Fatalf("Saving the report: %v", err)
+		}
+	}
+}
+
+// parseFlags reads the command line
+func parseFlags(args []string) (config, error) {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	baseURL := fs.String("url", "http://localhost:4000", "server to load")
+	prefix := fs.String("prefix", "/v2", "API version prefix of the course routes (\"\" for the legacy routes)")
+	tenant := fs.String("tenant", "", "tenant to send as X-Tenant")
+	apiKey := fs.String("api-key", "", "API key to send as X-API-Key")
+	mixFlag := fs.String("mix", defaultMix, "weighted operations: "+fmt.Sprint(operations))
+	rate := fs.Float64("rate", 0, "target requests per second (0: as fast as possible)")
+	workers := fs.Int("workers", 8, "concurrent requests")
+	duration := fs.Duration("duration", 30*time.Second, "how long to run (0: until -requests are sent)")
+	requests := fs.Int64("requests", 0, "stop after this many requests (0: no limit)")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-request timeout")
+	jsonPath := fs.String("json", "", "save the report as JSON to this file (\"-\" for stdout)")
+	baseline := fs.String("baseline", "", "compare with the JSON report of an earlier run")
+	quiet := fs.Bool("quiet", false, "don't print progress every second")
+	caFile := fs.String("cacert", "", "PEM CA certificates to trust for https:// (e.g. the server's development ca.pem)")
+	certFile := fs.String("cert", "", "PEM client certificate for mutual TLS")
+	keyFile := fs.String("key", "", "PEM key of the -cert client certificate")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	m, err := parseMix(*mixFlag)
+	switch {
+	case err != nil:
+		return config{}, err
+	case *workers < 1:
+		return config{}, fmt.Errorf("-workers must be at least 1")
+	case *rate < 0:
+		return config{}, fmt.Errorf("-rate must not be negative")
+	case *duration <= 0 && *requests <= 0:
+		return config{}, fmt.Errorf("set -duration or -requests, or the run never ends")
+	}
+	tlsConfig, err := clientTLSConfig(*caFile, *certFile, *keyFile)
+	if err != nil {
+		return config{}, err
+	}
+	return config{
+		target: target{
+			baseURL: strings.TrimSuffix(*baseURL, "/"),
+			prefix:  strings.TrimSuffix(*prefix, "/"),
+			tenant:  *tenant,
+			apiKey:  *apiKey,
+		},
+		mix:      m,
+		rate:     *rate,
+		workers:  *workers,
+		duration: *duration,
+		requests: *requests,
+		timeout:  *timeout,
+		tls:      tlsConfig,
+		jsonPath: *jsonPath,
+		baseline: *baseline,
+		quiet:    *quiet,
+	}, nil
+}
+
+// clientTLSConfig trusts the CAs in caFile besides the system ones and
+// presents the client certificate, when given
+func clientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("-cert and -key go together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// workerStats is what one worker measured; workers don't share them
+type workerStats struct {
+	latency  *histogram
+	ops      map[operation]*opStats
+	statuses map[string]int64
+}
+
+// opStats is what a worker measured for one operation
+type opStats struct {
+	requests, errors int64
+	latency          *histogram
+}
+
+func newWorkerStats() *workerStats {
+	return &workerStats{latency: newHistogram(), ops: map[operation]*opStats{}, statuses: map[string]int64{}}
+}
+
+// record adds one finished request
+func (s *workerStats) record(op operation, status string, failed bool, latency time.Duration) {
+	o, ok := s.ops[op]
+	if !ok {
+		o = &opStats{latency: newHistogram()}
+		s.ops[op] = o
+	}
+	o.requests++
+	o.latency.Record(latency)
+	if failed {
+		o.errors++
+	}
+	s.latency.Record(latency)
+	s.statuses[status]++
+}
+
+// run sends the traffic and builds the report
+func run(ctx context.Context, cfg config) (*report, error) {
+	client := &http.Client{
+		Timeout: cfg.timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        cfg.workers,
+			MaxIdleConnsPerHost: cfg.workers,
+			IdleConnTimeout:     30 * time.Second,
+			TLSClientConfig:     cfg.tls,
+			ForceAttemptHTTP2:   true,
+		},
+	}
+
+	pool := &coursePool{}
+	if cfg.mix.needsCourses() {
+		if err := loadCourses(client, cfg.target, pool); err != nil {
+			return nil, fmt.Errorf("Listing the existing courses: %v", err)
+		}
+	}
+
+	if cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+
+	// The pacer hands out request start times; a zero time means "now"
+	started := time.Now()
+	due := make(chan time.Time, cfg.workers)
+	go pace(ctx, cfg, started, due)
+
+	var completed, failures, seq atomic.Int64
+	stats := make([]*workerStats, cfg.workers)
+	var wg sync.WaitGroup
+	for i := range stats {
+		stats[i] = newWorkerStats()
+		wg.Add(1)
+		go func(s *workerStats) {
+			defer wg.Done()
+			for at := range due {
+				if at.IsZero() {
+					at = time.Now()
+				}
+				op, status, failed := send(client, cfg.target, cfg.mix.pick(), pool, uint64(seq.Add(1)))
+				s.record(op, status, failed, time.Since(at))
+				completed.Add(1)
+				if failed {
+					failures.Add(1)
+				}
+			}
+		}(stats[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if !cfg.quiet {
+		progress(done, started, &completed, &failures)
+	}
+	<-done
+	elapsed := time.Since(started)
+
+	return buildReport(cfg, started, elapsed, stats), nil
+}
+
+// pace sends start times to the workers until the run is over: on a fixed
+// schedule with -rate, otherwise as fast as workers take them
+func pace(ctx context.Context, cfg config, started time.Time, due chan<- time.Time) {
+	defer close(due)
+	var interval time.Duration
+	if cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for i := int64(0); cfg.requests == 0 || i < cfg.requests; i++ {
+		var at time.Time
+		if interval > 0 {
+			at = started.Add(time.Duration(i) * interval)
+			timer.Reset(time.Until(at))
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case due <- at:
+		}
+	}
+}
+
+// send performs one operation and returns the operation actually sent, its
+// status ("error" when the request failed outright) and whether it counts as
+// an error
+func send(client *http.Client, t target, op operation, pool *coursePool, n uint64) (operation, string, bool) {
+	req, op, err := buildRequest(op, t, pool, n)
+	if err != nil {
+		return op, "error", true
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return op, "error", true
+	}
+	defer resp.Body.Close()
+
+	if op == opCreate && resp.StatusCode < http.StatusBadRequest {
+		var created struct {
+			ID string `json:"id"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&created) == nil && created.ID != "" {
+			pool.add(created.ID)
+		}
+	}
+	// Drain the body so the connection is reused
+	io.Copy(io.Discard, resp.Body)
+	return op, strconv.Itoa(resp.StatusCode), resp.StatusCode >= http.StatusBadRequest
+}
+
+// progress prints the request rate every second until done is closed
+func progress(done <-chan struct{}, started time.Time, completed, failures *atomic.Int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last int64
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			n := completed.Load()
+			fmt.Fprintf(os.Stderr, "%5.0fs  %7d requests  %7d req/s  %d errors\n",
+				now.Sub(started).Seconds(), n, n-last, failures.Load())
+			last = n
+		}
+	}
+}
+
+// buildReport merges the workers' measurements
+func buildReport(cfg config, started time.Time, elapsed time.Duration, stats []*workerStats) *report {
+	latency := newHistogram()
+	ops := map[operation]*opStats{}
+	rep := &report{
+		Target:     cfg.target.baseURL + cfg.target.prefix,
+		Mix:        cfg.mix.String(),
+		Workers:    cfg.workers,
+		Rate:       cfg.rate,
+		Started:    started.UTC(),
+		Elapsed:    elapsed.Seconds(),
+		Statuses:   map[string]int64{},
+		Operations: map[operation]operationReport{},
+	}
+	for _, s := range stats {
+		latency.Merge(s.latency)
+		for status, n := range s.statuses {
+			rep.Statuses[status] += n
+		}
+		for op, o := range s.ops {
+			total, ok := ops[op]
+			if !ok {
+				total = &opStats{latency: newHistogram()}
+				ops[op] = total
+			}
+			total.requests += o.requests
+			total.errors += o.errors
+			total.latency.Merge(o.latency)
+		}
+	}
+
+	for op, o := range ops {
+		rep.Requests += o.requests
+		rep.Errors += o.errors
+		rep.Operations[op] = operationReport{Requests: o.requests, Errors: o.errors, Latency: summarize(o.latency)}
+	}
+	rep.Latency = summarize(latency)
+	if rep.Requests > 0 {
+		rep.ErrorRate = float64(rep.Errors) / float64(rep.Requests)
+	}
+	if rep.Elapsed > 0 {
+		rep.Throughput = float64(rep.Requests) / rep.Elapsed
+	}
+	return rep
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Results - report.go
+// A report sums up a run: throughput, error rate, status codes and latency
+// percentiles, overall and per operation. It prints as a table and is saved
+// as JSON; a saved report can be passed back with -baseline to compare runs.
+
+// latencySummary holds latency statistics in milliseconds
+type latencySummary struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p99_9"`
+	Max    float64 `json:"max"`
+}
+
+// operationReport sums up the requests of one operation
+type operationReport struct {
+	Requests int64          `json:"requests"`
+	Errors   int64          `json:"errors"`
+	Latency  latencySummary `json:"latency_ms"`
+}
+
+// report is the result of a run
+type report struct {
+	Target     string                        `json:"target"`
+	Mix        string                        `json:"mix"`
+	Workers    int                           `json:"workers"`
+	Rate       float64                       `json:"target_rate,omitempty"` // Requests per second; 0 is as fast as possible
+	Started    time.Time                     `json:"started"`
+	Elapsed    float64                       `json:"elapsed_seconds"`
+	Requests   int64                         `json:"requests"`
+	Errors     int64                         `json:"errors"`     // Transport errors and 4xx/5xx responses
+	ErrorRate  float64                       `json:"error_rate"` // Errors / requests
+	Throughput float64                       `json:"throughput"` // Completed requests per second
+	Statuses   map[string]int64              `json:"statuses"`   // By status code; "error" for transport errors
+	Latency    latencySummary                `json:"latency_ms"`
+	Operations map[operation]operationReport `json:"operations"`
+}
+
+// summarize reads the statistics of a histogram
+func summarize(h *histogram) latencySummary {
+	return latencySummary{
+		Min:    ms(h.Min()),
+		Mean:   ms(h.Mean()),
+		StdDev: ms(h.StdDev()),
+		P50:    ms(h.Percentile(50)),
+		P75:    ms(h.Percentile(75)),
+		P90:    ms(h.Percentile(90)),
+		P95:    ms(h.Percentile(95)),
+		P99:    ms(h.Percentile(99)),
+		P999:   ms(h.Percentile(99.9)),
+		Max:    ms(h.Max()),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// print writes the report as a table
+func (rep *report) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rate := "as fast as possible"
+	if rep.Rate > 0 {
+		rate = fmt.Sprintf("%g req/s", rep.Rate)
+	}
+	fmt.Fprintf(tw, "Target\t%s (%d workers, %s)\n", rep.Target, rep.Workers, rate)
+	fmt.Fprintf(tw, "Mix\t%s\n", rep.Mix)
+	fmt.Fprintf(tw, "Requests\t%d in %.1fs, %.1f req/s\n", rep.Requests, rep.Elapsed, rep.Throughput)
+	fmt.Fprintf(tw, "Errors\t%d (%.2f%%)\n", rep.Errors, 100*rep.ErrorRate)
+
+	fmt.Fprint(tw, "Statuses\t")
+	for _, s := range slices.Sorted(maps.Keys(rep.Statuses)) {
+		fmt.Fprintf(tw, "%s: %d  ", s, rep.Statuses[s])
+	}
+	fmt.Fprintln(tw)
+
+	l := rep.Latency
+	fmt.Fprintf(tw, "Latency\tmin %.2fms  mean %.2fms  stddev %.2fms  max %.2fms\n", l.Min, l.Mean, l.StdDev, l.Max)
+	fmt.Fprintf(tw, "\tp50 %.2fms  p75 %.2fms  p90 %.2fms  p95 %.2fms  p99 %.2fms  p99.9 %.2fms\n", l.P50, l.P75, l.P90, l.P95, l.P99, l.P999)
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "Operation\tRequests\tErrors\tp50\tp90\tp99\tmax")
+	for _, op := range operations {
+		o, ok := rep.Operations[op]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2fms\t%.2fms\t%.2fms\t%.2fms\n",
+			op, o.Requests, o.Errors, o.Latency.P50, o.Latency.P90, o.Latency.P99, o.Latency.Max)
+	}
+	tw.Flush()
+}
+
+// save writes the report as JSON to path, or to stdout for "-"
+func (rep *report) save(path string) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadReport reads a report saved by an earlier run
+func loadReport(path string) (*report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rep report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return &rep, nil
+}
+
+// compare prints how the report differs from a baseline run
+func (rep *report) compare(w io.Writer, base *report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "vs baseline\tBaseline\tThis run\tChange\t")
+	row := func(name string, before, after float64, unit string) {
+		fmt.Fprintf(tw, "%s\t%.2f%s\t%.2f%s\t%s\t\n", name, before, unit, after, unit, change(before, after))
+	}
+	row("throughput", base.Throughput, rep.Throughput, " req/s")
+	row("error rate", 100*base.ErrorRate, 100*rep.ErrorRate, "%")
+	row("p50", base.Latency.P50, rep.Latency.P50, "ms")
+	row("p90", base.Latency.P90, rep.Latency.P90, "ms")
+	row("p99", base.Latency.P99, rep.Latency.P99, "ms")
+	row("p99.9", base.Latency.P999, rep.Latency.P999, "ms")
+	row("max", base.Latency.Max, rep.Latency.Max, "ms")
+	tw.Flush()
+}
+
+// change formats the relative difference between two values
+func change(before, after float64) string {
+	if before == 0 {
+		if after == 0 {
+			return "~"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(after-before)/before)
+}
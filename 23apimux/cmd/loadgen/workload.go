@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Traffic mix - workload.go
+// A mix is a weighted set of operations, e.g. "list=50,get=30,create=10,
+// update=5,delete=5". Each request picks its operation at random by weight.
+// Gets and updates go to courses that exist; deletes only remove courses the
+// run created itself, so the server's own data survives a run.
+
+// operation is one kind of request
+type operation string
+
+const (
+	opHome   operation = "home"   // GET / (the only route of 22modules)
+	opList   operation = "list"   // GET /courses?page=1&per_page=20
+	opGet    operation = "get"    // GET /courses/{id}
+	opCreate operation = "create" // POST /courses
+	opUpdate operation = "update" // PUT /courses/{id}
+	opDelete operation = "delete" // DELETE /courses/{id}
+)
+
+// operations are the known operations, in report order
+var operations = []operation{opHome, opList, opGet, opCreate, opUpdate, opDelete}
+
+// defaultMix is a read-heavy catalog workload
+const defaultMix = "list=50,get=30,create=10,update=5,delete=5"
+
+// mix is a weighted choice of operations
+type mix struct {
+	ops     []operation
+	weights []int // Cumulative
+}
+
+// parseMix reads "op=weight,op=weight"
+func parseMix(s string) (mix, error) {
+	var m mix
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op := operation(strings.ToLower(strings.TrimSpace(name)))
+		if !ok || !slices.Contains(operations, op) {
+			return mix{}, fmt.Errorf("invalid mix entry %q: use op=weight with op one of %v", part, operations)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil || w < 0 {
+			return mix{}, fmt.Errorf("invalid weight in mix entry %q", part)
+		}
+		if w == 0 {
+			continue
+		}
+		total += w
+		m.ops = append(m.ops, op)
+		m.weights = append(m.weights, total)
+	}
+	if total == 0 {
+		return mix{}, errors.New("the mix has no operation with a positive weight")
+	}
+	return m, nil
+}
+
+// pick chooses an operation by weight
+func (m mix) pick() operation {
+	n := rand.IntN(m.weights[len(m.weights)-1])
+	i, _ := slices.BinarySearch(m.weights, n+1)
+	return m.ops[i]
+}
+
+// needsCourses reports whether the mix touches courses by ID
+func (m mix) needsCourses() bool {
+	return slices.ContainsFunc(m.ops, func(op operation) bool {
+		return op == opGet || op == opUpdate || op == opDelete
+	})
+}
+
+// String writes the mix back as "op=weight,..."
+func (m mix) String() string {
+	parts := make([]string, len(m.ops))
+	prev := 0
+	for i, op := range m.ops {
+		parts[i] = fmt.Sprintf("%s=%d", op, m.weights[i]-prev)
+		prev = m.weights[i]
+	}
+	return strings.Join(parts, ",")
+}
+
+// coursePool tracks the course IDs requests can use
+type coursePool struct {
+	mu      sync.Mutex
+	ids     []string // Every known course
+	created []string // Courses this run created, which may be deleted
+}
+
+// random returns a known course ID
+func (p *coursePool) random() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ids) == 0 {
+		return "", false
+	}
+	return p.ids[rand.IntN(len(p.ids))], true
+}
+
+// add records a course the run created
+func (p *coursePool) add(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids = append(p.ids, id)
+	p.created = append(p.created, id)
+}
+
+// takeCreated removes and returns a course the run created
+func (p *coursePool) takeCreated() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.created) == 0 {
+		return "", false
+	}
+	i := rand.IntN(len(p.created))
+	id := p.created[i]
+	p.created = slices.Delete(p.created, i, i+1)
+	if j := slices.Index(p.ids, id); j >= 0 {
+		p.ids = slices.Delete(p.ids, j, j+1)
+	}
+	return id, true
+}
+
+// target is the server under load and how to address it
+type target struct {
+	baseURL string // e.g. http://localhost:4000
+	prefix  string // API version prefix, e.g. /v2
+	tenant  string // Sent as X-Tenant when set
+	apiKey  string // Sent as X-API-Key when set
+}
+
+// newRequest builds a request with the target's headers
+func (t target) newRequest(method, path string, body string) (*http.Request, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, t.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.tenant != "" {
+		req.Header.Set("X-Tenant", t.tenant)
+	}
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+	return req, nil
+}
+
+// courseBody is the JSON of a course written by the load generator
+func courseBody(n uint64) string {
+	return fmt.Sprintf(`{"name":"Load test course %d","duration":"1h30m","price":19.99,"author":{"fullname":"Load Generator","email":"loadgen@example.com"}}`, n)
+}
+
+// loadCourses fills the pool with the courses already on the server
+func loadCourses(client *http.Client, t target, pool *coursePool) error {
+	req, err := t.newRequest("GET", t.prefix+"/courses", "")
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	var courses []struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&courses); err != nil {
+		return fmt.Errorf("GET %s: %v", req.URL, err)
+	}
+	for _, c := range courses {
+		pool.ids = append(pool.ids, c.ID)
+	}
+	return nil
+}
+
+// buildRequest prepares the request of an operation. Operations that need a
+// course fall back to a create when there isn't one, and the operation that
+// is actually sent is returned.
+func buildRequest(op operation, t target, pool *coursePool, n uint64) (*http.Request, operation, error) {
+	switch op {
+	case opHome:
+		req, err := t.newRequest("GET", "/", "")
+		return req, op, err
+	case opList:
+		req, err := t.newRequest("GET", t.prefix+"/courses?page=1&per_page=20", "")
+		return req, op, err
+	case opGet, opUpdate:
+		id, ok := pool.random()
+		if !ok {
+			break
+		}
+		if op == opGet {
+			req, err := t.newRequest("GET", t.prefix+"/courses/"+id, "")
+			return req, op, err
+		}
+		req, err := t.newRequest("PUT", t.prefix+"/courses/"+id, courseBody(n))
+		return req, op, err
+	case opDelete:
+		id, ok := pool.takeCreated()
+		if !ok {
+			break
+		}
+		req, err := t.newRequest("DELETE", t.prefix+"/courses/"+id, "")
+		return req, op, err
+	}
+	req, err := t.newRequest("POST", t.prefix+"/courses", courseBody(n))
+	return req, opCreate, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV representation - codec_csv.go
+// Each object is one row. Nested objects are flattened into dotted column names
+// ("author.fullname") and nested arrays are written as JSON in a single cell.
+// A single object is a one-row table; a plain scalar is a one-column "value" table.
+
+var csvCodec = &codec{
+	Name:       "csv",
+	MediaTypes: []string{"text/csv"},
+	encode: func(w io.Writer, v any) error {
+		tree, err := toTree(v)
+		if err != nil {
+			return err
+		}
+		return writeCSVTable(w, tree)
+	},
+	decode: func(r io.Reader, v any) error {
+		return decodeTreeInto(r, v, parseCSVTree)
+	},
+}
+
+// writeCSVTable writes a tree as a header row followed by one row per record
+func writeCSVTable(w io.Writer, tree any) error {
+	var records []any
+	switch tree := tree.(type) {
+	case []any:
+		records = tree
+	default:
+		records = []any{tree}
+	}
+
+	// Flatten every record and collect the union of columns in first-seen order
+	var columns []string
+	seen := map[string]bool{}
+	rows := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		row := map[string]string{}
+		flattenCSV("", record, row, func(col string) {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
+			}
+		})
+		rows = append(rows, row)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		line := make([]string, len(columns))
+		for i, col := range columns {
+			line[i] = row[col]
+		}
+		if err := cw.Write(line); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// flattenCSV writes node into row using dotted column names
+func flattenCSV(prefix string, node any, row map[string]string, addColumn func(string)) {
+	switch node := node.(type) {
+	case object:
+		for _, m := range node {
+			col := m.Key
+			if prefix != "" {
+				col = prefix + "." + m.Key
+			}
+			flattenCSV(col, m.Value, row, addColumn)
+		}
+	case []any:
+		data, _ := json.Marshal(treeToJSONValue(node))
+		addColumn(prefix)
+		row[prefix] = string(data)
+	default:
+		col := prefix
+		if col == "" {
+			col = "value"
+		}
+		addColumn(col)
+		row[col] = scalarString(node)
+	}
+}
+
+// parseCSVTree reads a header row and data rows. Dotted columns are rebuilt
+// into nested objects and empty cells are left out. A table with one data row
+// decodes as a single object, so it works for both lists and single resources.
+func parseCSVTree(data []byte) (any, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	lines, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty CSV document")
+	}
+
+	header := lines[0]
+	records := make([]any, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		record := object{}
+		for i, cell := range line {
+			if i >= len(header) || cell == "" {
+				continue
+			}
+			var value any = cell
+			// Cells holding JSON arrays came from nested lists
+			if strings.HasPrefix(cell, "[") {
+				if tree, err := toTreeFromJSON([]byte(cell)); err == nil {
+					value = tree
+				}
+			}
+			record = setDotted(record, strings.Split(header[i], "."), value)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) == 1 {
+		return records[0], nil
+	}
+	return records, nil
+}
+
+// setDotted stores value under a dotted path, creating nested objects as needed
+func setDotted(obj object, path []string, value any) object {
+	key := path[0]
+	if len(path) == 1 {
+		return append(obj, member{Key: key, Value: value})
+	}
+
+	for i, m := range obj {
+		if m.Key == key {
+			if child, ok := m.Value.(object); ok {
+				obj[i].Value = setDotted(child, path[1:], value)
+				return obj
+			}
+		}
+	}
+	return append(obj, member{Key: key, Value: setDotted(object{}, path[1:], value)})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MessagePack representation - codec_msgpack.go
+// A small encoder/decoder for the MessagePack spec
+// (https://github.com/msgpack/msgpack/blob/master/spec.md) covering nil, bool,
+// integers, floats, strings, binary, arrays and maps. Extension types are rejected.
+
+var msgpackCodec = &codec{
+	Name:       "msgpack",
+	MediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+	encode: func(w io.Writer, v any) error {
+		tree, err := toTree(v)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := writeMsgpack(&buf, tree); err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	},
+	decode: func(r io.Reader, v any) error {
+		return decodeTreeInto(r, v, parseMsgpackTree)
+	},
+}
+
+// Encoding
+
+// writeMsgpack appends one tree node to buf
+func writeMsgpack(buf *bytes.Buffer, node any) error {
+	switch node := node.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if node {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := node.Int64(); err == nil {
+			writeMsgpackInt(buf, n)
+			return nil
+		}
+		f, err := node.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(buf, len(node), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(node)
+	case []any:
+		writeMsgpackHeader(buf, len(node), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range node {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case object:
+		writeMsgpackHeader(buf, len(node), 0x80, 15, 0, 0xde, 0xdf)
+		for _, m := range node {
+			writeMsgpack(buf, m.Key)
+			if err := writeMsgpack(buf, m.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", node)
+	}
+	return nil
+}
+
+// writeMsgpackInt writes an integer in its smallest encoding
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(int8(n)))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(n)))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeMsgpackHeader writes a length-prefixed type header. fixBase/fixMax
+// describe the "fix" form; the remaining codes are the 8/16/32-bit length forms
+// (code8 is 0 when the type has no 8-bit form).
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fixBase byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fixBase | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// Decoding
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// parseMsgpackTree decodes a single MessagePack value into a generic tree
+func parseMsgpackTree(data []byte) (any, error) {
+	r := bytes.NewReader(data)
+	node, err := readMsgpack(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", r.Len())
+	}
+	return node, nil
+}
+
+// maxMsgpackDepth stops maliciously nested input from exhausting the stack
+const maxMsgpackDepth = 64
+
+// readMsgpack reads one value from r
+func readMsgpack(r *bytes.Reader, depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, errMsgpackShort
+	}
+
+	switch {
+	case b <= 0x7f:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readMsgpackUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the encoded width
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), nil
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(float64(math.Float32frombits(uint32(n))))
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackFloat(math.Float64frombits(n))
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		// str8/16/32 and bin8/16/32 - binary is surfaced as a string
+		sizeCode := b - 0xd9
+		if b <= 0xc6 {
+			sizeCode = b - 0xc4
+		}
+		n, err := readMsgpackUint(r, 1<<sizeCode)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", b)
+}
+
+// readMsgpackUint reads a big-endian unsigned integer of size bytes
+func readMsgpackUint(r *bytes.Reader, size int) (uint64, error) {
+	if r.Len() < size {
+		return 0, errMsgpackShort
+	}
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, _ := r.ReadByte()
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// readMsgpackString reads n bytes as a string
+func readMsgpackString(r *bytes.Reader, n int) (any, error) {
+	if n < 0 || r.Len() < n {
+		return nil, errMsgpackShort
+	}
+	buf := make([]byte, n)
+	r.Read(buf)
+	return string(buf), nil
+}
+
+// readMsgpackArray reads n values
+func readMsgpackArray(r *bytes.Reader, n, depth int) (any, error) {
+	// Every element takes at least one byte, which bounds bogus lengths
+	if n < 0 || n > r.Len() {
+		return nil, errMsgpackShort
+	}
+	list := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// readMsgpackMap reads n key/value pairs; keys must be strings or numbers
+func readMsgpackMap(r *bytes.Reader, n, depth int) (any, error) {
+	if n < 0 || 2*n > r.Len() {
+		return nil, errMsgpackShort
+	}
+	obj := make(object, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case string, json.Number:
+		default:
+			return nil, fmt.Errorf("msgpack: unsupported map key type %T", key)
+		}
+		value, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, member{Key: scalarString(key), Value: value})
+	}
+	return obj, nil
+}
+
+// msgpackFloat converts a decoded float into a tree number
+func msgpackFloat(f float64) (any, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("msgpack: NaN and Inf are not supported")
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XML representation - codec_xml.go
+// Objects become elements named after their keys and array items are named
+// after the singular form of their parent ("courses" -> "course"):
+//
+//	<response><id>1</id><author><fullname>John Doe</fullname></author></response>
+//
+// A top-level array is wrapped as <response><item>...</item></response>.
+
+var xmlCodec = &codec{
+	Name:       "xml",
+	MediaTypes: []string{"application/xml", "text/xml"},
+	encode: func(w io.Writer, v any) error {
+		tree, err := toTree(v)
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		if err := writeXMLNode(enc, "response", tree); err != nil {
+			return err
+		}
+		return enc.Flush()
+	},
+	decode: func(r io.Reader, v any) error {
+		return decodeTreeInto(r, v, parseXMLTree)
+	},
+}
+
+// writeXMLNode writes one tree node as an element called name
+func writeXMLNode(enc *xml.Encoder, name string, node any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if node == nil {
+		// Explicit nil marker so null and "" survive a round trip
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "nil"}, Value: "true"}}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch node := node.(type) {
+	case object:
+		for _, m := range node {
+			if err := writeXMLNode(enc, xmlName(m.Key), m.Value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range node {
+			if err := writeXMLNode(enc, singular(name), item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalarString(node))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// singular derives an array item element name from the array's element name
+func singular(name string) string {
+	if name == "response" {
+		return "item"
+	}
+	if strings.HasSuffix(name, "ies") {
+		return strings.TrimSuffix(name, "ies") + "y"
+	}
+	if strings.HasSuffix(name, "s") && len(name) > 1 {
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+// xmlName makes an object key usable as an element name
+func xmlName(key string) string {
+	if key == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range key {
+		valid := r == '_' || r == '-' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !valid || (i == 0 && (r == '-' || r == '.' || (r >= '0' && r <= '9'))) {
+			b.WriteByte('_')
+			if valid {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// xmlElement is a parsed element before it is turned into a tree node
+type xmlElement struct {
+	name     string
+	nilValue bool
+	text     strings.Builder
+	children []*xmlElement
+}
+
+// parseXMLTree parses an XML document into a generic tree. The root element's
+// name is ignored. Elements with children become objects, except when every
+// child has the same name and there is more than one, which becomes an array.
+func parseXMLTree(data []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*xmlElement
+	var root *xmlElement
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			el := &xmlElement{name: tok.Name.Local}
+			for _, attr := range tok.Attr {
+				if attr.Name.Local == "nil" && attr.Value == "true" {
+					el.nilValue = true
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, el)
+			} else if root == nil {
+				root = el
+			}
+			stack = append(stack, el)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(tok)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("empty XML document")
+	}
+	return root.tree(), nil
+}
+
+// tree converts a parsed element into a tree node
+func (el *xmlElement) tree() any {
+	if el.nilValue {
+		return nil
+	}
+	if len(el.children) == 0 {
+		return el.text.String()
+	}
+
+	// Repeated children with one name form a list ("<courses><course/><course/></courses>")
+	repeated := len(el.children) > 1
+	for _, child := range el.children[1:] {
+		if child.name != el.children[0].name {
+			repeated = false
+			break
+		}
+	}
+	if repeated || el.children[0].name == singular(el.name) && el.name != singular(el.name) {
+		list := make([]any, 0, len(el.children))
+		for _, child := range el.children {
+			list = append(list, child.tree())
+		}
+		return list
+	}
+
+	obj := object{}
+	for _, child := range el.children {
+		obj = append(obj, member{Key: child.name, Value: child.tree()})
+	}
+	return obj
+}
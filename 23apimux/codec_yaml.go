@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// YAML representation - codec_yaml.go
+// The encoder writes block-style YAML. The decoder understands the subset that
+// the encoder produces plus what people typically write by hand: block mappings
+// and sequences, plain/quoted scalars, "|" and ">" block scalars, comments and
+// simple flow collections like [a, b] and {}.
+
+var yamlCodec = &codec{
+	Name:       "yaml",
+	MediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml"},
+	encode: func(w io.Writer, v any) error {
+		tree, err := toTree(v)
+		if err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(w)
+		writeYAML(bw, tree, 0)
+		return bw.Flush()
+	},
+	decode: func(r io.Reader, v any) error {
+		return decodeTreeInto(r, v, parseYAMLTree)
+	},
+}
+
+// Encoding
+
+// writeYAML writes a node that starts on its own line at the given indent
+func writeYAML(w *bufio.Writer, node any, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch node := node.(type) {
+	case object:
+		if len(node) == 0 {
+			w.WriteString(pad + "{}\n")
+			return
+		}
+		writeYAMLMapping(w, node, indent, pad)
+	case []any:
+		if len(node) == 0 {
+			w.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range node {
+			// Objects start on the dash line: "- id: 1"
+			if obj, ok := item.(object); ok && len(obj) > 0 {
+				writeYAMLMapping(w, obj, indent+1, pad+"- ")
+				continue
+			}
+			w.WriteString(pad + "-")
+			writeYAMLValue(w, item, indent)
+		}
+	default:
+		w.WriteString(pad + yamlScalar(node) + "\n")
+	}
+}
+
+// writeYAMLMapping writes the keys of obj at indent, using firstPad in place
+// of the indentation on the first line
+func writeYAMLMapping(w *bufio.Writer, obj object, indent int, firstPad string) {
+	pad := strings.Repeat("  ", indent)
+	for i, m := range obj {
+		if i == 0 {
+			w.WriteString(firstPad)
+		} else {
+			w.WriteString(pad)
+		}
+		w.WriteString(yamlScalar(m.Key) + ":")
+		writeYAMLValue(w, m.Value, indent)
+	}
+}
+
+// writeYAMLValue writes the value after a "key:" or "-" marker
+func writeYAMLValue(w *bufio.Writer, value any, indent int) {
+	switch value := value.(type) {
+	case object:
+		if len(value) == 0 {
+			w.WriteString(" {}\n")
+			return
+		}
+		w.WriteString("\n")
+		writeYAML(w, value, indent+1)
+	case []any:
+		if len(value) == 0 {
+			w.WriteString(" []\n")
+			return
+		}
+		w.WriteString("\n")
+		writeYAML(w, value, indent+1)
+	default:
+		w.WriteString(" " + yamlScalar(value) + "\n")
+	}
+}
+
+// yamlPlainSafe matches strings that can be written without quotes
+var yamlPlainSafe = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9 _./@()+-]*$`)
+
+// yamlNumber matches plain scalars that are read back as numbers
+var yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// yamlReserved are plain words YAML would read as something other than a string
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"null": true, "y": true, "n": true,
+}
+
+// yamlScalar renders a scalar, quoting strings that would otherwise be misread
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlPlainSafe.MatchString(v) && !strings.HasSuffix(v, " ") && !yamlReserved[strings.ToLower(v)] {
+			return v
+		}
+		return strconv.Quote(v)
+	default:
+		return strconv.Quote(fmt.Sprint(v))
+	}
+}
+
+// Decoding
+
+// yamlLine is one meaningful input line
+type yamlLine struct {
+	indent int    // Leading spaces
+	text   string // Content without indentation or trailing comment
+	raw    string // Original line, used by block scalars
+}
+
+// parseYAMLTree parses a YAML document into a generic tree
+func parseYAMLTree(data []byte) (any, error) {
+	var lines []yamlLine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		raw := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.Contains(raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))], "\t") {
+			return nil, fmt.Errorf("yaml: tabs are not allowed for indentation")
+		}
+		text := strings.TrimSpace(stripYAMLComment(raw))
+		if text == "---" || text == "..." {
+			continue
+		}
+		lines = append(lines, yamlLine{indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, raw: raw})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	p := &yamlParser{lines: lines}
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	node, err := p.parseBlock(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("yaml: unexpected content %q", p.lines[p.pos].text)
+	}
+	return node, nil
+}
+
+// stripYAMLComment removes a trailing "# comment" that is not inside quotes
+func stripYAMLComment(line string) string {
+	inSingle, inDouble := false, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inDouble:
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case c == '#' && !inSingle && !inDouble && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlParser walks the lines of a document
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// skipBlank moves past empty and comment-only lines
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].text == "" {
+		p.pos++
+	}
+}
+
+// parseBlock parses the mapping or sequence that starts at the current line
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	p.skipBlank()
+	line := p.lines[p.pos]
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return parseYAMLScalar(line.text)
+}
+
+// parseSequence parses "- item" lines at the given indent
+func (p *yamlParser) parseSequence(indent int) (any, error) {
+	list := []any{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent != indent || !(line.text == "-" || strings.HasPrefix(line.text, "- ")) {
+			break
+		}
+
+		content := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if content == "" {
+			// Item is a nested block on the following lines
+			p.pos++
+			item, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			continue
+		}
+
+		// "- key: value" starts a mapping whose keys line up after the dash
+		itemIndent := indent + (len(line.text) - len(content))
+		p.lines[p.pos] = yamlLine{indent: itemIndent, text: content, raw: strings.Repeat(" ", itemIndent) + content}
+		item, err := p.parseBlock(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// parseMapping parses "key: value" lines at the given indent
+func (p *yamlParser) parseMapping(indent int) (any, error) {
+	obj := object{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent != indent {
+			if line.indent > indent {
+				return nil, fmt.Errorf("yaml: bad indentation at %q", line.text)
+			}
+			break
+		}
+
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml: expected \"key: value\", got %q", line.text)
+		}
+		p.pos++
+
+		var value any
+		var err error
+		switch {
+		case rest == "":
+			value, err = p.parseNested(indent)
+		case rest == "|" || rest == "|-" || rest == ">" || rest == ">-":
+			value = p.parseBlockScalar(indent, rest)
+		default:
+			value, err = parseYAMLScalar(rest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, member{Key: key, Value: value})
+	}
+	return obj, nil
+}
+
+// parseNested parses the block that follows a bare "key:" or "-". A sequence
+// may sit at the same indent as its key; anything else must be indented further.
+func (p *yamlParser) parseNested(indent int) (any, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	isSeq := next.text == "-" || strings.HasPrefix(next.text, "- ")
+	if next.indent > indent || (next.indent == indent && isSeq) {
+		return p.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar collects a "|" (literal) or ">" (folded) block
+func (p *yamlParser) parseBlockScalar(indent int, style string) string {
+	var parts []string
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.text != "" && line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 && line.text != "" {
+			blockIndent = line.indent
+		}
+		text := ""
+		if len(line.raw) > blockIndent && blockIndent >= 0 {
+			text = line.raw[blockIndent:]
+		}
+		parts = append(parts, text)
+		p.pos++
+	}
+
+	sep := "\n"
+	if strings.HasPrefix(style, ">") {
+		sep = " "
+	}
+	s := strings.Join(parts, sep)
+	if !strings.HasSuffix(style, "-") {
+		s += "\n"
+	}
+	return s
+}
+
+// splitYAMLKey splits "key: value" (or "key:") outside of quotes
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+
+	inSingle, inDouble := false, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && inDouble:
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case c == ':' && !inSingle && !inDouble && (i == len(text)-1 || text[i+1] == ' '):
+			rawKey := strings.TrimSpace(text[:i])
+			k, err := parseYAMLScalar(rawKey)
+			if err != nil {
+				return "", "", false
+			}
+			return scalarString(k), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLScalar parses a single-line value, including simple flow collections
+func parseYAMLScalar(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: bad double-quoted string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("yaml: bad single-quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("yaml: unterminated flow sequence %s", text)
+		}
+		list := []any{}
+		for _, part := range splitYAMLFlow(text[1 : len(text)-1]) {
+			item, err := parseYAMLScalar(part)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("yaml: unterminated flow mapping %s", text)
+		}
+		obj := object{}
+		for _, part := range splitYAMLFlow(text[1 : len(text)-1]) {
+			key, rest, ok := splitYAMLKey(part)
+			if !ok {
+				return nil, fmt.Errorf("yaml: bad flow mapping entry %q", part)
+			}
+			value, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{Key: key, Value: value})
+		}
+		return obj, nil
+	}
+
+	switch strings.ToLower(text) {
+	case "", "~", "null":
+		return nil, nil
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	if yamlNumber.MatchString(text) {
+		return json.Number(text), nil
+	}
+	return text, nil
+}
+
+// splitYAMLFlow splits the inside of a flow collection on top-level commas
+func splitYAMLFlow(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	inSingle, inDouble := false, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && inDouble:
+			i++
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case inSingle || inDouble:
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
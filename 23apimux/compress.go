@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response compression - compress.go
+// compressResponses negotiates a Content-Encoding from Accept-Encoding and
+// compresses the response body on the fly. gzip and deflate come from the
+// standard library; brotli is not offered because there is no pure Go
+// encoder in the standard library and this server only depends on mux.
+
+// minCompressSize is the smallest body worth compressing; tiny bodies often
+// grow once the gzip/zlib framing is added
+const minCompressSize = 512
+
+// encoder is one supported Content-Encoding
+type encoder struct {
+	name string
+	pool *sync.Pool // Reusable compressors, reset for every response
+}
+
+// resettableWriter is implemented by both gzip.Writer and zlib.Writer
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// encoders are listed in server preference order. Note that HTTP "deflate" is
+// the zlib format (RFC 1950), not a raw deflate stream.
+var encoders = []*encoder{
+	{name: "gzip", pool: &sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}},
+	{name: "deflate", pool: &sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}},
+}
+
+// negotiateEncoding picks an encoder from an Accept-Encoding header, or nil for identity
+func negotiateEncoding(header string) *encoder {
+	if header == "" {
+		return nil
+	}
+
+	// Collect q-values; "*" applies to every coding not listed explicitly
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var best *encoder
+	bestQ := 0.0
+	for _, e := range encoders {
+		q, ok := qualities[e.name]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// compressibleType reports whether a Content-Type benefits from compression
+func compressibleType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || codecFor(mediaType) != nil {
+		return true
+	}
+	return mediaType == "application/javascript" || mediaType == "image/svg+xml"
+}
+
+// compressResponses is middleware that compresses responses for clients that accept it
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == nil || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, enc: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large and compressible enough, then either compresses or passes through
+type compressWriter struct {
+	http.ResponseWriter
+	enc *encoder
+
+	status  int
+	buf     bytes.Buffer     // Body bytes held back until the decision is made
+	decided bool             // Whether compress-or-not has been decided
+	zw      resettableWriter // Active compressor (nil when passing through)
+}
+
+// WriteHeader delays the status until the encoding has been decided
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// Write buffers until minCompressSize bytes are available
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf.Write(p)
+	if cw.buf.Len() >= minCompressSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide starts compressing (or not) and flushes whatever was buffered
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	h := cw.Header()
+
+	compress := cw.buf.Len() >= minCompressSize &&
+		h.Get("Content-Encoding") == "" &&
+		compressibleType(h.Get("Content-Type")) &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified
+
+	if compress {
+		h.Set("Content-Encoding", cw.enc.name)
+		h.Del("Content-Length")
+		// A strong validator no longer matches the transformed bytes
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.zw = cw.enc.pool.Get().(resettableWriter)
+		cw.zw.Reset(cw.ResponseWriter)
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// Close finishes the compressed stream and returns the compressor to its pool
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.zw == nil {
+		return nil
+	}
+	err := cw.zw.Close()
+	cw.enc.pool.Put(cw.zw)
+	cw.zw = nil
+	return err
+}
+
+// Flush sends buffered data immediately, for streaming handlers
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if f, ok := cw.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket-style handlers take over the connection
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORS - cors.go
+// Lets browser apps on other origins call the API. The policy is read from
+// the environment and is off unless CORS_ORIGINS is set:
+//   - CORS_ORIGINS: allowed origins, comma-separated; "*" allows any, and
+//     "https://*.example.com" allows every subdomain
+//   - CORS_HEADERS: request headers browsers may send ("*" for any)
+//   - CORS_EXPOSED_HEADERS: response headers scripts may read
+//   - CORS_CREDENTIALS=true: allows cookies and HTTP auth; it needs a list of
+//     origins, since any site could then call the API as the user
+//   - CORS_MAX_AGE: how long browsers cache a preflight, e.g. "10m"
+//
+// The policy wraps the whole router rather than being a mux middleware, since
+// mux middleware only runs for requests that match a route and an OPTIONS
+// preflight matches none. Preflights are answered here, before tenant
+// resolution (browsers send them without API keys), with the methods of the
+// routes whose path matches.
+
+// Defaults cover the headers the API reads and writes
+var (
+	defaultCORSHeaders = []string{
+		"Accept", "Authorization", "Content-Type", "If-Modified-Since",
+		"Idempotency-Key", "Range", "X-API-Key", "X-Tenant",
+	}
+	defaultCORSExposedHeaders = []string{
+		"Content-Disposition", "Last-Modified", "Link", "Location",
+		"Retry-After", "X-Cache", "X-Tenant",
+	}
+)
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+// corsPolicy is who may call the API from a browser and how
+type corsPolicy struct {
+	Origins        []string // Exact origins or path.Match patterns; "*" is any
+	Headers        []string // Allowed request headers; "*" is any
+	ExposedHeaders []string
+	Credentials    bool
+	MaxAge         time.Duration
+}
+
+// loadCORSPolicy reads the CORS_* variables; it returns nil when CORS is off
+func loadCORSPolicy() (*corsPolicy, error) {
+	origins := splitList(os.Getenv("CORS_ORIGINS"))
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	p := &corsPolicy{
+		Origins:        origins,
+		Headers:        defaultCORSHeaders,
+		ExposedHeaders: defaultCORSExposedHeaders,
+		MaxAge:         defaultCORSMaxAge,
+	}
+	if headers := os.Getenv("CORS_HEADERS"); headers != "" {
+		p.Headers = splitList(headers)
+	}
+	if exposed := os.Getenv("CORS_EXPOSED_HEADERS"); exposed != "" {
+		p.ExposedHeaders = splitList(exposed)
+	}
+	if credentials := os.Getenv("CORS_CREDENTIALS"); credentials != "" {
+		var err error
+		if p.Credentials, err = strconv.ParseBool(credentials); err != nil {
+			return nil, errors.New("CORS_CREDENTIALS must be true or false")
+		}
+	}
+	if maxAge := os.Getenv("CORS_MAX_AGE"); maxAge != "" {
+		var err error
+		if p.MaxAge, err = time.ParseDuration(maxAge); err != nil || p.MaxAge < 0 {
+			return nil, errors.New("CORS_MAX_AGE must be a duration like 10m")
+		}
+	}
+	return p, p.validate()
+}
+
+// errCORSAnyOriginWithCredentials rejects a policy that would let every site
+// make credentialed calls
+var errCORSAnyOriginWithCredentials = errors.New(`CORS_ORIGINS="*" can't be combined with CORS_CREDENTIALS=true; list the origins instead`)
+
+// validate checks the origin patterns
+func (p *corsPolicy) validate() error {
+	if p.Credentials && slices.Contains(p.Origins, "*") {
+		return errCORSAnyOriginWithCredentials
+	}
+	for _, o := range p.Origins {
+		if _, err := path.Match(o, ""); err != nil {
+			return fmt.Errorf("invalid CORS origin %q", o)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated setting, dropping blanks
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// allowsOrigin reports whether an Origin header value is allowed
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.Origins {
+		if allowed == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(allowed), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handler applies the policy in front of the router; a nil policy leaves
+// the router as is
+func (p *corsPolicy) handler(router *mux.Router) http.Handler {
+	if p == nil {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		addVary(w.Header(), "Origin")
+		if origin == "" || !p.allowsOrigin(origin) {
+			// Not a cross-origin request, or one the browser will block
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			p.setOrigin(w.Header(), origin)
+			if len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		methods := routeMethods(router, r)
+		if len(methods) == 0 {
+			router.ServeHTTP(w, r) // No such path: the router's 404
+			return
+		}
+		h := w.Header()
+		addVary(h, "Access-Control-Request-Method")
+		addVary(h, "Access-Control-Request-Headers")
+		p.setOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if headers := p.allowedHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if p.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// setOrigin allows the origin of a request. validate keeps "*" out of
+// policies with credentials, which must name the origin.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if slices.Contains(p.Origins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowedHeaders answers Access-Control-Request-Headers: the requested
+// headers when any are allowed, otherwise the allowed list
+func (p *corsPolicy) allowedHeaders(requested string) string {
+	if slices.Contains(p.Headers, "*") {
+		return requested
+	}
+	return strings.Join(p.Headers, ", ")
+}
+
+// routeMethods returns the methods registered on the routes matching the
+// request's path (and host), whatever its method, as mux's
+// CORSMethodMiddleware finds them
+func routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		var match mux.RouteMatch
+		if route.Match(r, &match) || errors.Is(match.MatchErr, mux.ErrMethodMismatch) {
+			routeMethods, err := route.GetMethods()
+			if err != nil {
+				return nil // A route without a method matcher, e.g. a subrouter
+			}
+			for _, m := range routeMethods {
+				if !slices.Contains(methods, m) {
+					methods = append(methods, m)
+				}
+			}
+		}
+		return nil
+	})
+	return methods
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Course curriculum - curriculum.go
+// A course is split into ordered modules, each holding ordered lessons. When
+// a course has lessons, its duration is the sum of their durations. Courses
+// can also require other courses first; those prerequisites must form a DAG,
+// so any change that would close a cycle is rejected.
+
+// Module is a chapter of a course
+type Module struct {
+	ID       string   `json:"id"`       // Unique identifier for the module
+	Title    string   `json:"title"`    // Module title
+	Position int      `json:"position"` // 1-based order within the course
+	Duration Duration `json:"duration"` // Sum of the lesson durations
+	Lessons  []Lesson `json:"lessons"`  // Lessons in teaching order
+}
+
+// Lesson is a single unit of teaching inside a module
+type Lesson struct {
+	ID       string   `json:"id"`       // Unique identifier for the lesson
+	Title    string   `json:"title"`    // Lesson title
+	Position int      `json:"position"` // 1-based order within the module
+	Duration Duration `json:"duration"` // How long the lesson takes
+}
+
+var (
+	errModuleNotFound  = errors.New("No module found with the given ID")
+	errLessonNotFound  = errors.New("No lesson found with the given ID")
+	errDuplicateModule = errors.New("Another module already has this ID")
+	errDuplicateLesson = errors.New("Another lesson already has this ID")
+)
+
+// normalizeCurriculum hands out IDs to new modules and lessons, renumbers
+// positions and recomputes durations; called with s.mu held
+func (s *courseStore) normalizeCurriculum(c *Course) {
+	var total Duration
+	lessons := 0
+	for i := range c.Modules {
+		m := &c.Modules[i]
+		m.ID = s.assignID(m.ID, &s.nextModuleID)
+		m.Position = i + 1
+		m.Duration = 0
+		if m.Lessons == nil {
+			m.Lessons = []Lesson{}
+		}
+		for j := range m.Lessons {
+			l := &m.Lessons[j]
+			l.ID = s.assignID(l.ID, &s.nextLessonID)
+			l.Position = j + 1
+			m.Duration += l.Duration
+			lessons++
+		}
+		total += m.Duration
+	}
+
+	// Courses without lessons keep the duration they were created with
+	if lessons > 0 {
+		c.Duration = total
+	}
+}
+
+// checkCurriculumIDs rejects module and lesson IDs given by the client that
+// appear twice in course id or already belong to another course, since
+// lookups by ID would find the wrong one; called with s.mu held
+func (s *courseStore) checkCurriculumIDs(id string, c *Course) error {
+	modules, lessons := map[string]bool{}, map[string]bool{}
+	for _, m := range c.Modules {
+		if m.ID != "" && modules[m.ID] {
+			return fmt.Errorf("%w: %q", errDuplicateModule, m.ID)
+		}
+		modules[m.ID] = m.ID != ""
+		for _, l := range m.Lessons {
+			if l.ID != "" && lessons[l.ID] {
+				return fmt.Errorf("%w: %q", errDuplicateLesson, l.ID)
+			}
+			lessons[l.ID] = l.ID != ""
+		}
+	}
+
+	for _, other := range s.courses {
+		if other.ID == id {
+			continue
+		}
+		for _, m := range other.Modules {
+			if modules[m.ID] {
+				return fmt.Errorf("%w: %q", errDuplicateModule, m.ID)
+			}
+			for _, l := range m.Lessons {
+				if lessons[l.ID] {
+					return fmt.Errorf("%w: %q", errDuplicateLesson, l.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// assignID returns id, or the next ID from counter when id is empty. Numeric
+// IDs that were set by hand move the counter past them.
+func (s *courseStore) assignID(id string, counter *int) string {
+	if id == "" {
+		id = strconv.Itoa(*counter)
+	}
+	if n, err := strconv.Atoi(id); err == nil && n >= *counter {
+		*counter = n + 1
+	}
+	return id
+}
+
+// Prerequisites
+
+// checkPrerequisites verifies that every prerequisite exists and that making
+// them prerequisites of course id keeps the graph acyclic; called with s.mu held
+func (s *courseStore) checkPrerequisites(id string, prereqs []string) error {
+	seen := map[string]bool{}
+	for _, p := range prereqs {
+		switch {
+		case p == id:
+			return errors.New("a course cannot be its own prerequisite")
+		case seen[p]:
+			return fmt.Errorf("prerequisite %q is listed twice", p)
+		case s.index(p) < 0:
+			return fmt.Errorf("unknown prerequisite course %q", p)
+		}
+		seen[p] = true
+
+		// Adding the edge id -> p closes a cycle if id is already reachable from p
+		if path := s.prerequisitePath(p, id, map[string]bool{}); path != nil {
+			cycle := append([]string{id}, path...)
+			return fmt.Errorf("prerequisite cycle: %s", strings.Join(cycle, " → "))
+		}
+	}
+	return nil
+}
+
+// prerequisitePath returns the chain of prerequisites leading from one course
+// to another (both included), or nil if there is none; called with s.mu held
+func (s *courseStore) prerequisitePath(from, to string, visited map[string]bool) []string {
+	if from == to {
+		return []string{to}
+	}
+	if visited[from] {
+		return nil
+	}
+	visited[from] = true
+
+	i := s.index(from)
+	if i < 0 {
+		return nil
+	}
+	for _, next := range s.courses[i].Prerequisites {
+		if path := s.prerequisitePath(next, to, visited); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+	return nil
+}
+
+// LearningPath returns every direct and indirect prerequisite of a course in
+// an order they can be taken in (each course after its own prerequisites).
+// Like the course itself, prerequisites that aren't live are left out.
+func (s *courseStore) LearningPath(id string) ([]Course, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.index(id)
+	if i < 0 || !s.courses[i].Live() {
+		return nil, errCourseNotFound
+	}
+
+	// Depth-first post-order over the DAG is a topological order
+	path := []Course{}
+	visited := map[string]bool{id: true}
+	var visit func(c Course)
+	visit = func(c Course) {
+		for _, p := range c.Prerequisites {
+			if visited[p] {
+				continue
+			}
+			visited[p] = true
+			if j := s.index(p); j >= 0 {
+				visit(s.courses[j])
+				if s.courses[j].Live() {
+					path = append(path, cloneCourse(s.courses[j]))
+				}
+			}
+		}
+	}
+	visit(s.courses[i])
+	return path, nil
+}
+
+// Modules and lessons
+
+// moduleIndex returns the position of a module in a course or -1
+func moduleIndex(c *Course, mid string) int {
+	return slices.IndexFunc(c.Modules, func(m Module) bool { return m.ID == mid })
+}
+
+// lessonIndex returns the position of a lesson in a module or -1
+func lessonIndex(m *Module, lid string) int {
+	return slices.IndexFunc(m.Lessons, func(l Lesson) bool { return l.ID == lid })
+}
+
+// moveTo moves the element at index from to the 1-based position (clamped to
+// the list) and returns the list and the element's new index
+func moveTo[T any](list []T, from, position int) ([]T, int) {
+	item := list[from]
+	list = slices.Delete(list, from, from+1)
+	to := min(max(position-1, 0), len(list))
+	return slices.Insert(list, to, item), to
+}
+
+// Modules returns the modules of a live course in order
+func (s *courseStore) Modules(id string) ([]Module, error) {
+	c, ok := s.Get(id)
+	if !ok || !c.Live() {
+		return nil, errCourseNotFound
+	}
+	if c.Modules == nil {
+		return []Module{}, nil
+	}
+	return c.Modules, nil
+}
+
+// Module returns one module of a live course
+func (s *courseStore) Module(id, mid string) (Module, error) {
+	c, ok := s.Get(id)
+	if !ok || !c.Live() {
+		return Module{}, errCourseNotFound
+	}
+	i := moduleIndex(&c, mid)
+	if i < 0 {
+		return Module{}, errModuleNotFound
+	}
+	return c.Modules[i], nil
+}
+
+// AddModule appends a module to a course, or inserts it at m.Position if set
+func (s *courseStore) AddModule(id string, m Module) (Module, error) {
+	at := 0
+	c, err := s.updateCourse(id, func(c *Course) error {
+		m.ID = ""
+		c.Modules = append(c.Modules, m)
+		at = len(c.Modules) - 1
+		if m.Position > 0 {
+			c.Modules, at = moveTo(c.Modules, at, m.Position)
+		}
+		return nil
+	})
+	if err != nil {
+		return Module{}, err
+	}
+	return c.Modules[at], nil
+}
+
+// UpdateModule renames a module and, if m.Position is set, moves it. Its
+// lessons are managed through the lesson methods and left untouched.
+func (s *courseStore) UpdateModule(id, mid string, m Module) (Module, error) {
+	at := 0
+	c, err := s.updateCourse(id, func(c *Course) error {
+		if at = moduleIndex(c, mid); at < 0 {
+			return errModuleNotFound
+		}
+		c.Modules[at].Title = m.Title
+		if m.Position > 0 {
+			c.Modules, at = moveTo(c.Modules, at, m.Position)
+		}
+		return nil
+	})
+	if err != nil {
+		return Module{}, err
+	}
+	return c.Modules[at], nil
+}
+
+// DeleteModule removes a module and its lessons from a course
+func (s *courseStore) DeleteModule(id, mid string) error {
+	_, err := s.updateCourse(id, func(c *Course) error {
+		i := moduleIndex(c, mid)
+		if i < 0 {
+			return errModuleNotFound
+		}
+		c.Modules = slices.Delete(c.Modules, i, i+1)
+		return nil
+	})
+	return err
+}
+
+// Lesson returns one lesson of a module
+func (s *courseStore) Lesson(id, mid, lid string) (Lesson, error) {
+	m, err := s.Module(id, mid)
+	if err != nil {
+		return Lesson{}, err
+	}
+	i := lessonIndex(&m, lid)
+	if i < 0 {
+		return Lesson{}, errLessonNotFound
+	}
+	return m.Lessons[i], nil
+}
+
+// updateModule applies fn to one module of a course, like updateCourse
+func (s *courseStore) updateModule(id, mid string, fn func(m *Module) error) (Module, error) {
+	at := 0
+	c, err := s.updateCourse(id, func(c *Course) error {
+		if at = moduleIndex(c, mid); at < 0 {
+			return errModuleNotFound
+		}
+		return fn(&c.Modules[at])
+	})
+	if err != nil {
+		return Module{}, err
+	}
+	return c.Modules[at], nil
+}
+
+// AddLesson appends a lesson to a module, or inserts it at l.Position if set
+func (s *courseStore) AddLesson(id, mid string, l Lesson) (Lesson, error) {
+	at := 0
+	m, err := s.updateModule(id, mid, func(m *Module) error {
+		l.ID = ""
+		m.Lessons = append(m.Lessons, l)
+		at = len(m.Lessons) - 1
+		if l.Position > 0 {
+			m.Lessons, at = moveTo(m.Lessons, at, l.Position)
+		}
+		return nil
+	})
+	if err != nil {
+		return Lesson{}, err
+	}
+	return m.Lessons[at], nil
+}
+
+// UpdateLesson replaces a lesson's title and duration and, if l.Position is
+// set, moves it within its module
+func (s *courseStore) UpdateLesson(id, mid, lid string, l Lesson) (Lesson, error) {
+	at := 0
+	m, err := s.updateModule(id, mid, func(m *Module) error {
+		if at = lessonIndex(m, lid); at < 0 {
+			return errLessonNotFound
+		}
+		m.Lessons[at].Title = l.Title
+		m.Lessons[at].Duration = l.Duration
+		if l.Position > 0 {
+			m.Lessons, at = moveTo(m.Lessons, at, l.Position)
+		}
+		return nil
+	})
+	if err != nil {
+		return Lesson{}, err
+	}
+	return m.Lessons[at], nil
+}
+
+// DeleteLesson removes a lesson from a module
+func (s *courseStore) DeleteLesson(id, mid, lid string) error {
+	_, err := s.updateModule(id, mid, func(m *Module) error {
+		i := lessonIndex(m, lid)
+		if i < 0 {
+			return errLessonNotFound
+		}
+		m.Lessons = slices.Delete(m.Lessons, i, i+1)
+		return nil
+	})
+	return err
+}
+
+// Controller for the curriculum
+
+// getModules lists the modules of a course with their lessons
+// GET /courses/{id}/modules
+func getModules(w http.ResponseWriter, r *http.Request) {
+	modules, err := storeFrom(r).Modules(mux.Vars(r)["id"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, modules)
+}
+
+// getModule returns one module
+// GET /courses/{id}/modules/{mid}
+func getModule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m, err := storeFrom(r).Module(vars["id"], vars["mid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, m)
+}
+
+// createModule adds a module (optionally with lessons) to a course
+// POST /courses/{id}/modules
+func createModule(w http.ResponseWriter, r *http.Request) {
+	var m Module
+	if renderDecodeError(w, r, decodeBody(r, &m)) {
+		return
+	}
+	if m.Title == "" {
+		render(w, r, http.StatusBadRequest, "Module title is required")
+		return
+	}
+	for i := range m.Lessons {
+		m.Lessons[i].ID = ""
+	}
+
+	m, err := storeFrom(r).AddModule(mux.Vars(r)["id"], m)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusCreated, m)
+}
+
+// updateModule renames or moves a module
+// PUT /courses/{id}/modules/{mid}
+func updateModule(w http.ResponseWriter, r *http.Request) {
+	var m Module
+	if renderDecodeError(w, r, decodeBody(r, &m)) {
+		return
+	}
+	if m.Title == "" {
+		render(w, r, http.StatusBadRequest, "Module title is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	m, err := storeFrom(r).UpdateModule(vars["id"], vars["mid"], m)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, m)
+}
+
+// deleteModule removes a module and its lessons
+// DELETE /courses/{id}/modules/{mid}
+func deleteModule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := storeFrom(r).DeleteModule(vars["id"], vars["mid"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Module deleted successfully")
+}
+
+// getLessons lists the lessons of a module in order
+// GET /courses/{id}/modules/{mid}/lessons
+func getLessons(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m, err := storeFrom(r).Module(vars["id"], vars["mid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, m.Lessons)
+}
+
+// getLesson returns one lesson
+// GET /courses/{id}/modules/{mid}/lessons/{lid}
+func getLesson(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	l, err := storeFrom(r).Lesson(vars["id"], vars["mid"], vars["lid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, l)
+}
+
+// createLesson adds a lesson to a module
+// POST /courses/{id}/modules/{mid}/lessons
+func createLesson(w http.ResponseWriter, r *http.Request) {
+	var l Lesson
+	if renderDecodeError(w, r, decodeBody(r, &l)) {
+		return
+	}
+	if l.Title == "" {
+		render(w, r, http.StatusBadRequest, "Lesson title is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	l, err := storeFrom(r).AddLesson(vars["id"], vars["mid"], l)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusCreated, l)
+}
+
+// updateLesson replaces a lesson's title and duration, or moves it
+// PUT /courses/{id}/modules/{mid}/lessons/{lid}
+func updateLesson(w http.ResponseWriter, r *http.Request) {
+	var l Lesson
+	if renderDecodeError(w, r, decodeBody(r, &l)) {
+		return
+	}
+	if l.Title == "" {
+		render(w, r, http.StatusBadRequest, "Lesson title is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	l, err := storeFrom(r).UpdateLesson(vars["id"], vars["mid"], vars["lid"], l)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, l)
+}
+
+// deleteLesson removes a lesson
+// DELETE /courses/{id}/modules/{mid}/lessons/{lid}
+func deleteLesson(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := storeFrom(r).DeleteLesson(vars["id"], vars["mid"], vars["lid"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Lesson deleted successfully")
+}
+
+// getLearningPath lists every course to take before this one, in order
+// GET /courses/{id}/prerequisites
+func getLearningPath(w http.ResponseWriter, r *http.Request) {
+	courses, err := storeFrom(r).LearningPath(mux.Vars(r)["id"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	renderCourses(w, r, http.StatusOK, courses)
+}
+
+// registerCurriculumRoutes adds the module, lesson and prerequisite routes
+func registerCurriculumRoutes(r *mux.Router) {
+	r.HandleFunc("/courses/{id}/prerequisites", getLearningPath).Methods("GET")
+
+	r.HandleFunc("/courses/{id}/modules", getModules).Methods("GET")
+	r.HandleFunc("/courses/{id}/modules", createModule).Methods("POST")
+	r.HandleFunc("/courses/{id}/modules/{mid}", getModule).Methods("GET")
+	r.HandleFunc("/courses/{id}/modules/{mid}", updateModule).Methods("PUT")
+	r.HandleFunc("/courses/{id}/modules/{mid}", deleteModule).Methods("DELETE")
+
+	r.HandleFunc("/courses/{id}/modules/{mid}/lessons", getLessons).Methods("GET")
+	r.HandleFunc("/courses/{id}/modules/{mid}/lessons", createLesson).Methods("POST")
+	r.HandleFunc("/courses/{id}/modules/{mid}/lessons/{lid}", getLesson).Methods("GET")
+	r.HandleFunc("/courses/{id}/modules/{mid}/lessons/{lid}", updateLesson).Methods("PUT")
+	r.HandleFunc("/courses/{id}/modules/{mid}/lessons/{lid}", deleteLesson).Methods("DELETE")
+}
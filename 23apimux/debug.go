@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Route introspection - debug.go
+// Shows what the router registered, for debugging routing problems:
+//   - GET /debug/routes lists every route in the order mux tries them
+//   - GET /debug/match?method=PUT&path=/v2/courses/1 explains which route a
+//     request would reach and why the routes it came close to didn't match
+//
+// Only the route table is shown, no catalog data, but it maps out every
+// endpoint, so both routes need an admin key. Routes are listed as
+// Router.Walk visits them, subrouter routes with their full templates.
+
+// routeInfo describes one registered route
+type routeInfo struct {
+	Name         string   `json:"name,omitempty"`
+	PathTemplate string   `json:"path_template,omitempty"`
+	PathRegexp   string   `json:"path_regexp,omitempty"`
+	Methods      []string `json:"methods,omitempty"`
+	Queries      []string `json:"queries,omitempty"` // Query templates, e.g. "page={page}"
+	QueryRegexps []string `json:"query_regexps,omitempty"`
+	HostTemplate string   `json:"host_template,omitempty"`
+	Subrouter    bool     `json:"subrouter,omitempty"`     // Holds routes of its own instead of a handler
+	Depth        int      `json:"depth"`                   // Number of enclosing subrouters
+	ParentPrefix string   `json:"parent_prefix,omitempty"` // Path template of the enclosing subrouter
+}
+
+// describeRoute collects what mux can tell about a route
+func describeRoute(route *mux.Route, ancestors []*mux.Route) routeInfo {
+	info := routeInfo{
+		Name:      route.GetName(),
+		Subrouter: route.GetHandler() == nil,
+		Depth:     len(ancestors),
+	}
+	info.PathTemplate, _ = route.GetPathTemplate()
+	info.PathRegexp, _ = route.GetPathRegexp()
+	info.Methods, _ = route.GetMethods()
+	info.Queries, _ = route.GetQueriesTemplates()
+	info.QueryRegexps, _ = route.GetQueriesRegexp()
+	info.HostTemplate, _ = route.GetHostTemplate()
+	if len(ancestors) > 0 {
+		info.ParentPrefix, _ = ancestors[len(ancestors)-1].GetPathTemplate()
+	}
+	return info
+}
+
+// debugRoutes lists the routes of the router
+// GET /debug/routes
+func debugRoutes(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		routes := []routeInfo{}
+		router.Walk(func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+			routes = append(routes, describeRoute(route, ancestors))
+			return nil
+		})
+		render(w, r, http.StatusOK, map[string]any{"count": len(routes), "routes": routes})
+	}
+}
+
+// Route matching
+
+// matchCandidate is a route considered for a request, and why it did or
+// didn't get it
+type matchCandidate struct {
+	routeInfo
+	Result  string   `json:"result"`            // matched, shadowed or rejected
+	Reasons []string `json:"reasons,omitempty"` // Why it was shadowed or rejected
+}
+
+// matchReport explains how the router handles a request
+type matchReport struct {
+	Request struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		Host   string `json:"host"`
+	} `json:"request"`
+	Matched    *routeInfo        `json:"matched"`         // The route that gets the request
+	Vars       map[string]string `json:"vars,omitempty"`  // Its path variables
+	Error      string            `json:"error,omitempty"` // Why no route got it
+	Candidates []matchCandidate  `json:"candidates"`      // Near misses, or every route with ?verbose=true
+}
+
+// debugMatch explains which route a request would match
+// GET /debug/match?method=PUT&path=/v2/courses/1&host=example.com&verbose=true
+func debugMatch(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		method := strings.ToUpper(q.Get("method"))
+		if method == "" {
+			method = http.MethodGet
+		}
+		target := q.Get("path")
+		if !strings.HasPrefix(target, "/") {
+			render(w, r, http.StatusBadRequest, "path must be an absolute path like /v2/courses/1")
+			return
+		}
+		probe, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			render(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		probe.Host = r.Host
+		if host := q.Get("host"); host != "" {
+			probe.Host = host
+		}
+		render(w, r, http.StatusOK, explainMatch(router, probe, q.Get("verbose") == "true"))
+	}
+}
+
+// explainMatch matches probe against the router, then checks every route
+// with a handler on its own to say why it didn't take the request
+func explainMatch(router *mux.Router, probe *http.Request, verbose bool) matchReport {
+	var report matchReport
+	report.Request.Method, report.Request.Path, report.Request.Host = probe.Method, probe.URL.RequestURI(), probe.Host
+	report.Candidates = []matchCandidate{}
+
+	var match mux.RouteMatch
+	switch {
+	case router.Match(probe, &match):
+		report.Vars = match.Vars
+	case errors.Is(match.MatchErr, mux.ErrMethodMismatch):
+		report.Error = "A route matches the path but not the method (405 Method Not Allowed)"
+	default:
+		report.Error = "No route matches (404 Not Found)"
+	}
+
+	var winner *mux.Route
+	router.Walk(func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil // Subrouters are explained through their routes
+		}
+		info := describeRoute(route, ancestors)
+		var m mux.RouteMatch
+		if route.Match(probe, &m) {
+			if winner == nil && match.Route == route {
+				winner = route
+				report.Matched = &info
+				report.Candidates = append(report.Candidates, matchCandidate{routeInfo: info, Result: "matched"})
+				return nil
+			}
+			shadowedBy := "an earlier route"
+			if report.Matched != nil {
+				shadowedBy = report.Matched.PathTemplate
+			}
+			report.Candidates = append(report.Candidates, matchCandidate{
+				routeInfo: info,
+				Result:    "shadowed",
+				Reasons:   []string{"Also matches, but " + shadowedBy + " was registered first"},
+			})
+			return nil
+		}
+
+		reasons, pathMatches := rejectionReasons(info, probe)
+		if verbose || pathMatches {
+			report.Candidates = append(report.Candidates, matchCandidate{routeInfo: info, Result: "rejected", Reasons: reasons})
+		}
+		return nil
+	})
+	return report
+}
+
+// rejectionReasons checks a route's path, method and query matchers one by
+// one; mux doesn't expose the others (host, headers, custom matchers), so
+// they get the blame when these all pass
+func rejectionReasons(info routeInfo, probe *http.Request) ([]string, bool) {
+	var reasons []string
+	pathMatches := true
+	if info.PathRegexp != "" {
+		re, err := regexp.Compile(info.PathRegexp)
+		if err == nil && !re.MatchString(probe.URL.Path) {
+			pathMatches = false
+			reasons = append(reasons, fmt.Sprintf("Path %s doesn't match %s", probe.URL.Path, info.PathTemplate))
+		}
+	}
+	if len(info.Methods) > 0 && !slices.Contains(info.Methods, probe.Method) {
+		reasons = append(reasons, fmt.Sprintf("Method %s isn't one of %s", probe.Method, strings.Join(info.Methods, ", ")))
+	}
+	for i, pattern := range info.QueryRegexps {
+		if !queryMatches(pattern, probe.URL.Query()) {
+			reasons = append(reasons, fmt.Sprintf("Query doesn't have %s", info.Queries[i]))
+		}
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "Rejected by a host, header or custom matcher")
+	}
+	return reasons, pathMatches
+}
+
+// queryMatches reports whether any key=value pair of the query matches a
+// mux query regexp
+func queryMatches(pattern string, query url.Values) bool {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return true
+	}
+	for key, values := range query {
+		for _, v := range values {
+			if re.MatchString(key + "=" + v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// registerDebugRoutes adds the introspection routes over the whole router
+func registerDebugRoutes(r *mux.Router) {
+	r.HandleFunc("/debug/routes", requireAdmin(debugRoutes(r))).Methods("GET")
+	r.HandleFunc("/debug/match", requireAdmin(debugMatch(r))).Methods("GET")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Course durations - duration.go
+// Duration is a real length of time rather than free-form text, so courses can
+// be sorted and summed by length. It is written as ISO 8601 ("PT3H30M") and
+// read from any of the shapes clients have sent over time:
+//   - Go duration strings:  "3h", "3h30m"
+//   - ISO 8601 durations:   "PT3H30M"
+//   - seconds as a number:  12600
+//   - the v2 object shape:  {"iso8601": "PT3H30M", "seconds": 12600}
+
+// Duration is the length of a course
+type Duration time.Duration
+
+// maxDurationSeconds bounds durations given in seconds; longer ones overflow
+// time.Duration
+const maxDurationSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+// String returns the canonical ISO 8601 form
+func (d Duration) String() string {
+	return formatISO8601Duration(time.Duration(d))
+}
+
+// Short returns the hand-written form used by the v1 API, e.g. "3h30m"
+func (d Duration) Short() string {
+	if d == 0 {
+		return ""
+	}
+	return formatShortDuration(time.Duration(d))
+}
+
+// Seconds returns the duration in whole seconds
+func (d Duration) Seconds() int64 {
+	return int64(time.Duration(d) / time.Second)
+}
+
+// ParseDuration accepts a Go duration string or an ISO 8601 duration.
+// An empty string is a zero duration.
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	var err error
+	if strings.HasPrefix(strings.ToUpper(s), "P") {
+		d, err = parseISO8601Duration(s)
+	} else if d, err = time.ParseDuration(s); err != nil {
+		err = fmt.Errorf("invalid duration %q: use a Go duration like \"3h30m\" or ISO 8601 like \"PT3H30M\"", s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
+	}
+	return Duration(d), nil
+}
+
+// MarshalJSON writes the canonical ISO 8601 string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts every historical duration shape (see above)
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*d = 0
+		return nil
+
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+
+	case len(data) > 0 && data[0] == '{':
+		// Seconds may arrive as text from XML and CSV bodies, so it is read as
+		// a json.Number, which accepts both
+		var v2 struct {
+			ISO8601 string      `json:"iso8601"`
+			Seconds json.Number `json:"seconds"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return err
+		}
+		if v2.ISO8601 != "" {
+			return d.UnmarshalJSON([]byte(strconv.Quote(v2.ISO8601)))
+		}
+		if v2.Seconds == "" {
+			*d = 0
+			return nil
+		}
+		return d.UnmarshalJSON([]byte(v2.Seconds))
+	}
+
+	// Plain number of seconds
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	if secs < 0 {
+		return errors.New("duration seconds must not be negative")
+	}
+	if secs >= maxDurationSeconds {
+		return fmt.Errorf("duration of %s seconds is too long", data)
+	}
+	*d = Duration(time.Duration(secs * float64(time.Second)))
+	return nil
+}
+
+// Duration formatting helpers
+
+// iso8601Duration matches the time-only subset of ISO 8601 durations we accept,
+// e.g. "PT3H", "PT3H30M", "PT45M10S" and "P1DT2H" (days are treated as 24h)
+var iso8601Duration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISO8601Duration converts an ISO 8601 duration such as "PT3H30M" to a time.Duration
+func parseISO8601Duration(s string) (time.Duration, error) {
+	upper := strings.ToUpper(s)
+	m := iso8601Duration.FindStringSubmatch(upper)
+	if m == nil || upper == "P" || strings.HasSuffix(upper, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+
+	var d time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", s, err)
+		}
+		if n > int64(math.MaxInt64/unit) || time.Duration(n)*unit > math.MaxInt64-d {
+			return 0, fmt.Errorf("ISO 8601 duration %q is too long", s)
+		}
+		d += time.Duration(n) * unit
+	}
+
+	// Seconds may carry a fractional part ("PT1.5S")
+	if m[4] != "" {
+		secs, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", s, err)
+		}
+		if secs >= maxDurationSeconds-d.Seconds() {
+			return 0, fmt.Errorf("ISO 8601 duration %q is too long", s)
+		}
+		d += time.Duration(secs * float64(time.Second))
+	}
+	return d, nil
+}
+
+// formatISO8601Duration renders a duration as ISO 8601, e.g. 3h30m -> "PT3H30M"
+func formatISO8601Duration(d time.Duration) string {
+	if d <= 0 {
+		return "PT0S"
+	}
+
+	var b strings.Builder
+	b.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+		d -= m * time.Minute
+	}
+	if d > 0 {
+		b.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S")
+	}
+	return b.String()
+}
+
+// formatShortDuration renders a duration the way course data is written by hand,
+// dropping zero units: 3h -> "3h", 3h30m -> "3h30m", 45m -> "45m"
+func formatShortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Enrollment and learner progress - enrollment.go
+// Learners enroll in courses and record each lesson they complete. Progress
+// is the share of the course's current lessons that were completed, so it
+// follows curriculum changes; once it first reaches 100% the enrollment is
+// marked completed and a certificate can be issued (see certificate.go).
+//
+// Creating a learner returns an access_token, which the learner sends back as
+// X-Learner-Token to use their carts and orders (see checkout.go).
+
+// Learner is someone taking courses
+type Learner struct {
+	ID          string    `json:"id"`                     // Unique identifier for the learner
+	Name        string    `json:"name"`                   // Learner's full name
+	Email       string    `json:"email"`                  // Unique (case-insensitive) email address
+	CreatedAt   time.Time `json:"created_at"`             // When the account was created
+	AccessToken string    `json:"access_token,omitempty"` // Only in the response to its creation
+}
+
+// Enrollment links a learner to a course and tracks their progress
+type Enrollment struct {
+	ID          string       `json:"id"`           // Unique identifier for the enrollment
+	CourseID    string       `json:"course_id"`    // Enrolled course
+	LearnerID   string       `json:"learner_id"`   // Enrolled learner
+	EnrolledAt  time.Time    `json:"enrolled_at"`  // When the learner enrolled
+	Completions []Completion `json:"completions"`  // Lesson completion events in order
+	Progress    float64      `json:"progress"`     // Percentage of lessons completed (computed)
+	CompletedAt *time.Time   `json:"completed_at"` // When progress first reached 100%
+}
+
+// Completion records that a learner finished a lesson
+type Completion struct {
+	LessonID    string    `json:"lesson_id"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+var (
+	errLearnerNotFound    = errors.New("No learner found with the given ID")
+	errEnrollmentNotFound = errors.New("No enrollment found with the given ID")
+	errLearnerExists      = errors.New("A learner with this email already exists")
+	errAlreadyEnrolled    = errors.New("The learner is already enrolled in this course")
+	errNotLearner         = errors.New("Only the learner can access this")
+)
+
+// Learners
+
+// CreateLearner stores a new learner account under a fresh ID
+func (s *courseStore) CreateLearner(l Learner) (Learner, error) {
+	l.Name = strings.TrimSpace(l.Name)
+	if l.Name == "" {
+		return Learner{}, errors.New("Learner name is required")
+	}
+	addr, err := mail.ParseAddress(l.Email)
+	if err != nil {
+		return Learner{}, fmt.Errorf("invalid email address %q", l.Email)
+	}
+	l.Email = addr.Address
+	l.AccessToken = ""
+
+	s.mu.Lock()
+	if s.quota.Learners > 0 && len(s.learners) >= s.quota.Learners {
+		s.mu.Unlock()
+		return Learner{}, fmt.Errorf("%w: at most %d learners can sign up", errQuotaExceeded, s.quota.Learners)
+	}
+	for _, existing := range s.learners {
+		if strings.EqualFold(existing.Email, l.Email) {
+			s.mu.Unlock()
+			return Learner{}, errLearnerExists
+		}
+	}
+	l.ID = strconv.Itoa(s.nextLearnerID)
+	s.nextLearnerID++
+	l.CreatedAt = time.Now().UTC()
+	s.learners = append(s.learners, l)
+	s.mu.Unlock()
+
+	// Learner lists are served through the same response cache
+	s.catalogChanged()
+	return l, nil
+}
+
+// Learners returns every learner in creation order
+func (s *courseStore) Learners() []Learner {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.learners)
+}
+
+// Learner returns the learner with the given ID
+func (s *courseStore) Learner(id string) (Learner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.learnerIndex(id); i >= 0 {
+		return s.learners[i], nil
+	}
+	return Learner{}, errLearnerNotFound
+}
+
+// learnerToken is the secret that identifies a learner: an HMAC of the
+// learner under the tenant's certificate key, so it isn't stored
+func (s *courseStore) learnerToken(l Learner) string {
+	mac := hmac.New(sha256.New, s.certificateKey())
+	fmt.Fprintf(mac, "learner:%s/%d", l.ID, l.CreatedAt.UnixNano())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// learnerIndex returns the position of a learner or -1; called with s.mu held
+func (s *courseStore) learnerIndex(id string) int {
+	return slices.IndexFunc(s.learners, func(l Learner) bool { return l.ID == id })
+}
+
+// Enrollments
+
+// Enroll enrolls a learner in a course, at most once
+func (s *courseStore) Enroll(courseID, learnerID string) (Enrollment, error) {
+	s.mu.Lock()
+	switch {
+	case s.index(courseID) < 0:
+		s.mu.Unlock()
+		return Enrollment{}, errCourseNotFound
+	case !s.courses[s.index(courseID)].Live():
+		s.mu.Unlock()
+		return Enrollment{}, errCourseNotPublished
+	case s.learnerIndex(learnerID) < 0:
+		s.mu.Unlock()
+		return Enrollment{}, errLearnerNotFound
+	case slices.ContainsFunc(s.enrollments, func(e Enrollment) bool {
+		return e.CourseID == courseID && e.LearnerID == learnerID
+	}):
+		s.mu.Unlock()
+		return Enrollment{}, errAlreadyEnrolled
+	}
+
+	e := s.addEnrollment(courseID, learnerID)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return e, nil
+}
+
+// addEnrollment stores a new enrollment and returns it with its progress;
+// called with s.mu held after checking the course and learner
+func (s *courseStore) addEnrollment(courseID, learnerID string) Enrollment {
+	e := Enrollment{
+		ID:          strconv.Itoa(s.nextEnrollmentID),
+		CourseID:    courseID,
+		LearnerID:   learnerID,
+		EnrolledAt:  time.Now().UTC(),
+		Completions: []Completion{},
+	}
+	s.nextEnrollmentID++
+	s.enrollments = append(s.enrollments, e)
+	return s.withProgress(e)
+}
+
+// Enrollments returns the enrollments of a course
+func (s *courseStore) Enrollments(courseID string) ([]Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.index(courseID) < 0 {
+		return nil, errCourseNotFound
+	}
+	return s.filterEnrollments(func(e Enrollment) bool { return e.CourseID == courseID }), nil
+}
+
+// LearnerEnrollments returns every enrollment of a learner
+func (s *courseStore) LearnerEnrollments(learnerID string) ([]Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.learnerIndex(learnerID) < 0 {
+		return nil, errLearnerNotFound
+	}
+	return s.filterEnrollments(func(e Enrollment) bool { return e.LearnerID == learnerID }), nil
+}
+
+// filterEnrollments returns matching enrollments with progress; called with s.mu held
+func (s *courseStore) filterEnrollments(keep func(Enrollment) bool) []Enrollment {
+	list := []Enrollment{}
+	for _, e := range s.enrollments {
+		if keep(e) {
+			list = append(list, s.withProgress(e))
+		}
+	}
+	return list
+}
+
+// Enrollment returns one enrollment of a course
+func (s *courseStore) Enrollment(courseID, id string) (Enrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, err := s.enrollmentIndex(courseID, id)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	return s.withProgress(s.enrollments[i]), nil
+}
+
+// enrollmentIndex finds an enrollment of a course; called with s.mu held
+func (s *courseStore) enrollmentIndex(courseID, id string) (int, error) {
+	if s.index(courseID) < 0 {
+		return -1, errCourseNotFound
+	}
+	i := slices.IndexFunc(s.enrollments, func(e Enrollment) bool {
+		return e.ID == id && e.CourseID == courseID
+	})
+	if i < 0 {
+		return -1, errEnrollmentNotFound
+	}
+	return i, nil
+}
+
+// CompleteLesson records that the learner finished a lesson of the course.
+// Completing a lesson twice keeps the first event.
+func (s *courseStore) CompleteLesson(courseID, id, lessonID string) (Enrollment, error) {
+	s.mu.Lock()
+	i, err := s.enrollmentIndex(courseID, id)
+	if err != nil {
+		s.mu.Unlock()
+		return Enrollment{}, err
+	}
+	if !slices.Contains(courseLessonIDs(s.courses[s.index(courseID)]), lessonID) {
+		s.mu.Unlock()
+		return Enrollment{}, errLessonNotFound
+	}
+
+	e := &s.enrollments[i]
+	if !slices.ContainsFunc(e.Completions, func(c Completion) bool { return c.LessonID == lessonID }) {
+		e.Completions = append(e.Completions, Completion{LessonID: lessonID, CompletedAt: time.Now().UTC()})
+	}
+	updated := s.withProgress(*e)
+	if updated.Progress == 100 && e.CompletedAt == nil {
+		completedAt := time.Now().UTC()
+		e.CompletedAt = &completedAt
+		updated.CompletedAt = &completedAt
+	}
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return updated, nil
+}
+
+// withProgress returns a copy of an enrollment with its progress computed
+// against the course's current lessons; called with s.mu held
+func (s *courseStore) withProgress(e Enrollment) Enrollment {
+	e.Completions = slices.Clone(e.Completions)
+	e.Progress = 0
+
+	i := s.index(e.CourseID)
+	if i < 0 {
+		return e
+	}
+	lessons := courseLessonIDs(s.courses[i])
+	if len(lessons) == 0 {
+		return e
+	}
+	done := 0
+	for _, c := range e.Completions {
+		if slices.Contains(lessons, c.LessonID) {
+			done++
+		}
+	}
+	// One decimal place is enough for a progress bar
+	e.Progress = math.Round(float64(done)/float64(len(lessons))*1000) / 10
+	return e
+}
+
+// courseLessonIDs lists the IDs of every lesson in a course
+func courseLessonIDs(c Course) []string {
+	var ids []string
+	for _, m := range c.Modules {
+		for _, l := range m.Lessons {
+			ids = append(ids, l.ID)
+		}
+	}
+	return ids
+}
+
+// Controller for learners and enrollments
+
+// authorizeLearner checks that the caller is the learner, by their
+// X-Learner-Token, or an admin. It reports false after responding.
+func authorizeLearner(w http.ResponseWriter, r *http.Request, learnerID string) bool {
+	if tenantFrom(r.Context()).admin(r) {
+		return true
+	}
+	store := storeFrom(r)
+	l, err := store.Learner(learnerID)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return false
+	}
+	if !hmac.Equal([]byte(r.Header.Get("X-Learner-Token")), []byte(store.learnerToken(l))) {
+		render(w, r, http.StatusForbidden, errNotLearner.Error())
+		return false
+	}
+	return true
+}
+
+// createLearner creates a learner account
+// POST /learners
+func createLearner(w http.ResponseWriter, r *http.Request) {
+	var l Learner
+	if renderDecodeError(w, r, decodeBody(r, &l)) {
+		return
+	}
+	store := storeFrom(r)
+	l, err := store.CreateLearner(l)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	l.AccessToken = store.learnerToken(l)
+	render(w, r, http.StatusCreated, l)
+}
+
+// getLearners lists every learner
+// GET /learners
+func getLearners(w http.ResponseWriter, r *http.Request) {
+	render(w, r, http.StatusOK, storeFrom(r).Learners())
+}
+
+// getLearner returns one learner
+// GET /learners/{learner}
+func getLearner(w http.ResponseWriter, r *http.Request) {
+	l, err := storeFrom(r).Learner(mux.Vars(r)["learner"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, l)
+}
+
+// getLearnerEnrollments lists the courses a learner is enrolled in
+// GET /learners/{learner}/enrollments
+func getLearnerEnrollments(w http.ResponseWriter, r *http.Request) {
+	list, err := storeFrom(r).LearnerEnrollments(mux.Vars(r)["learner"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, list)
+}
+
+// createEnrollment enrolls a learner in a course
+// POST /courses/{id}/enrollments  {"learner_id": "1"}
+func createEnrollment(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LearnerID string `json:"learner_id"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) {
+		return
+	}
+	if body.LearnerID == "" {
+		render(w, r, http.StatusBadRequest, "learner_id is required")
+		return
+	}
+
+	e, err := storeFrom(r).Enroll(mux.Vars(r)["id"], body.LearnerID)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusCreated, e)
+}
+
+// getEnrollments lists the enrollments of a course
+// GET /courses/{id}/enrollments
+func getEnrollments(w http.ResponseWriter, r *http.Request) {
+	list, err := storeFrom(r).Enrollments(mux.Vars(r)["id"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, list)
+}
+
+// getEnrollment returns one enrollment with its progress
+// GET /courses/{id}/enrollments/{eid}
+func getEnrollment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := storeFrom(r).Enrollment(vars["id"], vars["eid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, e)
+}
+
+// completeLesson records a lesson completion event
+// POST /courses/{id}/enrollments/{eid}/completions  {"lesson_id": "3"}
+func completeLesson(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LessonID string `json:"lesson_id"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) {
+		return
+	}
+	if body.LessonID == "" {
+		render(w, r, http.StatusBadRequest, "lesson_id is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	e, err := storeFrom(r).CompleteLesson(vars["id"], vars["eid"], body.LessonID)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, e)
+}
+
+// registerEnrollmentRoutes adds the learner, enrollment and certificate routes
+func registerEnrollmentRoutes(r *mux.Router) {
+	r.HandleFunc("/learners", getLearners).Methods("GET")
+	r.HandleFunc("/learners", createLearner).Methods("POST")
+	r.HandleFunc("/learners/{learner}", getLearner).Methods("GET")
+	r.HandleFunc("/learners/{learner}/enrollments", getLearnerEnrollments).Methods("GET")
+
+	r.HandleFunc("/courses/{id}/enrollments", getEnrollments).Methods("GET")
+	r.HandleFunc("/courses/{id}/enrollments", createEnrollment).Methods("POST")
+	r.HandleFunc("/courses/{id}/enrollments/{eid}", getEnrollment).Methods("GET")
+	r.HandleFunc("/courses/{id}/enrollments/{eid}/completions", completeLesson).Methods("POST")
+	r.HandleFunc("/courses/{id}/enrollments/{eid}/certificate", getCertificate).Methods("GET")
+	r.HandleFunc("/certificates/verify", verifyCertificate).Methods("POST")
+}
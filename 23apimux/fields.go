@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Sparse fieldsets and expansion - fields.go
+// ?fields=id,name,author.fullname trims a response down to the listed fields,
+// and ?expand=author,modules picks which related resources are inlined.
+// Both work on the generic value tree (see tree.go), and field names are
+// checked against the JSON tags of the response type, so new model fields
+// can be selected without touching this file.
+//
+// Without ?expand a response keeps its version's usual shape. With it, every
+// expandable relation that isn't listed is collapsed: an author becomes a
+// reference (id and links), modules are left out and prerequisites stay IDs.
+
+// fieldSet is a parsed ?fields= list. Each key is a JSON field name mapped to
+// the fields selected inside it; nil selects the whole value.
+type fieldSet map[string]fieldSet
+
+// parseFields parses a comma separated list of dotted field paths
+func parseFields(list string) (fieldSet, error) {
+	fs := fieldSet{}
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if slices.Contains(names, "") {
+			return nil, fmt.Errorf("invalid field %q", path)
+		}
+		fs.add(names)
+	}
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("fields must list at least one field")
+	}
+	return fs, nil
+}
+
+// add selects a path; selecting a whole value wins over selecting parts of it
+func (fs fieldSet) add(names []string) {
+	sub, seen := fs[names[0]]
+	switch {
+	case len(names) == 1:
+		fs[names[0]] = nil
+	case seen && sub == nil:
+		// Already selected as a whole
+	default:
+		if sub == nil {
+			sub = fieldSet{}
+			fs[names[0]] = sub
+		}
+		sub.add(names[1:])
+	}
+}
+
+// check verifies that every selected field exists in values of type t.
+// Top-level fields listed in override are checked against their type there
+// instead, for relations that expansion replaces with a different shape.
+func (fs fieldSet) check(t reflect.Type, prefix string, override map[string]reflect.Type) error {
+	t = elemType(t)
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil // Anything can be inside
+	case reflect.Map:
+		// Any key can be present, e.g. a link relation in _links
+		for _, name := range slices.Sorted(maps.Keys(fs)) {
+			if sub := fs[name]; sub != nil {
+				if err := sub.check(t.Elem(), prefix+name+".", nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	fields := jsonFields(t)
+	for _, name := range slices.Sorted(maps.Keys(fs)) {
+		ft, ok := override[name]
+		if !ok {
+			ft, ok = fields[name]
+		}
+		if !ok {
+			return fmt.Errorf("unknown field %q", prefix+name)
+		}
+		if sub := fs[name]; sub != nil {
+			if err := sub.check(ft, prefix+name+".", nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// project keeps only the selected fields of a tree; lists are projected item
+// by item and scalars are left alone
+func (fs fieldSet) project(node any) any {
+	switch n := node.(type) {
+	case object:
+		out := object{}
+		for _, m := range n {
+			sub, ok := fs[m.Key]
+			switch {
+			case !ok:
+				continue
+			case sub == nil:
+				out = append(out, m)
+			default:
+				out = append(out, member{Key: m.Key, Value: sub.project(m.Value)})
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(n))
+		for i, item := range n {
+			out[i] = fs.project(item)
+		}
+		return out
+	}
+	return node
+}
+
+var jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+// elemType unwraps pointers, slices and arrays down to the type of one value
+func elemType(t reflect.Type) reflect.Type {
+	for {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array:
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+// jsonFields maps the JSON names of a type's fields to their types, the way
+// encoding/json names them: tags first, embedded structs flattened. Types
+// with their own MarshalJSON are opaque.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return fields
+	}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			for k, v := range jsonFields(elemType(f.Type)) {
+				if _, ok := fields[k]; !ok {
+					fields[k] = v
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// Expansion
+
+// expansion is a related resource that ?expand= can inline
+type expansion struct {
+	// inline returns the full related value for the resource with the given
+	// ID; nil when responses already carry it
+	inline func(r *http.Request, id string) any
+	// inlineType is the type inline returns, for checking ?fields=
+	inlineType func(r *http.Request) reflect.Type
+	// collapse reduces the value when it isn't expanded; false drops the field
+	collapse func(value any) (any, bool)
+}
+
+// keepAsIs leaves a relation as it is when it isn't expanded
+func keepAsIs(value any) (any, bool) { return value, true }
+
+// dropField leaves a relation out when it isn't expanded
+func dropField(any) (any, bool) { return nil, false }
+
+// reference collapses an embedded resource to its ID and links
+func reference(value any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	return fieldSet{"id": nil, "_links": nil}.project(value), true
+}
+
+// courseExpansions are the relations of a course
+var courseExpansions = map[string]expansion{
+	"author": {collapse: reference},
+	"modules": {
+		inline: func(r *http.Request, id string) any {
+			modules, _ := storeFrom(r).Modules(id)
+			if modules == nil {
+				modules = []Module{}
+			}
+			return modules
+		},
+		inlineType: func(*http.Request) reflect.Type { return reflect.TypeFor[[]Module]() },
+		collapse:   dropField,
+	},
+	"prerequisites": {
+		inline: func(r *http.Request, id string) any {
+			store := storeFrom(r)
+			course, _ := store.Get(id)
+			v := versionFrom(r)
+			list := []any{}
+			for _, p := range course.Prerequisites {
+				if c, ok := store.Get(p); ok && c.Live() {
+					list = append(list, v.encodeCourse(r, c))
+				}
+			}
+			return list
+		},
+		inlineType: courseListType,
+		collapse:   keepAsIs,
+	},
+}
+
+// authorExpansions are the relations of an author
+var authorExpansions = map[string]expansion{
+	"courses": {
+		inline: func(r *http.Request, id string) any {
+			v := versionFrom(r)
+			list := []any{}
+			for _, c := range publishedOnly(storeFrom(r).CoursesByAuthor(id)) {
+				list = append(list, v.encodeCourse(r, c))
+			}
+			return list
+		},
+		inlineType: courseListType,
+		collapse:   dropField,
+	},
+}
+
+// courseListType is the type of a list of courses in the request's version
+func courseListType(r *http.Request) reflect.Type {
+	return reflect.SliceOf(reflect.TypeOf(versionFrom(r).encodeCourse(r, Course{})))
+}
+
+// shape is how a request wants a resource's responses trimmed and expanded
+type shape struct {
+	expand     map[string]bool // Relations to inline; nil keeps the default shape
+	fields     fieldSet        // Fields to keep; nil keeps every field
+	expansions map[string]expansion
+}
+
+// parseShape reads ?expand= and ?fields= for responses whose values have the
+// given type (a wire struct such as courseV2)
+func parseShape(r *http.Request, t reflect.Type, expansions map[string]expansion) (shape, error) {
+	s := shape{expansions: expansions}
+	q := r.URL.Query()
+
+	if q.Has("expand") {
+		s.expand = map[string]bool{}
+		for _, name := range strings.Split(q.Get("expand"), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := expansions[name]; !ok {
+				names := slices.Sorted(maps.Keys(expansions))
+				return shape{}, fmt.Errorf("cannot expand %q; expandable: %s", name, strings.Join(names, ", "))
+			}
+			s.expand[name] = true
+		}
+	}
+
+	if q.Has("fields") {
+		fields, err := parseFields(q.Get("fields"))
+		if err != nil {
+			return shape{}, err
+		}
+		// Expanded relations are checked against what they are expanded into
+		override := map[string]reflect.Type{}
+		for name := range s.expand {
+			if e := expansions[name]; e.inlineType != nil {
+				override[name] = e.inlineType(r)
+			}
+		}
+		if err := fields.check(t, "", override); err != nil {
+			return shape{}, err
+		}
+		s.fields = fields
+	}
+	return s, nil
+}
+
+// active reports whether the response needs reshaping at all
+func (s shape) active() bool {
+	return s.expand != nil || s.fields != nil
+}
+
+// apply reshapes an encoded resource, or a list of them
+func (s shape) apply(r *http.Request, v any) (any, error) {
+	if !s.active() {
+		return v, nil
+	}
+	tree, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+	if list, ok := tree.([]any); ok {
+		for i, item := range list {
+			list[i] = s.applyOne(r, item)
+		}
+	} else {
+		tree = s.applyOne(r, tree)
+	}
+	if s.fields != nil {
+		tree = s.fields.project(tree)
+	}
+	return tree, nil
+}
+
+// applyOne expands or collapses the relations of one resource
+func (s shape) applyOne(r *http.Request, node any) any {
+	obj, ok := node.(object)
+	if !ok || s.expand == nil {
+		return node
+	}
+	id, _ := obj.get("id")
+	idString, _ := id.(string)
+
+	out := object{}
+	for _, m := range obj {
+		e, ok := s.expansions[m.Key]
+		switch {
+		case !ok:
+			out = append(out, m)
+		case s.expand[m.Key]:
+			if e.inline != nil {
+				m.Value = treeOf(e.inline(r, idString))
+			}
+			out = append(out, m)
+		default:
+			if value, keep := e.collapse(m.Value); keep {
+				out = append(out, member{Key: m.Key, Value: value})
+			}
+		}
+	}
+	// Relations that the version doesn't carry at all are added at the end
+	for _, name := range slices.Sorted(maps.Keys(s.expand)) {
+		if _, present := obj.get(name); !present && s.expansions[name].inline != nil {
+			out = append(out, member{Key: name, Value: treeOf(s.expansions[name].inline(r, idString))})
+		}
+	}
+	return out
+}
+
+// treeOf converts a value to a tree, for splicing it into another tree
+func treeOf(v any) any {
+	tree, err := toTree(v)
+	if err != nil {
+		return nil
+	}
+	return tree
+}
+
+// renderShaped renders an encoded resource (or list) of the given type after
+// applying the request's ?expand= and ?fields=
+func renderShaped(w http.ResponseWriter, r *http.Request, status int, v any, t reflect.Type, expansions map[string]expansion) {
+	s, err := parseShape(r, t, expansions)
+	if err != nil {
+		render(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	shaped, err := s.apply(r, v)
+	if err != nil {
+		render(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render(w, r, status, shaped)
+}
+
+// renderCourses sends courses in the request's version and shape
+func renderCourses(w http.ResponseWriter, r *http.Request, status int, courses []Course) {
+	v := versionFrom(r)
+	list := make([]any, 0, len(courses))
+	for _, c := range courses {
+		list = append(list, v.encodeCourse(r, c))
+	}
+	renderShaped(w, r, status, list, reflect.TypeOf(v.encodeCourse(r, Course{})), courseExpansions)
+}
+
+// renderCourse sends one course in the request's version and shape
+func renderCourse(w http.ResponseWriter, r *http.Request, status int, c Course) {
+	wire := versionFrom(r).encodeCourse(r, c)
+	renderShaped(w, r, status, wire, reflect.TypeOf(wire), courseExpansions)
+}
+
+// renderAuthors sends authors with their links, in the request's shape
+func renderAuthors(w http.ResponseWriter, r *http.Request, status int, authors []Author) {
+	l := linksFrom(r)
+	list := make([]*authorResource, 0, len(authors))
+	for _, a := range authors {
+		list = append(list, l.authorResource(&a))
+	}
+	renderShaped(w, r, status, list, reflect.TypeFor[authorResource](), authorExpansions)
+}
+
+// renderAuthor sends one author with its links, in the request's shape
+func renderAuthor(w http.ResponseWriter, r *http.Request, status int, a Author) {
+	renderShaped(w, r, status, linksFrom(r).authorResource(&a), reflect.TypeFor[authorResource](), authorExpansions)
+}
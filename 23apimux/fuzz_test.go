@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Fuzz tests - fuzz_test.go
+// The JSON decoding paths must reject bad input with a client error, never
+// panic, and read back whatever they write. Run one for longer with e.g.
+//
+//	go test -run '^$' -fuzz FuzzCreateCourse -fuzztime 30s
+
+func FuzzCreateCourse(f *testing.F) {
+	for _, seed := range []string{
+		`{"name":"Go","duration":"3h","price":29.99,"author":{"fullname":"Jane Roe"}}`,
+		`{"name":"Go","duration":{"iso8601":"PT3H","seconds":10800},"price":{"amount":2999,"currency":"USD"}}`,
+		`{"name":"Go","duration":5400,"price":"19.99 EUR","status":"scheduled","publish_at":"2030-01-01T00:00:00Z"}`,
+		`{"name":" ","duration":"-1h"}`,
+		`{"name":"Go","prerequisites":["1","1"]}`,
+		`{"name":`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	newTestRouter(f)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		rec := httptest.NewRecorder()
+		createOneCourse(rec, handlerRequest(apiCall{method: "POST", path: "/courses", body: body}, nil))
+
+		switch rec.Code {
+		case http.StatusOK:
+			// Created, or the "No data in the request body" message
+		case http.StatusBadRequest, http.StatusConflict, http.StatusForbidden, http.StatusUnprocessableEntity:
+			if rec.Body.Len() == 0 {
+				t.Errorf("status %d with an empty body", rec.Code)
+			}
+		default:
+			t.Errorf("status = %d for body %q", rec.Code, body)
+		}
+		if !json.Valid(rec.Body.Bytes()) {
+			t.Errorf("response isn't JSON: %s", rec.Body)
+		}
+	})
+}
+
+func FuzzDurationJSON(f *testing.F) {
+	for _, seed := range []string{
+		`"3h30m"`, `"PT3H30M"`, `"P1DT2H"`, `"pt1.5s"`, `12600`, `0.5`, `-1`, `1e300`,
+		`{"iso8601":"PT2H","seconds":7200}`, `{"seconds":"60"}`, `{}`, `null`, `"P"`, `"PT"`, `"-1h"`, `"`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		var d Duration
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return
+		}
+		if d < 0 {
+			t.Fatalf("%s decoded to a negative duration %d", data, d)
+		}
+		out, err := json.Marshal(d)
+		if err != nil {
+			t.Fatalf("marshaling %d: %v", d, err)
+		}
+		var back Duration
+		if err := json.Unmarshal(out, &back); err != nil {
+			t.Fatalf("%s decoded to %d, written as %s, which doesn't decode: %v", data, d, out, err)
+		}
+		if back != d {
+			t.Fatalf("%s decoded to %d, written as %s, read back as %d", data, d, out, back)
+		}
+	})
+}
+
+func FuzzParseDuration(f *testing.F) {
+	for _, seed := range []string{"3h", "1h30m", "PT3H", "P2D", "PT0.25S", "", " ", "-5m", "P1Y", "PT1H1H"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		d, err := ParseDuration(s)
+		if err != nil {
+			return
+		}
+		back, err := ParseDuration(d.String())
+		if err != nil || back != d {
+			t.Fatalf("%q parsed to %d, formatted as %q, read back as %d (%v)", s, d, d.String(), back, err)
+		}
+	})
+}
+
+func FuzzMoneyJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{"amount":2999,"currency":"USD"}`, `{"amount":100,"currency":"jpy"}`, `{"amount":1.5,"currency":"USD"}`,
+		`29.99`, `"29.99"`, `"29.99 EUR"`, `"EUR 29.99"`, `"0.001"`, `-1`, `1e30`, `{"currency":"XXX"}`, `null`, `"`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		var m Money
+		if err := json.Unmarshal([]byte(data), &m); err != nil || m.Currency == "" {
+			return // null leaves the zero Money, which isn't a price
+		}
+		out, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("marshaling %+v: %v", m, err)
+		}
+		var back Money
+		if err := json.Unmarshal(out, &back); err != nil {
+			t.Fatalf("%s decoded to %+v, written as %s, which doesn't decode: %v", data, m, out, err)
+		}
+		if back != m {
+			t.Fatalf("%s decoded to %+v, written as %s, read back as %+v", data, m, out, back)
+		}
+	})
+}
+
+func FuzzReadBatch(f *testing.F) {
+	for _, seed := range []string{
+		`[{"method":"GET","path":"/v2/courses/1"}]`,
+		`{"transactional":true,"operations":[{"method":"POST","path":"/v2/courses","body":{"name":"Go"}}]}`,
+		`  [ ]  `, `{}`, `[{"method":1}]`, `{"operations":`, ``,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+		batch, err := readBatch(httptest.NewRecorder(), req)
+		if err != nil {
+			if batch.Transactional || batch.Operations != nil {
+				t.Fatalf("%q: error %v, yet the batch was filled in: %+v", body, err, batch)
+			}
+			return
+		}
+		if _, err := json.Marshal(batch); err != nil {
+			t.Fatalf("%q decoded to a batch that can't be encoded: %v", body, err)
+		}
+	})
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// GraphQL query language - graphql.go
+// A small lexer and parser for GraphQL executable documents
+// (https://spec.graphql.org/October2021/#sec-Language). It understands
+// queries and mutations with variables, aliases, arguments, named and inline
+// fragments and directives - everything the /graphql endpoint needs.
+// Schema definitions (SDL) are not parsed; the schema is built in Go.
+
+// gqlError is a GraphQL error as reported in the "errors" response list
+type gqlError struct {
+	Message   string        `json:"message"`
+	Locations []gqlLocation `json:"locations,omitempty"`
+	Path      []any         `json:"path,omitempty"`
+}
+
+func (e *gqlError) Error() string { return e.Message }
+
+// gqlLocation points at a line and column in the query text
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Lexer
+
+type gqlTokenKind int
+
+const (
+	gqlTokEOF gqlTokenKind = iota
+	gqlTokPunct
+	gqlTokName
+	gqlTokInt
+	gqlTokFloat
+	gqlTokString
+)
+
+// gqlToken is one lexical token
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string
+	loc   gqlLocation
+}
+
+// gqlLexer turns query text into tokens
+type gqlLexer struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+}
+
+// next returns the next token, skipping whitespace, commas and comments
+func (l *gqlLexer) next() (gqlToken, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.pos++
+			l.line++
+			l.lineStart = l.pos
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return gqlToken{kind: gqlTokEOF, loc: l.loc()}, nil
+}
+
+// loc returns the current position as a 1-based line and column
+func (l *gqlLexer) loc() gqlLocation {
+	return gqlLocation{Line: l.line + 1, Column: l.pos - l.lineStart + 1}
+}
+
+// token reads the token that starts at the current position
+func (l *gqlLexer) token() (gqlToken, error) {
+	loc := l.loc()
+	c := l.src[l.pos]
+
+	switch {
+	case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+		l.pos++
+		return gqlToken{kind: gqlTokPunct, value: string(c), loc: loc}, nil
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return gqlToken{kind: gqlTokPunct, value: "...", loc: loc}, nil
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return gqlToken{kind: gqlTokName, value: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		return l.string(loc)
+	}
+	return gqlToken{}, l.errorf(loc, "unexpected character %q", c)
+}
+
+// number reads an Int or Float literal
+func (l *gqlLexer) number(loc gqlLocation) (gqlToken, error) {
+	start := l.pos
+	kind := gqlTokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return gqlToken{}, l.errorf(loc, "invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlTokFloat
+		l.pos++
+		if digits() == 0 {
+			return gqlToken{}, l.errorf(loc, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlTokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return gqlToken{}, l.errorf(loc, "invalid number")
+		}
+	}
+	return gqlToken{kind: kind, value: l.src[start:l.pos], loc: loc}, nil
+}
+
+// string reads a "quoted" or """block""" string literal
+func (l *gqlLexer) string(loc gqlLocation) (gqlToken, error) {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return gqlToken{}, l.errorf(loc, "unterminated block string")
+		}
+		raw := l.src[l.pos+3 : l.pos+3+end]
+		for _, r := range raw {
+			if r == '\n' {
+				l.line++
+			}
+		}
+		l.pos += 3 + end + 3
+		return gqlToken{kind: gqlTokString, value: blockStringValue(raw), loc: loc}, nil
+	}
+
+	var b strings.Builder
+	l.pos++ // opening quote
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return gqlToken{kind: gqlTokString, value: b.String(), loc: loc}, nil
+		case c == '\n':
+			return gqlToken{}, l.errorf(loc, "unterminated string")
+		case c == '\\' && l.pos+1 < len(l.src):
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return gqlToken{}, l.errorf(loc, "invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return gqlToken{}, l.errorf(loc, "invalid unicode escape")
+				}
+				b.WriteRune(rune(n))
+				l.pos += 4
+			default:
+				return gqlToken{}, l.errorf(loc, "invalid escape \\%c", esc)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return gqlToken{}, l.errorf(loc, "unterminated string")
+}
+
+// blockStringValue removes the common indentation of a block string
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	common := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if indent := len(line) - len(trimmed); common < 0 || indent < common {
+			common = indent
+		}
+	}
+	if common > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= common {
+				lines[i] = lines[i][common:]
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (l *gqlLexer) errorf(loc gqlLocation, format string, args ...any) error {
+	return &gqlError{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []gqlLocation{loc}}
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// AST
+
+// gqlDocument is a parsed request document
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is a query or mutation
+type gqlOperation struct {
+	kind       string // "query" or "mutation"
+	name       string
+	vars       []*gqlVarDef
+	selections []*gqlSelection
+}
+
+// gqlVarDef declares an operation variable: ($id: ID! = "1")
+type gqlVarDef struct {
+	name     string
+	typ      *gqlTypeRef
+	defValue any // nil when there is no default
+	hasDef   bool
+	loc      gqlLocation
+}
+
+// gqlTypeRef is a type reference such as "[ID!]!"
+type gqlTypeRef struct {
+	name    string      // Named type (empty for lists)
+	elem    *gqlTypeRef // List element type
+	nonNull bool
+}
+
+func (t *gqlTypeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// gqlFragment is a named fragment definition
+type gqlFragment struct {
+	name       string
+	typeCond   string
+	selections []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (...Name) or an inline fragment
+type gqlSelection struct {
+	// Field
+	alias, name string
+	args        []*gqlArgument
+
+	// Fragments
+	spread   string // Fragment name for "...Name"
+	inline   bool   // "... on Type { }" or "... { }"
+	typeCond string
+
+	directives []*gqlDirective
+	selections []*gqlSelection
+	loc        gqlLocation
+}
+
+// responseKey is the alias if present, otherwise the field name
+func (s *gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// gqlArgument is a name: value pair
+type gqlArgument struct {
+	name  string
+	value any
+}
+
+// gqlDirective is @name(args)
+type gqlDirective struct {
+	name string
+	args []*gqlArgument
+}
+
+// Literal values that need resolution at execution time
+
+type gqlVariable string // $name
+type gqlEnum string     // ENUM_VALUE
+
+// Parser
+
+// maxGraphQLDepth is the deepest a query may nest selections, and list and
+// object values. It keeps the recursive parser and executor from running out
+// of stack on hostile queries.
+const maxGraphQLDepth = 10
+
+// gqlParser is a recursive descent parser over the lexer's tokens
+type gqlParser struct {
+	lex   *gqlLexer
+	tok   gqlToken
+	depth int // Nesting of the selection set or value being parsed
+}
+
+// parseGraphQL parses a complete executable document
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{lex: &gqlLexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.tok.kind != gqlTokEOF {
+		switch {
+		case p.peek("{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: sels})
+		case p.tok.kind == gqlTokName && (p.tok.value == "query" || p.tok.value == "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == gqlTokName && p.tok.value == "fragment":
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := doc.fragments[frag.name]; dup {
+				return nil, &gqlError{Message: fmt.Sprintf("There can be only one fragment named %q.", frag.name)}
+			}
+			doc.fragments[frag.name] = frag
+		case p.tok.kind == gqlTokName && p.tok.value == "subscription":
+			return nil, p.errorf("subscriptions are not supported")
+		default:
+			return nil, p.errorf("unexpected %s", p.describe())
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &gqlError{Message: "Document does not contain any operations."}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is the given punctuator
+func (p *gqlParser) peek(punct string) bool {
+	return p.tok.kind == gqlTokPunct && p.tok.value == punct
+}
+
+// skip consumes the punctuator if present
+func (p *gqlParser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes the punctuator or fails
+func (p *gqlParser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.errorf("expected %q, found %s", punct, p.describe())
+	}
+	return p.advance()
+}
+
+// name consumes a Name token
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != gqlTokName {
+		return "", p.errorf("expected Name, found %s", p.describe())
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *gqlParser) describe() string {
+	if p.tok.kind == gqlTokEOF {
+		return "<EOF>"
+	}
+	return strconv.Quote(p.tok.value)
+}
+
+func (p *gqlParser) errorf(format string, args ...any) error {
+	return p.lex.errorf(p.tok.loc, format, args...)
+}
+
+// nest enters a selection set or value, failing past maxGraphQLDepth; the
+// caller leaves it with p.depth--
+func (p *gqlParser) nest() error {
+	p.depth++
+	if p.depth > maxGraphQLDepth {
+		return p.errorf("query is nested more than %d levels deep", maxGraphQLDepth)
+	}
+	return nil
+}
+
+// operation parses "query Name($v: T) @dir { ... }"
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == gqlTokName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			def, err := p.varDef()
+			if err != nil {
+				return nil, err
+			}
+			op.vars = append(op.vars, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+// varDef parses "$name: Type = default"
+func (p *gqlParser) varDef() (*gqlVarDef, error) {
+	loc := p.tok.loc
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+	def := &gqlVarDef{name: name, typ: typ, loc: loc}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		def.hasDef = true
+		if def.defValue, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// typeRef parses "Name", "[Type]" and their "!" forms
+func (p *gqlParser) typeRef() (*gqlTypeRef, error) {
+	t := &gqlTypeRef{}
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t.elem = elem
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t.name = name
+	}
+	ok, err := p.skip("!")
+	t.nonNull = ok
+	return t, err
+}
+
+// fragment parses "fragment Name on Type { ... }"
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	if err := p.advance(); err != nil { // "fragment"
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.errorf("unexpected fragment name \"on\"")
+	}
+	if p.tok.kind != gqlTokName || p.tok.value != "on" {
+		return nil, p.errorf("expected \"on\", found %s", p.describe())
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	typeCond, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFragment{name: name, typeCond: typeCond, selections: sels}, nil
+}
+
+// selectionSet parses "{ selection ... }"
+func (p *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	defer func() { p.depth-- }()
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []*gqlSelection
+	for !p.peek("}") {
+		if p.tok.kind == gqlTokEOF {
+			return nil, p.errorf("expected \"}\", found <EOF>")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("expected Name, found \"}\"")
+	}
+	return sels, p.advance()
+}
+
+// selection parses a field or a fragment
+func (p *gqlParser) selection() (*gqlSelection, error) {
+	sel := &gqlSelection{loc: p.tok.loc}
+	var err error
+
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == gqlTokName && p.tok.value != "on" {
+			sel.spread = p.tok.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+
+		sel.inline = true
+		if p.tok.kind == gqlTokName && p.tok.value == "on" {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if sel.typeCond, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	// Field: alias: name(args) @dirs { ... }
+	if sel.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if sel.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// arguments parses an optional "(name: value ...)" list
+func (p *gqlParser) arguments(constant bool) ([]*gqlArgument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var args []*gqlArgument
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &gqlArgument{name: name, value: value})
+	}
+	return args, p.advance()
+}
+
+// directives parses any number of "@name(args)"
+func (p *gqlParser) directives() ([]*gqlDirective, error) {
+	var dirs []*gqlDirective
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, &gqlDirective{name: name, args: args})
+	}
+	return dirs, nil
+}
+
+// value parses a literal. Constant values (variable defaults) may not
+// reference variables.
+func (p *gqlParser) value(constant bool) (any, error) {
+	defer func() { p.depth-- }()
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case gqlTokInt:
+		n, err := strconv.ParseInt(tok.value, 10, 32)
+		if err != nil {
+			return nil, p.errorf("Int cannot represent non 32-bit signed integer value: %s", tok.value)
+		}
+		return int(n), p.advance()
+	case gqlTokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid Float %s", tok.value)
+		}
+		return f, p.advance()
+	case gqlTokString:
+		return tok.value, p.advance()
+	case gqlTokName:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(tok.value), nil
+	}
+
+	switch {
+	case p.peek("$"):
+		if constant {
+			return nil, p.errorf("unexpected variable in constant value")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return gqlVariable(name), err
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for !p.peek("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		obj := map[string]any{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.advance()
+	}
+	return nil, p.errorf("unexpected %s", p.describe())
+}
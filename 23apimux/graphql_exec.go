@@ -0,0 +1,881 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// GraphQL execution - graphql_exec.go
+// The schema is described with gqlType values built in Go (see
+// graphql_schema.go). A request is parsed, validated against the schema and
+// then executed field by field, calling each field's resolver.
+
+// gqlType kinds, named as in the introspection spec
+const (
+	gqlScalarKind      = "SCALAR"
+	gqlObjectKind      = "OBJECT"
+	gqlInputObjectKind = "INPUT_OBJECT"
+	gqlEnumKind        = "ENUM"
+	gqlListKind        = "LIST"
+	gqlNonNullKind     = "NON_NULL"
+)
+
+// gqlType is a named type or a List/NonNull wrapper around one
+type gqlType struct {
+	kind        string
+	name        string
+	description string
+	ofType      *gqlType // Wrapped type for LIST and NON_NULL
+
+	fields      []*gqlField      // OBJECT
+	inputFields []*gqlInputValue // INPUT_OBJECT
+	enumValues  []string         // ENUM
+}
+
+// gqlField is a field of an object type
+type gqlField struct {
+	name        string
+	description string
+	args        []*gqlInputValue
+	typ         *gqlType
+	resolve     func(p gqlResolveParams) (any, error)
+}
+
+// gqlInputValue is a field argument or an input object field
+type gqlInputValue struct {
+	name         string
+	typ          *gqlType
+	defaultValue any
+}
+
+// gqlResolveParams is passed to every resolver
+type gqlResolveParams struct {
+	ctx    context.Context
+	source any            // Parent value (nil for root fields)
+	args   map[string]any // Coerced arguments
+}
+
+// gqlSchema is the root of the type system
+type gqlSchema struct {
+	query    *gqlType
+	mutation *gqlType
+	types    map[string]*gqlType // Every named type, including built-in scalars
+}
+
+// Built-in scalars
+var (
+	gqlString  = &gqlType{kind: gqlScalarKind, name: "String"}
+	gqlInt     = &gqlType{kind: gqlScalarKind, name: "Int"}
+	gqlFloat   = &gqlType{kind: gqlScalarKind, name: "Float"}
+	gqlBoolean = &gqlType{kind: gqlScalarKind, name: "Boolean"}
+	gqlID      = &gqlType{kind: gqlScalarKind, name: "ID"}
+)
+
+// gqlNonNull and gqlListOf build wrapper types
+func gqlNonNull(t *gqlType) *gqlType { return &gqlType{kind: gqlNonNullKind, ofType: t} }
+func gqlListOf(t *gqlType) *gqlType  { return &gqlType{kind: gqlListKind, ofType: t} }
+
+// String renders a type reference in SDL notation, e.g. "[Course!]!"
+func (t *gqlType) String() string {
+	switch t.kind {
+	case gqlNonNullKind:
+		return t.ofType.String() + "!"
+	case gqlListKind:
+		return "[" + t.ofType.String() + "]"
+	}
+	return t.name
+}
+
+// named strips List and NonNull wrappers
+func (t *gqlType) named() *gqlType {
+	for t.ofType != nil {
+		t = t.ofType
+	}
+	return t
+}
+
+// isList reports whether t, under any NonNull wrapper, is a list
+func (t *gqlType) isList() bool {
+	for t.kind == gqlNonNullKind {
+		t = t.ofType
+	}
+	return t.kind == gqlListKind
+}
+
+// field looks up a field of an object type
+func (t *gqlType) field(name string) *gqlField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// newGQLSchema indexes every type reachable from the root types
+func newGQLSchema(query, mutation *gqlType) *gqlSchema {
+	s := &gqlSchema{query: query, mutation: mutation, types: map[string]*gqlType{}}
+	var visit func(t *gqlType)
+	visit = func(t *gqlType) {
+		t = t.named()
+		if _, seen := s.types[t.name]; seen {
+			return
+		}
+		s.types[t.name] = t
+		for _, f := range t.fields {
+			visit(f.typ)
+			for _, a := range f.args {
+				visit(a.typ)
+			}
+		}
+		for _, f := range t.inputFields {
+			visit(f.typ)
+		}
+	}
+	for _, t := range []*gqlType{gqlString, gqlInt, gqlFloat, gqlBoolean, gqlID, query, mutation} {
+		if t != nil {
+			visit(t)
+		}
+	}
+	return s
+}
+
+// gqlRequest is a GraphQL request as sent over HTTP
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// gqlResponse is the result of executing a request
+type gqlResponse struct {
+	Data   any         `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// gqlExecution holds the state of one request
+type gqlExecution struct {
+	ctx    context.Context
+	schema *gqlSchema
+	doc    *gqlDocument
+	vars   map[string]any
+	errors []*gqlError
+}
+
+// execute parses, validates and runs a request. allowMutation is false for GET
+// requests, which must not have side effects.
+func (s *gqlSchema) execute(ctx context.Context, req gqlRequest, allowMutation bool) *gqlResponse {
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return &gqlResponse{Errors: []*gqlError{asGQLError(err)}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &gqlResponse{Errors: []*gqlError{asGQLError(err)}}
+	}
+
+	root := s.query
+	if op.kind == "mutation" {
+		if s.mutation == nil {
+			return &gqlResponse{Errors: []*gqlError{{Message: "Schema is not configured for mutations."}}}
+		}
+		if !allowMutation {
+			return &gqlResponse{Errors: []*gqlError{{Message: "Mutations can only be sent with POST."}}}
+		}
+		if err := readOnlyError(ctx); err != nil {
+			return &gqlResponse{Errors: []*gqlError{{Message: err.Error()}}}
+		}
+		root = s.mutation
+	}
+
+	e := &gqlExecution{ctx: ctx, schema: s, doc: doc}
+	if errs := e.validate(root, op); len(errs) > 0 {
+		return &gqlResponse{Errors: errs}
+	}
+	if cost := e.cost(root, op.selections, map[string]int{}); cost > maxGraphQLCost {
+		return &gqlResponse{Errors: []*gqlError{{Message: fmt.Sprintf("Query costs %d, more than the limit of %d.", cost, maxGraphQLCost)}}}
+	}
+	if e.vars, err = e.coerceVariables(op, req.Variables); err != nil {
+		return &gqlResponse{Errors: []*gqlError{asGQLError(err)}}
+	}
+
+	// Root fields of a mutation run one after another; execution here is
+	// always sequential so queries and mutations share the same path
+	data, ok := e.executeSelectionSet(root, nil, op.selections, nil)
+	resp := &gqlResponse{Errors: e.errors}
+	if ok {
+		resp.Data = data
+	}
+	return resp
+}
+
+// selectOperation picks the operation to run from a document
+func selectOperation(doc *gqlDocument, name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, &gqlError{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &gqlError{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+// asGQLError converts any error into a GraphQL error
+func asGQLError(err error) *gqlError {
+	if ge, ok := err.(*gqlError); ok {
+		return ge
+	}
+	return &gqlError{Message: err.Error()}
+}
+
+// Validation
+
+// validate checks every selection against the schema before anything runs,
+// so a bad query never executes a mutation halfway
+func (e *gqlExecution) validate(root *gqlType, op *gqlOperation) []*gqlError {
+	var errs []*gqlError
+	declared := map[string]bool{}
+	for _, v := range op.vars {
+		if declared[v.name] {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("There can be only one variable named \"$%s\".", v.name), Locations: []gqlLocation{v.loc}})
+		}
+		declared[v.name] = true
+		if t := e.typeFromRef(v.typ); t == nil || !isInputType(t) {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" cannot be non-input type %q.", v.name, v.typ), Locations: []gqlLocation{v.loc}})
+		}
+	}
+
+	// depth counts the fields above sels, fragments included, so spreading
+	// fragments into each other can't nest deeper than the parser allows
+	var check func(t *gqlType, sels []*gqlSelection, fragmentPath []string, depth int)
+	check = func(t *gqlType, sels []*gqlSelection, fragmentPath []string, depth int) {
+		if depth > maxGraphQLDepth {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("Query is nested more than %d levels deep.", maxGraphQLDepth), Locations: []gqlLocation{sels[0].loc}})
+			return
+		}
+		for _, sel := range sels {
+			for _, d := range sel.directives {
+				if d.name != "skip" && d.name != "include" {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown directive \"@%s\".", d.name), Locations: []gqlLocation{sel.loc}})
+				}
+				e.checkVariables(d.args, declared, sel.loc, &errs)
+			}
+
+			switch {
+			case sel.spread != "":
+				frag, ok := e.doc.fragments[sel.spread]
+				if !ok {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown fragment %q.", sel.spread), Locations: []gqlLocation{sel.loc}})
+					continue
+				}
+				for _, name := range fragmentPath {
+					if name == frag.name {
+						errs = append(errs, &gqlError{Message: fmt.Sprintf("Cannot spread fragment %q within itself.", frag.name), Locations: []gqlLocation{sel.loc}})
+						return
+					}
+				}
+				fragType := e.schema.types[frag.typeCond]
+				if fragType == nil || fragType.kind != gqlObjectKind {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown type %q.", frag.typeCond), Locations: []gqlLocation{sel.loc}})
+					continue
+				}
+				if fragType == t {
+					check(t, frag.selections, append(fragmentPath, frag.name), depth)
+				}
+			case sel.inline:
+				if sel.typeCond != "" {
+					condType := e.schema.types[sel.typeCond]
+					if condType == nil || condType.kind != gqlObjectKind {
+						errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown type %q.", sel.typeCond), Locations: []gqlLocation{sel.loc}})
+						continue
+					}
+					if condType != t {
+						continue
+					}
+				}
+				check(t, sel.selections, fragmentPath, depth)
+			default:
+				if sel.name == "__typename" {
+					continue
+				}
+				f := t.field(sel.name)
+				if f == nil {
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Cannot query field %q on type %q.", sel.name, t.name), Locations: []gqlLocation{sel.loc}})
+					continue
+				}
+				errs = append(errs, e.checkArguments(f, sel)...)
+				e.checkVariables(sel.args, declared, sel.loc, &errs)
+
+				named := f.typ.named()
+				switch {
+				case named.kind == gqlObjectKind && len(sel.selections) == 0:
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Field %q of type %q must have a selection of subfields.", sel.name, f.typ), Locations: []gqlLocation{sel.loc}})
+				case named.kind != gqlObjectKind && len(sel.selections) > 0:
+					errs = append(errs, &gqlError{Message: fmt.Sprintf("Field %q must not have a selection since type %q has no subfields.", sel.name, f.typ), Locations: []gqlLocation{sel.loc}})
+				case named.kind == gqlObjectKind:
+					check(named, sel.selections, fragmentPath, depth+1)
+				}
+			}
+		}
+	}
+	check(root, op.selections, nil, 1)
+	return errs
+}
+
+// Query cost
+
+// maxGraphQLCost caps the estimated number of fields a query resolves. Every
+// field costs one and the selections under a list count gqlListCost times, so
+// nesting Author.courses and Course.author can't fan out within the depth limit.
+const (
+	maxGraphQLCost = 1000
+	gqlListCost    = 10
+)
+
+// cost estimates the fields resolved for sels on t. It runs after validate,
+// so every field exists and fragments don't spread into themselves;
+// fragments maps each fragment already costed to its cost. The estimate
+// saturates just past maxGraphQLCost.
+func (e *gqlExecution) cost(t *gqlType, sels []*gqlSelection, fragments map[string]int) int {
+	total := 0
+	for _, sel := range sels {
+		switch {
+		case sel.spread != "":
+			frag := e.doc.fragments[sel.spread]
+			if frag.typeCond != t.name {
+				continue
+			}
+			c, ok := fragments[frag.name]
+			if !ok {
+				c = e.cost(t, frag.selections, fragments)
+				fragments[frag.name] = c
+			}
+			total += c
+		case sel.inline:
+			if sel.typeCond == "" || sel.typeCond == t.name {
+				total += e.cost(t, sel.selections, fragments)
+			}
+		case sel.name == "__typename":
+			total++
+		default:
+			f := t.field(sel.name)
+			sub := 0
+			if named := f.typ.named(); named.kind == gqlObjectKind {
+				sub = e.cost(named, sel.selections, fragments)
+				if f.typ.isList() {
+					sub *= gqlListCost
+				}
+			}
+			total += 1 + sub
+		}
+		if total > maxGraphQLCost {
+			return maxGraphQLCost + 1
+		}
+	}
+	return total
+}
+
+// checkArguments reports unknown arguments and missing required ones
+func (e *gqlExecution) checkArguments(f *gqlField, sel *gqlSelection) []*gqlError {
+	var errs []*gqlError
+	given := map[string]bool{}
+	for _, a := range sel.args {
+		given[a.name] = true
+		known := false
+		for _, def := range f.args {
+			known = known || def.name == a.name
+		}
+		if !known {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("Unknown argument %q on field %q.", a.name, f.name), Locations: []gqlLocation{sel.loc}})
+		}
+	}
+	for _, def := range f.args {
+		if def.typ.kind == gqlNonNullKind && def.defaultValue == nil && !given[def.name] {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("Field %q argument %q of type %q is required, but it was not provided.", f.name, def.name, def.typ), Locations: []gqlLocation{sel.loc}})
+		}
+	}
+	return errs
+}
+
+// checkVariables reports variables that are used but not declared
+func (e *gqlExecution) checkVariables(args []*gqlArgument, declared map[string]bool, loc gqlLocation, errs *[]*gqlError) {
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case gqlVariable:
+			if !declared[string(v)] {
+				*errs = append(*errs, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" is not defined.", v), Locations: []gqlLocation{loc}})
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	for _, a := range args {
+		walk(a.value)
+	}
+}
+
+// typeFromRef resolves a parsed type reference against the schema
+func (e *gqlExecution) typeFromRef(ref *gqlTypeRef) *gqlType {
+	var t *gqlType
+	if ref.elem != nil {
+		elem := e.typeFromRef(ref.elem)
+		if elem == nil {
+			return nil
+		}
+		t = gqlListOf(elem)
+	} else if t = e.schema.types[ref.name]; t == nil {
+		return nil
+	}
+	if ref.nonNull {
+		t = gqlNonNull(t)
+	}
+	return t
+}
+
+// isInputType reports whether values of t can be sent by the client
+func isInputType(t *gqlType) bool {
+	switch t.named().kind {
+	case gqlScalarKind, gqlEnumKind, gqlInputObjectKind:
+		return true
+	}
+	return false
+}
+
+// Input coercion
+
+// coerceVariables validates the JSON variables against their declarations
+func (e *gqlExecution) coerceVariables(op *gqlOperation, raw map[string]any) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, def := range op.vars {
+		t := e.typeFromRef(def.typ)
+		value, provided := raw[def.name]
+		if !provided && def.hasDef {
+			value, provided = def.defValue, true
+		}
+		if !provided {
+			if t.kind == gqlNonNullKind {
+				return nil, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" of required type %q was not provided.", def.name, def.typ), Locations: []gqlLocation{def.loc}}
+			}
+			continue
+		}
+		coerced, err := coerceInput(value, t, nil)
+		if err != nil {
+			return nil, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" got invalid value: %v", def.name, err), Locations: []gqlLocation{def.loc}}
+		}
+		vars[def.name] = coerced
+	}
+	return vars, nil
+}
+
+// coerceInput converts a literal or JSON value to the Go value for type t.
+// vars is used to resolve variable references inside literals.
+func coerceInput(value any, t *gqlType, vars map[string]any) (any, error) {
+	if name, ok := value.(gqlVariable); ok {
+		value = vars[string(name)]
+	}
+
+	if t.kind == gqlNonNullKind {
+		if value == nil {
+			return nil, fmt.Errorf("expected non-null value of type %q", t)
+		}
+		return coerceInput(value, t.ofType, vars)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t.kind {
+	case gqlListKind:
+		list, ok := value.([]any)
+		if !ok {
+			// A single value is accepted where a list is expected
+			item, err := coerceInput(value, t.ofType, vars)
+			return []any{item}, err
+		}
+		out := make([]any, len(list))
+		for i, item := range list {
+			coerced, err := coerceInput(item, t.ofType, vars)
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+			out[i] = coerced
+		}
+		return out, nil
+
+	case gqlInputObjectKind:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type %q", t.name)
+		}
+		out := map[string]any{}
+		known := map[string]bool{}
+		for _, f := range t.inputFields {
+			known[f.name] = true
+			fieldValue, present := obj[f.name]
+			if v, isVar := fieldValue.(gqlVariable); isVar {
+				fieldValue, present = vars[string(v)]
+			}
+			if !present {
+				if f.defaultValue != nil {
+					out[f.name] = f.defaultValue
+				} else if f.typ.kind == gqlNonNullKind {
+					return nil, fmt.Errorf("field %q of type %q is required", f.name, f.typ)
+				}
+				continue
+			}
+			coerced, err := coerceInput(fieldValue, f.typ, vars)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", f.name, err)
+			}
+			out[f.name] = coerced
+		}
+		fields := make([]string, 0, len(obj))
+		for name := range obj {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		for _, name := range fields {
+			if !known[name] {
+				return nil, fmt.Errorf("field %q is not defined by type %q", name, t.name)
+			}
+		}
+		return out, nil
+
+	case gqlEnumKind:
+		var name string
+		switch v := value.(type) {
+		case gqlEnum:
+			name = string(v)
+		case string:
+			name = v
+		}
+		for _, allowed := range t.enumValues {
+			if name == allowed {
+				return name, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v does not exist in %q enum", value, t.name)
+	}
+
+	// Scalars. Literals arrive as int/float64/string/bool, JSON variables as float64.
+	switch t {
+	case gqlInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+		return nil, fmt.Errorf("Int cannot represent value %v", value)
+	case gqlFloat:
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("Float cannot represent value %v", value)
+	case gqlString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("String cannot represent value %v", value)
+	case gqlID:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return fmt.Sprint(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return fmt.Sprint(int64(v)), nil
+			}
+		}
+		return nil, fmt.Errorf("ID cannot represent value %v", value)
+	case gqlBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("Boolean cannot represent value %v", value)
+	}
+	return nil, fmt.Errorf("unsupported input type %q", t)
+}
+
+// Execution
+
+// collectFields flattens fragments and directives into the ordered list of
+// response keys, each with every selection that contributes to it
+func (e *gqlExecution) collectFields(t *gqlType, sels []*gqlSelection, keys *[]string, fields map[string][]*gqlSelection, visited map[string]bool) {
+	for _, sel := range sels {
+		if !e.shouldInclude(sel) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			if visited[sel.spread] {
+				continue
+			}
+			visited[sel.spread] = true
+			frag := e.doc.fragments[sel.spread]
+			if frag.typeCond == t.name {
+				e.collectFields(t, frag.selections, keys, fields, visited)
+			}
+		case sel.inline:
+			if sel.typeCond == "" || sel.typeCond == t.name {
+				e.collectFields(t, sel.selections, keys, fields, visited)
+			}
+		default:
+			key := sel.responseKey()
+			if _, seen := fields[key]; !seen {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		}
+	}
+}
+
+// shouldInclude evaluates @skip(if:) and @include(if:)
+func (e *gqlExecution) shouldInclude(sel *gqlSelection) bool {
+	for _, d := range sel.directives {
+		for _, a := range d.args {
+			if a.name != "if" {
+				continue
+			}
+			cond, _ := coerceInput(a.value, gqlNonNull(gqlBoolean), e.vars)
+			if b, _ := cond.(bool); (d.name == "skip" && b) || (d.name == "include" && !b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// executeSelectionSet resolves every field of an object. ok is false when a
+// non-null field came back null, which makes the whole object null.
+func (e *gqlExecution) executeSelectionSet(t *gqlType, source any, sels []*gqlSelection, path []any) (object, bool) {
+	var keys []string
+	fields := map[string][]*gqlSelection{}
+	e.collectFields(t, sels, &keys, fields, map[string]bool{})
+
+	result := make(object, 0, len(keys))
+	for _, key := range keys {
+		value, ok := e.executeField(t, source, fields[key], append(path[:len(path):len(path)], key))
+		if !ok {
+			return nil, false
+		}
+		result = append(result, member{Key: key, Value: value})
+	}
+	return result, true
+}
+
+// executeField resolves one response key and completes its value
+func (e *gqlExecution) executeField(t *gqlType, source any, sels []*gqlSelection, path []any) (any, bool) {
+	sel := sels[0]
+	if sel.name == "__typename" {
+		return t.name, true
+	}
+	f := t.field(sel.name)
+
+	args := map[string]any{}
+	for _, def := range f.args {
+		var raw any
+		present := false
+		for _, a := range sel.args {
+			if a.name == def.name {
+				raw, present = a.value, true
+			}
+		}
+		if v, isVar := raw.(gqlVariable); isVar {
+			_, present = e.vars[string(v)]
+		}
+		if !present {
+			if def.defaultValue != nil {
+				args[def.name] = def.defaultValue
+			}
+			continue
+		}
+		value, err := coerceInput(raw, def.typ, e.vars)
+		if err != nil {
+			e.addError(fmt.Sprintf("Argument %q has invalid value: %v", def.name, err), sel, path)
+			return nil, f.typ.kind != gqlNonNullKind
+		}
+		args[def.name] = value
+	}
+
+	value, err := f.resolve(gqlResolveParams{ctx: e.ctx, source: source, args: args})
+	if err != nil {
+		e.addError(err.Error(), sel, path)
+		return nil, f.typ.kind != gqlNonNullKind
+	}
+
+	// Sub-selections of every selection of this key are merged
+	var sub []*gqlSelection
+	for _, s := range sels {
+		sub = append(sub, s.selections...)
+	}
+	return e.completeValue(f.typ, sub, value, sel, path)
+}
+
+// completeValue shapes a resolved value according to its type
+func (e *gqlExecution) completeValue(t *gqlType, sels []*gqlSelection, value any, sel *gqlSelection, path []any) (any, bool) {
+	if t.kind == gqlNonNullKind {
+		completed, ok := e.completeValue(t.ofType, sels, value, sel, path)
+		if !ok {
+			return nil, false
+		}
+		if completed == nil {
+			e.addError(fmt.Sprintf("Cannot return null for non-nullable field %s.", sel.name), sel, path)
+			return nil, false
+		}
+		return completed, true
+	}
+
+	// Nullable positions absorb null bubbling up from non-null children
+	completed, ok := e.completeNullable(t, sels, value, sel, path)
+	if !ok {
+		return nil, true
+	}
+	return completed, true
+}
+
+func (e *gqlExecution) completeNullable(t *gqlType, sels []*gqlSelection, value any, sel *gqlSelection, path []any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, true
+		}
+	}
+
+	switch t.kind {
+	case gqlListKind:
+		if rv.Kind() != reflect.Slice {
+			e.addError(fmt.Sprintf("Expected a list for field %s.", sel.name), sel, path)
+			return nil, true
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			item, ok := e.completeValue(t.ofType, sels, rv.Index(i).Interface(), sel, append(path[:len(path):len(path)], i))
+			if !ok {
+				return nil, false
+			}
+			list[i] = item
+		}
+		return list, true
+	case gqlObjectKind:
+		obj, ok := e.executeSelectionSet(t, value, sels, path)
+		if !ok {
+			return nil, false
+		}
+		return obj, true
+	}
+	return value, true
+}
+
+// addError records a field error with its location and response path
+func (e *gqlExecution) addError(message string, sel *gqlSelection, path []any) {
+	e.errors = append(e.errors, &gqlError{
+		Message:   message,
+		Locations: []gqlLocation{sel.loc},
+		Path:      append([]any(nil), path...),
+	})
+}
+
+// SDL
+
+// printSchema renders the schema in SDL so it can be shown to API users
+func (s *gqlSchema) printSchema() string {
+	names := make([]string, 0, len(s.types))
+	for name, t := range s.types {
+		if t.kind != gqlScalarKind {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		// Root types first, then alphabetical
+		rank := func(n string) int {
+			switch {
+			case s.query != nil && n == s.query.name:
+				return 0
+			case s.mutation != nil && n == s.mutation.name:
+				return 1
+			}
+			return 2
+		}
+		if rank(names[i]) != rank(names[j]) {
+			return rank(names[i]) < rank(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		t := s.types[name]
+		if t.description != "" {
+			fmt.Fprintf(&b, "\"\"\"%s\"\"\"\n", t.description)
+		}
+		switch t.kind {
+		case gqlObjectKind:
+			fmt.Fprintf(&b, "type %s {\n", t.name)
+			for _, f := range t.fields {
+				if f.description != "" {
+					fmt.Fprintf(&b, "  \"%s\"\n", f.description)
+				}
+				fmt.Fprintf(&b, "  %s%s: %s\n", f.name, printArgs(f.args), f.typ)
+			}
+			b.WriteString("}\n")
+		case gqlInputObjectKind:
+			fmt.Fprintf(&b, "input %s {\n", t.name)
+			for _, f := range t.inputFields {
+				fmt.Fprintf(&b, "  %s: %s\n", f.name, f.typ)
+			}
+			b.WriteString("}\n")
+		case gqlEnumKind:
+			fmt.Fprintf(&b, "enum %s {\n", t.name)
+			for _, v := range t.enumValues {
+				fmt.Fprintf(&b, "  %s\n", v)
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+// printArgs renders a field's argument list
+func printArgs(args []*gqlInputValue) string {
+	if len(args) == 0 {
+		return ""
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.name + ": " + a.typ.String()
+		if a.defaultValue != nil {
+			parts[i] += fmt.Sprintf(" = %v", a.defaultValue)
+		}
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
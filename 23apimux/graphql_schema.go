@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// GraphQL schema and endpoint - graphql_schema.go
+// Exposes the course store through GraphQL so clients can fetch courses with
+// nested authors (and authors with their courses) in a single round trip.
+// Resolvers call the same courseStore methods as the REST handlers, and like
+// them only show published courses.
+
+// courseType and authorType reference each other, so their fields are
+// filled in by init below
+var (
+	courseType = &gqlType{kind: gqlObjectKind, name: "Course", description: "A training course"}
+	authorType = &gqlType{kind: gqlObjectKind, name: "Author", description: "A course instructor"}
+
+	courseOrderType = &gqlType{kind: gqlEnumKind, name: "CourseOrder", enumValues: []string{"NAME", "PRICE", "DURATION", "RATING"}}
+
+	authorInputType = &gqlType{kind: gqlInputObjectKind, name: "AuthorInput", inputFields: []*gqlInputValue{
+		{name: "id", typ: gqlID},
+		{name: "fullname", typ: gqlString},
+		{name: "email", typ: gqlString},
+	}}
+	courseInputType = &gqlType{kind: gqlInputObjectKind, name: "CourseInput", inputFields: []*gqlInputValue{
+		{name: "name", typ: gqlNonNull(gqlString)},
+		{name: "duration", typ: gqlString},
+		{name: "price", typ: gqlFloat},     // Major units, e.g. 29.99
+		{name: "currency", typ: gqlString}, // ISO 4217 code, USD if omitted
+		{name: "author", typ: authorInputType},
+	}}
+	// coursePatchType is like CourseInput but every field is optional
+	coursePatchType = &gqlType{kind: gqlInputObjectKind, name: "CoursePatch", inputFields: []*gqlInputValue{
+		{name: "name", typ: gqlString},
+		{name: "duration", typ: gqlString},
+		{name: "price", typ: gqlFloat},     // Major units, e.g. 29.99
+		{name: "currency", typ: gqlString}, // ISO 4217 code, USD if omitted
+		{name: "author", typ: authorInputType},
+	}}
+)
+
+// graphqlSchema is built once the object types are complete
+var graphqlSchema *gqlSchema
+
+func init() {
+	courseType.fields = []*gqlField{
+		{name: "id", typ: gqlNonNull(gqlID), resolve: courseField(func(c Course) any { return c.ID })},
+		{name: "name", typ: gqlNonNull(gqlString), resolve: courseField(func(c Course) any { return c.Name })},
+		{name: "duration", typ: gqlString, description: "ISO 8601 duration, e.g. PT3H30M", resolve: courseField(func(c Course) any { return c.Duration.String() })},
+		{name: "durationSeconds", typ: gqlInt, resolve: courseField(func(c Course) any { return int(c.Duration.Seconds()) })},
+		{name: "price", typ: gqlFloat, description: "Price in major units", resolve: courseField(func(c Course) any { return c.Price.Float() })},
+		{name: "priceAmount", typ: gqlInt, description: "Price in minor units (cents for USD)", resolve: courseField(func(c Course) any { return int(c.Price.Amount) })},
+		{name: "currency", typ: gqlString, resolve: courseField(func(c Course) any { return c.Price.Currency })},
+		{name: "author", typ: authorType, resolve: courseField(func(c Course) any { return c.Author })},
+		{name: "rating", typ: gqlFloat, description: "Average star rating of visible reviews", resolve: courseField(func(c Course) any { return c.Rating.Average })},
+		{name: "ratingCount", typ: gqlInt, resolve: courseField(func(c Course) any { return c.Rating.Count })},
+	}
+
+	authorType.fields = []*gqlField{
+		{name: "id", typ: gqlNonNull(gqlID), resolve: authorField(func(a Author) any { return a.ID })},
+		{name: "fullname", typ: gqlString, resolve: authorField(func(a Author) any { return a.Fullname })},
+		{name: "email", typ: gqlString, resolve: authorField(func(a Author) any { return a.Email })},
+		{
+			name:        "courses",
+			description: "Courses written by this author",
+			typ:         gqlNonNull(gqlListOf(gqlNonNull(courseType))),
+			resolve: func(p gqlResolveParams) (any, error) {
+				return authorField(func(a Author) any {
+					return publishedOnly(p.store().CoursesByAuthor(a.ID))
+				})(p)
+			},
+		},
+	}
+
+	queryType := &gqlType{kind: gqlObjectKind, name: "Query", fields: []*gqlField{
+		{
+			name:        "courses",
+			description: "List courses, optionally filtered, sorted and paginated",
+			args: []*gqlInputValue{
+				{name: "search", typ: gqlString},
+				{name: "authorId", typ: gqlID},
+				{name: "minPrice", typ: gqlFloat},
+				{name: "maxPrice", typ: gqlFloat},
+				{name: "orderBy", typ: courseOrderType},
+				{name: "first", typ: gqlInt},
+				{name: "offset", typ: gqlInt, defaultValue: 0},
+			},
+			typ:     gqlNonNull(gqlListOf(gqlNonNull(courseType))),
+			resolve: resolveCourses,
+		},
+		{
+			name: "course",
+			args: []*gqlInputValue{{name: "id", typ: gqlNonNull(gqlID)}},
+			typ:  courseType,
+			resolve: func(p gqlResolveParams) (any, error) {
+				if c, ok := p.store().Get(p.args["id"].(string)); ok && c.Live() {
+					return c, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			name:        "authors",
+			description: "List authors, optionally matching a name or email",
+			args:        []*gqlInputValue{{name: "search", typ: gqlString}},
+			typ:         gqlNonNull(gqlListOf(gqlNonNull(authorType))),
+			resolve: func(p gqlResolveParams) (any, error) {
+				search, _ := p.args["search"].(string)
+				var list []Author
+				for _, a := range p.store().Authors() {
+					if containsFold(a.Fullname, search) || containsFold(a.Email, search) {
+						list = append(list, a)
+					}
+				}
+				return list, nil
+			},
+		},
+		{
+			name: "author",
+			args: []*gqlInputValue{{name: "id", typ: gqlNonNull(gqlID)}},
+			typ:  authorType,
+			resolve: func(p gqlResolveParams) (any, error) {
+				if a, ok := p.store().Author(p.args["id"].(string)); ok {
+					return a, nil
+				}
+				return nil, nil
+			},
+		},
+	}}
+
+	mutationType := &gqlType{kind: gqlObjectKind, name: "Mutation", fields: []*gqlField{
+		{
+			name: "createCourse",
+			args: []*gqlInputValue{{name: "input", typ: gqlNonNull(courseInputType)}},
+			typ:  gqlNonNull(courseType),
+			resolve: func(p gqlResolveParams) (any, error) {
+				var course Course
+				if err := applyCourseInput(&course, p.args["input"].(map[string]any)); err != nil {
+					return nil, err
+				}
+				if course.IsEmpty() {
+					return nil, errors.New("No data in the request body")
+				}
+				return p.store().Create(course)
+			},
+		},
+		{
+			name: "updateCourse",
+			args: []*gqlInputValue{
+				{name: "id", typ: gqlNonNull(gqlID)},
+				{name: "input", typ: gqlNonNull(coursePatchType)},
+			},
+			typ: courseType,
+			resolve: func(p gqlResolveParams) (any, error) {
+				id := p.args["id"].(string)
+				course, ok := p.store().Get(id)
+				if !ok {
+					return nil, errors.New("No course found with the given ID")
+				}
+				if err := applyCourseInput(&course, p.args["input"].(map[string]any)); err != nil {
+					return nil, err
+				}
+				if course.IsEmpty() {
+					return nil, errors.New("No valid data in the request body")
+				}
+				return p.store().Update(id, course)
+			},
+		},
+		{
+			name: "deleteCourse",
+			args: []*gqlInputValue{{name: "id", typ: gqlNonNull(gqlID)}},
+			typ:  gqlNonNull(gqlBoolean),
+			resolve: func(p gqlResolveParams) (any, error) {
+				return p.store().Delete(p.args["id"].(string)), nil
+			},
+		},
+	}}
+
+	graphqlSchema = newGQLSchema(queryType, mutationType)
+}
+
+// store returns the store of the tenant the query runs as
+func (p gqlResolveParams) store() *courseStore {
+	return tenantFrom(p.ctx).store
+}
+
+// courseField adapts a Course accessor to a resolver
+func courseField(get func(Course) any) func(gqlResolveParams) (any, error) {
+	return func(p gqlResolveParams) (any, error) {
+		return get(p.source.(Course)), nil
+	}
+}
+
+// authorField adapts an Author accessor to a resolver; authors arrive either
+// as values (from the store) or as pointers (nested in a Course)
+func authorField(get func(Author) any) func(gqlResolveParams) (any, error) {
+	return func(p gqlResolveParams) (any, error) {
+		switch a := p.source.(type) {
+		case Author:
+			return get(a), nil
+		case *Author:
+			return get(*a), nil
+		}
+		return nil, fmt.Errorf("unexpected author source %T", p.source)
+	}
+}
+
+// resolveCourses implements Query.courses
+func resolveCourses(p gqlResolveParams) (any, error) {
+	search, _ := p.args["search"].(string)
+	authorID, hasAuthor := p.args["authorId"].(string)
+	minPrice, hasMin := p.args["minPrice"].(float64)
+	maxPrice, hasMax := p.args["maxPrice"].(float64)
+
+	list := []Course{}
+	for _, c := range publishedOnly(p.store().List()) {
+		authorName := ""
+		if c.Author != nil {
+			authorName = c.Author.Fullname
+		}
+		switch {
+		case search != "" && !containsFold(c.Name, search) && !containsFold(authorName, search):
+		case hasAuthor && (c.Author == nil || c.Author.ID != authorID):
+		case hasMin && comparePrice(c.Price, minPrice) < 0:
+		case hasMax && comparePrice(c.Price, maxPrice) > 0:
+		default:
+			list = append(list, c)
+		}
+	}
+
+	if order, ok := p.args["orderBy"].(string); ok {
+		sortCourses(list, strings.ToLower(order))
+	}
+
+	offset, _ := p.args["offset"].(int)
+	if offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if first, ok := p.args["first"].(int); ok {
+		if first < 0 {
+			return nil, errors.New("first must not be negative")
+		}
+		if first < len(list) {
+			list = list[:first]
+		}
+	}
+	return list, nil
+}
+
+// comparePrice compares a price with a limit given in major units of the
+// price's currency. Limits with too many decimal places compare as unequal.
+func comparePrice(price Money, limit float64) int {
+	m, err := MoneyFromFloat(limit, price.Currency)
+	if err != nil {
+		return 0
+	}
+	return price.Compare(m)
+}
+
+// applyCourseInput copies the fields present in a CourseInput/CoursePatch onto a course
+func applyCourseInput(c *Course, input map[string]any) error {
+	if v, ok := input["name"].(string); ok {
+		c.Name = v
+	}
+	if v, ok := input["duration"].(string); ok {
+		d, err := ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		c.Duration = d
+	}
+	price, hasPrice := input["price"].(float64)
+	currency, hasCurrency := input["currency"].(string)
+	if hasPrice || hasCurrency {
+		if !hasPrice {
+			// Changing only the currency keeps the amount in major units
+			price = c.Price.Float()
+		}
+		if !hasCurrency {
+			currency = c.Price.Currency
+		}
+		m, err := MoneyFromFloat(price, currency)
+		if err != nil {
+			return err
+		}
+		c.Price = m
+	}
+	if author, ok := input["author"].(map[string]any); ok {
+		if c.Author == nil {
+			c.Author = &Author{}
+		}
+		if v, ok := author["id"].(string); ok {
+			c.Author.ID = v
+		}
+		if v, ok := author["fullname"].(string); ok {
+			c.Author.Fullname = v
+		}
+		if v, ok := author["email"].(string); ok {
+			c.Author.Email = v
+		}
+	}
+	return nil
+}
+
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// HTTP
+
+// maxGraphQLBody is the largest request body, in bytes
+const maxGraphQLBody = 1 << 20
+
+// serveGraphQL executes GraphQL requests
+// GET  /graphql?query=...&variables=...&operationName=...  (queries only)
+// POST /graphql  with a JSON body {"query", "variables", "operationName"}
+//
+//	or an application/graphql body holding just the query
+func serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a GraphQL route")
+
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, &gqlResponse{Errors: []*gqlError{{Message: "Variables are invalid JSON."}}})
+				return
+			}
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBody))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeGraphQL(w, http.StatusRequestEntityTooLarge, &gqlResponse{Errors: []*gqlError{{Message: fmt.Sprintf("Body is larger than %d bytes.", maxGraphQLBody)}}})
+			return
+		case err != nil:
+			writeGraphQL(w, http.StatusBadRequest, &gqlResponse{Errors: []*gqlError{{Message: err.Error()}}})
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/graphql" {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			writeGraphQL(w, http.StatusBadRequest, &gqlResponse{Errors: []*gqlError{{Message: "Body must be a JSON object with a \"query\" string."}}})
+			return
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQL(w, http.StatusBadRequest, &gqlResponse{Errors: []*gqlError{{Message: "Must provide query string."}}})
+		return
+	}
+
+	resp := graphqlSchema.execute(r.Context(), req, r.Method == http.MethodPost)
+	writeGraphQL(w, http.StatusOK, resp)
+}
+
+// writeGraphQL sends a GraphQL response as JSON
+func writeGraphQL(w http.ResponseWriter, status int, resp *gqlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveGraphiQL serves a self-contained query editor for /graphql. It needs no
+// CDN or build step, so it works offline.
+// GET /graphiql
+func serveGraphiQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	graphiqlPage.Execute(w, map[string]any{
+		"SDL": graphqlSchema.printSchema(),
+		"Example": `query Catalog($search: String) {
+  courses(search: $search, orderBy: PRICE) {
+    id
+    name
+    price
+    author { fullname email }
+  }
+}`,
+	})
+}
+
+var graphiqlPage = template.Must(template.New("graphiql").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Course API - GraphiQL</title>
+<style>
+  body { margin: 0; font-family: system-ui, sans-serif; display: grid; grid-template-columns: 1fr 1fr 22rem; height: 100vh; }
+  section { display: flex; flex-direction: column; border-right: 1px solid #ddd; min-height: 0; }
+  h2 { font-size: .9rem; margin: 0; padding: .5rem; background: #f4f4f4; border-bottom: 1px solid #ddd; }
+  textarea, pre { flex: 1; margin: 0; padding: .5rem; border: 0; font: 13px/1.4 monospace; resize: none; overflow: auto; }
+  #variables { flex: 0 0 8rem; border-top: 1px solid #ddd; }
+  button { margin: .5rem; padding: .4rem 1rem; }
+</style>
+</head>
+<body>
+<section>
+  <h2>Query <button id="run" title="Ctrl+Enter">Run</button></h2>
+  <textarea id="query" spellcheck="false">{{.Example}}</textarea>
+  <h2>Variables (JSON)</h2>
+  <textarea id="variables" spellcheck="false">{"search": ""}</textarea>
+</section>
+<section>
+  <h2>Result</h2>
+  <pre id="result"></pre>
+</section>
+<section>
+  <h2>Schema</h2>
+  <pre>{{.SDL}}</pre>
+</section>
+<script>
+  const $ = (id) => document.getElementById(id);
+  async function run() {
+    let variables = {};
+    try { variables = JSON.parse($("variables").value || "{}"); }
+    catch (e) { $("result").textContent = "Variables are not valid JSON: " + e.message; return; }
+    const resp = await fetch("/graphql", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ query: $("query").value, variables }),
+    });
+    $("result").textContent = JSON.stringify(await resp.json(), null, 2);
+  }
+  $("run").addEventListener("click", run);
+  document.addEventListener("keydown", (e) => { if (e.ctrlKey && e.key === "Enter") run(); });
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idempotency keys - idempotency.go
+// Clients that retry a POST after a timeout can send the same Idempotency-Key
+// header (https://datatracker.ietf.org/doc/draft-ietf-httpapi-idempotency-key-header/)
+// with every attempt. The first response is stored under that key and replayed
+// for later attempts, so retries never create duplicate courses.
+
+const (
+	idempotencyTTL     = 24 * time.Hour // How long a stored response is replayed
+	maxIdempotencyKey  = 255            // Longest key we accept
+	maxIdempotencyBody = 1 << 20        // Largest request body, in bytes; it is read up front
+)
+
+// idempotencyEntry is one key's state: in flight, or finished with a response
+type idempotencyEntry struct {
+	fingerprint string    // Hash of method, path and body of the first request
+	done        bool      // False while the first request is still running
+	expires     time.Time // When the entry may be forgotten
+	status      int
+	header      http.Header
+	body        []byte
+	tooLarge    bool // The response passed maxCachedBody and can't be replayed
+}
+
+// idempotencyStore remembers responses per client and key
+type idempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	ttl       time.Duration
+}
+
+// idempotencyKeys is shared by every idempotent route
+var idempotencyKeys = newIdempotencyStore(idempotencyTTL)
+
+// newIdempotencyStore creates an empty store whose entries live for ttl
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{entries: map[string]*idempotencyEntry{}, ttl: ttl}
+}
+
+// clientID scopes keys to a tenant and a client so two clients can't collide
+// on (or read) each other's keys. The API key is preferred, then any other
+// Authorization, then X-Client-ID, then the IP.
+func clientID(r *http.Request) string {
+	return tenantFrom(r.Context()).ID + "/" + tenantClientID(r)
+}
+
+// tenantClientID identifies a client within its tenant
+func tenantClientID(r *http.Request) string {
+	if key := apiKey(r); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:8])
+	}
+	if id := r.Header.Get("X-Client-ID"); id != "" {
+		return "client:" + id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// parseIdempotencyKey accepts both a structured-field string ("\"abc\"") and a bare token
+func parseIdempotencyKey(header string) (string, bool) {
+	key := strings.TrimSpace(header)
+	if strings.HasPrefix(key, `"`) {
+		unquoted, err := strconv.Unquote(key)
+		if err != nil {
+			return "", false
+		}
+		key = unquoted
+	}
+	return key, key != "" && len(key) <= maxIdempotencyKey
+}
+
+// idempotent wraps a handler so requests carrying an Idempotency-Key are
+// executed at most once per client and key
+func (s *idempotencyStore) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := parseIdempotencyKey(header)
+		if !ok {
+			render(w, r, http.StatusBadRequest, "Invalid Idempotency-Key header")
+			return
+		}
+
+		// The body is part of the fingerprint, so read it up front and hand
+		// the handler a fresh reader
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotencyBody))
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge):
+				render(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", maxIdempotencyBody))
+				return
+			case err != nil:
+				render(w, r, http.StatusBadRequest, "Could not read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		scopedKey := clientID(r) + "\n" + key
+
+		entry, fresh := s.begin(scopedKey, fingerprint)
+		switch {
+		case !fresh && entry.fingerprint != fingerprint:
+			render(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			return
+		case !fresh && !entry.done:
+			render(w, r, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			return
+		case !fresh && entry.tooLarge:
+			// Running it again would repeat its effects
+			render(w, r, http.StatusConflict, "A request with this Idempotency-Key was already processed, but its response was too large to keep")
+			return
+		case !fresh:
+			replay(w, entry)
+			return
+		}
+
+		// First request with this key: run it and remember the response
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// A panicking handler must not leave the key stuck in flight
+			if !completed {
+				rec.status = http.StatusInternalServerError
+				s.finish(scopedKey, rec)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+		s.finish(scopedKey, rec)
+	})
+}
+
+// begin returns the entry for key, creating an in-flight one if there is none.
+// fresh is true when the caller is the first request for this key.
+func (s *idempotencyStore) begin(key, fingerprint string) (*idempotencyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		// Copy so the caller can read it without holding the lock
+		copied := *entry
+		return &copied, false
+	}
+	entry := &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	s.entries[key] = entry
+	return entry, true
+}
+
+// finish stores the response of the first request. Server errors are not
+// stored so the client can retry them with the same key.
+func (s *idempotencyStore) finish(key string, rec *recordingWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.status >= 500 {
+		delete(s.entries, key)
+		return
+	}
+	entry := s.entries[key]
+	entry.done = true
+	entry.status = rec.status
+	entry.header = rec.header
+	entry.body = rec.body.Bytes()
+	entry.tooLarge = rec.tooLarge
+	entry.expires = time.Now().Add(s.ttl)
+}
+
+// sweep drops expired entries at most once a minute; called with s.mu held
+func (s *idempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if entry.done && now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// replay writes a stored response again
+func replay(w http.ResponseWriter, entry *idempotencyEntry) {
+	for name, values := range entry.header {
+		if name == "Vary" {
+			for _, v := range values {
+				addVary(w.Header(), v)
+			}
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
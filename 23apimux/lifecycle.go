@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Publishing lifecycle - lifecycle.go
+// A course is a draft, scheduled, published or archived. Only published
+// courses appear on the public routes (GET /courses, GET /courses/{id}, an
+// author's courses and GraphQL queries); the others can still be edited by
+// ID and are managed through /courses/{id}/status or the admin UI.
+//
+// A scheduled course has a publish_at time. A background scheduler publishes
+// it when that time comes, waking up exactly then rather than polling.
+//
+// Courses created without a status are published right away, as they were
+// before the lifecycle existed.
+
+// errCourseNotPublished is returned when enrolling in or buying a course
+// that isn't public
+var errCourseNotPublished = errors.New("The course is not published")
+
+// CourseStatus is a stage of the publishing lifecycle
+type CourseStatus string
+
+const (
+	StatusDraft     CourseStatus = "draft"     // Being written, not public
+	StatusScheduled CourseStatus = "scheduled" // Published automatically at publish_at
+	StatusPublished CourseStatus = "published" // Public
+	StatusArchived  CourseStatus = "archived"  // Withdrawn, kept for enrolled learners
+)
+
+// courseStatuses are the valid statuses
+var courseStatuses = []CourseStatus{StatusDraft, StatusScheduled, StatusPublished, StatusArchived}
+
+// valid reports whether s is a known status
+func (s CourseStatus) valid() bool {
+	return slices.Contains(courseStatuses, s)
+}
+
+// Live reports whether the course is shown on the public routes
+func (c Course) Live() bool {
+	return c.Status == StatusPublished
+}
+
+// publishedOnly keeps the live courses of a list
+func publishedOnly(list []Course) []Course {
+	live := []Course{}
+	for _, c := range list {
+		if c.Live() {
+			live = append(live, c)
+		}
+	}
+	return live
+}
+
+// validateLifecycle checks the status and publish_at of a course
+func validateLifecycle(c *Course, errs *validationErrors) {
+	switch {
+	case c.Status != "" && !c.Status.valid():
+		errs.add("status", fmt.Sprintf("Status must be one of %v", courseStatuses))
+	case c.Status == StatusScheduled && c.PublishAt == nil:
+		errs.add("publish_at", "Scheduled courses need a publish_at time")
+	case c.Status != StatusScheduled && c.PublishAt != nil:
+		errs.add("publish_at", "publish_at is only used by scheduled courses")
+	}
+}
+
+// advanceLifecycle fills in the lifecycle fields of a course being stored:
+// courses without a status are published, scheduled courses whose time has
+// come are published, and published_at records when a course went live
+func advanceLifecycle(c *Course, now time.Time) {
+	if c.Status == "" {
+		c.Status = StatusPublished
+	}
+	if c.Status == StatusScheduled && !c.PublishAt.After(now) {
+		at := *c.PublishAt
+		c.Status, c.PublishAt, c.PublishedAt = StatusPublished, nil, &at
+	}
+	switch c.Status {
+	case StatusPublished:
+		if c.PublishedAt == nil {
+			c.PublishedAt = &now
+		}
+	case StatusDraft, StatusScheduled:
+		c.PublishedAt = nil
+	}
+}
+
+// courseLifecycle is the body of /courses/{id}/status
+type courseLifecycle struct {
+	Status      CourseStatus `json:"status"`
+	PublishAt   *time.Time   `json:"publish_at,omitempty"`   // Required when scheduled
+	PublishedAt *time.Time   `json:"published_at,omitempty"` // Set by the server
+}
+
+// SetStatus moves a course through the lifecycle
+func (s *courseStore) SetStatus(id string, status CourseStatus, publishAt *time.Time) (Course, error) {
+	if status == "" {
+		var errs validationErrors
+		errs.add("status", "Status is required")
+		return Course{}, errs
+	}
+	return s.updateCourse(id, func(c *Course) error {
+		c.Status = status
+		c.PublishAt = publishAt
+		return nil
+	})
+}
+
+// publishDue publishes the scheduled courses whose time has come and returns
+// when the next one is due (zero if none is scheduled)
+func (s *courseStore) publishDue(now time.Time) time.Time {
+	s.mu.Lock()
+	var next time.Time
+	published := false
+	for i := range s.courses {
+		c := &s.courses[i]
+		if c.Status != StatusScheduled {
+			continue
+		}
+		if !c.PublishAt.After(now) {
+			advanceLifecycle(c, now)
+			s.logWAL(putCourseEntry(*c))
+			published = true
+		} else if next.IsZero() || c.PublishAt.Before(next) {
+			next = *c.PublishAt
+		}
+	}
+	s.mu.Unlock()
+
+	if published {
+		s.catalogChanged()
+	}
+	return next
+}
+
+// Scheduler
+
+// maxSchedulerSleep bounds how long the scheduler sleeps, so a clock change
+// can't hold back a publication for long
+const maxSchedulerSleep = time.Minute
+
+// publishScheduler publishes scheduled courses of every tenant on time
+type publishScheduler struct {
+	wake chan struct{} // Signals that a schedule changed
+}
+
+// scheduler is poked by the stores whenever a course is scheduled
+var scheduler = &publishScheduler{wake: make(chan struct{}, 1)}
+
+// poke makes the scheduler look at the schedules again
+func (p *publishScheduler) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default: // A wake-up is already pending
+	}
+}
+
+// run publishes due courses, then sleeps until the next one is due or a
+// schedule changes, until ctx is done
+func (p *publishScheduler) run(ctx context.Context, reg *tenantRegistry) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-p.wake:
+		}
+
+		now := time.Now().UTC()
+		sleep := maxSchedulerSleep
+		for _, t := range reg.byID {
+			if next := t.store.publishDue(now); !next.IsZero() {
+				sleep = min(sleep, next.Sub(now))
+			}
+		}
+		timer.Reset(sleep)
+	}
+}
+
+// Handlers
+
+// lifecycleOf returns the lifecycle fields of a course
+func lifecycleOf(c Course) courseLifecycle {
+	return courseLifecycle{Status: c.Status, PublishAt: c.PublishAt, PublishedAt: c.PublishedAt}
+}
+
+// getCourseStatus returns where a course is in the lifecycle, whatever its
+// status
+// GET /courses/{id}/status
+func getCourseStatus(w http.ResponseWriter, r *http.Request) {
+	c, ok := storeFrom(r).Get(mux.Vars(r)["id"])
+	if !ok {
+		render(w, r, http.StatusNotFound, errCourseNotFound.Error())
+		return
+	}
+	render(w, r, http.StatusOK, lifecycleOf(c))
+}
+
+// setCourseStatus drafts, schedules, publishes or archives a course
+// PUT /courses/{id}/status  {"status": "scheduled", "publish_at": "2026-01-05T09:00:00Z"}
+func setCourseStatus(w http.ResponseWriter, r *http.Request) {
+	var body courseLifecycle
+	if renderDecodeError(w, r, decodeBody(r, &body)) {
+		return
+	}
+	c, err := storeFrom(r).SetStatus(mux.Vars(r)["id"], body.Status, body.PublishAt)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, lifecycleOf(c))
+}
+
+// registerLifecycleRoutes adds the publishing routes
+func registerLifecycleRoutes(r *mux.Router, l *linker) {
+	r.HandleFunc("/courses/{id}/status", getCourseStatus).Methods("GET").Name(l.name("courseStatus"))
+	r.HandleFunc("/courses/{id}/status", setCourseStatus).Methods("PUT")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Hypermedia links - links.go
+// Course and author responses carry a "_links" object pointing at related
+// resources, so clients can follow links instead of building URLs. Links are
+// generated from named routes with Route.URL, which keeps them in step with
+// the route templates and with the prefix of the subrouter that served the
+// request: /v2/courses/1 links to /v2/authors/a1, /courses/1 to /authors/a1.
+//
+// Subrouters share one table of route names, and the same routes are mounted
+// on several of them, so each subrouter qualifies its names with a prefix.
+
+// link is one hypermedia link
+type link struct {
+	Href string `json:"href"`
+}
+
+// links maps relation names ("self", "author", ...) to links
+type links map[string]link
+
+// add sets a link, skipping routes that couldn't be built
+func (ls links) add(rel, href string) {
+	if href != "" {
+		ls[rel] = link{Href: href}
+	}
+}
+
+// linker builds links to the named routes of one subrouter
+type linker struct {
+	router *mux.Router
+	prefix string // Qualifies route names, e.g. "v2." on the /v2 subrouter
+}
+
+// newLinker creates a linker for routes registered on router with prefix
+func newLinker(router *mux.Router, prefix string) *linker {
+	return &linker{router: router, prefix: prefix}
+}
+
+// name qualifies a route name for this subrouter
+func (l *linker) name(route string) string {
+	return l.prefix + route
+}
+
+// middleware makes the linker available to the subrouter's handlers
+func (l *linker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), linkerKey, l)))
+	})
+}
+
+// linksFrom returns the linker of the subrouter that served r. Outside the
+// REST API (e.g. GraphQL) it is empty and builds no links.
+func linksFrom(r *http.Request) *linker {
+	if l, ok := r.Context().Value(linkerKey).(*linker); ok {
+		return l
+	}
+	return &linker{}
+}
+
+// url builds the URL of a named route, or "" when the route isn't mounted here
+func (l *linker) url(route string, pairs ...string) string {
+	if l.router == nil {
+		return ""
+	}
+	rt := l.router.Get(l.name(route))
+	if rt == nil {
+		return ""
+	}
+	u, err := rt.URL(pairs...)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// course returns the links of a course
+func (l *linker) course(c Course) links {
+	ls := links{}
+	ls.add("self", l.url("course", "id", c.ID))
+	ls.add("collection", l.url("courses"))
+	ls.add("materials", l.url("materials", "id", c.ID))
+	ls.add("status", l.url("courseStatus", "id", c.ID))
+	if c.Author != nil && c.Author.ID != "" {
+		ls.add("author", l.url("author", "author", c.Author.ID))
+	}
+	return ls
+}
+
+// author returns the links of an author
+func (l *linker) author(a Author) links {
+	ls := links{}
+	ls.add("self", l.url("author", "author", a.ID))
+	ls.add("courses", l.url("authorCourses", "author", a.ID))
+	ls.add("collection", l.url("authors"))
+	return ls
+}
+
+// authorResource is an author with its links, as sent in responses
+type authorResource struct {
+	Author
+	Links links `json:"_links,omitempty"`
+}
+
+// authorResource adds links to an author; nil stays nil
+func (l *linker) authorResource(a *Author) *authorResource {
+	if a == nil {
+		return nil
+	}
+	return &authorResource{Author: *a, Links: l.author(*a)}
+}
+
+// Pagination
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// page is the slice of a collection selected by ?page= and ?per_page=
+type page struct {
+	Number  int // 1-based page number
+	PerPage int // Items per page, 0 for everything
+}
+
+// parsePage reads ?page= and ?per_page=. Without either the whole collection
+// is returned, as before pagination existed.
+func parsePage(r *http.Request) (page, error) {
+	q := r.URL.Query()
+	if q.Get("page") == "" && q.Get("per_page") == "" {
+		return page{Number: 1}, nil
+	}
+
+	number, err := pageParam(q, "page", 1, 0)
+	if err != nil {
+		return page{}, err
+	}
+	perPage, err := pageParam(q, "per_page", defaultPerPage, maxPerPage)
+	if err != nil {
+		return page{}, err
+	}
+	return page{Number: number, PerPage: perPage}, nil
+}
+
+// pageParam reads a positive whole number parameter, at most limit unless
+// limit is 0
+func pageParam(q url.Values, name string, def, limit int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	switch {
+	case err != nil || n < 1:
+		return 0, fmt.Errorf("%s must be a positive whole number", name)
+	case limit > 0 && n > limit:
+		return 0, fmt.Errorf("%s must be at most %d", name, limit)
+	}
+	return n, nil
+}
+
+// paginate returns the requested page of list and reports the total in
+// X-Total-Count. Links to the first, previous, next and last pages of the
+// named collection route go in the Link header, since collections are sent
+// as bare arrays.
+func paginate[T any](w http.ResponseWriter, r *http.Request, p page, route string, list []T, pairs ...string) []T {
+	total := len(list)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if p.PerPage == 0 {
+		return list
+	}
+
+	base := linksFrom(r).url(route, pairs...)
+	if base != "" {
+		last := max(1, (total+p.PerPage-1)/p.PerPage)
+		var rels []string
+		pageLink := func(rel string, n int) {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(n))
+			q.Set("per_page", strconv.Itoa(p.PerPage))
+			rels = append(rels, fmt.Sprintf(`<%s?%s>; rel="%s"`, base, q.Encode(), rel))
+		}
+		pageLink("first", 1)
+		if p.Number > 1 {
+			pageLink("prev", min(p.Number-1, last))
+		}
+		if p.Number < last {
+			pageLink("next", p.Number+1)
+		}
+		pageLink("last", last)
+		w.Header().Add("Link", strings.Join(rels, ", "))
+	}
+
+	start := min((p.Number-1)*p.PerPage, total)
+	end := min(start+p.PerPage, total)
+	return list[start:end]
+}
@@ -1,23 +1,31 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/debarshee2004/apimux/tlsserver"
 	"github.com/gorilla/mux"
 )
 
 // Model for Course and Author - course.go and author.go
 // Course represents a training course with details and author information
 type Course struct {
-	ID       string  `json:"id"`       // Unique identifier for the course
-	Name     string  `json:"name"`     // Course title/name
-	Duration string  `json:"duration"` // Course duration (e.g., "3h", "5h")
-	Price    float64 `json:"price"`    // Course price in dollars
-	Author   *Author `json:"author"`   // Pointer to author information
+	ID            string        `json:"id"`                      // Unique identifier for the course
+	Name          string        `json:"name"`                    // Course title/name
+	Duration      Duration      `json:"duration"`                // Course length, summed from lessons if it has any (see duration.go)
+	Price         Money         `json:"price"`                   // Course price in minor units (see money.go)
+	Author        *Author       `json:"author"`                  // Pointer to author information
+	Modules       []Module      `json:"modules,omitempty"`       // Curriculum in teaching order (see curriculum.go)
+	Prerequisites []string      `json:"prerequisites,omitempty"` // IDs of courses to take first
+	Rating        RatingSummary `json:"rating"`                  // Aggregated review scores (see review.go)
+	Status        CourseStatus  `json:"status,omitempty"`        // draft, scheduled, published or archived (see lifecycle.go)
+	PublishAt     *time.Time    `json:"publish_at,omitempty"`    // When a scheduled course goes live
+	PublishedAt   *time.Time    `json:"published_at,omitempty"`  // When the course went live; set by the server
 }
 
 // Author represents the course instructor/creator
@@ -27,13 +35,14 @@ type Author struct {
 	Email    string `json:"email"`    // Author's email address
 }
 
-// Fake DB - In-memory slice to simulate database storage
-var courses = []Course{
+// Fake DB - sample courses for the default tenant's in-memory store (see
+// store.go and tenant.go)
+var sampleCourses = []Course{
 	{
 		ID:       "1",
 		Name:     "Go Basics",
-		Duration: "3h",
-		Price:    29.99,
+		Duration: Duration(3 * time.Hour),
+		Price:    Money{Amount: 2999, Currency: "USD"},
 		Author: &Author{
 			ID:       "a1",
 			Fullname: "John Doe",
@@ -43,13 +52,14 @@ var courses = []Course{
 	{
 		ID:       "2",
 		Name:     "Advanced Go",
-		Duration: "5h",
-		Price:    49.99,
+		Duration: Duration(5 * time.Hour),
+		Price:    Money{Amount: 4999, Currency: "USD"},
 		Author: &Author{
 			ID:       "a2",
 			Fullname: "Jane Smith",
 			Email:    "jane@example.com",
 		},
+		Prerequisites: []string{"1"},
 	},
 }
 
@@ -62,23 +72,31 @@ func (c *Course) IsEmpty() bool {
 
 // Controller for Course - course_controller.go
 
-// serveHome handles the root endpoint and displays welcome message
-// GET /
-func serveHome(w http.ResponseWriter, r *http.Request) {
-	// Send HTML welcome message to client
-	w.Write([]byte("<h1>Welcome to Course API</h1>"))
-}
-
 // getAllCourse handles retrieving all courses from the database
-// GET /courses
+// GET /courses?sort=-price&page=2&per_page=10
 func getAllCourse(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("This is a GET All route")
 
-	// Set response content type to JSON
-	w.Header().Set("Content-Type", "application/json")
+	// Optional ordering: ?sort=name|duration|price, "-" prefix for descending
+	courses := publishedOnly(storeFrom(r).List())
+	if key := r.URL.Query().Get("sort"); key != "" {
+		if err := sortCourses(courses, key); err != nil {
+			render(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
-	// Encode the entire courses slice to JSON and send response
-	json.NewEncoder(w).Encode(courses)
+	// Optional pagination, with links to the other pages (see links.go)
+	p, err := parsePage(r)
+	if err != nil {
+		render(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	courses = paginate(w, r, p, "courses", courses)
+
+	// Map every course to the requested version's representation, trimmed
+	// and expanded as asked (see fields.go), and encode it in the requested format
+	renderCourses(w, r, http.StatusOK, courses)
 }
 
 // getOneCourse handles retrieving a single course by ID
@@ -86,24 +104,19 @@ func getAllCourse(w http.ResponseWriter, r *http.Request) {
 func getOneCourse(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("This is a GET One route")
 
-	// Set response content type to JSON
-	w.Header().Set("Content-Type", "application/json")
-
 	// Extract URL parameters using Gorilla Mux
 	params := mux.Vars(r)
 	courseID := params["id"] // Get the course ID from URL path
 
-	// Search through courses slice to find matching ID
-	for _, course := range courses {
-		if course.ID == courseID {
-			// Course found - return it as JSON
-			json.NewEncoder(w).Encode(course)
-			return
-		}
+	// Look up the course with matching ID in the store; drafts aren't public
+	if course, ok := storeFrom(r).Get(courseID); ok && course.Live() {
+		// Course found - return it in the requested format
+		renderCourse(w, r, http.StatusOK, course)
+		return
 	}
 
 	// No course found with the given ID
-	json.NewEncoder(w).Encode("No course found with the given ID")
+	render(w, r, http.StatusOK, "No course found with the given ID")
 }
 
 // createOneCourse handles creating a new course
@@ -111,34 +124,36 @@ func getOneCourse(w http.ResponseWriter, r *http.Request) {
 func createOneCourse(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("This is a create one route")
 
-	// Set response content type to JSON
-	w.Header().Set("Content-Type", "application/json")
-
 	// Validate request body exists
 	if r.Body == nil {
-		json.NewEncoder(w).Encode("Please send a request body")
+		render(w, r, http.StatusOK, "Please send a request body")
 		return
 	}
 
-	// Parse JSON request body into Course struct
-	var course Course
-	_ = json.NewDecoder(r.Body).Decode(&course)
+	// Parse request body into Course struct using the version's mapper
+	// (the body format follows the Content-Type header)
+	v := versionFrom(r)
+	course, err := v.decodeCourse(r)
+	if renderDecodeError(w, r, err) {
+		return
+	}
 
 	// Validate that course has required data
 	if course.IsEmpty() {
-		json.NewEncoder(w).Encode("No data in the request body")
+		render(w, r, http.StatusOK, "No data in the request body")
 		return
 	}
 
-	// Generate unique ID for the new course
-	// Note: In production, use UUID or database auto-increment
-	course.ID = strconv.Itoa(rand.Intn(100))
-
 	// Add the new course to our in-memory database
-	courses = append(courses, course)
+	// (the store generates a unique ID and drops cached catalog responses)
+	course, err = storeFrom(r).Create(course)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
 
 	// Return the created course with generated ID
-	json.NewEncoder(w).Encode(course)
+	renderCourse(w, r, http.StatusOK, course)
 }
 
 // updateOneCourse handles updating an existing course
@@ -146,44 +161,43 @@ func createOneCourse(w http.ResponseWriter, r *http.Request) {
 func updateOneCourse(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("This is an update one route")
 
-	// Set response content type to JSON
-	w.Header().Set("Content-Type", "application/json")
-
 	// Extract course ID from URL parameters
 	params := mux.Vars(r)
 	courseID := params["id"]
 
 	// Validate request body exists
 	if r.Body == nil {
-		json.NewEncoder(w).Encode("Please send a request body")
+		render(w, r, http.StatusOK, "Please send a request body")
 		return
 	}
 
-	// Parse JSON request body into Course struct
-	var updatedCourse Course
-	_ = json.NewDecoder(r.Body).Decode(&updatedCourse)
+	// Parse request body into Course struct using the version's mapper
+	// (the body format follows the Content-Type header)
+	v := versionFrom(r)
+	updatedCourse, err := v.decodeCourse(r)
+	if renderDecodeError(w, r, err) {
+		return
+	}
 
 	// Validate that updated course has required data
 	if updatedCourse.IsEmpty() {
-		json.NewEncoder(w).Encode("No valid data in the request body")
+		render(w, r, http.StatusOK, "No valid data in the request body")
 		return
 	}
 
-	// Find and update the course with matching ID
-	for index, course := range courses {
-		if course.ID == courseID {
-			// Preserve the original ID and update other fields
-			updatedCourse.ID = courseID
-			courses[index] = updatedCourse
-
-			// Return the updated course
-			json.NewEncoder(w).Encode(updatedCourse)
-			return
-		}
+	// Find and update the course with matching ID (the original ID is preserved)
+	updatedCourse, err = storeFrom(r).Update(courseID, updatedCourse)
+	switch {
+	case errors.Is(err, errCourseNotFound):
+		// No course found with the given ID
+		render(w, r, http.StatusOK, err.Error())
+	case err != nil:
+		// Invalid prerequisites
+		renderStoreError(w, r, err)
+	default:
+		// Return the updated course
+		renderCourse(w, r, http.StatusOK, updatedCourse)
 	}
-
-	// No course found with the given ID
-	json.NewEncoder(w).Encode("No course found with the given ID")
 }
 
 // deleteOneCourse handles deleting a course by ID
@@ -191,50 +205,170 @@ func updateOneCourse(w http.ResponseWriter, r *http.Request) {
 func deleteOneCourse(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("This is a delete one route")
 
-	// Set response content type to JSON
-	w.Header().Set("Content-Type", "application/json")
-
 	// Extract course ID from URL parameters
 	params := mux.Vars(r)
 	courseID := params["id"]
 
 	// Find and remove the course with matching ID
-	for index, course := range courses {
-		if course.ID == courseID {
-			// Remove course from slice using slice operations
-			// courses[:index] gets elements before the target
-			// courses[index+1:]... gets elements after the target
-			courses = append(courses[:index], courses[index+1:]...)
-
-			// Confirm deletion with success message
-			json.NewEncoder(w).Encode("Course deleted successfully")
-			return
-		}
+	if storeFrom(r).Delete(courseID) {
+		// Confirm deletion with success message
+		render(w, r, http.StatusOK, "Course deleted successfully")
+		return
 	}
 
 	// No course found with the given ID
-	json.NewEncoder(w).Encode("No course found with the given ID")
+	render(w, r, http.StatusOK, "No course found with the given ID")
+}
+
+// registerCourseRoutes adds the course CRUD routes to a (sub)router; routes
+// that responses link to are named through l (see links.go)
+func registerCourseRoutes(r *mux.Router, l *linker) {
+	// Retried POSTs carrying the same Idempotency-Key only create one course
+	createCourse := idempotencyKeys.idempotent(http.HandlerFunc(createOneCourse))
+
+	r.HandleFunc("/courses", getAllCourse).Methods("GET").Name(l.name("courses"))     // Read all courses
+	r.HandleFunc("/courses/{id}", getOneCourse).Methods("GET").Name(l.name("course")) // Read one course
+	r.Handle("/courses", createCourse).Methods("POST")                                // Create new course
+	r.HandleFunc("/courses/{id}", updateOneCourse).Methods("PUT")                     // Update existing course
+	r.HandleFunc("/courses/{id}", deleteOneCourse).Methods("DELETE")                  // Delete course
+
+	// Read-only author registry
+	registerAuthorRoutes(r, l)
+
+	// Modules, lessons and prerequisites of a course
+	registerCurriculumRoutes(r)
+
+	// Slides, PDFs and sample code attached to a course
+	registerMaterialRoutes(r, l)
+
+	// Drafts, scheduled publishing and archiving
+	registerLifecycleRoutes(r, l)
+
+	// Learners, enrollments and certificates
+	registerEnrollmentRoutes(r)
+
+	// Reviews and moderation
+	registerReviewRoutes(r)
+
+	// Coupons, carts and checkout
+	registerCheckoutRoutes(r)
 }
 
 func main() {
 	fmt.Println("Course API Server Starting...")
 
+	// Tenants and their keys come from TENANTS_FILE (see tenant.go)
+	registry, err := loadTenants()
+	if err != nil {
+		log.Fatalf("Loading tenants: %v", err)
+	}
+	tenants = registry
+
+	// Follow another server's catalog when REPLICATE_FROM is set (see
+	// replication.go); otherwise publish scheduled courses when their time
+	// comes, which a replica leaves to its primary
+	replica, err := loadReplica(tenants)
+	if err != nil {
+		log.Fatalf("Loading replication settings: %v", err)
+	}
+	if replica != nil {
+		fmt.Println("Replicating from", replica.primary)
+		go replica.run(context.Background())
+	} else {
+		go scheduler.run(context.Background(), tenants)
+	}
+
+	// Answer CORS preflights for browser clients (see cors.go)
+	cors, err := loadCORSPolicy()
+	if err != nil {
+		log.Fatalf("Loading the CORS policy: %v", err)
+	}
+	handler := cors.handler(newRouterFor(tenants, replica))
+
+	// Serve HTTPS and HTTP/2 when TLS=on (see tlsserver)
+	settings, err := tlsserver.LoadSettings("apimux")
+	if err != nil {
+		log.Fatalf("Loading TLS settings: %v", err)
+	}
+	if settings.Enabled {
+		config, reloader, err := tlsserver.ServerConfig(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go reloader.Watch(context.Background())
+
+		server := &http.Server{Addr: ":4000", Handler: handler, TLSConfig: config}
+		fmt.Println("Server is listening on port 4000 with TLS...")
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+
+	// Start the HTTP server on port 4000
+	fmt.Println("Server is listening on port 4000...")
+	http.ListenAndServe(":4000", handler)
+}
+
+// newRouter creates the router with every route and middleware over the
+// configured tenants; the tests serve requests through it too
+func newRouter() *mux.Router {
+	return newRouterFor(tenants, nil)
+}
+
+// newRouterFor creates the router over a tenant registry. With a replica it
+// serves a read-only follower of another server (see replication.go).
+func newRouterFor(registry *tenantRegistry, replica *replica) *mux.Router {
 	// Create a new Gorilla Mux router
 	r := mux.NewRouter()
 
+	// Compress every response for clients that send Accept-Encoding
+	r.Use(compressResponses)
+
+	// Scope every request to its tenant's catalog
+	r.Use(registry.resolveTenant)
+
+	// Replicas only change through replication
+	if replica != nil {
+		r.Use(replica.readOnly)
+	}
+
+	// Hold requests while a transactional batch of the tenant runs (see batch.go)
+	r.Use(gateRequests)
+
 	// Define API routes with their corresponding handlers
 
-	// Home/Welcome route
+	// Home/Welcome route and the server-rendered admin UI (see admin.go)
 	r.HandleFunc("/", serveHome).Methods("GET")
+	registerBackupRoutes(r)
+	registerAdminRoutes(r)
+
+	// Many operations in one request, dispatched through this router
+	registerBatchRoutes(r)
+
+	// Versioned APIs - every version shares the same handlers
+	for _, v := range []*apiVersion{v1, v2} {
+		api := r.PathPrefix("/" + v.Name).Subrouter()
+		links := newLinker(api, v.Name+".")
+		api.Use(withVersion(v), links.middleware, cacheCatalog)
+		registerCourseRoutes(api, links)
+	}
 
-	// CRUD operations for courses
-	r.HandleFunc("/courses", getAllCourse).Methods("GET")            // Read all courses
-	r.HandleFunc("/courses/{id}", getOneCourse).Methods("GET")       // Read one course
-	r.HandleFunc("/courses", createOneCourse).Methods("POST")        // Create new course
-	r.HandleFunc("/courses/{id}", updateOneCourse).Methods("PUT")    // Update existing course
-	r.HandleFunc("/courses/{id}", deleteOneCourse).Methods("DELETE") // Delete course
+	// Unversioned routes pick a version from the Accept header (v1 by default)
+	legacy := r.NewRoute().Subrouter()
+	legacyLinks := newLinker(legacy, "")
+	legacy.Use(negotiateVersion, legacyLinks.middleware, cacheCatalog)
+	registerCourseRoutes(legacy, legacyLinks)
 
-	// Start the HTTP server on port 4000
-	fmt.Println("Server is listening on port 4000...")
-	http.ListenAndServe(":4000", r)
+	// GraphQL endpoint over the same store, plus an offline query editor
+	r.HandleFunc("/graphql", serveGraphQL).Methods("GET", "POST").Name("graphql")
+	r.HandleFunc("/graphiql", serveGraphiQL).Methods("GET")
+
+	// JSON-RPC 2.0 endpoint over the same store (see rpc.go)
+	r.HandleFunc("/rpc", serveRPC).Methods("POST").Name("rpc")
+
+	// Write-ahead log streaming to followers, and replication status
+	registerReplicationRoutes(r, replica)
+
+	// What the router registered and how it matches (see debug.go)
+	registerDebugRoutes(r)
+
+	return r
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Test helpers and course handler tests - main_test.go
+// Every test runs against a fresh default tenant seeded with the sample
+// courses, so tests don't see each other's changes. Handlers are tested both
+// directly (with mux.SetURLVars standing in for the router) and through the
+// full router, middleware included.
+//
+// Golden files in testdata/golden hold expected response bodies; rewrite
+// them after an intended change with
+//
+//	go test -run TestGolden -update
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// testAdminKey is the admin key of the test tenant; asAdmin sends it
+const testAdminKey = "test-admin-key"
+
+var asAdmin = map[string]string{"X-API-Key": testAdminKey}
+
+// newTestTenants returns a registry with only a fresh default tenant, open to
+// everyone and with testAdminKey as its admin key
+func newTestTenants(t testing.TB) *tenantRegistry {
+	t.Helper()
+	digest := sha256.Sum256([]byte(testAdminKey))
+	registry, err := newTenantRegistry([]tenantConfig{
+		{ID: defaultTenantID, Name: "Default", AdminKeys: []string{hex.EncodeToString(digest[:])}},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// newTestRouter returns the full router over a fresh default tenant, with
+// materials stored in a temporary directory
+func newTestRouter(t testing.TB) *mux.Router {
+	t.Helper()
+	savedTenants, savedDir, savedBackups, savedKeys, savedPayments := tenants, materialsDir, backupDir, idempotencyKeys, payments
+	tenants = newTestTenants(t)
+	materialsDir, backupDir = t.TempDir(), t.TempDir()
+	idempotencyKeys = newIdempotencyStore(idempotencyTTL)
+	payments = newFakePaymentProvider() // Charges are remembered by tenant and cart ID
+	t.Cleanup(func() {
+		tenants, materialsDir, backupDir, idempotencyKeys, payments = savedTenants, savedDir, savedBackups, savedKeys, savedPayments
+	})
+	return newRouter()
+}
+
+// apiCall is one request of a test
+type apiCall struct {
+	method string
+	path   string
+	body   string
+	header map[string]string // Content-Type defaults to JSON when there is a body
+}
+
+// serve sends a request through h and returns the recorded response
+func serve(t *testing.T, h http.Handler, call apiCall) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newTestRequest(call)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestRequest builds the request for a call
+func newTestRequest(call apiCall) *http.Request {
+	var body io.Reader
+	if call.body != "" {
+		body = strings.NewReader(call.body)
+	}
+	req := httptest.NewRequest(call.method, call.path, body)
+	if call.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range call.header {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+// mustServe sends a setup request and fails the test unless it succeeds
+func mustServe(t *testing.T, h http.Handler, call apiCall) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := serve(t, h, call)
+	if rec.Code >= http.StatusBadRequest {
+		t.Fatalf("%s %s: status %d: %s", call.method, call.path, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+// decodeJSON decodes a response body into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
+
+// handlerRequest builds a request for calling a handler directly: the route
+// variables are set with mux.SetURLVars and the request is scoped to the
+// default tenant and API version v2, as the router would do
+func handlerRequest(call apiCall, vars map[string]string) *http.Request {
+	req := newTestRequest(call)
+	ctx := context.WithValue(req.Context(), tenantKey{}, tenants.byID[defaultTenantID])
+	ctx = context.WithValue(ctx, versionKey, v2)
+	return mux.SetURLVars(req.WithContext(ctx), vars)
+}
+
+func TestCourseHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		call       apiCall
+		vars       map[string]string
+		wantStatus int
+		wantBody   string // Substring of the response body
+	}{
+		{"get one", getOneCourse, apiCall{method: "GET", path: "/courses/1"}, map[string]string{"id": "1"}, http.StatusOK, `"name":"Go Basics"`},
+		{"get unknown ID", getOneCourse, apiCall{method: "GET", path: "/courses/99"}, map[string]string{"id": "99"}, http.StatusOK, "No course found with the given ID"},
+		{"get without ID", getOneCourse, apiCall{method: "GET", path: "/courses/"}, nil, http.StatusOK, "No course found with the given ID"},
+		{"get all", getAllCourse, apiCall{method: "GET", path: "/courses"}, nil, http.StatusOK, `"name":"Advanced Go"`},
+		{"get all sorted", getAllCourse, apiCall{method: "GET", path: "/courses?sort=-price"}, nil, http.StatusOK, `"name":"Advanced Go"`},
+		{"get all unknown sort", getAllCourse, apiCall{method: "GET", path: "/courses?sort=colour"}, nil, http.StatusBadRequest, "colour"},
+		{"get all bad page", getAllCourse, apiCall{method: "GET", path: "/courses?page=0"}, nil, http.StatusBadRequest, "page must be a positive whole number"},
+		{"get all page too large", getAllCourse, apiCall{method: "GET", path: "/courses?per_page=1000"}, nil, http.StatusBadRequest, "per_page must be at most 100"},
+
+		{"create", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":"Testing in Go","duration":"PT2H","price":{"amount":1500,"currency":"EUR"}}`}, nil, http.StatusOK, `"id":"3"`},
+		{"create empty body", createOneCourse, apiCall{method: "POST", path: "/courses"}, nil, http.StatusOK, "No data in the request body"},
+		{"create empty object", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{}`}, nil, http.StatusOK, "No data in the request body"},
+		{"create malformed JSON", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":`}, nil, http.StatusBadRequest, ""},
+		{"create wrong type", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":42}`}, nil, http.StatusBadRequest, ""},
+		{"create bad duration", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":"X","duration":"forever"}`}, nil, http.StatusBadRequest, "invalid duration"},
+		{"create with blank name", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":" ","price":10}`}, nil, http.StatusUnprocessableEntity, "Name is required"},
+		{"create negative price", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":"X","price":{"amount":-1,"currency":"USD"}}`}, nil, http.StatusUnprocessableEntity, "Price must not be negative"},
+		{"create unknown prerequisite", createOneCourse, apiCall{method: "POST", path: "/courses", body: `{"name":"X","prerequisites":["42"]}`}, nil, http.StatusBadRequest, "42"},
+		{"create unsupported media type", createOneCourse, apiCall{method: "POST", path: "/courses", body: `name=X`, header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}}, nil, http.StatusUnsupportedMediaType, ""},
+
+		{"update", updateOneCourse, apiCall{method: "PUT", path: "/courses/1", body: `{"name":"Go Basics, 2nd edition","duration":"PT4H"}`}, map[string]string{"id": "1"}, http.StatusOK, `"name":"Go Basics, 2nd edition"`},
+		{"update unknown ID", updateOneCourse, apiCall{method: "PUT", path: "/courses/99", body: `{"name":"X"}`}, map[string]string{"id": "99"}, http.StatusOK, "No course found with the given ID"},
+		{"update empty body", updateOneCourse, apiCall{method: "PUT", path: "/courses/1"}, map[string]string{"id": "1"}, http.StatusOK, "No valid data in the request body"},
+		{"update malformed JSON", updateOneCourse, apiCall{method: "PUT", path: "/courses/1", body: `[`}, map[string]string{"id": "1"}, http.StatusBadRequest, ""},
+		{"update invalid", updateOneCourse, apiCall{method: "PUT", path: "/courses/1", body: `{"name":" "}`}, map[string]string{"id": "1"}, http.StatusUnprocessableEntity, "Name is required"},
+		{"update own prerequisite", updateOneCourse, apiCall{method: "PUT", path: "/courses/1", body: `{"name":"X","prerequisites":["1"]}`}, map[string]string{"id": "1"}, http.StatusBadRequest, ""},
+
+		{"delete", deleteOneCourse, apiCall{method: "DELETE", path: "/courses/2"}, map[string]string{"id": "2"}, http.StatusOK, "Course deleted successfully"},
+		{"delete unknown ID", deleteOneCourse, apiCall{method: "DELETE", path: "/courses/99"}, map[string]string{"id": "99"}, http.StatusOK, "No course found with the given ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRouter(t)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, handlerRequest(tt.call, tt.vars))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCourseLifecycleThroughHandlers(t *testing.T) {
+	newTestRouter(t)
+
+	rec := httptest.NewRecorder()
+	createOneCourse(rec, handlerRequest(apiCall{method: "POST", path: "/courses", body: `{"name":"Concurrency","duration":"1h"}`}, nil))
+	var created courseV2
+	decodeJSON(t, rec, &created)
+	if created.ID == "" || created.Status != StatusPublished {
+		t.Fatalf("created = %+v, want a published course with an ID", created)
+	}
+
+	rec = httptest.NewRecorder()
+	getOneCourse(rec, handlerRequest(apiCall{method: "GET", path: "/courses/" + created.ID}, map[string]string{"id": created.ID}))
+	var got courseV2
+	decodeJSON(t, rec, &got)
+	if got.Name != "Concurrency" || got.Duration.Seconds != 3600 {
+		t.Errorf("got %+v, want the created course", got)
+	}
+
+	rec = httptest.NewRecorder()
+	deleteOneCourse(rec, handlerRequest(apiCall{method: "DELETE", path: "/courses/" + created.ID}, map[string]string{"id": created.ID}))
+	if _, ok := storeFrom(handlerRequest(apiCall{method: "GET", path: "/"}, nil)).Get(created.ID); ok {
+		t.Error("course still stored after delete")
+	}
+}
+
+// Golden responses
+
+// volatile matches timestamps, which change from run to run
+var volatile = regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?Z`)
+
+func TestGoldenResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		call apiCall
+	}{
+		{"v1_courses", apiCall{method: "GET", path: "/v1/courses"}},
+		{"v2_courses", apiCall{method: "GET", path: "/v2/courses"}},
+		{"v2_course", apiCall{method: "GET", path: "/v2/courses/1"}},
+		{"legacy_course", apiCall{method: "GET", path: "/courses/1"}},
+		{"v2_course_fields", apiCall{method: "GET", path: "/v2/courses/1?fields=id,name,price&expand=author"}},
+		{"v2_courses_page", apiCall{method: "GET", path: "/v2/courses?page=2&per_page=1"}},
+		{"v2_authors", apiCall{method: "GET", path: "/v2/authors"}},
+		{"v2_author_courses", apiCall{method: "GET", path: "/v2/authors/a1/courses"}},
+		{"v2_course_xml", apiCall{method: "GET", path: "/v2/courses/1", header: map[string]string{"Accept": "application/xml"}}},
+		{"v2_courses_csv", apiCall{method: "GET", path: "/v2/courses", header: map[string]string{"Accept": "text/csv"}}},
+		{"v2_validation_error", apiCall{method: "POST", path: "/v2/courses", body: `{"name":"","price":{"amount":-5,"currency":"XYZ"}}`}},
+		{"graphql_courses", apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses(orderBy: PRICE) { id name price author { fullname } } }"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, newTestRouter(t), tt.call)
+			got := goldenBody(rec)
+
+			path := filepath.Join("testdata", "golden", tt.name+".golden")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s:\n got: %s\nwant: %s", path, got, want)
+			}
+		})
+	}
+}
+
+// goldenBody is the status line and body of a response, with JSON indented
+// and volatile values replaced
+func goldenBody(rec *httptest.ResponseRecorder) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(http.StatusText(rec.Code) + "\n")
+	body := rec.Body.Bytes()
+	if json.Indent(&buf, body, "", "  ") != nil {
+		buf.Write(body)
+	}
+	out := volatile.ReplaceAll(buf.Bytes(), []byte("<time>"))
+	return append(bytes.TrimRight(out, "\n"), '\n')
+}
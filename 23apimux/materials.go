@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Course materials - materials.go
+// Slides, PDFs and sample code attached to a course. Files are uploaded as
+// multipart/form-data, checked by sniffing their content (the client's
+// Content-Type isn't trusted), and stored on disk under their SHA-256, so the
+// same file attached twice is stored once:
+//
+//	$MATERIALS_DIR/<tenant>/ab/abcdef0123...
+//
+// The store keeps only the metadata. Blobs no material refers to any more are
+// removed when a material or its course is deleted. Downloads support Range
+// requests, so large videos can be streamed and interrupted downloads resumed.
+
+const (
+	maxMaterialSize = 50 << 20  // Largest single file, in bytes
+	maxUploadBody   = 200 << 20 // Largest upload request, in bytes
+	sniffLen        = 512       // Bytes http.DetectContentType looks at
+)
+
+var (
+	errMaterialNotFound = errors.New("No material found with the given ID")
+	errMaterialTooLarge = fmt.Errorf("Files may be at most %d MB", maxMaterialSize>>20)
+	errMaterialType     = errors.New("Unsupported file type")
+)
+
+// Material is a file attached to a course
+type Material struct {
+	ID          string    `json:"id"`           // Unique identifier for the material
+	CourseID    string    `json:"course_id"`    // Course it belongs to
+	Filename    string    `json:"filename"`     // Name of the uploaded file
+	ContentType string    `json:"content_type"` // Sniffed from the content
+	Size        int64     `json:"size"`         // In bytes
+	SHA256      string    `json:"sha256"`       // Hex digest, also the blob's name
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// materialTypes are the content types that may be uploaded, as reported by
+// http.DetectContentType. HTML and other types a browser might render are
+// left out.
+var materialTypes = map[string]bool{
+	"application/pdf":           true,
+	"application/zip":           true,
+	"application/x-gzip":        true,
+	"text/plain; charset=utf-8": true,
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"audio/mpeg":                true,
+	"video/mp4":                 true,
+	"video/webm":                true,
+}
+
+// zipTypes refines application/zip for office documents, which are zip files
+var zipTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+}
+
+// materialType returns the content type of a file from its first bytes, or
+// errMaterialType when it isn't allowed
+func materialType(filename string, head []byte) (string, error) {
+	sniffed := http.DetectContentType(head)
+	if !materialTypes[sniffed] {
+		return "", fmt.Errorf("%w: %s", errMaterialType, sniffed)
+	}
+	if sniffed == "application/zip" {
+		if t, ok := zipTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+			return t, nil
+		}
+	}
+	return sniffed, nil
+}
+
+// cleanFilename keeps the base name of an uploaded file, without control
+// characters, so it is safe in Content-Disposition
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		return "material"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// Blob storage
+
+// materialsDir is where blobs are stored, one directory per tenant
+var materialsDir = loadMaterialsDir()
+
+// loadMaterialsDir reads MATERIALS_DIR, defaulting to a temporary directory
+func loadMaterialsDir() string {
+	if dir := os.Getenv("MATERIALS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "apimux-materials")
+}
+
+// blobStore keeps files on disk under their SHA-256
+type blobStore struct {
+	dir string
+}
+
+// blobs returns the blob storage of the store's tenant
+func (s *courseStore) blobs() blobStore {
+	return blobStore{dir: filepath.Join(materialsDir, s.tenant)}
+}
+
+// path is where the blob with the given digest is stored
+func (b blobStore) path(sum string) string {
+	return filepath.Join(b.dir, sum[:2], sum)
+}
+
+// blobInfo describes a stored blob
+type blobInfo struct {
+	sum  string // Hex SHA-256
+	size int64
+}
+
+// put stores the content of r, which may be at most limit bytes. A blob with
+// the same content is stored only once.
+func (b blobStore) put(r io.Reader, limit int64) (blobInfo, error) {
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return blobInfo{}, err
+	}
+	tmp, err := os.CreateTemp(b.dir, "upload-*")
+	if err != nil {
+		return blobInfo{}, err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	defer tmp.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, limit+1))
+	switch {
+	case err != nil:
+		return blobInfo{}, err
+	case n > limit:
+		return blobInfo{}, errMaterialTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return blobInfo{}, err
+	}
+
+	info := blobInfo{sum: hex.EncodeToString(hash.Sum(nil)), size: n}
+	path := b.path(info.sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return blobInfo{}, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return info, nil
+	}
+	return info, os.Rename(tmp.Name(), path)
+}
+
+// open opens a blob for reading
+func (b blobStore) open(sum string) (*os.File, error) {
+	return os.Open(b.path(sum))
+}
+
+// sweep removes every blob (and leftover upload) not in keep
+func (b blobStore) sweep(keep map[string]bool) error {
+	err := filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || keep[d.Name()] {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Store methods
+
+// Materials returns the materials of a live course in upload order
+func (s *courseStore) Materials(courseID string) ([]Material, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.index(courseID); i < 0 || !s.courses[i].Live() {
+		return nil, errCourseNotFound
+	}
+	list := []Material{}
+	for _, m := range s.materials {
+		if m.CourseID == courseID {
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
+
+// Material returns one material of a live course
+func (s *courseStore) Material(courseID, id string) (Material, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.index(courseID); i < 0 || !s.courses[i].Live() {
+		return Material{}, errCourseNotFound
+	}
+	for _, m := range s.materials {
+		if m.CourseID == courseID && m.ID == id {
+			return m, nil
+		}
+	}
+	return Material{}, errMaterialNotFound
+}
+
+// AddMaterial stores an uploaded file and attaches it to a course
+func (s *courseStore) AddMaterial(courseID, filename string, content io.Reader) (Material, error) {
+	// Uploads may run side by side, but not during a sweep, which would
+	// remove a blob that isn't attached yet
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+
+	if _, ok := s.Get(courseID); !ok {
+		return Material{}, errCourseNotFound
+	}
+
+	// Check the type before anything is written to disk
+	m := Material{CourseID: courseID, Filename: cleanFilename(filename), UploadedAt: time.Now().UTC()}
+	br := bufio.NewReaderSize(content, sniffLen)
+	head, _ := br.Peek(sniffLen) // Shorter files give fewer bytes; read errors resurface below
+	contentType, err := materialType(m.Filename, head)
+	if err != nil {
+		return Material{}, err
+	}
+	blob, err := s.blobs().put(br, maxMaterialSize)
+	if err != nil {
+		return Material{}, err
+	}
+	m.ContentType, m.Size, m.SHA256 = contentType, blob.size, blob.sum
+
+	s.mu.Lock()
+	if s.index(courseID) < 0 {
+		// Deleted during the upload; the blob goes with the next sweep
+		s.mu.Unlock()
+		return Material{}, errCourseNotFound
+	}
+	m.ID = strconv.Itoa(s.nextMaterialID)
+	s.nextMaterialID++
+	s.materials = append(s.materials, m)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return m, nil
+}
+
+// DeleteMaterial detaches a material from its course and removes its blob
+// unless another material has the same content
+func (s *courseStore) DeleteMaterial(courseID, id string) error {
+	s.mu.Lock()
+	if s.index(courseID) < 0 {
+		s.mu.Unlock()
+		return errCourseNotFound
+	}
+	n := len(s.materials)
+	s.materials = slices.DeleteFunc(s.materials, func(m Material) bool { return m.CourseID == courseID && m.ID == id })
+	deleted := len(s.materials) < n
+	s.mu.Unlock()
+
+	if !deleted {
+		return errMaterialNotFound
+	}
+	s.catalogChanged()
+	s.sweepBlobs()
+	return nil
+}
+
+// sweepBlobs removes the blobs no material refers to. While sweeps are held
+// (see holdSweeps) it does nothing; the hold sweeps when it is released.
+func (s *courseStore) sweepBlobs() {
+	if s.sweepHolds.Load() > 0 {
+		return
+	}
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	s.mu.RLock()
+	keep := map[string]bool{}
+	for _, m := range s.materials {
+		keep[m.SHA256] = true
+	}
+	s.mu.RUnlock()
+
+	if err := s.blobs().sweep(keep); err != nil {
+		fmt.Println("Removing unused materials:", err)
+	}
+}
+
+// holdSweeps keeps blobs on disk until the returned function is called.
+// Transactional batches hold sweeps so that restoring the store never brings
+// back a material whose blob is already gone.
+func (s *courseStore) holdSweeps() (release func()) {
+	s.sweepHolds.Add(1)
+	return func() {
+		s.sweepHolds.Add(-1)
+		s.sweepBlobs()
+	}
+}
+
+// Handlers
+
+// materialResource is a material with its links, as sent in responses
+type materialResource struct {
+	Material
+	Links links `json:"_links,omitempty"`
+}
+
+// material adds links to a material
+func (l *linker) material(m Material) materialResource {
+	ls := links{}
+	ls.add("self", l.url("material", "id", m.CourseID, "material", m.ID))
+	ls.add("download", l.url("materialContent", "id", m.CourseID, "material", m.ID))
+	ls.add("course", l.url("course", "id", m.CourseID))
+	return materialResource{Material: m, Links: ls}
+}
+
+// materialResources adds links to a list of materials
+func (l *linker) materialResources(list []Material) []materialResource {
+	out := make([]materialResource, len(list))
+	for i, m := range list {
+		out[i] = l.material(m)
+	}
+	return out
+}
+
+// getMaterials lists the materials of a course
+// GET /courses/{id}/materials
+func getMaterials(w http.ResponseWriter, r *http.Request) {
+	list, err := storeFrom(r).Materials(mux.Vars(r)["id"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, linksFrom(r).materialResources(list))
+}
+
+// getMaterial returns the metadata of one material
+// GET /courses/{id}/materials/{material}
+func getMaterial(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m, err := storeFrom(r).Material(vars["id"], vars["material"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, linksFrom(r).material(m))
+}
+
+// uploadMaterials attaches the files sent in the "file" fields of a
+// multipart/form-data body. Either every file is stored or none is.
+// POST /courses/{id}/materials
+func uploadMaterials(w http.ResponseWriter, r *http.Request) {
+	courseID := mux.Vars(r)["id"]
+	store := storeFrom(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBody)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		render(w, r, http.StatusUnsupportedMediaType, "Upload files as multipart/form-data")
+		return
+	}
+
+	var created []Material
+	fail := func(status int, message string) {
+		for _, m := range created {
+			store.DeleteMaterial(courseID, m.ID)
+		}
+		render(w, r, status, message)
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads may be at most %d MB", maxUploadBody>>20))
+			return
+		case err != nil:
+			fail(http.StatusBadRequest, "Invalid multipart body: "+err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		m, err := store.AddMaterial(courseID, part.FileName(), part)
+		part.Close()
+		switch {
+		case errors.As(err, &tooLarge), errors.Is(err, errMaterialTooLarge):
+			fail(http.StatusRequestEntityTooLarge, errMaterialTooLarge.Error())
+			return
+		case errors.Is(err, errMaterialType):
+			fail(http.StatusUnsupportedMediaType, err.Error())
+			return
+		case errors.Is(err, errCourseNotFound):
+			fail(http.StatusNotFound, err.Error())
+			return
+		case err != nil:
+			fail(http.StatusInternalServerError, "Could not store the file")
+			return
+		}
+		created = append(created, m)
+	}
+
+	if len(created) == 0 {
+		render(w, r, http.StatusBadRequest, `Send at least one file in a "file" field`)
+		return
+	}
+	render(w, r, http.StatusCreated, linksFrom(r).materialResources(created))
+}
+
+// downloadMaterial sends the content of a material. Range and conditional
+// requests are handled by http.ServeContent, with the digest as ETag.
+// GET /courses/{id}/materials/{material}/content
+func downloadMaterial(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	store := storeFrom(r)
+	m, err := store.Material(vars["id"], vars["material"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	f, err := store.blobs().open(m.SHA256)
+	if err != nil {
+		render(w, r, http.StatusNotFound, "The material's file is missing")
+		return
+	}
+	defer f.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", m.ContentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": m.Filename}))
+	h.Set("ETag", `"`+m.SHA256+`"`)
+	h.Set("Cache-Control", "private, max-age=31536000, immutable")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+	h.Del("Last-Modified") // Set by the catalog cache; ServeContent uses the upload time
+	http.ServeContent(w, r, m.Filename, m.UploadedAt, f)
+}
+
+// deleteMaterial detaches a material from its course
+// DELETE /courses/{id}/materials/{material}
+func deleteMaterial(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := storeFrom(r).DeleteMaterial(vars["id"], vars["material"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Material deleted successfully")
+}
+
+// registerMaterialRoutes mounts the material routes on r
+func registerMaterialRoutes(r *mux.Router, l *linker) {
+	r.HandleFunc("/courses/{id}/materials", getMaterials).Methods("GET").Name(l.name("materials"))
+	r.HandleFunc("/courses/{id}/materials", uploadMaterials).Methods("POST")
+	r.HandleFunc("/courses/{id}/materials/{material}", getMaterial).Methods("GET").Name(l.name("material"))
+	r.HandleFunc("/courses/{id}/materials/{material}", deleteMaterial).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/materials/{material}/content", downloadMaterial).Methods("GET").Name(l.name("materialContent"))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money - money.go
+// Prices are stored as an integer number of minor currency units (cents for
+// USD, yen for JPY) together with an ISO 4217 currency code, so totals never
+// pick up floating point rounding errors. Decimal input like 29.99 is parsed
+// digit by digit, never through a float.
+
+// defaultCurrency is assumed for prices sent in the old plain-number shape
+const defaultCurrency = "USD"
+
+// currencyExponents maps supported ISO 4217 codes to their number of minor
+// unit digits (2 for USD: 1 dollar = 100 cents)
+var currencyExponents = map[string]int{
+	"AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "CZK": 2,
+	"DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2,
+	"INR": 2, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "MYR": 2,
+	"NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "SEK": 2, "SGD": 2,
+	"THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// Money is an amount in minor units of a currency
+type Money struct {
+	Amount   int64  `json:"amount"`   // Minor units, e.g. 2999 for 29.99 USD
+	Currency string `json:"currency"` // ISO 4217 code, e.g. "USD"
+}
+
+// currencyExponent returns the minor unit digits of a currency
+func currencyExponent(currency string) (int, error) {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exp, nil
+}
+
+// normalizeCurrency upper-cases a code and defaults an empty one to USD
+func normalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if _, err := currencyExponent(currency); err != nil {
+		return "", err
+	}
+	return currency, nil
+}
+
+// ParseMoney parses a decimal amount in major units ("29.99") for a currency.
+// More fractional digits than the currency allows is an error, not a rounding.
+func ParseMoney(decimal, currency string) (Money, error) {
+	currency, err := normalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	exp, _ := currencyExponent(currency)
+
+	s := strings.TrimSpace(decimal)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	// Exponent notation (1e2) is expanded exactly with big.Rat
+	if strings.ContainsAny(s, "eE") {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return Money{}, fmt.Errorf("invalid amount %q", decimal)
+		}
+		s = r.FloatString(exp + 6)
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(frac, "0123456789") != "" {
+		return Money{}, fmt.Errorf("invalid amount %q", decimal)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", decimal, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q is out of range", decimal)
+	}
+	if neg {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MoneyFromFloat converts a float amount in major units, as used by the v1
+// API and GraphQL Float arguments
+func MoneyFromFloat(f float64, currency string) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, errors.New("amount must be a finite number")
+	}
+	// 'f' with -1 precision gives the shortest decimal that round-trips,
+	// e.g. 29.99 rather than 29.989999999999998
+	return ParseMoney(strconv.FormatFloat(f, 'f', -1, 64), currency)
+}
+
+// Decimal renders the amount in major units, e.g. "29.99"
+func (m Money) Decimal() string {
+	exp, err := currencyExponent(m.Currency)
+	if err != nil || exp == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := fmt.Sprintf("%0*d", exp+1, amount)
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// Float returns the amount in major units. Only for legacy representations;
+// never do arithmetic on the result.
+func (m Money) Float() float64 {
+	f, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return f
+}
+
+// String renders the price for people, e.g. "29.99 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Add sums two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, errors.New("amount overflow")
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub subtracts an amount of the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Share returns a fraction of the amount given in basis points (1/100 of a
+// percent, so 1950 is 19.5%), rounded half up to a whole minor unit
+func (m Money) Share(basisPoints int64) Money {
+	// big.Int keeps large amounts times large rates from overflowing
+	n := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(basisPoints))
+	n.Add(n, big.NewInt(5000))
+	n.Div(n, big.NewInt(10000))
+	return Money{Amount: n.Int64(), Currency: m.Currency}
+}
+
+// Compare orders prices by currency, then amount. It returns -1, 0 or 1.
+func (m Money) Compare(other Money) int {
+	if c := strings.Compare(m.Currency, other.Currency); c != 0 {
+		return c
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+// UnmarshalJSON accepts the current object shape and the old plain number:
+//   - {"amount": 2999, "currency": "USD"}  (minor units)
+//   - 29.99                                (major units, USD)
+//   - "29.99", "29.99 EUR" or "EUR 29.99"  (major units, from text formats)
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*m = Money{}
+		return nil
+
+	case len(data) > 0 && data[0] == '{':
+		var raw struct {
+			Amount   json.Number `json:"amount"`
+			Currency string      `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		currency, err := normalizeCurrency(raw.Currency)
+		if err != nil {
+			return err
+		}
+		amount := int64(0)
+		if raw.Amount != "" {
+			if amount, err = raw.Amount.Int64(); err != nil {
+				return fmt.Errorf("price amount %s must be a whole number of minor units", raw.Amount)
+			}
+		}
+		*m = Money{Amount: amount, Currency: currency}
+		return nil
+
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		amount, currency := s, ""
+		if fields := strings.Fields(s); len(fields) == 2 {
+			amount, currency = fields[0], fields[1]
+			if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+				amount, currency = fields[1], fields[0]
+			}
+		}
+		parsed, err := ParseMoney(amount, currency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	// Old shape: a bare number in dollars. Parse the literal text, not a float.
+	parsed, err := ParseMoney(string(data), defaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
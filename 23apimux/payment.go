@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payments - payment.go
+// Checkout charges orders through a PaymentProvider. The only implementation
+// is a fake that runs in-process, so the whole checkout flow works offline;
+// a real provider would implement the same interface.
+
+// PaymentProvider charges a customer for an order
+type PaymentProvider interface {
+	// Charge takes payment for an order. Declined payments return an error
+	// wrapping errPaymentDeclined.
+	Charge(ctx context.Context, req PaymentRequest) (Payment, error)
+}
+
+// PaymentRequest is one charge
+type PaymentRequest struct {
+	Reference string // Idempotency reference: retries with the same one are charged once
+	Amount    Money  // What to charge
+	Token     string // Opaque payment method token from the client
+}
+
+// Payment is a successful charge
+type Payment struct {
+	ID       string    `json:"id"`
+	Amount   Money     `json:"amount"`
+	Provider string    `json:"provider"`
+	PaidAt   time.Time `json:"paid_at"`
+}
+
+// errPaymentDeclined is returned when the provider refuses a charge
+var errPaymentDeclined = errors.New("Payment was declined")
+
+// payments is the provider used by checkout
+var payments PaymentProvider = newFakePaymentProvider()
+
+// fakePaymentProvider approves and declines charges based on test tokens,
+// the same way hosted providers' sandboxes do:
+//   - "tok_ok" (or any token starting with "tok_ok_") is approved
+//   - "tok_declined" is declined
+//   - "tok_error" fails as if the provider were unreachable
+type fakePaymentProvider struct {
+	mu      sync.Mutex
+	charges map[string]Payment // By reference, so retried charges aren't taken twice
+	nextID  int
+}
+
+// newFakePaymentProvider creates a provider with no charges
+func newFakePaymentProvider() *fakePaymentProvider {
+	return &fakePaymentProvider{charges: map[string]Payment{}, nextID: 1}
+}
+
+// Charge implements PaymentProvider
+func (p *fakePaymentProvider) Charge(ctx context.Context, req PaymentRequest) (Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return Payment{}, err
+	}
+
+	switch {
+	case req.Token == "tok_declined":
+		return Payment{}, fmt.Errorf("%w: card declined", errPaymentDeclined)
+	case req.Token == "tok_error":
+		return Payment{}, errors.New("payment provider unavailable")
+	case req.Token != "tok_ok" && !strings.HasPrefix(req.Token, "tok_ok_"):
+		return Payment{}, fmt.Errorf("%w: unknown payment token", errPaymentDeclined)
+	case req.Amount.Amount <= 0:
+		return Payment{}, fmt.Errorf("%w: nothing to charge", errPaymentDeclined)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if existing, ok := p.charges[req.Reference]; ok {
+		return existing, nil
+	}
+	payment := Payment{
+		ID:       fmt.Sprintf("pay_fake_%d", p.nextID),
+		Amount:   req.Amount,
+		Provider: "fake",
+		PaidAt:   time.Now().UTC(),
+	}
+	p.nextID++
+	p.charges[req.Reference] = payment
+	return payment, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content negotiation - render.go
+// Handlers hand their response to render, which picks an encoding from the
+// Accept header, and read request bodies through decodeBody, which picks a
+// decoder from the Content-Type header.
+
+// codec is one supported representation format
+type codec struct {
+	Name       string   // Short name, also the +suffix of structured media types ("json", "xml")
+	MediaTypes []string // Accepted media types; the first one is used in responses
+
+	encode func(w io.Writer, v any) error
+	decode func(r io.Reader, v any) error
+}
+
+var jsonCodec = &codec{
+	Name:       "json",
+	MediaTypes: []string{"application/json"},
+	encode: func(w io.Writer, v any) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+	decode: func(r io.Reader, v any) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+}
+
+// codecs lists every format in order of server preference
+var codecs = []*codec{jsonCodec, xmlCodec, csvCodec, yamlCodec, msgpackCodec}
+
+var (
+	// errNotAcceptable means none of the Accept media types can be produced
+	errNotAcceptable = errors.New("none of the requested media types are supported")
+	// errUnsupportedMediaType means the request body's Content-Type can't be decoded
+	errUnsupportedMediaType = errors.New("unsupported request content type")
+)
+
+// acceptRange is one entry of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept splits an Accept header and orders it by quality (highest first)
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// codecFor returns the codec that produces or reads the given media type.
+// Structured suffixes like "application/vnd.courseapi.v2+json" match by suffix.
+func codecFor(mediaType string) *codec {
+	for _, c := range codecs {
+		for _, mt := range c.MediaTypes {
+			if mediaType == mt {
+				return c
+			}
+		}
+		if strings.HasSuffix(mediaType, "+"+c.Name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// negotiateCodec picks the response codec for an Accept header. It returns the
+// codec and the exact media type to send back as Content-Type.
+func negotiateCodec(accept string) (*codec, string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonCodec, jsonCodec.MediaTypes[0], nil
+	}
+
+	for _, ar := range parseAccept(accept) {
+		switch {
+		case ar.mediaType == "*/*":
+			return jsonCodec, jsonCodec.MediaTypes[0], nil
+		case strings.HasSuffix(ar.mediaType, "/*"):
+			// "application/*" or "text/*" - first codec with a matching type wins
+			prefix := strings.TrimSuffix(ar.mediaType, "*")
+			for _, c := range codecs {
+				for _, mt := range c.MediaTypes {
+					if strings.HasPrefix(mt, prefix) {
+						return c, mt, nil
+					}
+				}
+			}
+		default:
+			if c := codecFor(ar.mediaType); c != nil {
+				return c, ar.mediaType, nil
+			}
+		}
+	}
+	return nil, "", errNotAcceptable
+}
+
+// render encodes v in the representation the client asked for and writes it
+// with the given status. If the Accept header can't be satisfied a 406 listing
+// the supported types is sent instead.
+func render(w http.ResponseWriter, r *http.Request, status int, v any) {
+	addVary(w.Header(), "Accept")
+
+	c, mediaType, err := negotiateCodec(r.Header.Get("Accept"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotAcceptable)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":     err.Error(),
+			"supported": supportedMediaTypes(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	c.encode(w, v)
+}
+
+// decodeBody decodes the request body into v according to its Content-Type.
+// Bodies without a Content-Type are treated as JSON, like they always were.
+func decodeBody(r *http.Request, v any) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return jsonCodec.decode(r.Body, v)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errUnsupportedMediaType
+	}
+	c := codecFor(mediaType)
+	if c == nil {
+		return errUnsupportedMediaType
+	}
+	return c.decode(r.Body, v)
+}
+
+// renderDecodeError reports a body that couldn't be decoded: 415 for an
+// unsupported Content-Type and 400 for anything else. An empty body is left
+// to the caller, which reports it with its own message.
+func renderDecodeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		// An empty body is reported by the handler's own validation
+		return false
+	case errors.Is(err, errUnsupportedMediaType):
+		if r.Method == http.MethodPost {
+			w.Header().Set("Accept-Post", strings.Join(supportedMediaTypes(), ", "))
+		}
+		render(w, r, http.StatusUnsupportedMediaType, err.Error())
+	default:
+		// Malformed bodies and invalid values (e.g. a bad duration or currency)
+		render(w, r, http.StatusBadRequest, err.Error())
+	}
+	return true
+}
+
+// renderStoreError reports a failed store operation: 422 for invalid fields,
+// 404 when something doesn't exist, 409 for duplicates or unmet state, 403
+// for actions the learner may not take and 400 for invalid changes
+func renderStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if renderValidationError(w, r, err) {
+		return
+	}
+	render(w, r, storeErrorStatus(err), err.Error())
+}
+
+// storeErrorStatus classifies a store error as an HTTP status
+func storeErrorStatus(err error) int {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errCourseNotFound), errors.Is(err, errModuleNotFound), errors.Is(err, errLessonNotFound),
+		errors.Is(err, errLearnerNotFound), errors.Is(err, errEnrollmentNotFound), errors.Is(err, errReviewNotFound),
+		errors.Is(err, errCartNotFound), errors.Is(err, errOrderNotFound), errors.Is(err, errCouponNotFound),
+		errors.Is(err, errAuthorNotFound), errors.Is(err, errMaterialNotFound), errors.Is(err, errBackupNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errLearnerExists), errors.Is(err, errAlreadyEnrolled), errors.Is(err, errNotCompleted),
+		errors.Is(err, errAlreadyReviewed), errors.Is(err, errCouponExists), errors.Is(err, errAuthorExists),
+		errors.Is(err, errAuthorInUse), errors.Is(err, errCourseNotPublished), errors.Is(err, errBackupExists),
+		errors.Is(err, errBackupTenant), errors.Is(err, errDuplicateModule), errors.Is(err, errDuplicateLesson):
+		status = http.StatusConflict
+	case errors.Is(err, errBackupCorrupt):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, errNotEnrolled), errors.Is(err, errQuotaExceeded), errors.Is(err, errReadOnlyReplica):
+		status = http.StatusForbidden
+	}
+	return status
+}
+
+// supportedMediaTypes lists the primary media type of every codec
+func supportedMediaTypes() []string {
+	types := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		types = append(types, c.MediaTypes[0])
+	}
+	return types
+}
+
+// addVary adds a header name to Vary unless it is already listed
+func addVary(h http.Header, name string) {
+	for _, existing := range h.Values("Vary") {
+		for _, field := range strings.Split(existing, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
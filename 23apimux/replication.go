@@ -0,0 +1,780 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Replication - replication.go
+// Read-only replicas of the catalog, e.g. for reporting. Every store keeps a
+// write-ahead log of its course and author changes, and a follower streams it
+// over HTTP from the primary, applies the entries in order and serves reads:
+//
+//	GET /replication/snapshot                  the catalog and the offset it is at
+//	GET /replication/wal?log=ID&after=N        entries after offset N, then new ones as they happen
+//	GET /replication/status                    log position, connected followers and replica lag
+//
+// The snapshot and the log need an admin key of the tenant. A server follows
+// another when REPLICATE_FROM is set to the primary's URL:
+//   - REPLICATION_API_KEY: key sent to the primary; an admin key of every
+//     tenant there
+//   - REPLICA_DIR: where each tenant's replicated catalog and offset are
+//     checkpointed (default a temporary directory)
+//   - REPLICATION_CA_FILE, REPLICATION_CERT_FILE, REPLICATION_KEY_FILE: CA to
+//     trust and client certificate, for an https:// primary
+//
+// Each tenant is followed separately. A follower starts from a snapshot, then
+// streams from its offset; after a restart it loads its checkpoint and
+// resumes where it stopped. Logs live in memory, so a primary that restarts
+// starts a new log (with a new ID), and a follower that fell further behind
+// than the log reaches takes a new snapshot.
+//
+// Only the catalog is replicated: learners, enrollments, carts and orders
+// stay on the primary. A replica refuses every write: REST writes by method,
+// and GraphQL mutations and JSON-RPC calls that change the store by
+// operation (see readOnlyError), so queries sent with POST still work.
+
+const (
+	maxWALEntries      = 10000            // Entries a log keeps for followers that fall behind
+	maxWALLine         = 16 << 20         // Longest streamed entry, in bytes
+	replicaCheckpoint  = time.Second      // How often a follower saves its position while applying
+	maxReplicaBackoff  = 30 * time.Second // Longest wait before reconnecting to the primary
+	walHeartbeatPeriod = 5 * time.Second  // How often an idle stream reports the primary's offset
+)
+
+// Log entry kinds
+const (
+	walPutCourse    = "put_course"
+	walDeleteCourse = "delete_course"
+	walPutAuthor    = "put_author"
+	walDeleteAuthor = "delete_author"
+	walHeartbeat    = "heartbeat" // Not logged; tells an idle follower how far the log is
+)
+
+var (
+	// errWALGone means a log can't be followed from the requested position
+	errWALGone = errors.New("The log doesn't have the requested offset; take a new snapshot")
+	// errReadOnlyReplica refuses a write sent to a replica
+	errReadOnlyReplica = errors.New("This server is a read-only replica; send writes to the primary")
+)
+
+// walEntry is one change of the catalog. Entries carry whole courses and
+// authors, so applying one twice does no harm.
+type walEntry struct {
+	Offset int64     `json:"offset"` // 1-based position in the log
+	Time   time.Time `json:"time"`   // When the primary logged it
+	Op     string    `json:"op"`     // put_course, delete_course, put_author, delete_author or heartbeat
+	ID     string    `json:"id,omitempty"`
+	Course *Course   `json:"course,omitempty"` // New state for put_course
+	Author *Author   `json:"author,omitempty"` // New state for put_author
+}
+
+// Write-ahead log
+
+// writeAheadLog records a store's catalog changes. Each mutation of the
+// courses or authors logs what it changed while it still holds the store's
+// lock (see logWAL), so the log's head always matches the catalog, except
+// while a transactional batch holds the log back (see holdWAL).
+type writeAheadLog struct {
+	id string // Random; a new log gets a new ID, so followers notice
+
+	mu      sync.Mutex
+	entries []walEntry    // The most recent entries, up to maxWALEntries
+	head    int64         // Offset of the last entry
+	changed chan struct{} // Closed when entries are added
+	streams map[*walStream]bool
+	holding bool       // A transactional batch is running (see holdWAL)
+	held    []walEntry // Entries logged by the batch so far, not yet published
+}
+
+// walStream is a follower reading the log
+type walStream struct {
+	Remote string    `json:"remote"` // Follower's address
+	Since  time.Time `json:"since"`  // When it connected
+	Sent   int64     `json:"sent"`   // Offset of the last entry sent to it
+}
+
+// newWriteAheadLog starts an empty log
+func newWriteAheadLog() *writeAheadLog {
+	return &writeAheadLog{
+		id:      hex.EncodeToString(randomBytes(8)),
+		changed: make(chan struct{}),
+		streams: map[*walStream]bool{},
+	}
+}
+
+// logWAL appends entries for the catalog changes just made. Called with s.mu
+// held for writing; the seed catalog is the log's starting point and isn't
+// logged.
+func (s *courseStore) logWAL(entries ...walEntry) {
+	l := s.wal
+	if l == nil || len(entries) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holding {
+		l.held = append(l.held, entries...)
+		return
+	}
+	l.append(entries)
+}
+
+// holdWAL keeps the entries logged from now on from followers until the
+// returned function is called: with commit they are published, otherwise
+// they are dropped. Transactional batches hold the log, so a change they
+// roll back never reaches a follower; the tenant's gate keeps snapshots from
+// being taken in the meantime.
+func (s *courseStore) holdWAL() (release func(commit bool)) {
+	l := s.wal
+	l.mu.Lock()
+	l.holding = true
+	l.mu.Unlock()
+	return func(commit bool) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		held := l.held
+		l.holding, l.held = false, nil
+		if commit && len(held) > 0 {
+			l.append(held)
+		}
+	}
+}
+
+// append publishes entries to followers; called with l.mu held
+func (l *writeAheadLog) append(entries []walEntry) {
+	now := time.Now().UTC()
+	for _, e := range entries {
+		l.head++
+		e.Offset, e.Time = l.head, now
+		l.entries = append(l.entries, e)
+	}
+	if n := len(l.entries) - maxWALEntries; n > 0 {
+		l.entries = slices.Delete(l.entries, 0, n)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// putCourseEntry logs a course's new state
+func putCourseEntry(c Course) walEntry {
+	c = cloneCourse(c)
+	return walEntry{Op: walPutCourse, ID: c.ID, Course: &c}
+}
+
+// putAuthorEntry logs an author's new state
+func putAuthorEntry(a Author) walEntry {
+	return walEntry{Op: walPutAuthor, ID: a.ID, Author: &a}
+}
+
+// logCatalogReplaced logs a catalog that was replaced as a whole (a restore or
+// a replica's snapshot): every author and course as it is now, then
+// deletions of those that are gone. Called with s.mu held for writing.
+func (s *courseStore) logCatalogReplaced(oldCourses []Course, oldAuthors []Author) {
+	var entries []walEntry
+	for _, a := range s.authors {
+		entries = append(entries, putAuthorEntry(a))
+	}
+	for _, c := range s.courses {
+		entries = append(entries, putCourseEntry(c))
+	}
+	for _, c := range oldCourses {
+		if s.index(c.ID) < 0 {
+			entries = append(entries, walEntry{Op: walDeleteCourse, ID: c.ID})
+		}
+	}
+	for _, a := range oldAuthors {
+		if s.authorIndex(a.ID) < 0 {
+			entries = append(entries, walEntry{Op: walDeleteAuthor, ID: a.ID})
+		}
+	}
+	s.logWAL(entries...)
+}
+
+// read returns the entries after an offset, and a channel that is closed when
+// more are added
+func (l *writeAheadLog) read(after int64) ([]walEntry, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first := l.head - int64(len(l.entries)) // Offset just before the oldest entry kept
+	if after < first || after > l.head {
+		return nil, nil, errWALGone
+	}
+	return slices.Clone(l.entries[after-first:]), l.changed, nil
+}
+
+// walSnapshot is the catalog at an offset of a log
+type walSnapshot struct {
+	Log     string    `json:"log"`
+	Offset  int64     `json:"offset"`
+	Time    time.Time `json:"time"`
+	Courses []Course  `json:"courses"`
+	Authors []Author  `json:"authors"`
+}
+
+// walSnapshot returns the catalog with the offset of the last entry, which
+// every change of the catalog logs before it lets go of the store
+func (s *courseStore) walSnapshot() walSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l := s.wal
+	l.mu.Lock()
+	head := l.head
+	l.mu.Unlock()
+
+	snap := walSnapshot{
+		Log:     l.id,
+		Offset:  head,
+		Time:    time.Now().UTC(),
+		Courses: make([]Course, len(s.courses)),
+		Authors: slices.Clone(s.authors),
+	}
+	for i, c := range s.courses {
+		snap.Courses[i] = cloneCourse(c)
+	}
+	return snap
+}
+
+// Applying entries
+
+// applyWAL applies an entry of a primary's log, and logs it again for any
+// followers of the replica. Replicas change only through here and
+// replaceCatalog.
+func (s *courseStore) applyWAL(e walEntry) {
+	s.mu.Lock()
+	switch e.Op {
+	case walPutCourse:
+		if e.Course == nil {
+			break
+		}
+		if i := s.index(e.ID); i >= 0 {
+			s.courses[i] = cloneCourse(*e.Course)
+		} else {
+			s.courses = append(s.courses, cloneCourse(*e.Course))
+		}
+	case walDeleteCourse:
+		if i := s.index(e.ID); i >= 0 {
+			s.courses = slices.Delete(s.courses, i, i+1)
+		}
+	case walPutAuthor:
+		if e.Author == nil {
+			break
+		}
+		if i := slices.IndexFunc(s.authors, func(a Author) bool { return a.ID == e.ID }); i >= 0 {
+			s.authors[i] = *e.Author
+		} else {
+			s.authors = append(s.authors, *e.Author)
+		}
+	case walDeleteAuthor:
+		s.authors = slices.DeleteFunc(s.authors, func(a Author) bool { return a.ID == e.ID })
+	}
+	s.logWAL(e)
+	s.mu.Unlock()
+	s.catalogChanged()
+}
+
+// replaceCatalog replaces the courses and authors, from a snapshot or a
+// checkpoint
+func (s *courseStore) replaceCatalog(courses []Course, authors []Author) {
+	s.mu.Lock()
+	oldCourses, oldAuthors := s.courses, s.authors
+	s.courses = make([]Course, len(courses))
+	for i, c := range courses {
+		s.courses[i] = cloneCourse(c)
+	}
+	s.authors = slices.Clone(authors)
+	s.logCatalogReplaced(oldCourses, oldAuthors)
+	s.mu.Unlock()
+	s.catalogChanged()
+}
+
+// catalog returns copies of the courses and authors
+func (s *courseStore) catalog() ([]Course, []Author) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	courses := make([]Course, len(s.courses))
+	for i, c := range s.courses {
+		courses[i] = cloneCourse(c)
+	}
+	return courses, slices.Clone(s.authors)
+}
+
+// Followers
+
+// replica follows a primary server's tenants
+type replica struct {
+	primary  string // Base URL, e.g. http://primary:4000
+	apiKey   string // Sent as X-API-Key when set
+	dir      string // Checkpoints, one file per tenant
+	client   *http.Client
+	registry *tenantRegistry // The follower's own tenants
+
+	mu     sync.Mutex
+	status map[string]*replicaStatus // By tenant
+}
+
+// replicaStatus is how far a tenant's replica is behind
+type replicaStatus struct {
+	Primary     string     `json:"primary"`
+	Log         string     `json:"log"`                    // ID of the primary's log being followed
+	Applied     int64      `json:"applied"`                // Offset of the last applied entry
+	Head        int64      `json:"head"`                   // Latest offset the primary reported
+	LagEntries  int64      `json:"lag_entries"`            // Head - Applied
+	LagSeconds  float64    `json:"lag_seconds"`            // Time since the replica was last caught up
+	Connected   bool       `json:"connected"`              // Streaming from the primary right now
+	LastContact *time.Time `json:"last_contact,omitempty"` // Last entry or heartbeat received
+	Snapshots   int        `json:"snapshots"`              // Full copies taken since startup
+	Error       string     `json:"error,omitempty"`        // Why the last attempt failed
+
+	caughtUp time.Time // When Applied last reached Head
+}
+
+// replicaPosition is where a follower is in a primary's log, and what it
+// checkpoints
+type replicaPosition = walSnapshot
+
+// loadReplica configures following from REPLICATE_FROM; it returns nil when
+// the server is a primary
+func loadReplica(registry *tenantRegistry) (*replica, error) {
+	primary := os.Getenv("REPLICATE_FROM")
+	if primary == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(primary); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("REPLICATE_FROM must be an http:// or https:// URL")
+	}
+	config := &tls.Config{}
+	if caFile := os.Getenv("REPLICATION_CA_FILE"); caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if certFile, keyFile := os.Getenv("REPLICATION_CERT_FILE"), os.Getenv("REPLICATION_KEY_FILE"); certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("replication client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	dir := os.Getenv("REPLICA_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "apimux-replica")
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+	return newReplica(primary, os.Getenv("REPLICATION_API_KEY"), dir, client, registry), nil
+}
+
+// newReplica creates a follower of primary for the tenants of registry
+func newReplica(primary, apiKey, dir string, client *http.Client, registry *tenantRegistry) *replica {
+	return &replica{
+		primary:  primary,
+		apiKey:   apiKey,
+		dir:      dir,
+		client:   client,
+		registry: registry,
+		status:   map[string]*replicaStatus{},
+	}
+}
+
+// run follows every tenant until ctx is done
+func (rep *replica) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range rep.registry.byID {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.follow(ctx, t)
+		}()
+	}
+	wg.Wait()
+}
+
+// follow keeps a tenant's store in step with the primary, reconnecting with
+// a growing delay when the primary can't be reached
+func (rep *replica) follow(ctx context.Context, t *tenant) {
+	pos, err := rep.loadCheckpoint(t)
+	if err != nil {
+		fmt.Printf("Replica %s: ignoring the checkpoint: %v\n", t.ID, err)
+	}
+	rep.update(t.ID, func(st *replicaStatus) {
+		st.Primary, st.Log, st.Applied = rep.primary, pos.Log, pos.Offset
+	})
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		if pos.Log == "" {
+			err = rep.resync(ctx, t, &pos)
+		}
+		if err == nil {
+			err = rep.stream(ctx, t, &pos, func() { backoff = time.Second })
+			if errors.Is(err, errWALGone) {
+				pos.Log = "" // Start again from a snapshot
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		rep.update(t.ID, func(st *replicaStatus) { st.Connected, st.Error = false, err.Error() })
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxReplicaBackoff)
+		err = nil
+	}
+	rep.update(t.ID, func(st *replicaStatus) { st.Connected = false })
+}
+
+// get sends a request for a tenant to the primary
+func (rep *replica) get(ctx context.Context, t *tenant, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rep.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Tenant", t.ID)
+	if rep.apiKey != "" {
+		req.Header.Set("X-API-Key", rep.apiKey)
+	}
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusGone:
+		resp.Body.Close()
+		return nil, errWALGone
+	}
+	defer resp.Body.Close()
+	var message string
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &message) != nil {
+		message = string(data)
+	}
+	return nil, fmt.Errorf("primary answered %s: %s", resp.Status, message)
+}
+
+// resync replaces the tenant's catalog with a snapshot from the primary
+func (rep *replica) resync(ctx context.Context, t *tenant, pos *replicaPosition) error {
+	resp, err := rep.get(ctx, t, "/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var snap walSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return fmt.Errorf("reading the snapshot: %v", err)
+	}
+	t.store.replaceCatalog(snap.Courses, snap.Authors)
+	snap.Courses, snap.Authors = nil, nil
+	*pos = snap
+	rep.update(t.ID, func(st *replicaStatus) {
+		st.Log, st.Applied, st.Head = snap.Log, snap.Offset, max(st.Head, snap.Offset)
+		st.Snapshots++
+	})
+	return rep.saveCheckpoint(t, pos)
+}
+
+// stream applies the primary's log from pos until the connection ends.
+// connected is called once the primary accepts the stream.
+func (rep *replica) stream(ctx context.Context, t *tenant, pos *replicaPosition, connected func()) error {
+	query := url.Values{"log": {pos.Log}, "after": {strconv.FormatInt(pos.Offset, 10)}}
+	resp, err := rep.get(ctx, t, "/replication/wal?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	connected()
+	rep.update(t.ID, func(st *replicaStatus) { st.Connected, st.Error = true, "" })
+
+	// Save the position every so often, and when the stream ends
+	saved := pos.Offset
+	save := func() error {
+		if pos.Offset == saved {
+			return nil
+		}
+		saved = pos.Offset
+		return rep.saveCheckpoint(t, pos)
+	}
+	defer save()
+	lastSave := time.Now()
+
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(make([]byte, 64<<10), maxWALLine)
+	for lines.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			return fmt.Errorf("reading the log: %v", err)
+		}
+		if e.Op != walHeartbeat {
+			if e.Offset != pos.Offset+1 {
+				return fmt.Errorf("the log skipped from offset %d to %d", pos.Offset, e.Offset)
+			}
+			t.store.applyWAL(e)
+			pos.Offset, pos.Time = e.Offset, e.Time
+		}
+		rep.update(t.ID, func(st *replicaStatus) {
+			now := time.Now().UTC()
+			st.Applied, st.Head, st.LastContact = pos.Offset, max(st.Head, e.Offset), &now
+		})
+		if e.Op == walHeartbeat || time.Since(lastSave) >= replicaCheckpoint {
+			if err := save(); err != nil {
+				return err
+			}
+			lastSave = time.Now()
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	return errors.New("the primary ended the stream")
+}
+
+// update changes a tenant's status and keeps its lag current
+func (rep *replica) update(tenant string, change func(st *replicaStatus)) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	st, ok := rep.status[tenant]
+	if !ok {
+		st = &replicaStatus{caughtUp: time.Now()}
+		rep.status[tenant] = st
+	}
+	change(st)
+	st.LagEntries = max(st.Head-st.Applied, 0)
+	if st.LagEntries == 0 && st.Connected {
+		st.caughtUp = time.Now()
+	}
+}
+
+// statusOf returns a copy of a tenant's status
+func (rep *replica) statusOf(tenant string) replicaStatus {
+	rep.update(tenant, func(*replicaStatus) {})
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	st := *rep.status[tenant]
+	if st.LagEntries > 0 || !st.Connected {
+		st.LagSeconds = time.Since(st.caughtUp).Seconds()
+	}
+	return st
+}
+
+// checkpointPath is where a tenant's replica is checkpointed
+func (rep *replica) checkpointPath(t *tenant) string {
+	return filepath.Join(rep.dir, t.ID+".json")
+}
+
+// saveCheckpoint writes the tenant's catalog with the position it is at
+func (rep *replica) saveCheckpoint(t *tenant, pos *replicaPosition) error {
+	if err := os.MkdirAll(rep.dir, 0o755); err != nil {
+		return err
+	}
+	checkpoint := *pos
+	checkpoint.Courses, checkpoint.Authors = t.store.catalog()
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(rep.checkpointPath(t), data)
+}
+
+// loadCheckpoint restores the tenant's catalog from its checkpoint, if it
+// has one for this primary
+func (rep *replica) loadCheckpoint(t *tenant) (replicaPosition, error) {
+	data, err := os.ReadFile(rep.checkpointPath(t))
+	if errors.Is(err, os.ErrNotExist) {
+		return replicaPosition{}, nil
+	}
+	if err != nil {
+		return replicaPosition{}, err
+	}
+	var pos replicaPosition
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return replicaPosition{}, err
+	}
+	t.store.replaceCatalog(pos.Courses, pos.Authors)
+	pos.Courses, pos.Authors = nil, nil
+	return pos, nil
+}
+
+// readOnlyKey marks the requests of a replica; its value is the primary's URL
+type readOnlyKey struct{}
+
+// operationRoutes carry reads and writes alike in POST bodies, and refuse the
+// writes themselves; batch operations pass through readOnly on their own
+var operationRoutes = map[string]bool{"graphql": true, "rpc": true, "batch": true}
+
+// readOnly turns away writes, which only the primary takes
+func (rep *replica) readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), readOnlyKey{}, rep.primary))
+		route := mux.CurrentRoute(r)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && (route == nil || !operationRoutes[route.GetName()]) {
+			render(w, r, http.StatusForbidden, readOnlyError(r.Context()).Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlyError returns the error for a write on a replica, and nil on a
+// primary
+func readOnlyError(ctx context.Context) error {
+	primary, ok := ctx.Value(readOnlyKey{}).(string)
+	if !ok {
+		return nil
+	}
+	return fmt.Errorf("%w at %s", errReadOnlyReplica, primary)
+}
+
+// Handlers
+
+// streamWAL sends the tenant's log after an offset as JSON lines, then new
+// entries as they are logged, with a heartbeat when there are none. With
+// follow=false it stops at the end of the log instead.
+// GET /replication/wal?log=ID&after=N&follow=false
+func streamWAL(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a replication stream route")
+
+	q := r.URL.Query()
+	l := storeFrom(r).wal
+	after, err := strconv.ParseInt(q.Get("after"), 10, 64)
+	if q.Get("after") == "" {
+		after, err = 0, nil
+	}
+	if err != nil {
+		render(w, r, http.StatusBadRequest, "after must be a log offset")
+		return
+	}
+	if id := q.Get("log"); id != "" && id != l.id {
+		render(w, r, http.StatusGone, errWALGone.Error())
+		return
+	}
+	entries, changed, err := l.read(after)
+	if err != nil {
+		render(w, r, http.StatusGone, err.Error())
+		return
+	}
+
+	stream := &walStream{Remote: r.RemoteAddr, Since: time.Now().UTC(), Sent: after}
+	l.mu.Lock()
+	l.streams[stream] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.streams, stream)
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-WAL-Log", l.id)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	heartbeat := time.NewTicker(walHeartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			after = e.Offset
+		}
+		l.mu.Lock()
+		stream.Sent = after
+		l.mu.Unlock()
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+		if q.Get("follow") == "false" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			l.mu.Lock()
+			head := l.head
+			l.mu.Unlock()
+			if err := enc.Encode(walEntry{Offset: head, Time: time.Now().UTC(), Op: walHeartbeat}); err != nil {
+				return
+			}
+		}
+		if entries, changed, err = l.read(after); err != nil {
+			return // Fell out of the log; the follower reconnects and is told so
+		}
+	}
+}
+
+// getWALSnapshot sends the tenant's catalog with the log offset it is at
+// GET /replication/snapshot
+func getWALSnapshot(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a replication snapshot route")
+
+	w.Header().Set("Cache-Control", "no-store")
+	render(w, r, http.StatusOK, storeFrom(r).walSnapshot())
+}
+
+// replicationStatus reports the tenant's log and, on a replica, its lag
+// GET /replication/status
+func replicationStatus(replica *replica) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("This is a replication status route")
+
+		t := tenantFrom(r.Context())
+		l := t.store.wal
+		l.mu.Lock()
+		status := map[string]any{"role": "primary", "log": l.id, "head": l.head}
+		followers := []walStream{}
+		for s := range l.streams {
+			followers = append(followers, *s)
+		}
+		l.mu.Unlock()
+		slices.SortFunc(followers, func(a, b walStream) int { return a.Since.Compare(b.Since) })
+		status["followers"] = followers
+		if replica != nil {
+			status["role"] = "replica"
+			status["replica"] = replica.statusOf(t.ID)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		render(w, r, http.StatusOK, status)
+	}
+}
+
+// registerReplicationRoutes adds the log and status routes. Streams stay open
+// for as long as a follower is connected, so they don't hold the tenant's
+// gate (see gateRequests).
+func registerReplicationRoutes(r *mux.Router, replica *replica) {
+	r.HandleFunc("/replication/wal", requireAdmin(streamWAL)).Methods("GET").Name("replicationStream")
+	r.HandleFunc("/replication/snapshot", requireAdmin(getWALSnapshot)).Methods("GET")
+	r.HandleFunc("/replication/status", replicationStatus(replica)).Methods("GET")
+}
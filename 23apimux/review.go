@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Course reviews - review.go
+// Enrolled learners can rate a course from 1 to 5 stars, once per course.
+// Other learners can flag a review, and a moderator can hide it. Every course
+// carries a rating summary that is adjusted on each change instead of being
+// recomputed from all reviews; hidden reviews don't count towards it.
+//
+// Creating a review returns an edit_token, which its author sends back as
+// X-Review-Token to edit or delete it. Moderation, and reading hidden
+// reviews, need an admin key of the tenant (see tenant.go); admins may also
+// edit and delete any review.
+
+// maxReviewText caps the length of a review's text
+const maxReviewText = 5000
+
+// Review is a learner's rating of a course
+type Review struct {
+	ID        string    `json:"id"`         // Unique identifier for the review
+	CourseID  string    `json:"course_id"`  // Reviewed course
+	LearnerID string    `json:"learner_id"` // Author of the review
+	Rating    int       `json:"rating"`     // 1 to 5 stars
+	Text      string    `json:"text"`       // Optional written feedback
+	Flags     []Flag    `json:"flags"`      // Reports from other learners
+	Hidden    bool      `json:"hidden"`     // Hidden by a moderator
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	EditToken string    `json:"edit_token,omitempty"` // Only in the response to its creation
+}
+
+// Flag reports a review for moderation
+type Flag struct {
+	Reason    string    `json:"reason"`
+	FlaggedAt time.Time `json:"flagged_at"`
+}
+
+// RatingSummary aggregates the visible reviews of a course
+type RatingSummary struct {
+	Average   float64 `json:"average"`   // Mean star rating, 0 without reviews
+	Count     int     `json:"count"`     // Number of visible reviews
+	Histogram [5]int  `json:"histogram"` // Histogram[0] counts 1-star reviews, Histogram[4] 5-star ones
+}
+
+// add counts delta more (or, if negative, fewer) reviews with the given rating
+func (s *RatingSummary) add(rating, delta int) {
+	s.Histogram[rating-1] += delta
+	s.Count += delta
+
+	stars := 0
+	for i, n := range s.Histogram {
+		stars += (i + 1) * n
+	}
+	s.Average = 0
+	if s.Count > 0 {
+		s.Average = math.Round(float64(stars)/float64(s.Count)*100) / 100
+	}
+}
+
+var (
+	errReviewNotFound  = errors.New("No review found with the given ID")
+	errAlreadyReviewed = errors.New("The learner has already reviewed this course")
+	errNotEnrolled     = errors.New("Only learners enrolled in the course can review it")
+	errNotReviewAuthor = errors.New("Only the author of the review can change it")
+)
+
+// validateReview checks the rating and text of a new or edited review
+func validateReview(rv *Review) error {
+	rv.Text = strings.TrimSpace(rv.Text)
+	switch {
+	case rv.Rating < 1 || rv.Rating > 5:
+		return errors.New("rating must be between 1 and 5")
+	case len(rv.Text) > maxReviewText:
+		return errors.New("review text is too long")
+	}
+	return nil
+}
+
+// Reviews returns the reviews of a live course, newest first. Hidden reviews
+// are only included when asked for.
+func (s *courseStore) Reviews(courseID string, includeHidden bool) ([]Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.index(courseID); i < 0 || !s.courses[i].Live() {
+		return nil, errCourseNotFound
+	}
+	list := []Review{}
+	for i := len(s.reviews) - 1; i >= 0; i-- {
+		rv := s.reviews[i]
+		if rv.CourseID == courseID && (includeHidden || !rv.Hidden) {
+			rv.Flags = slices.Clone(rv.Flags)
+			list = append(list, rv)
+		}
+	}
+	return list, nil
+}
+
+// Review returns one review of a live course
+func (s *courseStore) Review(courseID, id string) (Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ci := s.index(courseID); ci >= 0 && !s.courses[ci].Live() {
+		return Review{}, errCourseNotFound
+	}
+	i, err := s.reviewIndex(courseID, id)
+	if err != nil {
+		return Review{}, err
+	}
+	rv := s.reviews[i]
+	rv.Flags = slices.Clone(rv.Flags)
+	return rv, nil
+}
+
+// reviewIndex finds a review of a course; called with s.mu held
+func (s *courseStore) reviewIndex(courseID, id string) (int, error) {
+	if s.index(courseID) < 0 {
+		return -1, errCourseNotFound
+	}
+	i := slices.IndexFunc(s.reviews, func(rv Review) bool {
+		return rv.ID == id && rv.CourseID == courseID
+	})
+	if i < 0 {
+		return -1, errReviewNotFound
+	}
+	return i, nil
+}
+
+// AddReview stores a learner's first review of a course
+func (s *courseStore) AddReview(courseID string, rv Review) (Review, error) {
+	if err := validateReview(&rv); err != nil {
+		return Review{}, err
+	}
+
+	s.mu.Lock()
+	ci := s.index(courseID)
+	switch {
+	case ci < 0:
+		s.mu.Unlock()
+		return Review{}, errCourseNotFound
+	case s.learnerIndex(rv.LearnerID) < 0:
+		s.mu.Unlock()
+		return Review{}, errLearnerNotFound
+	case !slices.ContainsFunc(s.enrollments, func(e Enrollment) bool {
+		return e.CourseID == courseID && e.LearnerID == rv.LearnerID
+	}):
+		s.mu.Unlock()
+		return Review{}, errNotEnrolled
+	case slices.ContainsFunc(s.reviews, func(existing Review) bool {
+		return existing.CourseID == courseID && existing.LearnerID == rv.LearnerID
+	}):
+		s.mu.Unlock()
+		return Review{}, errAlreadyReviewed
+	}
+
+	now := time.Now().UTC()
+	rv.ID = strconv.Itoa(s.nextReviewID)
+	s.nextReviewID++
+	rv.CourseID = courseID
+	rv.Flags = []Flag{}
+	rv.Hidden = false
+	rv.CreatedAt, rv.UpdatedAt = now, now
+	s.reviews = append(s.reviews, rv)
+	s.courses[ci].Rating.add(rv.Rating, 1)
+	s.logWAL(putCourseEntry(s.courses[ci]))
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return rv, nil
+}
+
+// updateReview applies fn to a review and moves the course's rating summary
+// from the review's old contribution to its new one
+func (s *courseStore) updateReview(courseID, id string, fn func(rv *Review) error) (Review, error) {
+	s.mu.Lock()
+	i, err := s.reviewIndex(courseID, id)
+	if err != nil {
+		s.mu.Unlock()
+		return Review{}, err
+	}
+	rv := s.reviews[i]
+	rv.Flags = slices.Clone(rv.Flags)
+	if err := fn(&rv); err != nil {
+		s.mu.Unlock()
+		return Review{}, err
+	}
+
+	course := &s.courses[s.index(courseID)]
+	before := course.Rating
+	if old := s.reviews[i]; !old.Hidden {
+		course.Rating.add(old.Rating, -1)
+	}
+	if !rv.Hidden {
+		course.Rating.add(rv.Rating, 1)
+	}
+	if course.Rating != before {
+		s.logWAL(putCourseEntry(*course))
+	}
+	s.reviews[i] = rv
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return rv, nil
+}
+
+// EditReview changes the rating and text of a review
+func (s *courseStore) EditReview(courseID, id string, edit Review) (Review, error) {
+	if err := validateReview(&edit); err != nil {
+		return Review{}, err
+	}
+	return s.updateReview(courseID, id, func(rv *Review) error {
+		rv.Rating = edit.Rating
+		rv.Text = edit.Text
+		rv.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// FlagReview records a report against a review
+func (s *courseStore) FlagReview(courseID, id, reason string) (Review, error) {
+	return s.updateReview(courseID, id, func(rv *Review) error {
+		rv.Flags = append(rv.Flags, Flag{Reason: strings.TrimSpace(reason), FlaggedAt: time.Now().UTC()})
+		return nil
+	})
+}
+
+// ModerateReview hides or restores a review. Restoring clears its flags.
+func (s *courseStore) ModerateReview(courseID, id string, hidden bool) (Review, error) {
+	return s.updateReview(courseID, id, func(rv *Review) error {
+		rv.Hidden = hidden
+		if !hidden {
+			rv.Flags = []Flag{}
+		}
+		return nil
+	})
+}
+
+// DeleteReview removes a review
+func (s *courseStore) DeleteReview(courseID, id string) error {
+	s.mu.Lock()
+	i, err := s.reviewIndex(courseID, id)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if rv := s.reviews[i]; !rv.Hidden {
+		ci := s.index(courseID)
+		s.courses[ci].Rating.add(rv.Rating, -1)
+		s.logWAL(putCourseEntry(s.courses[ci]))
+	}
+	s.reviews = slices.Delete(s.reviews, i, i+1)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return nil
+}
+
+// reviewToken is the secret that lets the author of a review change it: an
+// HMAC of the review under the tenant's certificate key, so it isn't stored
+func (s *courseStore) reviewToken(rv Review) string {
+	mac := hmac.New(sha256.New, s.certificateKey())
+	fmt.Fprintf(mac, "review:%s/%s/%d", rv.CourseID, rv.ID, rv.CreatedAt.UnixNano())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Controller for reviews
+
+// authorizeReview finds the review a request changes and checks that the
+// caller wrote it or is an admin. It reports false after responding.
+func authorizeReview(w http.ResponseWriter, r *http.Request) bool {
+	vars := mux.Vars(r)
+	store := storeFrom(r)
+	rv, err := store.Review(vars["id"], vars["rid"])
+	if err != nil {
+		renderStoreError(w, r, err)
+		return false
+	}
+	token := r.Header.Get("X-Review-Token")
+	if !tenantFrom(r.Context()).admin(r) && !hmac.Equal([]byte(token), []byte(store.reviewToken(rv))) {
+		render(w, r, http.StatusForbidden, errNotReviewAuthor.Error())
+		return false
+	}
+	return true
+}
+
+// getReviews lists the reviews of a course, newest first; hidden ones only
+// for admins
+// GET /courses/{id}/reviews?include=hidden
+func getReviews(w http.ResponseWriter, r *http.Request) {
+	includeHidden := r.URL.Query().Get("include") == "hidden"
+	if includeHidden && !tenantFrom(r.Context()).admin(r) {
+		render(w, r, http.StatusForbidden, "An admin key for this tenant is required")
+		return
+	}
+	list, err := storeFrom(r).Reviews(mux.Vars(r)["id"], includeHidden)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, list)
+}
+
+// getReview returns one review
+// GET /courses/{id}/reviews/{rid}
+func getReview(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rv, err := storeFrom(r).Review(vars["id"], vars["rid"])
+	if err == nil && rv.Hidden && !tenantFrom(r.Context()).admin(r) {
+		err = errReviewNotFound
+	}
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, rv)
+}
+
+// createReview adds a learner's review of a course
+// POST /courses/{id}/reviews  {"learner_id": "1", "rating": 5, "text": "..."}
+func createReview(w http.ResponseWriter, r *http.Request) {
+	var rv Review
+	if renderDecodeError(w, r, decodeBody(r, &rv)) {
+		return
+	}
+	if rv.LearnerID == "" {
+		render(w, r, http.StatusBadRequest, "learner_id is required")
+		return
+	}
+
+	store := storeFrom(r)
+	rv, err := store.AddReview(mux.Vars(r)["id"], rv)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	rv.EditToken = store.reviewToken(rv)
+	render(w, r, http.StatusCreated, rv)
+}
+
+// updateReview changes the rating and text of a review, for its author
+// PUT /courses/{id}/reviews/{rid}  with X-Review-Token
+func updateReview(w http.ResponseWriter, r *http.Request) {
+	var edit Review
+	if renderDecodeError(w, r, decodeBody(r, &edit)) {
+		return
+	}
+	if !authorizeReview(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	rv, err := storeFrom(r).EditReview(vars["id"], vars["rid"], edit)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, rv)
+}
+
+// deleteReview removes a review, for its author
+// DELETE /courses/{id}/reviews/{rid}  with X-Review-Token
+func deleteReview(w http.ResponseWriter, r *http.Request) {
+	if !authorizeReview(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	if err := storeFrom(r).DeleteReview(vars["id"], vars["rid"]); err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, "Review deleted successfully")
+}
+
+// flagReview reports a review for moderation
+// POST /courses/{id}/reviews/{rid}/flags  {"reason": "spam"}
+func flagReview(w http.ResponseWriter, r *http.Request) {
+	var flag Flag
+	if renderDecodeError(w, r, decodeBody(r, &flag)) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	rv, err := storeFrom(r).FlagReview(vars["id"], vars["rid"], flag.Reason)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, rv)
+}
+
+// moderateReview hides or restores a review, for admins
+// PUT /courses/{id}/reviews/{rid}/moderation  {"hidden": true}
+func moderateReview(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Hidden *bool `json:"hidden"`
+	}
+	if renderDecodeError(w, r, decodeBody(r, &body)) {
+		return
+	}
+	if body.Hidden == nil {
+		render(w, r, http.StatusBadRequest, "hidden is required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	rv, err := storeFrom(r).ModerateReview(vars["id"], vars["rid"], *body.Hidden)
+	if err != nil {
+		renderStoreError(w, r, err)
+		return
+	}
+	render(w, r, http.StatusOK, rv)
+}
+
+// registerReviewRoutes adds the review and moderation routes
+func registerReviewRoutes(r *mux.Router) {
+	r.HandleFunc("/courses/{id}/reviews", getReviews).Methods("GET")
+	r.HandleFunc("/courses/{id}/reviews", createReview).Methods("POST")
+	r.HandleFunc("/courses/{id}/reviews/{rid}", getReview).Methods("GET")
+	r.HandleFunc("/courses/{id}/reviews/{rid}", updateReview).Methods("PUT")
+	r.HandleFunc("/courses/{id}/reviews/{rid}", deleteReview).Methods("DELETE")
+	r.HandleFunc("/courses/{id}/reviews/{rid}/flags", flagReview).Methods("POST")
+	r.HandleFunc("/courses/{id}/reviews/{rid}/moderation", requireAdmin(moderateReview)).Methods("PUT")
+}
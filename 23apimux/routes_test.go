@@ -0,0 +1,1247 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Route tests - routes_test.go
+// Every route of the API through the full router: each case runs its setup
+// calls on a fresh tenant, then checks the status and body of one request.
+
+// The setups that several cases share
+var (
+	withLearner = []apiCall{
+		{method: "POST", path: "/learners", body: `{"name":"Ada","email":"ada@example.com"}`},
+	}
+	withEnrollment = slices.Concat(withLearner, []apiCall{
+		{method: "POST", path: "/v2/courses/1/modules", body: `{"title":"Intro","lessons":[{"title":"Hello","duration":"PT10M"}]}`},
+		{method: "POST", path: "/courses/1/enrollments", body: `{"learner_id":"1"}`},
+	})
+	withReview = slices.Concat(withEnrollment, []apiCall{
+		{method: "POST", path: "/courses/1/enrollments/1/completions", body: `{"lesson_id":"1"}`},
+		{method: "POST", path: "/courses/1/reviews", body: `{"learner_id":"1","rating":4,"text":"Good"}`},
+	})
+	withModule = []apiCall{
+		{method: "POST", path: "/v2/courses/1/modules", body: `{"title":"Basics","lessons":[{"title":"Types","duration":"PT20M"}]}`},
+	}
+	withCart = slices.Concat(withLearner, []apiCall{
+		{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`, header: asAdmin},
+	})
+	withCartItem = slices.Concat(withCart, []apiCall{
+		{method: "POST", path: "/carts/1/items", body: `{"course_id":"1"}`, header: asAdmin},
+	})
+	withCoupon = []apiCall{
+		{method: "POST", path: "/coupons", body: `{"code":"SAVE10","percent":10}`, header: asAdmin},
+	}
+	withDraft = []apiCall{
+		{method: "PUT", path: "/v2/courses/2/status", body: `{"status":"draft"}`},
+	}
+)
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      []apiCall
+		call       apiCall
+		wantStatus int
+		wantBody   string // Substring of the response body
+	}{
+		// Home, versions and tenants
+		{"home", nil, apiCall{method: "GET", path: "/"}, http.StatusOK, "Course API"},
+		{"unknown route", nil, apiCall{method: "GET", path: "/nowhere"}, http.StatusNotFound, ""},
+		{"wrong method", nil, apiCall{method: "PATCH", path: "/learners"}, http.StatusMethodNotAllowed, ""},
+		{"legacy v2 by Accept", nil, apiCall{method: "GET", path: "/courses/1", header: map[string]string{"Accept": "application/vnd.courseapi.v2+json"}}, http.StatusOK, `"iso8601":"PT3H"`},
+		{"not acceptable", nil, apiCall{method: "GET", path: "/v2/courses/1", header: map[string]string{"Accept": "image/png"}}, http.StatusNotAcceptable, "supported"},
+		{"unknown tenant", nil, apiCall{method: "GET", path: "/v2/courses", header: map[string]string{"X-Tenant": "nobody"}}, http.StatusNotFound, errTenantNotFound.Error()},
+
+		// Authors
+		{"authors", nil, apiCall{method: "GET", path: "/v2/authors"}, http.StatusOK, `"fullname":"John Doe"`},
+		{"author", nil, apiCall{method: "GET", path: "/v2/authors/a1"}, http.StatusOK, `"courses":{"href":"/v2/authors/a1/courses"}`},
+		{"unknown author", nil, apiCall{method: "GET", path: "/v2/authors/a99"}, http.StatusNotFound, errAuthorNotFound.Error()},
+		{"author courses", nil, apiCall{method: "GET", path: "/v2/authors/a1/courses"}, http.StatusOK, `"name":"Go Basics"`},
+		{"unknown author courses", nil, apiCall{method: "GET", path: "/v2/authors/a99/courses"}, http.StatusNotFound, errAuthorNotFound.Error()},
+		{"course links a known author", nil, apiCall{method: "POST", path: "/v2/courses", body: `{"name":"New","author":{"id":"a1"}}`}, http.StatusOK, `"fullname":"John Doe"`},
+		{"known author not overwritten", []apiCall{{method: "POST", path: "/v2/courses", body: `{"name":"New","author":{"id":"a1","fullname":"Someone Else","email":"else@example.com"}}`}}, apiCall{method: "GET", path: "/v2/authors/a1"}, http.StatusOK, `"fullname":"John Doe"`},
+		{"new author with a taken email", nil, apiCall{method: "POST", path: "/v2/courses", body: `{"name":"New","author":{"id":"a9","fullname":"Copy","email":"JOHN@example.com"}}`}, http.StatusConflict, errAuthorExists.Error()},
+
+		// Curriculum
+		{"learning path", nil, apiCall{method: "GET", path: "/v2/courses/2/prerequisites"}, http.StatusOK, ""},
+		{"learning path unknown course", nil, apiCall{method: "GET", path: "/v2/courses/99/prerequisites"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"modules", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules"}, http.StatusOK, `"title":"Basics"`},
+		{"no modules", nil, apiCall{method: "GET", path: "/v2/courses/1/modules"}, http.StatusOK, "[]"},
+		{"modules of a draft", withDraft, apiCall{method: "GET", path: "/v2/courses/2/modules"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"learning path of a draft", withDraft, apiCall{method: "GET", path: "/v2/courses/2/prerequisites"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"duplicate module IDs", nil, apiCall{method: "PUT", path: "/v2/courses/1", body: `{"name":"Go Basics","modules":[{"id":"m","title":"A"},{"id":"m","title":"B"}]}`}, http.StatusConflict, errDuplicateModule.Error()},
+		{"module ID of another course", withModule, apiCall{method: "PUT", path: "/v2/courses/2", body: `{"name":"Advanced Go","modules":[{"id":"1","title":"Copied"}]}`}, http.StatusConflict, errDuplicateModule.Error()},
+		{"duplicate lesson IDs", nil, apiCall{method: "POST", path: "/v2/courses", body: `{"name":"New","modules":[{"title":"A","lessons":[{"id":"x","title":"L"}]},{"title":"B","lessons":[{"id":"x","title":"L"}]}]}`}, http.StatusConflict, errDuplicateLesson.Error()},
+		{"create module", nil, apiCall{method: "POST", path: "/v2/courses/1/modules", body: `{"title":"Basics"}`}, http.StatusCreated, `"title":"Basics"`},
+		{"create module without title", nil, apiCall{method: "POST", path: "/v2/courses/1/modules", body: `{}`}, http.StatusBadRequest, "Module title is required"},
+		{"create module malformed", nil, apiCall{method: "POST", path: "/v2/courses/1/modules", body: `{`}, http.StatusBadRequest, ""},
+		{"create module unknown course", nil, apiCall{method: "POST", path: "/v2/courses/99/modules", body: `{"title":"X"}`}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"module", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules/1"}, http.StatusOK, `"title":"Basics"`},
+		{"unknown module", nil, apiCall{method: "GET", path: "/v2/courses/1/modules/99"}, http.StatusNotFound, errModuleNotFound.Error()},
+		{"update module", withModule, apiCall{method: "PUT", path: "/v2/courses/1/modules/1", body: `{"title":"Fundamentals"}`}, http.StatusOK, `"title":"Fundamentals"`},
+		{"delete module", withModule, apiCall{method: "DELETE", path: "/v2/courses/1/modules/1"}, http.StatusOK, ""},
+		{"lessons", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules/1/lessons"}, http.StatusOK, `"title":"Types"`},
+		{"create lesson in lower case", withModule, apiCall{method: "POST", path: "/v2/courses/1/modules/1/lessons", body: `{"title":"Loops","duration":"pt15m"}`}, http.StatusCreated, `"duration":"PT15M"`},
+		{"create lesson with a bare designator", withModule, apiCall{method: "POST", path: "/v2/courses/1/modules/1/lessons", body: `{"title":"Loops","duration":"p"}`}, http.StatusBadRequest, "invalid ISO 8601 duration"},
+		{"create lesson", withModule, apiCall{method: "POST", path: "/v2/courses/1/modules/1/lessons", body: `{"title":"Loops","duration":"PT15M"}`}, http.StatusCreated, `"title":"Loops"`},
+		{"lesson", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules/1/lessons/1"}, http.StatusOK, `"title":"Types"`},
+		{"unknown lesson", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules/1/lessons/99"}, http.StatusNotFound, errLessonNotFound.Error()},
+		{"update lesson", withModule, apiCall{method: "PUT", path: "/v2/courses/1/modules/1/lessons/1", body: `{"title":"Basic types","duration":"PT25M"}`}, http.StatusOK, `"title":"Basic types"`},
+		{"delete lesson", withModule, apiCall{method: "DELETE", path: "/v2/courses/1/modules/1/lessons/1"}, http.StatusOK, ""},
+
+		// Publishing lifecycle
+		{"course status", nil, apiCall{method: "GET", path: "/v2/courses/1/status"}, http.StatusOK, `"status":"published"`},
+		{"draft status", withDraft, apiCall{method: "GET", path: "/v2/courses/2/status"}, http.StatusOK, `"status":"draft"`},
+		{"drafts can't be read", withDraft, apiCall{method: "GET", path: "/v2/courses/2"}, http.StatusOK, "No course found with the given ID"},
+		{"schedule without time", nil, apiCall{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"scheduled"}`}, http.StatusUnprocessableEntity, "publish_at"},
+		{"schedule", nil, apiCall{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"scheduled","publish_at":"2999-01-01T00:00:00Z"}`}, http.StatusOK, `"status":"scheduled"`},
+		{"unknown status", nil, apiCall{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"gone"}`}, http.StatusUnprocessableEntity, "Status must be one of"},
+		{"status of unknown course", nil, apiCall{method: "GET", path: "/v2/courses/99/status"}, http.StatusNotFound, errCourseNotFound.Error()},
+
+		// Learners, enrollments and certificates
+		{"learners", withLearner, apiCall{method: "GET", path: "/learners"}, http.StatusOK, `"name":"Ada"`},
+		{"create learner", nil, apiCall{method: "POST", path: "/learners", body: `{"name":"Ada","email":"ada@example.com"}`}, http.StatusCreated, `"email":"ada@example.com"`},
+		{"duplicate learner", withLearner, apiCall{method: "POST", path: "/learners", body: `{"name":"Ada","email":"ADA@example.com"}`}, http.StatusConflict, ""},
+		{"learner", withLearner, apiCall{method: "GET", path: "/learners/1"}, http.StatusOK, `"name":"Ada"`},
+		{"unknown learner", nil, apiCall{method: "GET", path: "/learners/99"}, http.StatusNotFound, errLearnerNotFound.Error()},
+		{"learner enrollments", withEnrollment, apiCall{method: "GET", path: "/learners/1/enrollments"}, http.StatusOK, `"course_id":"1"`},
+		{"enrollments", withEnrollment, apiCall{method: "GET", path: "/courses/1/enrollments"}, http.StatusOK, `"learner_id":"1"`},
+		{"enroll", withLearner, apiCall{method: "POST", path: "/courses/1/enrollments", body: `{"learner_id":"1"}`}, http.StatusCreated, ""},
+		{"enroll twice", withEnrollment, apiCall{method: "POST", path: "/courses/1/enrollments", body: `{"learner_id":"1"}`}, http.StatusConflict, errAlreadyEnrolled.Error()},
+		{"enroll without learner", nil, apiCall{method: "POST", path: "/courses/1/enrollments", body: `{}`}, http.StatusBadRequest, ""},
+		{"enroll in draft", slices.Concat(withDraft, withLearner), apiCall{method: "POST", path: "/courses/2/enrollments", body: `{"learner_id":"1"}`}, http.StatusConflict, errCourseNotPublished.Error()},
+		{"enrollment", withEnrollment, apiCall{method: "GET", path: "/courses/1/enrollments/1"}, http.StatusOK, `"learner_id":"1"`},
+		{"unknown enrollment", nil, apiCall{method: "GET", path: "/courses/1/enrollments/99"}, http.StatusNotFound, errEnrollmentNotFound.Error()},
+		{"complete lesson", withEnrollment, apiCall{method: "POST", path: "/courses/1/enrollments/1/completions", body: `{"lesson_id":"1"}`}, http.StatusOK, ""},
+		{"complete unknown lesson", withEnrollment, apiCall{method: "POST", path: "/courses/1/enrollments/1/completions", body: `{"lesson_id":"99"}`}, http.StatusNotFound, ""},
+		{"certificate before completion", withEnrollment, apiCall{method: "GET", path: "/courses/1/enrollments/1/certificate"}, http.StatusConflict, errNotCompleted.Error()},
+		{"certificate", withReview, apiCall{method: "GET", path: "/courses/1/enrollments/1/certificate"}, http.StatusOK, `"signature"`},
+		{"verify forged certificate", nil, apiCall{method: "POST", path: "/certificates/verify", body: `{"id":"x","signature":"AAAA"}`}, http.StatusOK, `"valid":false`},
+
+		// Reviews
+		{"reviews", withReview, apiCall{method: "GET", path: "/courses/1/reviews"}, http.StatusOK, `"text":"Good"`},
+		{"review without enrolling", withLearner, apiCall{method: "POST", path: "/courses/1/reviews", body: `{"learner_id":"1","rating":5}`}, http.StatusForbidden, errNotEnrolled.Error()},
+		{"review twice", withReview, apiCall{method: "POST", path: "/courses/1/reviews", body: `{"learner_id":"1","rating":5}`}, http.StatusConflict, ""},
+		{"review", withReview, apiCall{method: "GET", path: "/courses/1/reviews/1"}, http.StatusOK, `"rating":4`},
+		{"unknown review", nil, apiCall{method: "GET", path: "/courses/1/reviews/99"}, http.StatusNotFound, errReviewNotFound.Error()},
+		{"update review as admin", withReview, apiCall{method: "PUT", path: "/courses/1/reviews/1", body: `{"rating":5,"text":"Great"}`, header: asAdmin}, http.StatusOK, `"text":"Great"`},
+		{"update someone else's review", withReview, apiCall{method: "PUT", path: "/courses/1/reviews/1", body: `{"rating":1}`, header: map[string]string{"X-Review-Token": "guess"}}, http.StatusForbidden, errNotReviewAuthor.Error()},
+		{"flag review", withReview, apiCall{method: "POST", path: "/courses/1/reviews/1/flags", body: `{"reason":"spam"}`}, http.StatusOK, ""},
+		{"moderate review", withReview, apiCall{method: "PUT", path: "/courses/1/reviews/1/moderation", body: `{"hidden":true}`, header: asAdmin}, http.StatusOK, `"hidden":true`},
+		{"moderate without admin key", withReview, apiCall{method: "PUT", path: "/courses/1/reviews/1/moderation", body: `{"hidden":false}`}, http.StatusForbidden, "admin key"},
+		{"moderate without decision", withReview, apiCall{method: "PUT", path: "/courses/1/reviews/1/moderation", body: `{}`, header: asAdmin}, http.StatusBadRequest, ""},
+		{"reviews of a draft", slices.Concat(withReview, []apiCall{{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"draft"}`}}), apiCall{method: "GET", path: "/courses/1/reviews"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"review of a draft", slices.Concat(withReview, []apiCall{{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"draft"}`}}), apiCall{method: "GET", path: "/courses/1/reviews/1"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"hidden reviews", withReview, apiCall{method: "GET", path: "/courses/1/reviews?include=hidden", header: asAdmin}, http.StatusOK, `"text":"Good"`},
+		{"hidden reviews without admin key", withReview, apiCall{method: "GET", path: "/courses/1/reviews?include=hidden"}, http.StatusForbidden, "admin key"},
+		{"hidden review", slices.Concat(withReview, []apiCall{{method: "PUT", path: "/courses/1/reviews/1/moderation", body: `{"hidden":true}`, header: asAdmin}}), apiCall{method: "GET", path: "/courses/1/reviews/1"}, http.StatusNotFound, errReviewNotFound.Error()},
+		{"delete review as admin", withReview, apiCall{method: "DELETE", path: "/courses/1/reviews/1", header: asAdmin}, http.StatusOK, ""},
+		{"delete someone else's review", withReview, apiCall{method: "DELETE", path: "/courses/1/reviews/1"}, http.StatusForbidden, errNotReviewAuthor.Error()},
+
+		// Coupons, carts and orders
+		{"coupons", withCoupon, apiCall{method: "GET", path: "/coupons", header: asAdmin}, http.StatusOK, `"code":"SAVE10"`},
+		{"create coupon", nil, apiCall{method: "POST", path: "/coupons", body: `{"code":"save10","percent":10}`, header: asAdmin}, http.StatusCreated, `"code":"SAVE10"`},
+		{"duplicate coupon", withCoupon, apiCall{method: "POST", path: "/coupons", body: `{"code":"SAVE10","percent":20}`, header: asAdmin}, http.StatusConflict, ""},
+		{"coupon", withCoupon, apiCall{method: "GET", path: "/coupons/save10"}, http.StatusOK, `"percent":10`},
+		{"unknown coupon", nil, apiCall{method: "GET", path: "/coupons/NOPE"}, http.StatusNotFound, errCouponNotFound.Error()},
+		{"delete coupon", withCoupon, apiCall{method: "DELETE", path: "/coupons/SAVE10", header: asAdmin}, http.StatusOK, ""},
+		{"coupons without admin key", withCoupon, apiCall{method: "GET", path: "/coupons"}, http.StatusForbidden, "admin key"},
+		{"create coupon without admin key", nil, apiCall{method: "POST", path: "/coupons", body: `{"code":"FREE","percent":100}`}, http.StatusForbidden, "admin key"},
+		{"delete coupon without admin key", withCoupon, apiCall{method: "DELETE", path: "/coupons/SAVE10"}, http.StatusForbidden, "admin key"},
+		{"create cart", withLearner, apiCall{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`, header: asAdmin}, http.StatusCreated, `"id":"1"`},
+		{"cart", withCart, apiCall{method: "GET", path: "/carts/1", header: asAdmin}, http.StatusOK, `"learner_id":"1"`},
+		{"unknown cart", nil, apiCall{method: "GET", path: "/carts/cart-99", header: asAdmin}, http.StatusNotFound, errCartNotFound.Error()},
+		{"apply coupon", slices.Concat(withCartItem, withCoupon), apiCall{method: "PUT", path: "/carts/1", body: `{"region":"US","coupon":"SAVE10"}`, header: asAdmin}, http.StatusOK, `"SAVE10"`},
+		{"add item", withCart, apiCall{method: "POST", path: "/carts/1/items", body: `{"course_id":"1"}`, header: asAdmin}, http.StatusOK, `"course_id":"1"`},
+		{"add unknown course", withCart, apiCall{method: "POST", path: "/carts/1/items", body: `{"course_id":"99"}`, header: asAdmin}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"remove item", withCartItem, apiCall{method: "DELETE", path: "/carts/1/items/1", header: asAdmin}, http.StatusOK, ""},
+		{"checkout", withCartItem, apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asAdmin}, http.StatusCreated, `"id":"1"`},
+		{"checkout declined", withCartItem, apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_declined"}`, header: asAdmin}, http.StatusPaymentRequired, ""},
+		{"order", slices.Concat(withCartItem, []apiCall{{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asAdmin}}), apiCall{method: "GET", path: "/orders/1", header: asAdmin}, http.StatusOK, `"learner_id":"1"`},
+		{"learner orders", withLearner, apiCall{method: "GET", path: "/learners/1/orders", header: asAdmin}, http.StatusOK, "[]"},
+		{"delete cart", withCart, apiCall{method: "DELETE", path: "/carts/1", header: asAdmin}, http.StatusOK, ""},
+
+		// Batches
+		{"batch", nil, apiCall{method: "POST", path: "/batch", body: `[{"method":"GET","path":"/v2/courses/1"},{"method":"POST","path":"/v2/courses","body":{"name":"Batched"}}]`}, http.StatusOK, `"name":"Batched"`},
+		{"empty batch", nil, apiCall{method: "POST", path: "/batch", body: `[]`}, http.StatusBadRequest, "Batch has no operations"},
+		{"malformed batch", nil, apiCall{method: "POST", path: "/batch", body: `{"operations":`}, http.StatusBadRequest, "invalid batch"},
+		{"nested batch", nil, apiCall{method: "POST", path: "/batch", body: `[{"method":"POST","path":"/batch"}]`}, http.StatusOK, "Batches can't be nested"},
+		{"checkout in a transaction", withCartItem, apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/carts/1/checkout","body":{"payment_token":"tok_ok"}}]}`}, http.StatusUnprocessableEntity, "Checkout can't be part of a transactional batch"},
+		{"escaped checkout in a transaction", withCartItem, apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/carts/1/%63heckout","body":{"payment_token":"tok_ok"}}]}`}, http.StatusUnprocessableEntity, "Checkout can't be part of a transactional batch"},
+		{"checkout in a batch", withCartItem, apiCall{method: "POST", path: "/batch", body: `[{"method":"POST","path":"/carts/1/checkout","body":{"payment_token":"tok_ok"}}]`, header: map[string]string{"Authorization": "Bearer " + testAdminKey}}, http.StatusOK, `"status":201`},
+		{"failed transaction", nil, apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"DELETE","path":"/v2/courses/1"},{"method":"POST","path":"/v2/courses","body":{"name":"Broken","duration":"soon"}}]}`}, http.StatusUnprocessableEntity, `"committed":false`},
+
+		// GraphQL and the admin UI
+		{"graphql query", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ course(id: \"1\") { name } }"}`}, http.StatusOK, `"name":"Go Basics"`},
+		{"graphql syntax error", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ course("}`}, http.StatusOK, "Syntax Error"},
+		{"graphql nested too deep", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{` + strings.Repeat(" courses { author {", 20) + " fullname" + strings.Repeat(" } }", 20) + ` }"}`}, http.StatusOK, "nested more than"},
+		{"graphql fragments nested too deep", nil, apiCall{method: "POST", path: "/graphql", body: func() string {
+			query := "{ courses { ...F0 } }"
+			for i := range 20 {
+				query += fmt.Sprintf(" fragment F%d on Course { author { courses { ...F%d } } }", i, i+1)
+			}
+			return `{"query":"` + query + ` fragment F20 on Course { name }"}`
+		}()}, http.StatusOK, "Query is nested more than"},
+		{"graphql nested within the cost", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses { author { courses { name } } } }"}`}, http.StatusOK, `"courses":[{"name":`},
+		{"graphql fanning out past the cost", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses { author { courses { author { courses { author { courses { name } } } } } } } }"}`}, http.StatusOK, "more than the limit of"},
+		{"graphql fragments fanning out past the cost", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses { ...A } } fragment A on Course { author { courses { ...B } } } fragment B on Course { author { courses { ...C } } } fragment C on Course { name }"}`}, http.StatusOK, "more than the limit of"},
+		{"graphql body too large", nil, apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses { name } }"` + strings.Repeat(" ", maxGraphQLBody) + `}`}, http.StatusRequestEntityTooLarge, "Body is larger than"},
+		{"graphql mutation over GET", nil, apiCall{method: "GET", path: "/graphql?query=mutation{deleteCourse(id:\"1\")}"}, http.StatusOK, "Mutations can only be sent with POST"},
+		{"graphiql", nil, apiCall{method: "GET", path: "/graphiql"}, http.StatusOK, "<html"},
+		{"admin courses", nil, apiCall{method: "GET", path: "/admin/courses"}, http.StatusOK, "Go Basics"},
+		{"admin post without token", nil, apiCall{method: "POST", path: "/admin/courses/1/delete"}, http.StatusForbidden, ""},
+
+		// JSON-RPC
+		{"rpc call", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.get","params":{"id":"1"},"id":1}`}, http.StatusOK, `"name":"Go Basics"`},
+		{"rpc positional params", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.list","params":["-price",1,1],"id":"list"}`}, http.StatusOK, `"total":2`},
+		{"rpc batch", nil, apiCall{method: "POST", path: "/rpc", body: `[{"jsonrpc":"2.0","method":"authors.get","params":["a1"],"id":1},{"jsonrpc":"2.0","method":"courses.delete","params":["2"]},{"jsonrpc":"2.0","method":"nope","id":2}]`}, http.StatusOK, `"code":-32601`},
+		{"rpc notification", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.delete","params":["1"]}`}, http.StatusNoContent, ""},
+		{"rpc parse error", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":`}, http.StatusOK, `"code":-32700`},
+		{"rpc invalid request", nil, apiCall{method: "POST", path: "/rpc", body: `[1]`}, http.StatusOK, `"code":-32600`},
+		{"rpc invalid params", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.get","id":1}`}, http.StatusOK, `"code":-32602`},
+		{"rpc not found", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.get","params":["99"],"id":1}`}, http.StatusOK, `"code":-32004`},
+		{"rpc validation error", nil, apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.create","params":{"course":{"name":"X","price":{"amount":-1,"currency":"USD"}}},"id":1}`}, http.StatusOK, `"data":[{"field":"price"`},
+
+		// Route introspection
+		{"debug routes", nil, apiCall{method: "GET", path: "/debug/routes", header: asAdmin}, http.StatusOK, `"path_template":"/v2/courses/{id}"`},
+		{"debug routes without admin key", nil, apiCall{method: "GET", path: "/debug/routes"}, http.StatusForbidden, "admin key"},
+		{"debug match", nil, apiCall{method: "GET", path: "/debug/match?method=PUT&path=/v2/courses/1", header: asAdmin}, http.StatusOK, `"vars":{"id":"1"}`},
+		{"debug match without admin key", nil, apiCall{method: "GET", path: "/debug/match?path=/v2/courses"}, http.StatusForbidden, "admin key"},
+		{"debug match wrong method", nil, apiCall{method: "GET", path: "/debug/match?method=PATCH&path=/learners/1", header: asAdmin}, http.StatusOK, "Method PATCH isn't one of GET"},
+		{"debug match no route", nil, apiCall{method: "GET", path: "/debug/match?path=/nowhere", header: asAdmin}, http.StatusOK, "No route matches"},
+		{"debug match relative path", nil, apiCall{method: "GET", path: "/debug/match?path=nowhere", header: asAdmin}, http.StatusBadRequest, "absolute path"},
+		// Replication
+		{"replication snapshot", nil, apiCall{method: "GET", path: "/replication/snapshot", header: asAdmin}, http.StatusOK, `"offset":0`},
+		{"replication snapshot without admin key", nil, apiCall{method: "GET", path: "/replication/snapshot"}, http.StatusForbidden, "admin key"},
+		{"replication snapshot after other changes", withLearner, apiCall{method: "GET", path: "/replication/snapshot", header: asAdmin}, http.StatusOK, `"offset":0`},
+		{"replication log", []apiCall{{method: "DELETE", path: "/v2/courses/2"}}, apiCall{method: "GET", path: "/replication/wal?after=0&follow=false", header: asAdmin}, http.StatusOK, `"op":"delete_course","id":"2"`},
+		{"replication log without admin key", nil, apiCall{method: "GET", path: "/replication/wal?after=0&follow=false"}, http.StatusForbidden, "admin key"},
+		{"replication log of a new author", []apiCall{{method: "POST", path: "/v2/courses", body: `{"name":"New","author":{"fullname":"Ada","email":"ada@example.com"}}`}}, apiCall{method: "GET", path: "/replication/wal?after=0&follow=false", header: asAdmin}, http.StatusOK, `{"offset":1,`},
+		{"replication log of a transaction", []apiCall{{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/v2/courses","body":{"name":"Batched"}}]}`}}, apiCall{method: "GET", path: "/replication/wal?after=0&follow=false", header: asAdmin}, http.StatusOK, `"name":"Batched"`},
+		{"replication log of another primary", nil, apiCall{method: "GET", path: "/replication/wal?log=elsewhere", header: asAdmin}, http.StatusGone, errWALGone.Error()},
+		{"replication log ahead", nil, apiCall{method: "GET", path: "/replication/wal?after=5", header: asAdmin}, http.StatusGone, errWALGone.Error()},
+		{"replication status", nil, apiCall{method: "GET", path: "/replication/status"}, http.StatusOK, `"role":"primary"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			for _, call := range tt.setup {
+				mustServe(t, router, call)
+			}
+			rec := serve(t, router, tt.call)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// multipartBody encodes files as a multipart/form-data body
+func multipartBody(t *testing.T, field string, files map[string]string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile(field, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestDraftListings(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     apiCall
+		wantBody string // Substring of the response body
+	}{
+		{"v2 courses", apiCall{method: "GET", path: "/v2/courses"}, `"name":"Go Basics"`},
+		{"v1 courses", apiCall{method: "GET", path: "/v1/courses"}, `"name":"Go Basics"`},
+		{"CSV", apiCall{method: "GET", path: "/v2/courses", header: map[string]string{"Accept": "text/csv"}}, "Go Basics"},
+		{"author courses", apiCall{method: "GET", path: "/v2/authors/a2/courses"}, "[]"},
+		{"GraphQL", apiCall{method: "POST", path: "/graphql", body: `{"query":"{ courses { name } }"}`}, `"name":"Go Basics"`},
+		{"RPC", apiCall{method: "POST", path: "/rpc", body: `{"jsonrpc":"2.0","method":"courses.list","id":1}`}, `"name":"Go Basics"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			for _, call := range withDraft {
+				mustServe(t, router, call)
+			}
+			body := mustServe(t, router, tt.call).Body.String()
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %q", body, tt.wantBody)
+			}
+			if strings.Contains(body, "Advanced Go") {
+				t.Errorf("body = %s, want the draft left out", body)
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     []apiCall
+		call      apiCall
+		wantCache string // X-Cache of the second request; "" means not cached
+	}{
+		{"courses", nil, apiCall{method: "GET", path: "/v2/courses"}, "HIT"},
+		{"course by Accept", nil, apiCall{method: "GET", path: "/courses/1"}, "HIT"},
+		{"author courses", nil, apiCall{method: "GET", path: "/v1/authors/a1/courses"}, "HIT"},
+		{"lessons", withModule, apiCall{method: "GET", path: "/v2/courses/1/modules/1/lessons"}, "HIT"},
+		{"learner", withLearner, apiCall{method: "GET", path: "/learners/1"}, ""},
+		{"enrollments", withEnrollment, apiCall{method: "GET", path: "/courses/1/enrollments"}, ""},
+		{"cart", withCart, apiCall{method: "GET", path: "/carts/1", header: asAdmin}, ""},
+		{"hidden reviews", withReview, apiCall{method: "GET", path: "/courses/1/reviews?include=hidden", header: asAdmin}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			for _, call := range tt.setup {
+				mustServe(t, router, call)
+			}
+			mustServe(t, router, tt.call)
+			rec := mustServe(t, router, tt.call)
+			if got := rec.Header().Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("X-Cache = %q, want %q", got, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestReviewAuthor(t *testing.T) {
+	router := newTestRouter(t)
+	for _, call := range withEnrollment {
+		mustServe(t, router, call)
+	}
+	var review Review
+	decodeJSON(t, mustServe(t, router, apiCall{method: "POST", path: "/courses/1/reviews", body: `{"learner_id":"1","rating":4}`}), &review)
+	if review.EditToken == "" {
+		t.Fatal("the new review has no edit_token")
+	}
+	if rec := mustServe(t, router, apiCall{method: "GET", path: "/courses/1/reviews/1"}); strings.Contains(rec.Body.String(), "edit_token") {
+		t.Errorf("review = %s, want no edit_token once created", rec.Body)
+	}
+
+	author := map[string]string{"X-Review-Token": review.EditToken}
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+	}{
+		{"edit with another token", apiCall{method: "PUT", path: "/courses/1/reviews/1", body: `{"rating":1}`, header: map[string]string{"X-Review-Token": review.EditToken + "x"}}, http.StatusForbidden},
+		{"edit", apiCall{method: "PUT", path: "/courses/1/reviews/1", body: `{"rating":5}`, header: author}, http.StatusOK},
+		{"delete without token", apiCall{method: "DELETE", path: "/courses/1/reviews/1"}, http.StatusForbidden},
+		{"delete", apiCall{method: "DELETE", path: "/courses/1/reviews/1", header: author}, http.StatusOK},
+	}
+
+	// The cases run in order, on one tenant
+	for _, tt := range tests {
+		if rec := serve(t, router, tt.call); rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+	}
+}
+
+func TestCartOwner(t *testing.T) {
+	router := newTestRouter(t)
+	var ada, bob Learner
+	decodeJSON(t, mustServe(t, router, withLearner[0]), &ada)
+	decodeJSON(t, mustServe(t, router, apiCall{method: "POST", path: "/learners", body: `{"name":"Bob","email":"bob@example.com"}`}), &bob)
+	if ada.AccessToken == "" {
+		t.Fatal("the new learner has no access_token")
+	}
+	if rec := mustServe(t, router, apiCall{method: "GET", path: "/learners/1"}); strings.Contains(rec.Body.String(), "access_token") {
+		t.Errorf("learner = %s, want no access_token once created", rec.Body)
+	}
+
+	asAda := map[string]string{"X-Learner-Token": ada.AccessToken}
+	asBob := map[string]string{"X-Learner-Token": bob.AccessToken}
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+	}{
+		{"create without token", apiCall{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`}, http.StatusForbidden},
+		{"create for another learner", apiCall{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`, header: asBob}, http.StatusForbidden},
+		{"create", apiCall{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`, header: asAda}, http.StatusCreated},
+		{"add item", apiCall{method: "POST", path: "/carts/1/items", body: `{"course_id":"1"}`, header: asAda}, http.StatusOK},
+		{"read another learner's cart", apiCall{method: "GET", path: "/carts/1", header: asBob}, http.StatusForbidden},
+		{"change another learner's cart", apiCall{method: "PUT", path: "/carts/1", body: `{"region":"DE"}`, header: asBob}, http.StatusForbidden},
+		{"check out another learner's cart", apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asBob}, http.StatusForbidden},
+		{"check out with another token", apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: map[string]string{"X-Learner-Token": ada.AccessToken + "x"}}, http.StatusForbidden},
+		{"check out", apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asAda}, http.StatusCreated},
+		{"read another learner's order", apiCall{method: "GET", path: "/orders/1", header: asBob}, http.StatusForbidden},
+		{"list another learner's orders", apiCall{method: "GET", path: "/learners/1/orders", header: asBob}, http.StatusForbidden},
+		{"order", apiCall{method: "GET", path: "/orders/1", header: asAda}, http.StatusOK},
+		{"orders", apiCall{method: "GET", path: "/learners/1/orders", header: asAda}, http.StatusOK},
+	}
+
+	// The cases run in order, on one tenant
+	for _, tt := range tests {
+		if rec := serve(t, router, tt.call); rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	router := newTestRouter(t)
+	create := func(key, body string) apiCall {
+		return apiCall{method: "POST", path: "/v2/courses", body: body, header: map[string]string{"Idempotency-Key": key}}
+	}
+	mustServe(t, router, create("first", `{"name":"Once"}`))
+	if rec := serve(t, router, create("first", `{"name":"Once"}`)); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: headers = %v, want a replayed response", rec.Header())
+	}
+	if rec := serve(t, router, create("first", `{"name":"Twice"}`)); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("retry with another body: status = %d, want 422", rec.Code)
+	}
+
+	// Keys are scoped to the API key, whichever header carries it
+	byKey := func(header map[string]string, body string) apiCall {
+		call := create("shared", body)
+		maps.Copy(call.header, header)
+		return call
+	}
+	mustServe(t, router, byKey(map[string]string{"X-API-Key": "alice"}, `{"name":"Alice's"}`))
+	for _, header := range []map[string]string{{"X-API-Key": "bob"}, {"X-Client-ID": "alice"}, {"Authorization": "Bearer carol"}} {
+		if rec := serve(t, router, byKey(header, `{"name":"Someone else's"}`)); rec.Code != http.StatusOK {
+			t.Errorf("same key from %v: status = %d, want 200 (body %s)", header, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(t, router, byKey(map[string]string{"Authorization": "Bearer alice"}, `{"name":"Alice's"}`)); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry with the API key as a bearer token: headers = %v, want a replayed response", rec.Header())
+	}
+
+	if rec := serve(t, router, create("large", `{"name":"`+strings.Repeat("x", maxIdempotencyBody)+`"}`)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: status = %d, want 413", rec.Code)
+	}
+
+	// A response too large to keep isn't replayed empty
+	large := idempotencyKeys.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write(bytes.Repeat([]byte("x"), maxCachedBody+1))
+	}))
+	call := apiCall{method: "POST", path: "/v2/courses", body: `{}`, header: map[string]string{"Idempotency-Key": "big"}}
+	for i, want := range []int{http.StatusCreated, http.StatusConflict} {
+		rec := httptest.NewRecorder()
+		large.ServeHTTP(rec, handlerRequest(call, nil))
+		if rec.Code != want {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestMaterialRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	pdf := "%PDF-1.4\nslides\n"
+
+	upload := func(field string, files map[string]string) apiCall {
+		body, contentType := multipartBody(t, field, files)
+		return apiCall{method: "POST", path: "/v2/courses/1/materials", body: body, header: map[string]string{"Content-Type": contentType}}
+	}
+
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+		wantBody   string
+	}{
+		{"upload", upload("file", map[string]string{"slides.pdf": pdf}), http.StatusCreated, `"content_type":"application/pdf"`},
+		{"upload HTML", upload("file", map[string]string{"page.html": "<html><body>hi</body></html>"}), http.StatusUnsupportedMediaType, errMaterialType.Error()},
+		{"upload without file field", upload("attachment", map[string]string{"slides.pdf": pdf}), http.StatusBadRequest, "file"},
+		{"upload JSON", apiCall{method: "POST", path: "/v2/courses/1/materials", body: `{}`}, http.StatusUnsupportedMediaType, "multipart/form-data"},
+		{"upload to unknown course", func() apiCall {
+			c := upload("file", map[string]string{"a.pdf": pdf})
+			c.path = "/v2/courses/99/materials"
+			return c
+		}(), http.StatusNotFound, errCourseNotFound.Error()},
+		{"list", apiCall{method: "GET", path: "/v2/courses/1/materials"}, http.StatusOK, `"filename":"slides.pdf"`},
+		{"metadata", apiCall{method: "GET", path: "/v2/courses/1/materials/1"}, http.StatusOK, `"download":{"href":"/v2/courses/1/materials/1/content"}`},
+		{"unknown material", apiCall{method: "GET", path: "/v2/courses/1/materials/99"}, http.StatusNotFound, errMaterialNotFound.Error()},
+		{"download", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content"}, http.StatusOK, pdf},
+		{"download range", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content", header: map[string]string{"Range": "bytes=0-3"}}, http.StatusPartialContent, "%PDF"},
+		{"download bad range", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content", header: map[string]string{"Range": "bytes=100-200"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{"unpublish", apiCall{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"draft"}`}, http.StatusOK, `"status":"draft"`},
+		{"list of a draft", apiCall{method: "GET", path: "/v2/courses/1/materials"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"metadata of a draft", apiCall{method: "GET", path: "/v2/courses/1/materials/1"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"download from a draft", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content"}, http.StatusNotFound, errCourseNotFound.Error()},
+		{"publish again", apiCall{method: "PUT", path: "/v2/courses/1/status", body: `{"status":"published"}`}, http.StatusOK, `"status":"published"`},
+		{"delete", apiCall{method: "DELETE", path: "/v2/courses/1/materials/1"}, http.StatusOK, ""},
+		{"download deleted", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content"}, http.StatusNotFound, errMaterialNotFound.Error()},
+	}
+
+	// The cases run in order, on one tenant
+	for _, tt := range tests {
+		rec := serve(t, router, tt.call)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body = %s, want it to contain %q", tt.name, rec.Body, tt.wantBody)
+		}
+	}
+}
+
+func TestBackupRoutes(t *testing.T) {
+	router := newTestRouter(t)
+	pdf := "%PDF-1.4\nslides\n"
+	body, contentType := multipartBody(t, "file", map[string]string{"slides.pdf": pdf})
+	mustServe(t, router, apiCall{method: "POST", path: "/v2/courses/1/materials", body: body, header: map[string]string{"Content-Type": contentType}})
+
+	var backup backupInfo
+	decodeJSON(t, mustServe(t, router, apiCall{method: "POST", path: "/admin/backups", header: asAdmin}), &backup)
+	if backup.Counts["courses"] != 2 || backup.Counts["materials"] != 1 || backup.SHA256 == "" {
+		t.Fatalf("backup = %+v, want 2 courses, 1 material and a checksum", backup)
+	}
+	archive := mustServe(t, router, apiCall{method: "GET", path: "/admin/backups/" + backup.Name, header: asAdmin})
+	if got := archive.Header().Get("X-Backup-SHA256"); got != backup.SHA256 {
+		t.Fatalf("X-Backup-SHA256 = %q, want %q", got, backup.SHA256)
+	}
+	damaged := []byte(archive.Body.String())
+	damaged[len(damaged)/2] ^= 0xff
+
+	// Lose the course and its material, then bring them back
+	mustServe(t, router, apiCall{method: "DELETE", path: "/v2/courses/1"})
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+		wantBody   string
+	}{
+		{"list", apiCall{method: "GET", path: "/admin/backups", header: asAdmin}, http.StatusOK, `"name":"` + backup.Name + `"`},
+		{"verify", apiCall{method: "GET", path: "/admin/backups/" + backup.Name + "/verify", header: asAdmin}, http.StatusOK, `"verified":true`},
+		{"restore", apiCall{method: "POST", path: "/admin/backups/" + backup.Name + "/restore", header: asAdmin}, http.StatusOK, `"restored":true`},
+		{"restored course", apiCall{method: "GET", path: "/v2/courses/1"}, http.StatusOK, `"name":"Go Basics"`},
+		{"restored material", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content"}, http.StatusOK, pdf},
+		{"upload copy", apiCall{method: "PUT", path: "/admin/backups/offsite", body: archive.Body.String(), header: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/gzip", "X-Backup-SHA256": backup.SHA256}}, http.StatusCreated, `"name":"offsite"`},
+		{"upload taken name", apiCall{method: "PUT", path: "/admin/backups/offsite", body: archive.Body.String(), header: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/gzip"}}, http.StatusConflict, errBackupExists.Error()},
+		{"upload damaged", apiCall{method: "PUT", path: "/admin/backups/damaged", body: string(damaged), header: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/gzip"}}, http.StatusUnprocessableEntity, errBackupCorrupt.Error()},
+		{"upload wrong checksum", apiCall{method: "PUT", path: "/admin/backups/other", body: archive.Body.String(), header: map[string]string{"X-API-Key": testAdminKey, "Content-Type": "application/gzip", "X-Backup-SHA256": strings.Repeat("0", 64)}}, http.StatusUnprocessableEntity, "doesn't match its checksum"},
+		{"restore unknown", apiCall{method: "POST", path: "/admin/backups/nope/restore", header: asAdmin}, http.StatusNotFound, errBackupNotFound.Error()},
+		{"restore in a batch", apiCall{method: "POST", path: "/batch", body: `[{"method":"POST","path":"/admin/backups/` + backup.Name + `/restore"}]`}, http.StatusOK, "A backup restore can't be part of a batch"},
+		{"restore in a transaction", apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/admin/backups/` + backup.Name + `/restore"}]}`}, http.StatusUnprocessableEntity, "A backup restore can't be part of a batch"},
+		{"escaped restore in a transaction", apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/admin/backups/` + backup.Name + `/%72estore"}]}`}, http.StatusUnprocessableEntity, "A backup restore can't be part of a batch"},
+		{"bad name", apiCall{method: "GET", path: "/admin/backups/.hidden", header: asAdmin}, http.StatusBadRequest, errBackupName.Error()},
+		{"cross-origin restore", apiCall{method: "POST", path: "/admin/backups/offsite/restore", header: map[string]string{"X-API-Key": testAdminKey, "Origin": "https://evil.example"}}, http.StatusForbidden, ""},
+		{"list without admin key", apiCall{method: "GET", path: "/admin/backups"}, http.StatusForbidden, "admin key"},
+		{"create with another key", apiCall{method: "POST", path: "/admin/backups", header: map[string]string{"X-API-Key": "someone"}}, http.StatusForbidden, "admin key"},
+		{"download with another key", apiCall{method: "GET", path: "/admin/backups/offsite", header: map[string]string{"X-API-Key": "someone"}}, http.StatusForbidden, "admin key"},
+		{"upload with another key", apiCall{method: "PUT", path: "/admin/backups/stolen", body: archive.Body.String(), header: map[string]string{"X-API-Key": "someone", "Content-Type": "application/gzip"}}, http.StatusForbidden, "admin key"},
+		{"verify with another key", apiCall{method: "GET", path: "/admin/backups/offsite/verify", header: map[string]string{"X-API-Key": "someone"}}, http.StatusForbidden, "admin key"},
+		{"restore with another key", apiCall{method: "POST", path: "/admin/backups/offsite/restore", header: map[string]string{"X-API-Key": "someone"}}, http.StatusForbidden, "admin key"},
+		{"delete with another key", apiCall{method: "DELETE", path: "/admin/backups/offsite", header: map[string]string{"X-API-Key": "someone"}}, http.StatusForbidden, "admin key"},
+		{"delete", apiCall{method: "DELETE", path: "/admin/backups/offsite", header: asAdmin}, http.StatusOK, ""},
+		{"delete again", apiCall{method: "DELETE", path: "/admin/backups/offsite", header: asAdmin}, http.StatusNotFound, errBackupNotFound.Error()},
+	}
+
+	// The cases run in order, on one tenant
+	for _, tt := range tests {
+		rec := serve(t, router, tt.call)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body = %s, want it to contain %q", tt.name, rec.Body, tt.wantBody)
+		}
+	}
+}
+
+func TestReplicationRollback(t *testing.T) {
+	router := newTestRouter(t)
+	failed := apiCall{method: "POST", path: "/batch", body: `{"transactional":true,"operations":[{"method":"POST","path":"/v2/courses","body":{"name":"Batched"}},{"method":"POST","path":"/v2/courses","body":{"name":"Broken","duration":"soon"}}]}`}
+	if rec := serve(t, router, failed); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422 (body %s)", rec.Code, rec.Body)
+	}
+
+	// Followers never hear of the course the batch created and took back
+	var snapshot walSnapshot
+	decodeJSON(t, mustServe(t, router, apiCall{method: "GET", path: "/replication/snapshot", header: asAdmin}), &snapshot)
+	if snapshot.Offset != 0 {
+		t.Errorf("log offset = %d after a rolled back batch, want 0", snapshot.Offset)
+	}
+	mustServe(t, router, apiCall{method: "DELETE", path: "/v2/courses/2"})
+	rec := mustServe(t, router, apiCall{method: "GET", path: "/replication/wal?after=0&follow=false", header: asAdmin})
+	if body := rec.Body.String(); strings.Contains(body, "Batched") || !strings.Contains(body, `{"offset":1,`) {
+		t.Errorf("log = %s, want only the later delete, at offset 1", body)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primary := httptest.NewServer(newTestRouter(t))
+	defer primary.Close()
+	dir := t.TempDir()
+
+	// startReplica runs a follower of primary with its own tenants
+	startReplica := func() (follower *httptest.Server, stop func()) {
+		registry := mustDefaultTenants()
+		rep := newReplica(primary.URL, testAdminKey, dir, primary.Client(), registry)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			rep.run(ctx)
+			close(done)
+		}()
+		follower = httptest.NewServer(newRouterFor(registry, rep))
+		return follower, func() {
+			cancel()
+			<-done
+			follower.Close()
+		}
+	}
+	call := func(server *httptest.Server, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	eventually := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	replicaStatus := func(follower *httptest.Server) replicaStatus {
+		t.Helper()
+		_, body := call(follower, "GET", "/replication/status", "")
+		var status struct{ Replica replicaStatus }
+		if err := json.Unmarshal([]byte(body), &status); err != nil {
+			t.Fatalf("decoding %q: %v", body, err)
+		}
+		return status.Replica
+	}
+
+	// A new follower copies the catalog, then follows changes
+	follower, stop := startReplica()
+	call(primary, "POST", "/v2/courses", `{"name":"Replicated"}`)
+	eventually("the new course", func() bool {
+		_, body := call(follower, "GET", "/v2/courses/3", "")
+		return strings.Contains(body, `"name":"Replicated"`)
+	})
+
+	// Writes are refused by operation, so reads sent with POST still work
+	for _, tt := range []struct {
+		name       string
+		path, body string
+		wantStatus int
+		wantBody   string
+	}{
+		{"REST write", "/v2/courses", `{"name":"Local"}`, http.StatusForbidden, errReadOnlyReplica.Error()},
+		{"GraphQL query", "/graphql", `{"query":"{ course(id: \"3\") { name } }"}`, http.StatusOK, `"name":"Replicated"`},
+		{"GraphQL mutation", "/graphql", `{"query":"mutation { deleteCourse(id: \"1\") }"}`, http.StatusOK, errReadOnlyReplica.Error()},
+		{"RPC read", "/rpc", `{"jsonrpc":"2.0","method":"courses.get","params":["3"],"id":1}`, http.StatusOK, `"name":"Replicated"`},
+		{"RPC write", "/rpc", `{"jsonrpc":"2.0","method":"courses.delete","params":["1"],"id":1}`, http.StatusOK, `"code":-32003`},
+		{"batch", "/batch", `[{"method":"GET","path":"/v2/courses/3"},{"method":"DELETE","path":"/v2/courses/1"}]`, http.StatusOK, `"status":403`},
+	} {
+		if status, body := call(follower, "POST", tt.path, tt.body); status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+			t.Errorf("%s on the replica: status = %d, body %s; want %d and %q", tt.name, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+	if _, body := call(follower, "GET", "/v2/courses/1", ""); !strings.Contains(body, `"name":"Go Basics"`) {
+		t.Errorf("course 1 on the replica = %s, want it kept", body)
+	}
+	eventually("the replica to catch up", func() bool {
+		st := replicaStatus(follower)
+		return st.Connected && st.LagEntries == 0 && st.Applied > 0
+	})
+	if st := replicaStatus(follower); st.Snapshots != 1 || st.LagSeconds != 0 {
+		t.Errorf("status = %+v, want 1 snapshot and no lag", st)
+	}
+	if _, body := call(primary, "GET", "/replication/status", ""); !strings.Contains(body, `"followers":[{"remote"`) {
+		t.Errorf("primary status = %s, want a connected follower", body)
+	}
+
+	// A restarted follower resumes from its checkpoint instead of copying again
+	stop()
+	call(primary, "PUT", "/v2/courses/3", `{"name":"Renamed"}`)
+	call(primary, "DELETE", "/v2/courses/2", "")
+	follower, stop = startReplica()
+	defer stop()
+	eventually("the changes made while the replica was down", func() bool {
+		_, renamed := call(follower, "GET", "/v2/courses/3", "")
+		_, deleted := call(follower, "GET", "/v2/courses/2", "")
+		return strings.Contains(renamed, `"name":"Renamed"`) && strings.Contains(deleted, errCourseNotFound.Error())
+	})
+	if st := replicaStatus(follower); st.Snapshots != 0 {
+		t.Errorf("status = %+v, want it resumed without a snapshot", st)
+	}
+}
+
+func TestDebugMatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantTemplate string // Of the matched route; "" when none matches
+		wantVars     map[string]string
+		wantError    string   // Substring of the error
+		wantResults  []string // Result of each candidate, in order
+	}{
+		{"match among methods", "method=PUT&path=/v2/courses/1", "/v2/courses/{id}", map[string]string{"id": "1"}, "", []string{"rejected", "matched", "rejected"}},
+		{"default method", "path=/courses/1", "/courses/{id}", map[string]string{"id": "1"}, "", []string{"matched", "rejected", "rejected"}},
+		{"wrong method", "method=PATCH&path=/learners", "", nil, "405 Method Not Allowed", []string{"rejected", "rejected"}},
+		{"no route", "path=/nowhere", "", nil, "404 Not Found", []string{}},
+	}
+
+	router := newTestRouter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, router, apiCall{method: "GET", path: "/debug/match?" + tt.query, header: asAdmin})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+			}
+			var report matchReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.wantTemplate == "" && report.Matched != nil:
+				t.Errorf("matched %s, want no route", report.Matched.PathTemplate)
+			case tt.wantTemplate != "" && (report.Matched == nil || report.Matched.PathTemplate != tt.wantTemplate):
+				t.Errorf("matched %+v, want %s", report.Matched, tt.wantTemplate)
+			}
+			if !maps.Equal(report.Vars, tt.wantVars) {
+				t.Errorf("vars = %v, want %v", report.Vars, tt.wantVars)
+			}
+			if !strings.Contains(report.Error, tt.wantError) || (tt.wantError == "") != (report.Error == "") {
+				t.Errorf("error = %q, want %q", report.Error, tt.wantError)
+			}
+			results := []string{}
+			for _, c := range report.Candidates {
+				results = append(results, c.Result)
+				if c.Result == "rejected" && len(c.Reasons) == 0 {
+					t.Errorf("candidate %s %v was rejected without a reason", c.PathTemplate, c.Methods)
+				}
+			}
+			if !slices.Equal(results, tt.wantResults) {
+				t.Errorf("candidate results = %v, want %v", results, tt.wantResults)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	policy := &corsPolicy{
+		Origins:        []string{"https://app.example.com", "https://*.example.org"},
+		Headers:        defaultCORSHeaders,
+		ExposedHeaders: []string{"Link", "X-Cache"},
+		Credentials:    true,
+		MaxAge:         time.Minute,
+	}
+	handler := policy.handler(newTestRouter(t))
+	preflight := func(origin, path, method string) apiCall {
+		return apiCall{method: "OPTIONS", path: path, header: map[string]string{
+			"Origin":                         origin,
+			"Access-Control-Request-Method":  method,
+			"Access-Control-Request-Headers": "content-type, x-api-key",
+		}}
+	}
+
+	tests := []struct {
+		name        string
+		call        apiCall
+		wantStatus  int
+		wantHeaders map[string]string // "" means the header must be absent
+	}{
+		{"preflight", preflight("https://app.example.com", "/v2/courses/1", "PUT"), http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     "GET, PUT, DELETE",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "60",
+		}},
+		{"preflight of a collection", preflight("https://app.example.com", "/courses", "POST"), http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Methods": "GET, POST",
+		}},
+		{"preflight from a subdomain", preflight("https://physics.example.org", "/graphql", "POST"), http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "https://physics.example.org",
+			"Access-Control-Allow-Methods": "GET, POST",
+		}},
+		{"preflight from another origin", preflight("https://evil.example.com", "/v2/courses/1", "DELETE"), http.StatusNotFound, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"preflight of an unknown path", preflight("https://app.example.com", "/nowhere", "GET"), http.StatusNotFound, map[string]string{
+			"Access-Control-Allow-Methods": "",
+		}},
+		{"request", apiCall{method: "GET", path: "/v2/courses", header: map[string]string{"Origin": "https://app.example.com"}}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.example.com",
+			"Access-Control-Expose-Headers": "Link, X-Cache",
+		}},
+		{"request without an origin", apiCall{method: "GET", path: "/v2/courses"}, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, handler, tt.call)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Origin") {
+				t.Errorf("Vary = %q, want it to include Origin", rec.Header().Values("Vary"))
+			}
+		})
+	}
+}
+
+func TestCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     string
+		credentials string
+		wantErr     string // "" for a valid policy
+	}{
+		{"off", "", "", ""},
+		{"listed origins with credentials", "https://app.example.com, https://*.example.org", "true", ""},
+		{"any origin", "*", "", ""},
+		{"any origin without credentials", "*", "false", ""},
+		{"any origin with credentials", "*", "true", errCORSAnyOriginWithCredentials.Error()},
+		{"any origin among others with credentials", "https://app.example.com,*", "true", errCORSAnyOriginWithCredentials.Error()},
+		{"bad credentials", "https://app.example.com", "sometimes", "CORS_CREDENTIALS must be true or false"},
+		{"bad pattern", "https://[.example.com", "", "invalid CORS origin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ORIGINS", tt.origins)
+			t.Setenv("CORS_CREDENTIALS", tt.credentials)
+			_, err := loadCORSPolicy()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("loadCORSPolicy() = %v, want no error", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("loadCORSPolicy() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	router := newTestRouter(t)
+	pdf := "%PDF-1.4\n" + strings.Repeat("slides\n", 200)
+	body, contentType := multipartBody(t, "file", map[string]string{"slides.pdf": pdf})
+	mustServe(t, router, apiCall{method: "POST", path: "/v2/courses/1/materials", body: body, header: map[string]string{"Content-Type": contentType}})
+
+	get := func(path, acceptEncoding string) apiCall {
+		return apiCall{method: "GET", path: path, header: map[string]string{"Accept-Encoding": acceptEncoding}}
+	}
+	tests := []struct {
+		name         string
+		call         apiCall
+		wantEncoding string // Content-Encoding; "" means sent as is
+	}{
+		{"gzip", get("/v2/courses", "gzip"), "gzip"},
+		{"deflate", get("/v2/courses", "deflate"), "deflate"},
+		{"preferred by quality", get("/v2/courses", "gzip;q=0.5, deflate"), "deflate"},
+		{"wildcard", get("/v2/courses", "*"), "gzip"},
+		{"refused", get("/v2/courses", "gzip;q=0, deflate;q=0"), ""},
+		{"unsupported", get("/v2/courses", "br"), ""},
+		{"not asked for", apiCall{method: "GET", path: "/v2/courses"}, ""},
+		{"small body", get("/v2/courses/1?fields=id", "gzip"), ""},
+		{"not compressible", get("/v2/courses/1/materials/1/content", "gzip"), ""},
+		{"range", apiCall{method: "GET", path: "/v2/courses/1/materials/1/content", header: map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-599"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := mustServe(t, router, tt.call)
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding != "" && !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary = %q, want it to name Accept-Encoding", rec.Header().Get("Vary"))
+			}
+
+			var decoded io.Reader = rec.Body
+			var err error
+			switch tt.wantEncoding {
+			case "gzip":
+				decoded, err = gzip.NewReader(rec.Body)
+			case "deflate":
+				decoded, err = zlib.NewReader(rec.Body)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The same request without Accept-Encoding gets the same content
+			plain := tt.call
+			plain.header = maps.Clone(tt.call.header)
+			delete(plain.header, "Accept-Encoding")
+			if want := mustServe(t, router, plain).Body.String(); string(got) != want {
+				t.Errorf("decoded body = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCodecRoundTrips(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+	}{
+		{"JSON", "application/json"},
+		{"YAML", "application/yaml"},
+		{"MessagePack", "application/msgpack"},
+	}
+
+	asJSON := map[string]string{"Accept": "application/json"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			want := mustServe(t, router, apiCall{method: "GET", path: "/v2/courses/2", header: asJSON}).Body.String()
+			encoded := mustServe(t, router, apiCall{method: "GET", path: "/v2/courses/2", header: map[string]string{"Accept": tt.mediaType}})
+			if got := encoded.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.mediaType) {
+				t.Fatalf("Content-Type = %q, want %s", got, tt.mediaType)
+			}
+
+			// Writing the course back as it was read leaves it unchanged
+			header := map[string]string{"Accept": "application/json", "Content-Type": tt.mediaType}
+			put := mustServe(t, router, apiCall{method: "PUT", path: "/v2/courses/2", body: encoded.Body.String(), header: header})
+			if got := put.Body.String(); got != want {
+				t.Errorf("PUT = %s, want %s", got, want)
+			}
+
+			// Creating a course from it copies every field but the server's own
+			post := mustServe(t, router, apiCall{method: "POST", path: "/v2/courses", body: encoded.Body.String(), header: header})
+			var original, created courseV2
+			decodeJSON(t, put, &original)
+			decodeJSON(t, post, &created)
+			for _, c := range []*courseV2{&original, &created} {
+				c.ID, c.Links, c.PublishedAt = "", nil, nil
+			}
+			gotJSON, _ := json.Marshal(created)
+			wantJSON, _ := json.Marshal(original)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("created = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestCheckoutTotals(t *testing.T) {
+	usd := func(cents int64) Money { return Money{Amount: cents, Currency: "USD"} }
+	tests := []struct {
+		name    string
+		courses []string // Course 1 costs $29.99 and course 2 $49.99
+		region  string
+		coupon  string // Coupon to create and apply, if any
+		want    Quote
+	}{
+		{"untaxed", []string{"1"}, "US", "",
+			Quote{Subtotal: usd(2999), Discount: usd(0), Tax: usd(0), Total: usd(2999)}},
+		{"state tax", []string{"1"}, "US-CA", "",
+			Quote{Subtotal: usd(2999), Discount: usd(0), Tax: usd(217), TaxRate: 725, Total: usd(3216)}},
+		{"VAT on two courses", []string{"1", "2"}, "DE", "",
+			Quote{Subtotal: usd(7998), Discount: usd(0), Tax: usd(1520), TaxRate: 1900, Total: usd(9518)}},
+		{"percentage taken off before tax", []string{"1", "2"}, "US-CA", `{"code":"TENOFF","percent":10}`,
+			Quote{Subtotal: usd(7998), Discount: usd(800), Tax: usd(522), TaxRate: 725, Total: usd(7720)}},
+		{"percentage rounded half up", []string{"1"}, "US", `{"code":"HALF","percent":50}`,
+			Quote{Subtotal: usd(2999), Discount: usd(1500), Tax: usd(0), Total: usd(1499)}},
+		{"fixed amount", []string{"1", "2"}, "GB", `{"code":"TENBUCKS","amount":{"amount":1000,"currency":"USD"}}`,
+			Quote{Subtotal: usd(7998), Discount: usd(1000), Tax: usd(1400), TaxRate: 2000, Total: usd(8398)}},
+		{"fixed amount over the subtotal", []string{"1"}, "DE", `{"code":"BIG","amount":{"amount":5000,"currency":"USD"}}`,
+			Quote{Subtotal: usd(2999), Discount: usd(2999), Tax: usd(0), TaxRate: 1900, Total: usd(0)}},
+		{"free", []string{"1", "2"}, "US", `{"code":"FREE","percent":100}`,
+			Quote{Subtotal: usd(7998), Discount: usd(7998), Tax: usd(0), Total: usd(0)}},
+		{"coupon in another currency", []string{"1"}, "US", `{"code":"EURO","amount":{"amount":500,"currency":"EUR"}}`,
+			Quote{Subtotal: usd(2999), Discount: usd(0), Tax: usd(0), Total: usd(2999), CouponError: "The coupon is for EUR prices"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			for _, call := range withCart {
+				mustServe(t, router, call)
+			}
+			for _, id := range tt.courses {
+				mustServe(t, router, apiCall{method: "POST", path: "/carts/1/items", body: fmt.Sprintf(`{"course_id":%q}`, id), header: asAdmin})
+			}
+			var coupon Coupon
+			if tt.coupon != "" {
+				decodeJSON(t, mustServe(t, router, apiCall{method: "POST", path: "/coupons", body: tt.coupon, header: asAdmin}), &coupon)
+			}
+			mustServe(t, router, apiCall{method: "PUT", path: "/carts/1", body: fmt.Sprintf(`{"region":%q,"coupon":%q}`, tt.region, coupon.Code), header: asAdmin})
+
+			var cart Cart
+			decodeJSON(t, mustServe(t, router, apiCall{method: "GET", path: "/carts/1", header: asAdmin}), &cart)
+			if cart.Quote != tt.want {
+				t.Errorf("quote = %+v, want %+v", cart.Quote, tt.want)
+			}
+			if tt.want.CouponError != "" {
+				return
+			}
+
+			// The order is charged the quoted total
+			var order Order
+			decodeJSON(t, mustServe(t, router, apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asAdmin}), &order)
+			if order.Quote != tt.want {
+				t.Errorf("order = %+v, want %+v", order.Quote, tt.want)
+			}
+			switch {
+			case tt.want.Total.Amount == 0 && order.Payment != nil:
+				t.Errorf("payment = %+v, want none for a free order", order.Payment)
+			case tt.want.Total.Amount > 0 && (order.Payment == nil || order.Payment.Amount != tt.want.Total):
+				t.Errorf("payment = %+v, want %v charged", order.Payment, tt.want.Total)
+			}
+		})
+	}
+}
+
+func TestCouponUses(t *testing.T) {
+	router := newTestRouter(t)
+	for _, call := range withCartItem {
+		mustServe(t, router, call)
+	}
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+		wantBody   string
+	}{
+		{"create", apiCall{method: "POST", path: "/coupons", body: `{"code":"once","percent":20,"max_uses":1}`, header: asAdmin}, http.StatusCreated, `"code":"ONCE"`},
+		{"apply", apiCall{method: "PUT", path: "/carts/1", body: `{"region":"US","coupon":"once"}`, header: asAdmin}, http.StatusOK, `"discount":{"amount":600`},
+		{"check out", apiCall{method: "POST", path: "/carts/1/checkout", body: `{"payment_token":"tok_ok"}`, header: asAdmin}, http.StatusCreated, `"coupon":"ONCE"`},
+		{"counted", apiCall{method: "GET", path: "/coupons/ONCE"}, http.StatusOK, `"uses":1`},
+		{"new cart", apiCall{method: "POST", path: "/carts", body: `{"learner_id":"1","region":"US"}`, header: asAdmin}, http.StatusCreated, `"id":"2"`},
+		{"add item", apiCall{method: "POST", path: "/carts/2/items", body: `{"course_id":"2"}`, header: asAdmin}, http.StatusOK, `"course_id":"2"`},
+		{"apply when used up", apiCall{method: "PUT", path: "/carts/2", body: `{"region":"US","coupon":"once"}`, header: asAdmin}, http.StatusBadRequest, errCouponUsedUp.Error()},
+	}
+
+	// The cases run in order, on one tenant
+	for _, tt := range tests {
+		rec := serve(t, router, tt.call)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body = %s, want it to contain %q", tt.name, rec.Body, tt.wantBody)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	newTestRouter(t) // Materials and backups go to temporary directories
+	digest := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	registry, err := newTenantRegistry([]tenantConfig{
+		{ID: "physics", Name: "Physics", APIKeys: []string{digest("physics-key")}, AdminKeys: []string{digest("physics-admin")},
+			Quota: tenantQuota{Courses: 1, Learners: 1}},
+		{ID: "chemistry", Name: "Chemistry", Quota: tenantQuota{RequestsPerMinute: 2}},
+	}, "courses.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouterFor(registry, nil)
+
+	physics := func(call apiCall, header map[string]string) apiCall {
+		call.header = maps.Clone(header)
+		if call.header == nil {
+			call.header = map[string]string{}
+		}
+		if _, ok := call.header["X-Tenant"]; !ok && !strings.HasPrefix(call.path, "http") {
+			call.header["X-Tenant"] = "physics"
+		}
+		return call
+	}
+	withKey := map[string]string{"X-API-Key": "physics-key"}
+	courses := apiCall{method: "GET", path: "/v2/courses"}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("ops:physics-key"))
+
+	tests := []struct {
+		name       string
+		call       apiCall
+		wantStatus int
+		wantTenant string // X-Tenant of the response
+		wantBody   string // Substring of the response body
+		wantAbsent string // Must not appear in the response body
+	}{
+		// API keys
+		{"no key", physics(courses, nil), http.StatusUnauthorized, "physics", "valid API key", ""},
+		{"wrong key", physics(courses, map[string]string{"X-API-Key": "chemistry-key"}), http.StatusUnauthorized, "physics", "valid API key", ""},
+		{"X-API-Key", physics(courses, withKey), http.StatusOK, "physics", "[]", ""},
+		{"bearer token", physics(courses, map[string]string{"Authorization": "Bearer physics-key"}), http.StatusOK, "physics", "[]", ""},
+		{"Basic password", physics(courses, map[string]string{"Authorization": basic}), http.StatusOK, "physics", "[]", ""},
+		{"admin key", physics(courses, map[string]string{"X-API-Key": "physics-admin"}), http.StatusOK, "physics", "[]", ""},
+		{"case-insensitive tenant", physics(courses, map[string]string{"X-Tenant": "Physics", "X-API-Key": "physics-key"}), http.StatusOK, "physics", "[]", ""},
+
+		// Isolation and quotas
+		{"create", physics(apiCall{method: "POST", path: "/v2/courses", body: `{"name":"Optics","duration":"PT10H"}`}, withKey), http.StatusOK, "physics", `"name":"Optics"`, ""},
+		{"over the course quota", physics(apiCall{method: "POST", path: "/v2/courses", body: `{"name":"Mechanics","duration":"PT10H"}`}, withKey), http.StatusForbidden, "physics", errQuotaExceeded.Error(), ""},
+		{"own catalog", physics(courses, withKey), http.StatusOK, "physics", `"name":"Optics"`, "Go Basics"},
+		{"another tenant's course", physics(apiCall{method: "GET", path: "/v2/courses/2"}, withKey), http.StatusOK, "physics", errCourseNotFound.Error(), "Advanced Go"},
+		{"default catalog", courses, http.StatusOK, defaultTenantID, `"name":"Go Basics"`, "Optics"},
+		{"learner", physics(withLearner[0], withKey), http.StatusCreated, "physics", `"name":"Ada"`, ""},
+		{"over the learner quota", physics(apiCall{method: "POST", path: "/learners", body: `{"name":"Bob","email":"bob@example.com"}`}, withKey), http.StatusForbidden, "physics", errQuotaExceeded.Error(), ""},
+		{"admin route with an API key", physics(apiCall{method: "GET", path: "/coupons"}, withKey), http.StatusForbidden, "physics", "admin key", ""},
+		{"admin route with the admin key", physics(apiCall{method: "GET", path: "/coupons"}, map[string]string{"X-API-Key": "physics-admin"}), http.StatusOK, "physics", "[]", ""},
+		{"another tenant's admin key", physics(apiCall{method: "GET", path: "/coupons"}, map[string]string{"X-API-Key": testAdminKey}), http.StatusUnauthorized, "physics", "valid API key", ""},
+
+		// Host names
+		{"subdomain", physics(apiCall{method: "GET", path: "http://physics.courses.example.com/v2/courses"}, withKey), http.StatusOK, "physics", `"name":"Optics"`, ""},
+		{"subdomain and header", physics(apiCall{method: "GET", path: "http://physics.courses.example.com/v2/courses"}, map[string]string{"X-Tenant": "physics", "X-API-Key": "physics-key"}), http.StatusOK, "physics", `"name":"Optics"`, ""},
+		{"subdomain and another header", physics(apiCall{method: "GET", path: "http://physics.courses.example.com/v2/courses"}, map[string]string{"X-Tenant": "chemistry"}), http.StatusBadRequest, "", errTenantMismatch.Error(), ""},
+		{"unknown subdomain", apiCall{method: "GET", path: "http://biology.courses.example.com/v2/courses"}, http.StatusNotFound, "", errTenantNotFound.Error(), ""},
+		{"unknown header", apiCall{method: "GET", path: "/v2/courses", header: map[string]string{"X-Tenant": "biology"}}, http.StatusNotFound, "", errTenantNotFound.Error(), ""},
+		{"bare domain", apiCall{method: "GET", path: "http://courses.example.com/v2/courses"}, http.StatusOK, defaultTenantID, `"name":"Go Basics"`, ""},
+		{"another domain", apiCall{method: "GET", path: "http://physics.example.org/v2/courses"}, http.StatusOK, defaultTenantID, `"name":"Go Basics"`, ""},
+
+		// Rate limits
+		{"first request", apiCall{method: "GET", path: "http://chemistry.courses.example.com/v2/courses"}, http.StatusOK, "chemistry", "[]", ""},
+		{"second request", apiCall{method: "GET", path: "http://chemistry.courses.example.com/v2/courses"}, http.StatusOK, "chemistry", "[]", ""},
+		{"over the rate limit", apiCall{method: "GET", path: "http://chemistry.courses.example.com/v2/courses"}, http.StatusTooManyRequests, "chemistry", "Request quota exceeded", ""},
+		{"other tenants", courses, http.StatusOK, defaultTenantID, `"name":"Go Basics"`, ""},
+	}
+
+	// The cases run in order, on one registry
+	for _, tt := range tests {
+		rec := serve(t, router, tt.call)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d (body %s)", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if got := rec.Header().Get("X-Tenant"); got != tt.wantTenant {
+			t.Errorf("%s: X-Tenant = %q, want %q", tt.name, got, tt.wantTenant)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, tt.wantBody) || tt.wantAbsent != "" && strings.Contains(body, tt.wantAbsent) {
+			t.Errorf("%s: body = %s, want it to contain %q and not %q", tt.name, body, tt.wantBody, tt.wantAbsent)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", tt.name)
+		}
+	}
+}
+
+func TestScheduledPublishing(t *testing.T) {
+	const soon = 200 * time.Millisecond
+	tests := []struct {
+		name        string
+		publishAt   []time.Duration // Each schedule of course 2, from now
+		thenDraft   bool            // Moved back to draft before it's due
+		wantPublish bool
+	}{
+		{"due soon", []time.Duration{soon}, false, true},
+		{"already due", []time.Duration{-time.Hour}, false, true},
+		{"moved earlier", []time.Duration{time.Hour, soon}, false, true},
+		{"moved later", []time.Duration{soon, time.Hour}, false, false},
+		{"drafted", []time.Duration{soon}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t)
+			saved := scheduler
+			scheduler = &publishScheduler{wake: make(chan struct{}, 1)}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				scheduler.run(ctx, tenants)
+				close(done)
+			}()
+			t.Cleanup(func() {
+				cancel()
+				<-done
+				scheduler = saved
+			})
+
+			// Let the scheduler's first pass find nothing due, so it sleeps
+			// until a schedule wakes it
+			time.Sleep(10 * time.Millisecond)
+			var publishAt time.Time
+			for _, d := range tt.publishAt {
+				publishAt = time.Now().Add(d).UTC().Truncate(time.Millisecond)
+				body := fmt.Sprintf(`{"status":"scheduled","publish_at":%q}`, publishAt.Format(time.RFC3339Nano))
+				mustServe(t, router, apiCall{method: "PUT", path: "/v2/courses/2/status", body: body})
+			}
+			if tt.thenDraft {
+				mustServe(t, router, apiCall{method: "PUT", path: "/v2/courses/2/status", body: `{"status":"draft"}`})
+			}
+
+			status := func() courseLifecycle {
+				var l courseLifecycle
+				decodeJSON(t, mustServe(t, router, apiCall{method: "GET", path: "/v2/courses/2/status"}), &l)
+				return l
+			}
+			if !tt.wantPublish {
+				time.Sleep(soon + 300*time.Millisecond)
+				if l := status(); l.Status == StatusPublished {
+					t.Errorf("status = %+v, want it unpublished", l)
+				}
+				return
+			}
+
+			// Published no earlier than publish_at, and soon after it
+			deadline := publishAt.Add(500 * time.Millisecond)
+			for {
+				l := status()
+				if l.Status == StatusPublished {
+					if now := time.Now(); now.Before(publishAt) {
+						t.Errorf("published at %v, before publish_at %v", now, publishAt)
+					}
+					if l.PublishedAt == nil || !l.PublishedAt.Equal(publishAt) {
+						t.Errorf("published_at = %v, want %v", l.PublishedAt, publishAt)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("status = %+v at %v, want it published", l, time.Now())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if body := mustServe(t, router, apiCall{method: "GET", path: "/v2/courses"}).Body.String(); !strings.Contains(body, "Advanced Go") {
+				t.Errorf("courses = %s, want the published course listed", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// JSON-RPC - rpc.go
+// A JSON-RPC 2.0 interface (https://www.jsonrpc.org/specification) over the
+// same tenant stores as the REST routes, for tools that prefer calling
+// methods to addressing resources:
+//
+//	POST /rpc  {"jsonrpc": "2.0", "method": "courses.get", "params": {"id": "1"}, "id": 1}
+//
+// Parameters are given by name or by position. A request without an id is a
+// notification and gets no response; an array of requests is a batch, run in
+// order, and answered with an array of the responses that aren't for
+// notifications (or 204 No Content if there are none).
+//
+// Store errors keep their meaning: validation errors are Invalid params with
+// the field errors as data, and the others get a code in the
+// implementation-defined range that mirrors their REST status, e.g. -32004
+// for 404 Not Found.
+
+// Error codes defined by the specification
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// maxRPCBody is the largest request body, in bytes; batches are limited to
+// maxBatchOperations calls like those of /batch
+const maxRPCBody = 1 << 20
+
+// rpcError is the error member of a response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// invalidParams reports a problem with the parameters of a call
+func invalidParams(format string, args ...any) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// storeRPCError converts a store error to a JSON-RPC error
+func storeRPCError(err error) *rpcError {
+	if errs, ok := err.(validationErrors); ok {
+		return &rpcError{Code: rpcInvalidParams, Message: "Validation failed", Data: []fieldError(errs)}
+	}
+	status := storeErrorStatus(err)
+	if status == http.StatusBadRequest {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return &rpcError{Code: -32000 - status%100, Message: err.Error(), Data: map[string]int{"status": status}}
+}
+
+// rpcRequest is one call
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // Absent for notifications; "null" is a valid id
+}
+
+// rpcResponse is the answer to a call
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"` // Always present on success, possibly null
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Methods
+
+// rpcParams are the parameters of a call, by name
+type rpcParams map[string]json.RawMessage
+
+// decode reads a parameter into v; a missing optional parameter leaves v as is
+func (p rpcParams) decode(name string, v any, required bool) *rpcError {
+	raw, ok := p[name]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		if required {
+			return invalidParams("Missing parameter %q", name)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return invalidParams("Invalid parameter %q: %v", name, err)
+	}
+	return nil
+}
+
+// rpcMethod is a method callers can invoke
+type rpcMethod struct {
+	params []string // Names, in the order of positional parameters
+	write  bool     // Changes the store; refused on replicas
+	call   func(ctx context.Context, p rpcParams) (any, error)
+}
+
+// rpcMethods are the methods of /rpc, by name
+var rpcMethods = map[string]rpcMethod{
+	"courses.list": {
+		params: []string{"sort", "page", "per_page"},
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var sort string
+			number, perPage := 1, 0
+			if err := firstRPCError(p.decode("sort", &sort, false), p.decode("page", &number, false), p.decode("per_page", &perPage, false)); err != nil {
+				return nil, err
+			}
+			courses := publishedOnly(tenantFrom(ctx).store.List())
+			if sort != "" {
+				if err := sortCourses(courses, sort); err != nil {
+					return nil, invalidParams("%v", err)
+				}
+			}
+			total := len(courses)
+			switch {
+			case number < 1:
+				return nil, invalidParams("page must be a positive whole number")
+			case perPage < 0 || perPage > maxPerPage:
+				return nil, invalidParams("per_page must be between 1 and %d", maxPerPage)
+			case perPage > 0:
+				start := min((number-1)*perPage, total)
+				courses = courses[start:min(start+perPage, total)]
+			}
+			return map[string]any{"total": total, "courses": courses}, nil
+		},
+	},
+	"courses.get": {
+		params: []string{"id"},
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var id string
+			if err := p.decode("id", &id, true); err != nil {
+				return nil, err
+			}
+			if c, ok := tenantFrom(ctx).store.Get(id); ok && c.Live() {
+				return c, nil
+			}
+			return nil, errCourseNotFound
+		},
+	},
+	"courses.create": {
+		params: []string{"course"},
+		write:  true,
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var c Course
+			if err := p.decode("course", &c, true); err != nil {
+				return nil, err
+			}
+			if c.IsEmpty() {
+				return nil, invalidParams("No data in the course")
+			}
+			return tenantFrom(ctx).store.Create(c)
+		},
+	},
+	"courses.update": {
+		params: []string{"id", "course"},
+		write:  true,
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var id string
+			var c Course
+			if err := firstRPCError(p.decode("id", &id, true), p.decode("course", &c, true)); err != nil {
+				return nil, err
+			}
+			if c.IsEmpty() {
+				return nil, invalidParams("No data in the course")
+			}
+			return tenantFrom(ctx).store.Update(id, c)
+		},
+	},
+	"courses.delete": {
+		params: []string{"id"},
+		write:  true,
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var id string
+			if err := p.decode("id", &id, true); err != nil {
+				return nil, err
+			}
+			if !tenantFrom(ctx).store.Delete(id) {
+				return nil, errCourseNotFound
+			}
+			return true, nil
+		},
+	},
+	"authors.list": {
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			return tenantFrom(ctx).store.Authors(), nil
+		},
+	},
+	"authors.get": {
+		params: []string{"id"},
+		call: func(ctx context.Context, p rpcParams) (any, error) {
+			var id string
+			if err := p.decode("id", &id, true); err != nil {
+				return nil, err
+			}
+			if a, ok := tenantFrom(ctx).store.Author(id); ok {
+				return a, nil
+			}
+			return nil, errAuthorNotFound
+		},
+	},
+}
+
+// firstRPCError returns the first non-nil error. It takes *rpcError so a nil
+// one doesn't become a non-nil error interface.
+func firstRPCError(errs ...*rpcError) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namedParams reads the params member by name or by position
+func (m rpcMethod) namedParams(raw json.RawMessage) (rpcParams, *rpcError) {
+	raw = bytes.TrimSpace(raw)
+	params := rpcParams{}
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return params, nil
+	case raw[0] == '{':
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, invalidParams("Invalid params: %v", err)
+		}
+	case raw[0] == '[':
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, invalidParams("Invalid params: %v", err)
+		}
+		if len(list) > len(m.params) {
+			return nil, invalidParams("Expected at most %d parameters (%s)", len(m.params), strings.Join(m.params, ", "))
+		}
+		for i, v := range list {
+			params[m.params[i]] = v
+		}
+	default:
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "params must be an object or an array"}
+	}
+	return params, nil
+}
+
+// Transport
+
+// serveRPC runs a JSON-RPC call or batch
+// POST /rpc
+func serveRPC(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("This is a JSON-RPC route")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCBody))
+	if err != nil {
+		writeRPC(w, rpcFailure(nil, &rpcError{Code: rpcInvalidRequest, Message: fmt.Sprintf("Body is larger than %d bytes", maxRPCBody)}))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		writeRPC(w, rpcFailure(nil, &rpcError{Code: rpcParseError, Message: "Parse error"}))
+		return
+	}
+
+	// A single call
+	if body[0] != '[' {
+		if resp := runRPC(r.Context(), body); resp != nil {
+			writeRPC(w, resp)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// A batch
+	var calls []json.RawMessage
+	json.Unmarshal(body, &calls)
+	switch {
+	case len(calls) == 0:
+		writeRPC(w, rpcFailure(nil, &rpcError{Code: rpcInvalidRequest, Message: "Batch is empty"}))
+		return
+	case len(calls) > maxBatchOperations:
+		writeRPC(w, rpcFailure(nil, &rpcError{Code: rpcInvalidRequest, Message: fmt.Sprintf("Batch has more than %d calls", maxBatchOperations)}))
+		return
+	}
+	responses := []*rpcResponse{}
+	for _, call := range calls {
+		if resp := runRPC(r.Context(), call); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPC(w, responses)
+}
+
+// runRPC runs one call and returns its response, or nil for a notification
+func runRPC(ctx context.Context, data json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if len(data) == 0 || data[0] != '{' || json.Unmarshal(data, &req) != nil {
+		return rpcFailure(nil, &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" || !validRPCID(req.ID) {
+		return rpcFailure(validIDOrNil(req.ID), &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"})
+	}
+	notification := req.ID == nil
+
+	result, rpcErr := callRPC(ctx, req)
+	if notification {
+		return nil
+	}
+	if rpcErr != nil {
+		return rpcFailure(req.ID, rpcErr)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return rpcFailure(req.ID, &rpcError{Code: rpcInternalError, Message: err.Error()})
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+// callRPC invokes the method of a request
+func callRPC(ctx context.Context, req rpcRequest) (any, *rpcError) {
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("Method not found: %s", req.Method)}
+	}
+	if err := readOnlyError(ctx); method.write && err != nil {
+		return nil, storeRPCError(err)
+	}
+	params, rpcErr := method.namedParams(req.Params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	result, err := method.call(ctx, params)
+	var asRPC *rpcError
+	switch {
+	case err == nil:
+		return result, nil
+	case errors.As(err, &asRPC):
+		return nil, asRPC
+	default:
+		return nil, storeRPCError(err)
+	}
+}
+
+// validRPCID reports whether an id is absent, a string, a number or null
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	var v any
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+// validIDOrNil echoes an id in an error response when it is usable
+func validIDOrNil(id json.RawMessage) json.RawMessage {
+	if id != nil && validRPCID(id) {
+		return id
+	}
+	return nil
+}
+
+// rpcFailure builds an error response; a nil id is sent as null
+func rpcFailure(id json.RawMessage, err *rpcError) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+// writeRPC sends a response or a batch of responses. Errors are reported in
+// the body, so the status is always 200 OK.
+func writeRPC(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
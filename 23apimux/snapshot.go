@@ -0,0 +1,137 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// Store snapshots - snapshot.go
+// A snapshot is a deep copy of everything in the store. Transactional batches
+// (see batch.go) take one before their first operation and restore it if any
+// operation fails.
+
+// storeState is the data of a courseStore, without its locks
+type storeState struct {
+	Courses      []Course
+	NextID       int
+	Authors      []Author
+	NextAuthorID int
+	NextModuleID int
+	NextLessonID int
+
+	Learners         []Learner
+	Enrollments      []Enrollment
+	NextLearnerID    int
+	NextEnrollmentID int
+
+	Reviews      []Review
+	NextReviewID int
+
+	Materials      []Material
+	NextMaterialID int
+
+	Coupons     map[string]Coupon
+	Carts       map[string]Cart
+	Orders      []Order
+	NextCartID  int
+	NextOrderID int
+}
+
+// snapshot returns a deep copy of the store's data
+func (s *courseStore) snapshot() storeState {
+	s.checkoutMu.Lock()
+	defer s.checkoutMu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := storeState{
+		Courses:          make([]Course, len(s.courses)),
+		NextID:           s.nextID,
+		Authors:          slices.Clone(s.authors),
+		NextAuthorID:     s.nextAuthorID,
+		NextModuleID:     s.nextModuleID,
+		NextLessonID:     s.nextLessonID,
+		Learners:         slices.Clone(s.learners),
+		Enrollments:      make([]Enrollment, len(s.enrollments)),
+		NextLearnerID:    s.nextLearnerID,
+		NextEnrollmentID: s.nextEnrollmentID,
+		Reviews:          make([]Review, len(s.reviews)),
+		NextReviewID:     s.nextReviewID,
+		Materials:        slices.Clone(s.materials),
+		NextMaterialID:   s.nextMaterialID,
+		Coupons:          maps.Clone(s.coupons),
+		Carts:            make(map[string]Cart, len(s.carts)),
+		Orders:           slices.Clone(s.orders),
+		NextCartID:       s.nextCartID,
+		NextOrderID:      s.nextOrderID,
+	}
+	for i, c := range s.courses {
+		state.Courses[i] = cloneCourse(c)
+	}
+	// Slices that the store appends to in place must not be shared
+	for i, e := range s.enrollments {
+		e.Completions = slices.Clone(e.Completions)
+		state.Enrollments[i] = e
+	}
+	for i, rv := range s.reviews {
+		rv.Flags = slices.Clone(rv.Flags)
+		state.Reviews[i] = rv
+	}
+	for id, cart := range s.carts {
+		cart.Items = slices.Clone(cart.Items)
+		state.Carts[id] = cart
+	}
+	return state
+}
+
+// restore replaces the store's data with a snapshot. The snapshot is copied
+// again, so it can be restored more than once.
+func (s *courseStore) restore(state storeState) {
+	s.checkoutMu.Lock()
+	s.mu.Lock()
+	oldCourses, oldAuthors := s.courses, s.authors
+	s.courses = make([]Course, len(state.Courses))
+	for i, c := range state.Courses {
+		s.courses[i] = cloneCourse(c)
+	}
+	s.nextID = state.NextID
+	s.authors = slices.Clone(state.Authors)
+	s.nextAuthorID = state.NextAuthorID
+	s.nextModuleID = state.NextModuleID
+	s.nextLessonID = state.NextLessonID
+
+	s.learners = slices.Clone(state.Learners)
+	s.enrollments = make([]Enrollment, len(state.Enrollments))
+	for i, e := range state.Enrollments {
+		e.Completions = slices.Clone(e.Completions)
+		s.enrollments[i] = e
+	}
+	s.nextLearnerID = state.NextLearnerID
+	s.nextEnrollmentID = state.NextEnrollmentID
+
+	s.reviews = make([]Review, len(state.Reviews))
+	for i, rv := range state.Reviews {
+		rv.Flags = slices.Clone(rv.Flags)
+		s.reviews[i] = rv
+	}
+	s.nextReviewID = state.NextReviewID
+
+	s.materials = slices.Clone(state.Materials)
+	s.nextMaterialID = state.NextMaterialID
+
+	s.coupons = maps.Clone(state.Coupons)
+	s.carts = make(map[string]Cart, len(state.Carts))
+	for id, cart := range state.Carts {
+		cart.Items = slices.Clone(cart.Items)
+		s.carts[id] = cart
+	}
+	s.orders = slices.Clone(state.Orders)
+	s.nextCartID = state.NextCartID
+	s.nextOrderID = state.NextOrderID
+	s.logCatalogReplaced(oldCourses, oldAuthors)
+	s.mu.Unlock()
+	s.checkoutMu.Unlock()
+
+	s.catalogChanged()
+	scheduler.poke() // The restored schedule may differ
+}
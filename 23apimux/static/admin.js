@@ -0,0 +1,7 @@
+// Ask before submitting forms marked with data-confirm (e.g. delete buttons)
+document.addEventListener("submit", (event) => {
+  const message = event.target.dataset.confirm;
+  if (message && !window.confirm(message)) {
+    event.preventDefault();
+  }
+});
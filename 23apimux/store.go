@@ -0,0 +1,504 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Course storage - store.go
+// courseStore is the in-memory "database" of one tenant, shared by its REST
+// handlers and GraphQL resolvers (see tenant.go). All access goes through its
+// methods so concurrent requests are safe and every mutation invalidates the
+// tenant's cached catalog responses.
+
+var (
+	errCourseNotFound = errors.New("No course found with the given ID")
+	errAuthorNotFound = errors.New("No author found with the given ID")
+	errAuthorExists   = errors.New("An author with this email already exists")
+	errAuthorInUse    = errors.New("The author still has courses")
+	errQuotaExceeded  = errors.New("Quota exceeded")
+)
+
+// courseStore holds the courses and hands out copies of them
+type courseStore struct {
+	tenant string         // ID of the tenant that owns the store
+	cache  *responseCache // Cached catalog responses (see cache.go)
+	quota  tenantQuota    // Limits on what the tenant may store
+	wal    *writeAheadLog // Catalog changes for followers (see replication.go)
+
+	mu           sync.RWMutex
+	courses      []Course
+	nextID       int      // Next numeric course ID to hand out
+	authors      []Author // Every author, whether or not they have courses
+	nextAuthorID int
+	nextModuleID int // Module and lesson IDs are unique across all courses
+	nextLessonID int
+
+	learners         []Learner    // Learner accounts (see enrollment.go)
+	enrollments      []Enrollment // Learners enrolled in courses
+	nextLearnerID    int
+	nextEnrollmentID int
+
+	reviews      []Review // Course reviews (see review.go)
+	nextReviewID int
+
+	materials      []Material // Files attached to courses (see materials.go)
+	nextMaterialID int
+	blobMu         sync.RWMutex // Held for reading by uploads, for writing by sweeps
+	sweepHolds     atomic.Int32 // Sweeps are skipped while held
+
+	checkoutMu  sync.Mutex        // Serializes checkouts (see checkout.go)
+	coupons     map[string]Coupon // Discount codes by code
+	carts       map[string]Cart   // Open carts by ID
+	orders      []Order           // Completed orders
+	nextCartID  int
+	nextOrderID int
+}
+
+// newCourseStore creates a tenant's store seeded with the given courses
+func newCourseStore(tenant string, quota tenantQuota, seed []Course) *courseStore {
+	s := &courseStore{
+		tenant:           tenant,
+		cache:            newResponseCache(0),
+		quota:            quota,
+		nextID:           1,
+		nextAuthorID:     1,
+		nextModuleID:     1,
+		nextLessonID:     1,
+		nextLearnerID:    1,
+		nextEnrollmentID: 1,
+		nextReviewID:     1,
+		nextMaterialID:   1,
+		coupons:          map[string]Coupon{},
+		carts:            map[string]Cart{},
+		nextCartID:       1,
+		nextOrderID:      1,
+	}
+	for _, c := range seed {
+		c = cloneCourse(c)
+		s.normalizeCurriculum(&c)
+		advanceLifecycle(&c, time.Now().UTC())
+		s.registerAuthor(&c) // The sample authors don't clash
+		s.courses = append(s.courses, c)
+		if n, err := strconv.Atoi(c.ID); err == nil && n >= s.nextID {
+			s.nextID = n + 1
+		}
+	}
+	s.wal = newWriteAheadLog()
+	return s
+}
+
+// cloneCourse copies a course including its author and curriculum, so callers
+// can't modify stored data through shared pointers or slices
+func cloneCourse(c Course) Course {
+	if c.Author != nil {
+		author := *c.Author
+		c.Author = &author
+	}
+	if c.Modules != nil {
+		modules := make([]Module, len(c.Modules))
+		for i, m := range c.Modules {
+			m.Lessons = append([]Lesson(nil), m.Lessons...)
+			modules[i] = m
+		}
+		c.Modules = modules
+	}
+	if c.Prerequisites != nil {
+		c.Prerequisites = append([]string{}, c.Prerequisites...)
+	}
+	return c
+}
+
+// index returns the position of a course in s.courses or -1; called with s.mu held
+func (s *courseStore) index(id string) int {
+	for i, c := range s.courses {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// List returns every course in insertion order
+func (s *courseStore) List() []Course {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Course, 0, len(s.courses))
+	for _, c := range s.courses {
+		list = append(list, cloneCourse(c))
+	}
+	return list
+}
+
+// Get returns the course with the given ID
+func (s *courseStore) Get(id string) (Course, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.courses {
+		if c.ID == id {
+			return cloneCourse(c), true
+		}
+	}
+	return Course{}, false
+}
+
+// Create stores a new course under a freshly generated ID and returns it.
+// The course must pass validateCourse and its prerequisites must exist.
+func (s *courseStore) Create(c Course) (Course, error) {
+	if err := validateCourse(&c); err != nil {
+		return Course{}, err
+	}
+
+	s.mu.Lock()
+	if s.quota.Courses > 0 && len(s.courses) >= s.quota.Courses {
+		s.mu.Unlock()
+		return Course{}, fmt.Errorf("%w: the catalog is limited to %d courses", errQuotaExceeded, s.quota.Courses)
+	}
+	c.ID = strconv.Itoa(s.nextID)
+	if err := s.checkPrerequisites(c.ID, c.Prerequisites); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := s.checkCurriculumIDs(c.ID, &c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := s.registerAuthor(&c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	s.nextID++
+	s.normalizeCurriculum(&c)
+	c.Rating = RatingSummary{} // Ratings only come from reviews
+	c.PublishedAt = nil        // Set when the course goes live
+	advanceLifecycle(&c, time.Now().UTC())
+	s.courses = append(s.courses, cloneCourse(c))
+	c = cloneCourse(s.courses[len(s.courses)-1])
+	s.logWAL(putCourseEntry(c))
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	if c.Status == StatusScheduled {
+		scheduler.poke()
+	}
+	return c, nil
+}
+
+// Update replaces the course with the given ID, keeping that ID. A course
+// sent without modules, prerequisites or a status keeps its current ones, so
+// clients that don't know about the curriculum or lifecycle can't wipe them.
+func (s *courseStore) Update(id string, c Course) (Course, error) {
+	return s.updateCourse(id, func(stored *Course) error {
+		if c.Modules == nil {
+			c.Modules = stored.Modules
+		}
+		if c.Prerequisites == nil {
+			c.Prerequisites = stored.Prerequisites
+		}
+		if c.Status == "" {
+			c.Status, c.PublishAt = stored.Status, stored.PublishAt
+		}
+		c.PublishedAt = stored.PublishedAt
+		c.Rating = stored.Rating
+		c.ID = id
+		*stored = c
+		return nil
+	})
+}
+
+// updateCourse applies fn to a copy of the course with the given ID and
+// stores the result if fn succeeds and the prerequisites are still a DAG
+func (s *courseStore) updateCourse(id string, fn func(c *Course) error) (Course, error) {
+	s.mu.Lock()
+	i := s.index(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return Course{}, errCourseNotFound
+	}
+	c := cloneCourse(s.courses[i])
+	if err := fn(&c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := validateCourse(&c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := s.checkPrerequisites(id, c.Prerequisites); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := s.checkCurriculumIDs(id, &c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	if err := s.registerAuthor(&c); err != nil {
+		s.mu.Unlock()
+		return Course{}, err
+	}
+	s.normalizeCurriculum(&c)
+	advanceLifecycle(&c, time.Now().UTC())
+	s.courses[i] = cloneCourse(c)
+	c = cloneCourse(s.courses[i])
+	s.logWAL(putCourseEntry(c))
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	if c.Status == StatusScheduled {
+		scheduler.poke()
+	}
+	return c, nil
+}
+
+// Delete removes the course with the given ID, along with its enrollments,
+// reviews and any references to it as another course's prerequisite
+func (s *courseStore) Delete(id string) bool {
+	s.mu.Lock()
+	i := s.index(id)
+	if i >= 0 {
+		s.courses = append(s.courses[:i], s.courses[i+1:]...)
+		entries := []walEntry{{Op: walDeleteCourse, ID: id}}
+		for j := range s.courses {
+			if slices.Contains(s.courses[j].Prerequisites, id) {
+				s.courses[j].Prerequisites = slices.DeleteFunc(s.courses[j].Prerequisites, func(p string) bool { return p == id })
+				entries = append(entries, putCourseEntry(s.courses[j]))
+			}
+		}
+		s.logWAL(entries...)
+		s.enrollments = slices.DeleteFunc(s.enrollments, func(e Enrollment) bool { return e.CourseID == id })
+		s.reviews = slices.DeleteFunc(s.reviews, func(rv Review) bool { return rv.CourseID == id })
+		s.materials = slices.DeleteFunc(s.materials, func(m Material) bool { return m.CourseID == id })
+	}
+	s.mu.Unlock()
+
+	if i >= 0 {
+		s.catalogChanged()
+		s.sweepBlobs()
+	}
+	return i >= 0
+}
+
+// Authors returns every author sorted by name
+func (s *courseStore) Authors() []Author {
+	s.mu.RLock()
+	authors := slices.Clone(s.authors)
+	s.mu.RUnlock()
+
+	sort.Slice(authors, func(i, j int) bool {
+		return strings.ToLower(authors[i].Fullname) < strings.ToLower(authors[j].Fullname)
+	})
+	return authors
+}
+
+// Author returns the author with the given ID
+func (s *courseStore) Author(id string) (Author, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.authorIndex(id); i >= 0 {
+		return s.authors[i], true
+	}
+	return Author{}, false
+}
+
+// authorIndex returns the position of an author in s.authors or -1; called with s.mu held
+func (s *courseStore) authorIndex(id string) int {
+	return slices.IndexFunc(s.authors, func(a Author) bool { return a.ID == id })
+}
+
+// CreateAuthor stores a new author under a fresh ID
+func (s *courseStore) CreateAuthor(a Author) (Author, error) {
+	if err := validateAuthor(&a); err != nil {
+		return Author{}, err
+	}
+
+	s.mu.Lock()
+	if slices.ContainsFunc(s.authors, func(existing Author) bool { return strings.EqualFold(existing.Email, a.Email) }) {
+		s.mu.Unlock()
+		return Author{}, errAuthorExists
+	}
+	a.ID = s.assignAuthorID("")
+	s.authors = append(s.authors, a)
+	s.logWAL(putAuthorEntry(a))
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return a, nil
+}
+
+// UpdateAuthor changes an author everywhere, including the courses they wrote
+func (s *courseStore) UpdateAuthor(id string, a Author) (Author, error) {
+	if err := validateAuthor(&a); err != nil {
+		return Author{}, err
+	}
+
+	s.mu.Lock()
+	i := s.authorIndex(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return Author{}, errAuthorNotFound
+	}
+	if slices.ContainsFunc(s.authors, func(existing Author) bool {
+		return existing.ID != id && strings.EqualFold(existing.Email, a.Email)
+	}) {
+		s.mu.Unlock()
+		return Author{}, errAuthorExists
+	}
+	a.ID = id
+	s.saveAuthor(a)
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return a, nil
+}
+
+// DeleteAuthor removes an author who no longer has any courses
+func (s *courseStore) DeleteAuthor(id string) error {
+	s.mu.Lock()
+	i := s.authorIndex(id)
+	switch {
+	case i < 0:
+		s.mu.Unlock()
+		return errAuthorNotFound
+	case slices.ContainsFunc(s.courses, func(c Course) bool { return c.Author != nil && c.Author.ID == id }):
+		s.mu.Unlock()
+		return errAuthorInUse
+	}
+	s.authors = slices.Delete(s.authors, i, i+1)
+	s.logWAL(walEntry{Op: walDeleteAuthor, ID: id})
+	s.mu.Unlock()
+
+	s.catalogChanged()
+	return nil
+}
+
+// registerAuthor links a course's author to the author registry. A known ID
+// links to that author as stored, whatever else was sent; authors are only
+// changed through UpdateAuthor. An author without an ID is matched by email,
+// and any other author is added, unless their email belongs to someone else
+// (errAuthorExists). Called with s.mu held.
+func (s *courseStore) registerAuthor(c *Course) error {
+	a := c.Author
+	if a == nil {
+		return nil
+	}
+	if a.ID == "" && a.Fullname == "" && a.Email == "" {
+		c.Author = nil
+		return nil
+	}
+
+	i := -1
+	if a.ID != "" {
+		i = s.authorIndex(a.ID)
+	} else if a.Email != "" {
+		i = slices.IndexFunc(s.authors, func(existing Author) bool { return strings.EqualFold(existing.Email, a.Email) })
+	}
+	if i >= 0 {
+		author := s.authors[i]
+		c.Author = &author
+		return nil
+	}
+
+	if a.Email != "" && slices.ContainsFunc(s.authors, func(existing Author) bool { return strings.EqualFold(existing.Email, a.Email) }) {
+		return errAuthorExists
+	}
+	a.ID = s.assignAuthorID(a.ID)
+	s.authors = append(s.authors, *a)
+	s.logWAL(putAuthorEntry(*a)) // Ahead of the course that brought them
+	return nil
+}
+
+// saveAuthor upserts an author and copies it into every course that
+// references it; called with s.mu held
+func (s *courseStore) saveAuthor(a Author) {
+	if i := s.authorIndex(a.ID); i >= 0 {
+		s.authors[i] = a
+	} else {
+		s.authors = append(s.authors, a)
+	}
+	entries := []walEntry{putAuthorEntry(a)}
+	for i := range s.courses {
+		if s.courses[i].Author != nil && s.courses[i].Author.ID == a.ID {
+			author := a
+			s.courses[i].Author = &author
+			entries = append(entries, putCourseEntry(s.courses[i]))
+		}
+	}
+	s.logWAL(entries...)
+}
+
+// assignAuthorID returns id, or a fresh "a<n>" ID when id is empty; called with s.mu held
+func (s *courseStore) assignAuthorID(id string) string {
+	if id == "" {
+		id = "a" + strconv.Itoa(s.nextAuthorID)
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(id, "a")); err == nil && n >= s.nextAuthorID {
+		s.nextAuthorID = n + 1
+	}
+	return id
+}
+
+// CoursesByAuthor returns the courses written by the given author
+func (s *courseStore) CoursesByAuthor(authorID string) []Course {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []Course
+	for _, c := range s.courses {
+		if c.Author != nil && c.Author.ID == authorID {
+			list = append(list, cloneCourse(c))
+		}
+	}
+	return list
+}
+
+// courseOrders are the keys courses can be sorted by
+var courseOrders = map[string]func(a, b Course) int{
+	"name": func(a, b Course) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	},
+	"duration": func(a, b Course) int {
+		switch {
+		case a.Duration < b.Duration:
+			return -1
+		case a.Duration > b.Duration:
+			return 1
+		}
+		return 0
+	},
+	"price": func(a, b Course) int { return a.Price.Compare(b.Price) },
+	"rating": func(a, b Course) int {
+		// Equal averages rank the course with more reviews higher
+		if a.Rating.Average != b.Rating.Average {
+			return cmp.Compare(a.Rating.Average, b.Rating.Average)
+		}
+		return cmp.Compare(a.Rating.Count, b.Rating.Count)
+	},
+}
+
+// sortCourses sorts courses in place by a key from courseOrders; a leading
+// "-" sorts descending. Ties keep their store order.
+func sortCourses(list []Course, key string) error {
+	desc := strings.HasPrefix(key, "-")
+	cmp, ok := courseOrders[strings.TrimPrefix(key, "-")]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q", key)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if desc {
+			return cmp(list[j], list[i]) < 0
+		}
+		return cmp(list[i], list[j]) < 0
+	})
+	return nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Tenants - tenant.go
+// Each department's catalog is a tenant with its own courseStore, response
+// cache, quotas and API keys. Every request is resolved to one tenant before
+// it reaches a handler:
+//   - from the subdomain, when TENANT_DOMAIN is set (physics.courses.example.com
+//     with TENANT_DOMAIN=courses.example.com), using a mux Host matcher
+//   - otherwise from the X-Tenant header
+//   - otherwise the "default" tenant, which holds the sample catalog
+//
+// Handlers only reach a store through storeFrom(r), which returns the store
+// of the request's tenant; there is no global store, so a request can't read
+// or change another tenant's data.
+//
+// Tenants are configured in the JSON file named by TENANTS_FILE (see
+// tenants.example.json). API keys are listed as SHA-256 hex digests, e.g.
+// from `printf %s "$KEY" | sha256sum`. A tenant without keys is open to
+// everyone; otherwise every request needs one of its keys in X-API-Key, as a
+// Bearer token, or as the password of HTTP Basic auth (for the admin UI).
+//
+// Admin keys, listed the same way, are sent the same way and also pass as API
+// keys. Routes that act for the whole tenant, like review moderation, need one
+// (see requireAdmin); a tenant without admin keys has no such access.
+
+const defaultTenantID = "default"
+
+var (
+	errTenantNotFound = errors.New("No tenant found with the given ID")
+	errTenantMismatch = errors.New("X-Tenant does not match the tenant of the host name")
+)
+
+// tenantIDPattern is what tenant IDs (and so subdomains) may look like
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// tenantQuota limits what a tenant may store and how fast it may call the API;
+// zero means unlimited
+type tenantQuota struct {
+	Courses           int `json:"courses"`             // Courses in the catalog
+	Learners          int `json:"learners"`            // Learner accounts
+	RequestsPerMinute int `json:"requests_per_minute"` // API calls, with bursts up to the same number
+}
+
+// tenantConfig is one entry of the TENANTS_FILE
+type tenantConfig struct {
+	ID        string      `json:"id"`         // Lower-case letters, digits and dashes
+	Name      string      `json:"name"`       // Display name
+	APIKeys   []string    `json:"api_keys"`   // SHA-256 hex digests of the accepted keys
+	AdminKeys []string    `json:"admin_keys"` // SHA-256 hex digests of the keys of administrators
+	Quota     tenantQuota `json:"quota"`
+}
+
+// tenant is one isolated catalog
+type tenant struct {
+	ID        string
+	Name      string
+	store     *courseStore
+	keys      [][]byte     // SHA-256 digests of the accepted API keys
+	adminKeys [][]byte     // SHA-256 digests of the admin keys
+	limiter   *rateLimiter // Nil when requests aren't limited
+	gate      sync.RWMutex // Isolates transactional batches and restores (see batch.go)
+}
+
+// newTenant creates a tenant from its configuration, with an empty catalog
+// unless seed courses are given
+func newTenant(cfg tenantConfig, seed []Course) (*tenant, error) {
+	if !tenantIDPattern.MatchString(cfg.ID) {
+		return nil, fmt.Errorf("invalid tenant ID %q: use lower-case letters, digits and dashes", cfg.ID)
+	}
+	t := &tenant{
+		ID:      cfg.ID,
+		Name:    cfg.Name,
+		store:   newCourseStore(cfg.ID, cfg.Quota, seed),
+		limiter: newRateLimiter(cfg.Quota.RequestsPerMinute),
+	}
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+	var err error
+	if t.keys, err = parseKeyDigests(cfg.APIKeys); err != nil {
+		return nil, fmt.Errorf("tenant %s: API keys must be SHA-256 hex digests", cfg.ID)
+	}
+	if t.adminKeys, err = parseKeyDigests(cfg.AdminKeys); err != nil {
+		return nil, fmt.Errorf("tenant %s: admin keys must be SHA-256 hex digests", cfg.ID)
+	}
+	return t, nil
+}
+
+// parseKeyDigests decodes SHA-256 hex digests of keys
+func parseKeyDigests(keys []string) ([][]byte, error) {
+	var digests [][]byte
+	for _, key := range keys {
+		digest, err := hex.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(digest) != sha256.Size {
+			return nil, errors.New("invalid key digest")
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// authorized reports whether a request carries one of the tenant's API keys
+// or admin keys
+func (t *tenant) authorized(r *http.Request) bool {
+	return len(t.keys) == 0 || hasKey(r, t.keys) || hasKey(r, t.adminKeys)
+}
+
+// admin reports whether a request carries one of the tenant's admin keys
+func (t *tenant) admin(r *http.Request) bool {
+	return hasKey(r, t.adminKeys)
+}
+
+// hasKey reports whether the key sent with a request is one of keys
+func hasKey(r *http.Request, keys [][]byte) bool {
+	key := apiKey(r)
+	if key == "" {
+		return false
+	}
+	digest := sha256.Sum256([]byte(key))
+	ok := false
+	for _, k := range keys {
+		// Compare against every key so timing doesn't reveal which one matched
+		if subtle.ConstantTimeCompare(digest[:], k) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// requireAdmin only lets requests with one of the tenant's admin keys through
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !tenantFrom(r.Context()).admin(r) {
+			render(w, r, http.StatusForbidden, "An admin key for this tenant is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// apiKey returns the key sent with a request, if any
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// tenantRegistry holds every tenant; it doesn't change after startup
+type tenantRegistry struct {
+	byID map[string]*tenant
+	host *mux.Route // Extracts {tenant} from the host name; nil without TENANT_DOMAIN
+}
+
+// tenants is the registry newRouter serves
+var tenants = mustDefaultTenants()
+
+// mustDefaultTenants creates a registry with only the default tenant
+func mustDefaultTenants() *tenantRegistry {
+	reg, err := newTenantRegistry(nil, "")
+	if err != nil {
+		panic(err)
+	}
+	return reg
+}
+
+// newTenantRegistry creates the default tenant (seeded with the sample
+// courses) plus the configured ones. A configuration for "default" sets its
+// name, keys and quota. domain enables subdomain tenants.
+func newTenantRegistry(configs []tenantConfig, domain string) (*tenantRegistry, error) {
+	reg := &tenantRegistry{byID: map[string]*tenant{}}
+	hasDefault := false
+	for _, cfg := range configs {
+		hasDefault = hasDefault || cfg.ID == defaultTenantID
+	}
+	if !hasDefault {
+		configs = append([]tenantConfig{{ID: defaultTenantID, Name: "Default"}}, configs...)
+	}
+
+	for _, cfg := range configs {
+		if _, exists := reg.byID[cfg.ID]; exists {
+			return nil, fmt.Errorf("tenant %s is configured twice", cfg.ID)
+		}
+		var seed []Course
+		if cfg.ID == defaultTenantID {
+			seed = sampleCourses
+		}
+		t, err := newTenant(cfg, seed)
+		if err != nil {
+			return nil, err
+		}
+		reg.byID[t.ID] = t
+	}
+
+	if domain != "" {
+		route := mux.NewRouter().NewRoute().Host("{tenant:[a-z0-9-]+}." + domain)
+		if err := route.GetError(); err != nil {
+			return nil, fmt.Errorf("invalid TENANT_DOMAIN %q: %v", domain, err)
+		}
+		reg.host = route
+	}
+	return reg, nil
+}
+
+// loadTenants builds the registry from TENANTS_FILE and TENANT_DOMAIN
+func loadTenants() (*tenantRegistry, error) {
+	var configs []tenantConfig
+	if path := os.Getenv("TENANTS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return newTenantRegistry(configs, os.Getenv("TENANT_DOMAIN"))
+}
+
+// resolve finds the tenant a request is for
+func (reg *tenantRegistry) resolve(r *http.Request) (*tenant, error) {
+	id := ""
+	if reg.host != nil {
+		var match mux.RouteMatch
+		if reg.host.Match(r, &match) {
+			id = match.Vars["tenant"]
+		}
+	}
+	if header := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Tenant"))); header != "" {
+		if id != "" && header != id {
+			return nil, errTenantMismatch
+		}
+		id = header
+	}
+	if id == "" {
+		id = defaultTenantID
+	}
+
+	t, ok := reg.byID[id]
+	if !ok {
+		return nil, errTenantNotFound
+	}
+	return t, nil
+}
+
+// Request scoping
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant a request context was resolved to, or nil
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey{}).(*tenant)
+	return t
+}
+
+// storeFrom returns the store of the request's tenant. Every route runs
+// behind resolveTenant, so a missing tenant is a programming error.
+func storeFrom(r *http.Request) *courseStore {
+	t := tenantFrom(r.Context())
+	if t == nil {
+		panic("storeFrom: request has no tenant; is resolveTenant installed?")
+	}
+	return t.store
+}
+
+// resolveTenant picks the request's tenant from the registry, checks its API
+// key and rate limit, and scopes the request to it
+func (reg *tenantRegistry) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Operations of a batch already run as the batch's tenant
+		if tenantFrom(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := reg.resolve(r)
+		switch {
+		case errors.Is(err, errTenantNotFound):
+			render(w, r, http.StatusNotFound, err.Error())
+			return
+		case err != nil:
+			render(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("X-Tenant", t.ID)
+
+		if !t.authorized(r) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, Basic realm=%q`, t.Name, t.Name))
+			render(w, r, http.StatusUnauthorized, "A valid API key for this tenant is required")
+			return
+		}
+		if ok, wait := t.limiter.allow(1); !ok {
+			tooManyRequests(w, r, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, t)))
+	})
+}
+
+// tooManyRequests rejects a request over the tenant's rate limit
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	render(w, r, http.StatusTooManyRequests, "Request quota exceeded; try again later")
+}
+
+// rateLimiter is a token bucket refilled at a steady rate
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64 // Bucket size
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows perMinute requests a minute, or returns nil for no limit
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(perMinute),
+		tokens: float64(perMinute),
+		last:   time.Now(),
+	}
+}
+
+// allow takes n tokens if they are available; otherwise it reports how long
+// until they will be. A nil limiter allows everything.
+func (l *rateLimiter) allow(n int) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if need := float64(n); l.tokens < need {
+		return false, time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens -= float64(n)
+	return true, 0
+}
@@ -0,0 +1,387 @@
+// Package tlsserver serves HTTPS and HTTP/2 for the course API and the
+// modules example, with development certificates, hot reload and mutual TLS.
+package tlsserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS and HTTP/2 - tls.go
+// With TLS=on the server speaks HTTPS, and HTTP/2 to clients that offer it.
+// The certificate comes from TLS_CERT and TLS_KEY. Without them, a
+// development CA and a server certificate for TLS_HOSTS (localhost by
+// default) are generated into TLS_DIR (a directory named after the program
+// by default) and reused on later starts; trust ca.pem to call the server,
+// e.g. `curl --cacert $TLS_DIR/ca.pem`.
+//
+// The certificate files are checked every few seconds, and a renewed
+// certificate is served to new connections without a restart.
+//
+// Mutual TLS for service-to-service calls: TLS_CLIENT_AUTH=require rejects
+// clients without a certificate signed by TLS_CLIENT_CA (the development CA
+// when unset), and TLS_CLIENT_AUTH=verify checks certificates only from
+// clients that send one. A client certificate signed by the development CA is
+// generated as client.pem and client-key.pem.
+
+const (
+	certReloadInterval = 5 * time.Second
+	serverCertLifetime = 397 * 24 * time.Hour // The longest browsers accept
+	caCertLifetime     = 10 * 365 * 24 * time.Hour
+	certRenewBefore    = 30 * 24 * time.Hour // Generated certificates are reissued this close to expiry
+)
+
+// Settings configure HTTPS, from the environment
+type Settings struct {
+	Enabled      bool
+	Name         string             // Program name, for generated certificates and their directory
+	CertFile     string             // PEM certificate chain
+	KeyFile      string             // PEM private key
+	Dir          string             // Where development certificates are generated
+	Hosts        []string           // Names and IPs of generated server certificates
+	ClientAuth   tls.ClientAuthType // tls.NoClientCert unless mTLS is on
+	ClientCAFile string             // CAs that sign client certificates
+}
+
+// LoadSettings reads TLS, TLS_CERT, TLS_KEY, TLS_DIR, TLS_HOSTS,
+// TLS_CLIENT_AUTH and TLS_CLIENT_CA for the named program
+func LoadSettings(name string) (Settings, error) {
+	s := Settings{
+		Name:         name,
+		CertFile:     os.Getenv("TLS_CERT"),
+		KeyFile:      os.Getenv("TLS_KEY"),
+		Dir:          os.Getenv("TLS_DIR"),
+		Hosts:        []string{"localhost", "127.0.0.1", "::1"},
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA"),
+	}
+	switch strings.ToLower(os.Getenv("TLS")) {
+	case "", "off", "0", "false":
+		return s, nil
+	case "on", "1", "true":
+		s.Enabled = true
+	default:
+		return s, errors.New("TLS must be on or off")
+	}
+	if s.Dir == "" {
+		s.Dir = filepath.Join(os.TempDir(), s.Name+"-tls")
+	}
+	if hosts := os.Getenv("TLS_HOSTS"); hosts != "" {
+		s.Hosts = strings.Split(hosts, ",")
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return s, errors.New("set both TLS_CERT and TLS_KEY, or neither to generate a development certificate")
+	}
+
+	switch strings.ToLower(os.Getenv("TLS_CLIENT_AUTH")) {
+	case "", "off":
+		s.ClientAuth = tls.NoClientCert
+	case "verify":
+		s.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		s.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return s, errors.New("TLS_CLIENT_AUTH must be off, verify or require")
+	}
+	return s, nil
+}
+
+// ServerConfig returns the TLS configuration of the server and the reloader
+// that keeps its certificates current
+func ServerConfig(s Settings) (*tls.Config, *Reloader, error) {
+	if s.CertFile == "" || (s.ClientAuth != tls.NoClientCert && s.ClientCAFile == "") {
+		dev, err := ensureDevCertificates(s.Dir, s.Name, s.Hosts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating development certificates: %v", err)
+		}
+		if s.CertFile == "" {
+			s.CertFile, s.KeyFile = dev.certFile, dev.keyFile
+			log.Printf("Serving a development certificate; trust %s to connect", dev.caFile)
+		}
+		if s.ClientAuth != tls.NoClientCert && s.ClientCAFile == "" {
+			s.ClientCAFile = dev.caFile
+		}
+	}
+
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"}, // HTTP/2 first
+		ClientAuth: s.ClientAuth,
+	}
+	reloader := &Reloader{certFile: s.CertFile, keyFile: s.KeyFile, clientCAFile: s.ClientCAFile, base: base}
+	if err := reloader.reload(); err != nil {
+		return nil, nil, err
+	}
+
+	config := base.Clone()
+	config.GetCertificate = reloader.getCertificate
+	config.GetConfigForClient = reloader.getConfigForClient
+	return config, reloader, nil
+}
+
+// Hot reload
+
+// Reloader serves the current contents of the certificate files
+type Reloader struct {
+	certFile, keyFile, clientCAFile string
+	base                            *tls.Config // Settings that don't come from files
+
+	mu       sync.RWMutex
+	config   *tls.Config // base plus the loaded certificate and client CAs
+	modTimes []time.Time // Of the files, when last loaded
+}
+
+// reload reads the files. On error the certificates in use are kept.
+func (cr *Reloader) reload() error {
+	modTimes := cr.fileModTimes()
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading the TLS certificate: %v", err)
+	}
+	config := cr.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+	if cr.clientCAFile != "" {
+		data, err := os.ReadFile(cr.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading the client CAs: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", cr.clientCAFile)
+		}
+	}
+
+	cr.mu.Lock()
+	cr.config, cr.modTimes = config, modTimes
+	cr.mu.Unlock()
+	return nil
+}
+
+// fileModTimes returns when each file last changed (zero if it is missing)
+func (cr *Reloader) fileModTimes() []time.Time {
+	var times []time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile, cr.clientCAFile} {
+		var t time.Time
+		if info, err := os.Stat(path); err == nil {
+			t = info.ModTime()
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Watch reloads the files whenever they change, until ctx is done
+func (cr *Reloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := cr.reloadIfChanged()
+		switch {
+		case err != nil:
+			// Often a certificate written before its key; retried next tick
+			log.Printf("Keeping the current TLS certificate: %v", err)
+		case reloaded:
+			log.Printf("Reloaded the TLS certificate from %s", cr.certFile)
+		}
+	}
+}
+
+// reloadIfChanged reloads the files if any changed since they were loaded
+func (cr *Reloader) reloadIfChanged() (bool, error) {
+	cr.mu.RLock()
+	unchanged := slices.EqualFunc(cr.modTimes, cr.fileModTimes(), time.Time.Equal)
+	cr.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	if err := cr.reload(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (cr *Reloader) current() *tls.Config {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.config
+}
+
+// getCertificate is the tls.Config hook for the server certificate
+func (cr *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &cr.current().Certificates[0], nil
+}
+
+// getConfigForClient is the tls.Config hook that applies reloaded client CAs
+func (cr *Reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return cr.current(), nil
+}
+
+// Development certificates
+
+// devCertificates are the files of the development CA and what it signed
+type devCertificates struct {
+	caFile, caKeyFile         string
+	certFile, keyFile         string // Server
+	clientFile, clientKeyFile string
+}
+
+// ensureDevCertificates creates the development CA of the named program and
+// its server and client certificates in dir, keeping any that are still good
+func ensureDevCertificates(dir, name string, hosts []string) (devCertificates, error) {
+	dev := devCertificates{
+		caFile: filepath.Join(dir, "ca.pem"), caKeyFile: filepath.Join(dir, "ca-key.pem"),
+		certFile: filepath.Join(dir, "server.pem"), keyFile: filepath.Join(dir, "server-key.pem"),
+		clientFile: filepath.Join(dir, "client.pem"), clientKeyFile: filepath.Join(dir, "client-key.pem"),
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return dev, err
+	}
+	organization := []string{name + " development"}
+
+	ca, caKey, err := loadKeyPair(dev.caFile, dev.caKeyFile)
+	if err != nil || !stillValid(ca) {
+		template := &x509.Certificate{
+			Subject:               pkix.Name{Organization: organization, CommonName: name + " development CA"},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			MaxPathLenZero:        true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			NotAfter:              time.Now().Add(caCertLifetime),
+		}
+		if ca, caKey, err = issue(template, nil, nil, dev.caFile, dev.caKeyFile); err != nil {
+			return dev, err
+		}
+		// Certificates of an old CA no longer verify
+		os.Remove(dev.certFile)
+		os.Remove(dev.clientFile)
+	}
+
+	server, _, err := loadKeyPair(dev.certFile, dev.keyFile)
+	if err != nil || !stillValid(server) || !coversHosts(server, hosts) || server.CheckSignatureFrom(ca) != nil {
+		template := &x509.Certificate{
+			Subject:     pkix.Name{Organization: organization, CommonName: hosts[0]},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			NotAfter:    time.Now().Add(serverCertLifetime),
+		}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, h)
+			}
+		}
+		if _, _, err := issue(template, ca, caKey, dev.certFile, dev.keyFile); err != nil {
+			return dev, err
+		}
+	}
+
+	client, _, err := loadKeyPair(dev.clientFile, dev.clientKeyFile)
+	if err != nil || !stillValid(client) || client.CheckSignatureFrom(ca) != nil {
+		template := &x509.Certificate{
+			Subject:     pkix.Name{Organization: organization, CommonName: name + " client"},
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			NotAfter:    time.Now().Add(serverCertLifetime),
+		}
+		if _, _, err := issue(template, ca, caKey, dev.clientFile, dev.clientKeyFile); err != nil {
+			return dev, err
+		}
+	}
+	return dev, nil
+}
+
+// issue creates a key and a certificate from template, signed by parent (or
+// self-signed when parent is nil), and writes both as PEM files
+func issue(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour) // Tolerates clock skew
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The key is written first so a reload never pairs a new certificate
+	// with the old key
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0o600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// writePEM atomically replaces path with one PEM block
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadKeyPair reads a certificate and its ECDSA key
+func loadKeyPair(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("not an ECDSA key")
+	}
+	return pair.Leaf, key, nil
+}
+
+// stillValid reports whether a certificate has time left before renewal
+func stillValid(cert *x509.Certificate) bool {
+	return time.Now().Add(certRenewBefore).Before(cert.NotAfter)
+}
+
+// coversHosts reports whether a certificate is valid for every host
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
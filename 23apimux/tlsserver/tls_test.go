@@ -0,0 +1,199 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TLS server tests - tls_test.go
+// Each test serves HTTPS in process with the configuration ServerConfig
+// builds, on a development CA generated into a temporary directory, and calls
+// it over a real connection.
+
+// serve starts an HTTPS server with config and returns its URL
+func serve(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: config,
+	}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// testSettings are development-certificate settings in a temporary directory
+func testSettings(t *testing.T, clientAuth tls.ClientAuthType) Settings {
+	return Settings{
+		Enabled:    true,
+		Name:       "tlsserver-test",
+		Dir:        t.TempDir(),
+		Hosts:      []string{"localhost", "127.0.0.1"},
+		ClientAuth: clientAuth,
+	}
+}
+
+// client trusts the development CA in dir and presents its client
+// certificate if withCert is set
+func client(t *testing.T, dir string, withCert bool) *http.Client {
+	t.Helper()
+	pem, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	config := &tls.Config{RootCAs: roots}
+	if withCert {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+// serverSerial calls url and returns the serial number of the certificate
+// the server presented
+func serverSerial(t *testing.T, c *http.Client, url string) string {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.String()
+}
+
+func TestServeTLS(t *testing.T) {
+	s := testSettings(t, tls.NoClientCert)
+	config, _, err := ServerConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serve(t, config)
+
+	resp, err := client(t, s.Dir, false).Get(url)
+	if err != nil {
+		t.Fatalf("calling with the development CA trusted: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("protocol = %s, want HTTP/2", resp.Proto)
+	}
+
+	// A client that doesn't trust the development CA is refused
+	if _, err := http.Get(url); err == nil {
+		t.Error("untrusted client connected, want a certificate error")
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	s := testSettings(t, tls.NoClientCert)
+	config, reloader, err := ServerConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serve(t, config)
+	before := serverSerial(t, client(t, s.Dir, false), url)
+
+	if reloaded, err := reloader.reloadIfChanged(); reloaded || err != nil {
+		t.Fatalf("reloadIfChanged() = %v, %v without a change, want false, nil", reloaded, err)
+	}
+
+	// Reissue the server certificate, as a renewal would
+	serverFile := filepath.Join(s.Dir, "server.pem")
+	os.Remove(serverFile)
+	if _, err := ensureDevCertificates(s.Dir, s.Name, s.Hosts); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second) // Past the file system's timestamp resolution
+	os.Chtimes(serverFile, later, later)
+
+	if reloaded, err := reloader.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("reloadIfChanged() = %v, %v after a renewal, want true, nil", reloaded, err)
+	}
+	if after := serverSerial(t, client(t, s.Dir, false), url); after == before {
+		t.Errorf("new connections still get certificate %s, want the renewed one", before)
+	}
+
+	// A broken certificate is not loaded, and the renewed one stays in use
+	os.WriteFile(serverFile, []byte("not a certificate"), 0o644)
+	os.Chtimes(serverFile, later.Add(time.Second), later.Add(time.Second))
+	if _, err := reloader.reloadIfChanged(); err == nil {
+		t.Error("reloadIfChanged() loaded a broken certificate")
+	}
+	if _, err := client(t, s.Dir, false).Get(url); err != nil {
+		t.Errorf("calling after a failed reload: %v", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		withCert   bool
+		wantOK     bool
+	}{
+		{"required with a certificate", tls.RequireAndVerifyClientCert, true, true},
+		{"required without a certificate", tls.RequireAndVerifyClientCert, false, false},
+		{"verified with a certificate", tls.VerifyClientCertIfGiven, true, true},
+		{"verified without a certificate", tls.VerifyClientCertIfGiven, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSettings(t, tt.clientAuth)
+			config, _, err := ServerConfig(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			url := serve(t, config)
+
+			resp, err := client(t, s.Dir, tt.withCert).Get(url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if ok := err == nil; ok != tt.wantOK {
+				t.Errorf("connected = %v (err %v), want %v", ok, err, tt.wantOK)
+			}
+		})
+	}
+
+	// A certificate from another CA is refused
+	s := testSettings(t, tls.RequireAndVerifyClientCert)
+	config, _, err := ServerConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serve(t, config)
+	other := t.TempDir()
+	if _, err := ensureDevCertificates(other, "other", s.Hosts); err != nil {
+		t.Fatal(err)
+	}
+	stranger := client(t, s.Dir, false)
+	cert, err := tls.LoadX509KeyPair(filepath.Join(other, "client.pem"), filepath.Join(other, "client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+	if resp, err := stranger.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("client with another CA's certificate connected")
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Generic value trees - tree.go
+// Every non-JSON format is written and read through a small generic tree so the
+// models only need JSON struct tags. A tree is made of:
+//   - object      (ordered JSON object)
+//   - []any       (JSON array)
+//   - json.Number, string, bool and nil (scalars)
+// Formats that can only carry text (XML, CSV) produce strings for every scalar;
+// fromTree converts them to the destination field type.
+
+// member is one key/value pair of an object
+type member struct {
+	Key   string
+	Value any
+}
+
+// object is a JSON object that remembers the order of its keys, so columns and
+// elements come out in the same order as the struct fields
+type object []member
+
+// get returns the value stored under key
+func (o object) get(key string) (any, bool) {
+	for _, m := range o {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// MarshalJSON writes the object with its keys in order
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(m.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toTree converts any JSON-serializable value into a generic tree. Going through
+// encoding/json keeps struct tags, omitempty and custom MarshalJSON methods.
+func toTree(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return toTreeFromJSON(data)
+}
+
+// toTreeFromJSON parses raw JSON into a generic tree
+func toTreeFromJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return readTreeValue(dec)
+}
+
+// readTreeValue reads one JSON value from the token stream
+func readTreeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readTreeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{Key: keyTok.(string), Value: value})
+		}
+		_, err := dec.Token() // closing '}'
+		return obj, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := readTreeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token() // closing ']'
+		return list, err
+	default:
+		return tok, nil
+	}
+}
+
+// fromTree stores a generic tree into dst, which must be a non-nil pointer.
+// Scalars are converted to the destination type where that is unambiguous
+// (e.g. the XML text "29.99" into a float64 field).
+func fromTree(tree any, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("fromTree: destination must be a non-nil pointer")
+	}
+	return assignTree(rv.Elem(), tree)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// assignTree is the reflective worker behind fromTree
+func assignTree(dst reflect.Value, src any) error {
+	// Types with their own JSON decoding (e.g. backward compatible fields)
+	// get the tree re-encoded as JSON so their logic is reused
+	if dst.CanAddr() && dst.Addr().Type().Implements(jsonUnmarshalerType) {
+		data, err := json.Marshal(treeToJSONValue(src))
+		if err != nil {
+			return err
+		}
+		return dst.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(data)
+	}
+	if s, ok := src.(string); ok && dst.CanAddr() && dst.Addr().Type().Implements(textUnmarshalerType) {
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if src == nil {
+		dst.SetZero()
+		return nil
+	}
+
+	// Text formats write empty objects and lists as empty text
+	if s, ok := src.(string); ok && s == "" {
+		switch dst.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice:
+			dst.SetZero()
+			return nil
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assignTree(dst.Elem(), src)
+
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(treeToJSONValue(src)))
+		return nil
+
+	case reflect.Struct:
+		obj, ok := src.(object)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		return assignStruct(dst, obj)
+
+	case reflect.Map:
+		obj, ok := src.(object)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, m := range obj {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assignTree(elem, m.Value); err != nil {
+				return fmt.Errorf("%s: %w", m.Key, err)
+			}
+			dst.SetMapIndex(reflect.ValueOf(m.Key).Convert(dst.Type().Key()), elem)
+		}
+		return nil
+
+	case reflect.Slice:
+		list, ok := src.([]any)
+		if !ok {
+			// A single element where a list was expected (one XML child, one CSV row)
+			list = []any{src}
+		}
+		out := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := assignTree(out.Index(i), item); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		dst.Set(out)
+		return nil
+
+	case reflect.Array:
+		// Fixed-size lists, such as a rating histogram, must have every element
+		list, ok := src.([]any)
+		if !ok || len(list) != dst.Len() {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		for i, item := range list {
+			if err := assignTree(dst.Index(i), item); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		return nil
+
+	case reflect.String:
+		dst.SetString(scalarString(src))
+		return nil
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(scalarString(src))
+		if err != nil {
+			return fmt.Errorf("cannot decode %q as bool", scalarString(src))
+		}
+		dst.SetBool(b)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(scalarString(src), 10, 64)
+		if err != nil || dst.OverflowInt(n) {
+			return fmt.Errorf("cannot decode %q as %s", scalarString(src), dst.Type())
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(scalarString(src), 10, 64)
+		if err != nil || dst.OverflowUint(n) {
+			return fmt.Errorf("cannot decode %q as %s", scalarString(src), dst.Type())
+		}
+		dst.SetUint(n)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(scalarString(src), 64)
+		if err != nil {
+			return fmt.Errorf("cannot decode %q as %s", scalarString(src), dst.Type())
+		}
+		dst.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("cannot decode into %s", dst.Type())
+}
+
+// assignStruct fills struct fields by their JSON names, ignoring unknown keys
+func assignStruct(dst reflect.Value, obj object) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		value, ok := obj.get(name)
+		if !ok {
+			continue
+		}
+		if err := assignTree(dst.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// scalarString renders a scalar tree node as text
+func scalarString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// treeToJSONValue converts a tree into values encoding/json understands
+// (objects become maps; key order is irrelevant once we hand off to JSON)
+func treeToJSONValue(v any) any {
+	switch v := v.(type) {
+	case object:
+		m := make(map[string]any, len(v))
+		for _, member := range v {
+			m[member.Key] = treeToJSONValue(member.Value)
+		}
+		return m
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = treeToJSONValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// decodeTreeInto reads a whole body with parse and stores the result in dst
+func decodeTreeInto(body io.Reader, dst any, parse func([]byte) (any, error)) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	tree, err := parse(data)
+	if err != nil {
+		return err
+	}
+	return fromTree(tree, dst)
+}
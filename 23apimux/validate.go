@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Validation - validate.go
+// Course and author rules live here so the REST API, GraphQL and the admin
+// forms reject the same input with the same messages. Errors are reported per
+// field, which lets forms show each message next to its input.
+
+const (
+	maxNameLength  = 200
+	maxDuration    = Duration(1000 * time.Hour)
+	maxAuthorField = 200
+)
+
+// fieldError is a validation message for one input field
+type fieldError struct {
+	Field   string `json:"field"`   // JSON name of the field, e.g. "author.email"
+	Message string `json:"message"` // Human readable message
+}
+
+// validationErrors collects every problem with an input
+type validationErrors []fieldError
+
+// Error joins the messages, for callers that only report a single string
+func (v validationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add records a message for a field
+func (v *validationErrors) add(field, message string) {
+	*v = append(*v, fieldError{Field: field, Message: message})
+}
+
+// For returns the message for a field, or "" if it is valid
+func (v validationErrors) For(field string) string {
+	for _, e := range v {
+		if e.Field == field {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+// err returns v as an error, or nil when there are no messages
+func (v validationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// validateCourse checks a course before it is stored and normalizes its text
+func validateCourse(c *Course) error {
+	var errs validationErrors
+	c.Name = strings.TrimSpace(c.Name)
+	switch {
+	case c.Name == "":
+		errs.add("name", "Name is required")
+	case len(c.Name) > maxNameLength:
+		errs.add("name", "Name must be at most 200 characters")
+	}
+	if c.Duration > maxDuration {
+		errs.add("duration", "Duration must be at most 1000 hours")
+	}
+	if c.Price.Amount < 0 {
+		errs.add("price", "Price must not be negative")
+	}
+	if c.Price.Currency == "" {
+		c.Price.Currency = defaultCurrency
+	}
+	if _, err := currencyExponent(c.Price.Currency); err != nil {
+		errs.add("currency", "Currency "+c.Price.Currency+" is not supported")
+	}
+	validateLifecycle(c, &errs)
+	if c.Author != nil && (c.Author.Fullname != "" || c.Author.Email != "") {
+		if err := validateAuthor(c.Author); err != nil {
+			for _, e := range err.(validationErrors) {
+				errs.add("author."+e.Field, e.Message)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// validateAuthor checks an author and normalizes their name and email
+func validateAuthor(a *Author) error {
+	var errs validationErrors
+	a.Fullname = strings.TrimSpace(a.Fullname)
+	switch {
+	case a.Fullname == "":
+		errs.add("fullname", "Full name is required")
+	case len(a.Fullname) > maxAuthorField:
+		errs.add("fullname", "Full name must be at most 200 characters")
+	}
+	if addr, err := mail.ParseAddress(strings.TrimSpace(a.Email)); err != nil || len(a.Email) > maxAuthorField {
+		errs.add("email", "Email must be a valid address like jane@example.com")
+	} else {
+		a.Email = addr.Address
+	}
+	return errs.err()
+}
+
+// renderValidationError reports validation errors as 422 with one entry per
+// field; it returns false when there is nothing to report
+func renderValidationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	errs, ok := err.(validationErrors)
+	if !ok {
+		return false
+	}
+	render(w, r, http.StatusUnprocessableEntity, map[string]any{
+		"message": "Validation failed",
+		"errors":  []fieldError(errs),
+	})
+	return true
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API versioning - versioning.go
+// The course handlers are shared between every API version. Each version only
+// differs in how a Course is represented on the wire, so it carries a pair of
+// mapper functions that convert to and from that representation.
+
+// apiVersion describes one published version of the Course API
+type apiVersion struct {
+	Name       string    // Version name used in paths and media types ("v1", "v2")
+	Deprecated time.Time // When the version was deprecated (zero if current)
+	Sunset     time.Time // When the version will be removed (zero if not scheduled)
+	Successor  string    // Name of the version clients should migrate to
+
+	encodeCourse func(*http.Request, Course) any     // Domain -> wire representation, with links for the request
+	decodeCourse func(*http.Request) (Course, error) // Wire representation -> domain
+}
+
+// v1 is the original API shape: free-text durations ("3h") and float prices
+var v1 = &apiVersion{
+	Name:         "v1",
+	Deprecated:   time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+	Sunset:       time.Date(2027, time.October, 1, 0, 0, 0, 0, time.UTC),
+	Successor:    "v2",
+	encodeCourse: func(r *http.Request, c Course) any { return toCourseV1(c, linksFrom(r)) },
+	decodeCourse: decodeCourse,
+}
+
+// v2 exposes structured durations and currency-aware prices
+var v2 = &apiVersion{
+	Name:         "v2",
+	encodeCourse: func(r *http.Request, c Course) any { return toCourseV2(c, linksFrom(r)) },
+	decodeCourse: decodeCourse,
+}
+
+// decodeCourse reads a course in any version's shape. Duration and Money
+// understand both the old and the structured forms, so no mapping is needed.
+func decodeCourse(r *http.Request) (Course, error) {
+	var c Course
+	err := decodeBody(r, &c)
+	return c, err
+}
+
+// apiVersions lists every supported version by name
+var apiVersions = map[string]*apiVersion{
+	v1.Name: v1,
+	v2.Name: v2,
+}
+
+// defaultVersion is used for unversioned routes when the client does not ask
+// for a specific version, so existing clients keep getting the v1 shape
+var defaultVersion = v1
+
+// courseV1 is the v1 wire representation of a Course
+type courseV1 struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Duration string          `json:"duration"` // e.g. "3h30m"
+	Price    float64         `json:"price"`    // Major units, currency implied
+	Author   *authorResource `json:"author"`
+	Rating   RatingSummary   `json:"rating"`
+	Links    links           `json:"_links,omitempty"` // See links.go
+}
+
+// toCourseV1 maps a stored course to its v1 representation
+func toCourseV1(c Course, l *linker) courseV1 {
+	return courseV1{
+		ID:       c.ID,
+		Name:     c.Name,
+		Duration: c.Duration.Short(),
+		Price:    c.Price.Float(),
+		Author:   l.authorResource(c.Author),
+		Rating:   c.Rating,
+		Links:    l.course(c),
+	}
+}
+
+// courseV2 is the v2 wire representation of a Course
+type courseV2 struct {
+	ID            string          `json:"id"`
+	Name          string          `json:"name"`
+	Duration      durationV2      `json:"duration"`
+	Price         Money           `json:"price"`
+	Author        *authorResource `json:"author"`
+	Modules       []Module        `json:"modules"`
+	Prerequisites []string        `json:"prerequisites"`
+	Rating        RatingSummary   `json:"rating"`
+	Status        CourseStatus    `json:"status"`                 // See lifecycle.go
+	PublishAt     *time.Time      `json:"publish_at,omitempty"`   // Only while scheduled
+	PublishedAt   *time.Time      `json:"published_at,omitempty"` // When the course went live
+	Links         links           `json:"_links,omitempty"`       // See links.go
+}
+
+// durationV2 is a structured course duration
+type durationV2 struct {
+	ISO8601 string `json:"iso8601"` // e.g. "PT3H30M"
+	Seconds int64  `json:"seconds"` // Same duration in whole seconds
+}
+
+// toCourseV2 maps a stored course to its v2 representation
+func toCourseV2(c Course, l *linker) courseV2 {
+	wire := courseV2{
+		ID:   c.ID,
+		Name: c.Name,
+		Duration: durationV2{
+			ISO8601: c.Duration.String(),
+			Seconds: c.Duration.Seconds(),
+		},
+		Price:         c.Price,
+		Author:        l.authorResource(c.Author),
+		Modules:       c.Modules,
+		Prerequisites: c.Prerequisites,
+		Rating:        c.Rating,
+		Status:        c.Status,
+		PublishAt:     c.PublishAt,
+		PublishedAt:   c.PublishedAt,
+		Links:         l.course(c),
+	}
+	if wire.Modules == nil {
+		wire.Modules = []Module{}
+	}
+	if wire.Prerequisites == nil {
+		wire.Prerequisites = []string{}
+	}
+	return wire
+}
+
+// Version selection
+
+type contextKey int
+
+const (
+	versionKey contextKey = iota
+	linkerKey             // Route links of the serving subrouter (see links.go)
+)
+
+// versionFrom returns the API version a request was resolved to
+func versionFrom(r *http.Request) *apiVersion {
+	if v, ok := r.Context().Value(versionKey).(*apiVersion); ok {
+		return v
+	}
+	return defaultVersion
+}
+
+// withVersion pins every route of a subrouter to a single API version
+func withVersion(v *apiVersion) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setVersionHeaders(w, r, v)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey, v)))
+		})
+	}
+}
+
+// vendorMediaType matches media types like "application/vnd.courseapi.v2+json"
+// (any structured suffix is allowed, e.g. "+xml" or "+yaml")
+var vendorMediaType = regexp.MustCompile(`^application/vnd\.courseapi\.(v\d+)(\+[a-z]+)?$`)
+
+// negotiateVersion picks the API version for unversioned routes from the
+// Accept header. Both "application/vnd.courseapi.v2+json" and a version
+// parameter on any media type ("application/xml; version=2") are understood.
+func negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept")
+
+		v, err := versionFromAccept(r.Header.Get("Accept"))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotAcceptable)
+			json.NewEncoder(w).Encode(err.Error())
+			return
+		}
+
+		setVersionHeaders(w, r, v)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey, v)))
+	})
+}
+
+// versionFromAccept returns the first version requested in an Accept header,
+// or the default version when none is requested
+func versionFromAccept(accept string) (*apiVersion, error) {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		name := ""
+		if m := vendorMediaType.FindStringSubmatch(mediaType); m != nil {
+			name = m[1]
+		} else {
+			name = versionParam(params)
+		}
+		if name == "" {
+			continue
+		}
+
+		if v, ok := apiVersions[name]; ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("unsupported API version %q", name)
+	}
+	return defaultVersion, nil
+}
+
+// versionParam extracts a "version=N" media type parameter as "vN"
+func versionParam(params string) string {
+	for _, p := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || !strings.EqualFold(key, "version") {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		if _, err := strconv.Atoi(value); err == nil {
+			return "v" + value
+		}
+		return value
+	}
+	return ""
+}
+
+// setVersionHeaders reports the served version and, for deprecated versions,
+// adds Deprecation (RFC 9745), Sunset (RFC 8594) and successor-version links
+func setVersionHeaders(w http.ResponseWriter, r *http.Request, v *apiVersion) {
+	w.Header().Set("API-Version", v.Name)
+	if v.Deprecated.IsZero() {
+		return
+	}
+
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+	if !v.Sunset.IsZero() {
+		w.Header().Set("Sunset", v.Sunset.Format(http.TimeFormat))
+	}
+	if v.Successor != "" {
+		// "/v1/courses/1" and "/courses/1" both point at "/v2/courses/1"
+		path := strings.TrimPrefix(r.URL.Path, "/"+v.Name)
+		w.Header().Add("Link", fmt.Sprintf(`</%s%s>; rel="successor-version"`, v.Successor, path))
+	}
+}